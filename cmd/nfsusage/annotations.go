@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// auditAnnotate is the audited operation of posting an annotation
+const auditAnnotate = "annotate"
+
+// maxAnnotationBytes bounds one posted annotation
+const maxAnnotationBytes = 64 << 10
+
+// Annotation is an operational event posted to the fleet server, such as a
+// migration or a cleanup job, shown alongside usage so changes can be
+// explained. Without Host it applies to every host, without Mounts to every
+// mount.
+type Annotation struct {
+	Timestamp int64 `json:"timestamp"`
+	// End makes the annotation a span, e.g. a migration from start to end
+	End    int64    `json:"end,omitempty"`
+	Text   string   `json:"text"`
+	Host   string   `json:"host,omitempty"`
+	Mounts []string `json:"mounts,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	// Source is the address the annotation was posted from, set by the server
+	Source string `json:"source,omitempty"`
+}
+
+// annotationsPath is where the fleet server keeps annotations. It is not a
+// .jsonl file so fleetStores doesn't take it for a host.
+func annotationsPath(dataDir string) string {
+	return filepath.Join(dataDir, "annotations.log")
+}
+
+// validate checks a posted annotation
+func (a *Annotation) validate() error {
+	if strings.TrimSpace(a.Text) == "" {
+		return fmt.Errorf("text is required")
+	}
+	if a.End != 0 && a.End < a.Timestamp {
+		return fmt.Errorf("end is before timestamp")
+	}
+	return nil
+}
+
+// overlaps reports whether the annotation falls within from..to
+func (a *Annotation) overlaps(from, to int64) bool {
+	end := a.End
+	if end == 0 {
+		end = a.Timestamp
+	}
+	return end >= from && a.Timestamp <= to
+}
+
+// appliesTo reports whether the annotation concerns host, any host for ""
+func (a *Annotation) appliesTo(host string) bool {
+	return host == "" || a.Host == "" || a.Host == host
+}
+
+// annotationScope decides what of the annotations a tenant may see, matching
+// mounts like restrictEntry against the devices each host recorded last
+type annotationScope struct {
+	tenant *TenantConfig
+	// devices maps host and mount point to its device
+	devices map[string]map[string]string
+}
+
+// newAnnotationScope reads the devices of every host's newest entry
+func newAnnotationScope(dataDir string, tenant *TenantConfig) (*annotationScope, error) {
+	stores, err := fleetStores(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	scope := &annotationScope{tenant: tenant, devices: make(map[string]map[string]string)}
+	for host, st := range stores {
+		newest, _, err := lastEntry(st)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", host, err)
+		}
+		if newest == nil {
+			continue
+		}
+		devices := make(map[string]string)
+		for mount, detail := range newest.Details {
+			devices[mount] = detail.Device
+		}
+		scope.devices[host] = devices
+	}
+	return scope, nil
+}
+
+// owns reports whether the tenant owns mount on host, on any host for ""
+func (s *annotationScope) owns(host, mount string) bool {
+	for h, devices := range s.devices {
+		if host != "" && h != host {
+			continue
+		}
+		if device, ok := devices[mount]; ok && s.tenant.owns(nfsMount{MountPoint: mount, Device: device}) {
+			return true
+		}
+	}
+	return s.tenant.owns(nfsMount{MountPoint: mount})
+}
+
+// ownsHost reports whether the tenant owns any mount on host
+func (s *annotationScope) ownsHost(host string) bool {
+	for mount, device := range s.devices[host] {
+		if s.tenant.owns(nfsMount{MountPoint: mount, Device: device}) {
+			return true
+		}
+	}
+	return false
+}
+
+// restrict returns the annotation as the tenant may see it, or false when it
+// concerns none of the tenant's mounts. Fleet-wide annotations are shown to
+// every tenant, host-wide ones to tenants with a mount on the host and
+// mount-specific ones with only the tenant's mounts. The posting address is
+// never shown to tenants.
+func (s *annotationScope) restrict(a Annotation) (Annotation, bool) {
+	if s == nil {
+		return a, true
+	}
+	a.Source = ""
+	if len(a.Mounts) == 0 {
+		return a, a.Host == "" || s.ownsHost(a.Host)
+	}
+	var mounts []string
+	for _, mount := range a.Mounts {
+		if s.owns(a.Host, mount) {
+			mounts = append(mounts, mount)
+		}
+	}
+	a.Mounts = mounts
+	return a, len(mounts) > 0
+}
+
+// appendAnnotation adds an annotation to the data directory's log
+func appendAnnotation(dataDir string, a Annotation) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(annotationsPath(dataDir), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// loadAnnotations returns the annotations overlapping from..to for host (all
+// hosts for ""), oldest first, as tenant may see them. A missing log has
+// none.
+func loadAnnotations(dataDir, host string, from, to int64, tenant *TenantConfig) ([]Annotation, error) {
+	var scope *annotationScope
+	if tenant != nil {
+		var err error
+		if scope, err = newAnnotationScope(dataDir, tenant); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.Open(annotationsPath(dataDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var annotations []Annotation
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxAnnotationBytes*2)
+	for line := 1; scanner.Scan(); line++ {
+		var a Annotation
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", annotationsPath(dataDir), line, err)
+		}
+		if !a.overlaps(from, to) || !a.appliesTo(host) {
+			continue
+		}
+		if a, ok := scope.restrict(a); ok {
+			annotations = append(annotations, a)
+		}
+	}
+	return annotations, scanner.Err()
+}
+
+// handleAnnotations accepts annotations POSTed with the server token, and
+// lists them on GET with from, to and host parameters like /api/v1/history
+func (s *fleetServer) handleAnnotations(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.postAnnotation(w, r)
+	case http.MethodGet:
+		tenant, ok := s.authorizeRead(w, r)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		now := timeSource.Now()
+		from, to := int64(0), now.Unix()
+		var err error
+		if v := q.Get("from"); v != "" {
+			if from, err = parseAPITime(v, now); err != nil {
+				http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if v := q.Get("to"); v != "" {
+			if to, err = parseAPITime(v, now); err != nil {
+				http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		annotations, err := loadAnnotations(s.dataDir, q.Get("host"), from, to, tenant)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if annotations == nil {
+			annotations = []Annotation{}
+		}
+		s.redactAnnotations(annotations)
+		lo, hi, ok := s.paginate(w, r, len(annotations))
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(annotations[lo:hi])
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// redactAnnotations applies --redact-paths to the mounts annotations name
+func (s *fleetServer) redactAnnotations(annotations []Annotation) {
+	if s.redact == nil {
+		return
+	}
+	for i := range annotations {
+		mounts := make([]string, len(annotations[i].Mounts))
+		for j, mount := range annotations[i].Mounts {
+			mounts[j] = s.redact.path(mount)
+		}
+		annotations[i].Mounts = mounts
+	}
+}
+
+// postAnnotation records one annotation. Like ingest it needs the server
+// token; tenant tokens are read-only.
+func (s *fleetServer) postAnnotation(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeWrite(w, r) {
+		return
+	}
+	var a Annotation
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxAnnotationBytes)).Decode(&a); err != nil {
+		http.Error(w, "invalid annotation: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if a.Timestamp == 0 {
+		a.Timestamp = timeSource.Now().Unix()
+	}
+	if err := a.validate(); err != nil {
+		http.Error(w, "invalid annotation: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.Source, _, _ = net.SplitHostPort(r.RemoteAddr)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := appendAnnotation(s.dataDir, a); err != nil {
+		fmt.Fprintf(os.Stderr, "Error storing annotation from %s: %v\n", a.Source, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(annotationsPath(s.dataDir), auditAnnotate, "%q at %d from %s", a.Text, a.Timestamp, a.Source)
+	w.WriteHeader(http.StatusNoContent)
+}
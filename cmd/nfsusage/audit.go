@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// Audited operations, the ones that change a history rather than append to it
+const (
+	auditPrune      = "prune"
+	auditQuarantine = "quarantine"
+	auditRepair     = "repair"
+	auditSeal       = "seal"
+	auditRemapAdd   = "remap_add"
+	auditRemapDel   = "remap_remove"
+)
+
+// auditRecord is one line of the audit log: who changed the history, when,
+// from where and what they did
+type auditRecord struct {
+	Timestamp int64  `json:"timestamp"`
+	User      string `json:"user"`
+	Host      string `json:"host"`
+	Operation string `json:"operation"`
+	Detail    string `json:"detail"`
+}
+
+// auditPath returns the audit log of a data file. It is kept apart from the
+// events sidecar so operators sharing a history can hold it to a longer
+// retention and tighter permissions.
+func auditPath(filePath string) string {
+	return filePath + ".audit"
+}
+
+// auditUser names who ran an operation, the invoking user under sudo
+func auditUser() string {
+	if name := os.Getenv("SUDO_USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return fmt.Sprintf("uid %d", os.Getuid())
+}
+
+// recordAudit appends an operation to the data file's audit log. Like events,
+// failures are only warned about, the operation itself already happened.
+func recordAudit(filePath, operation, format string, args ...interface{}) {
+	record := auditRecord{
+		Timestamp: timeSource.Now().Unix(),
+		User:      auditUser(),
+		Operation: operation,
+		Detail:    fmt.Sprintf(format, args...),
+	}
+	record.Host, _ = os.Hostname()
+	data, err := json.Marshal(record)
+	if err == nil {
+		var file *os.File
+		file, err = os.OpenFile(auditPath(filePath), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		if err == nil {
+			_, err = file.Write(append(data, '\n'))
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error recording %s in the audit log: %v\n", operation, err)
+	}
+}
+
+// loadAudit reads the audit log, a missing log has no records
+func loadAudit(filePath string) ([]auditRecord, error) {
+	file, err := os.Open(auditPath(filePath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []auditRecord
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", auditPath(filePath), line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// runAudit implements the audit subcommand, listing the operations that
+// changed a history
+func runAudit(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	var filePath, configPath, since, operation string
+	var asJSON bool
+	fs.StringVar(&filePath, "file", "", "Path to the data file (default: config file, else CWD/nfsusage.json)")
+	fs.StringVar(&filePath, "f", "", "Path to the data file (shorthand)")
+	fs.StringVar(&configPath, "config", "", "Path to YAML config file")
+	fs.StringVar(&since, "since", "", "Only list operations after this: a date, a time or an age like 7d")
+	fs.StringVar(&operation, "operation", "", "Only list this operation, e.g. prune or remap_add")
+	fs.BoolVar(&asJSON, "json", false, "Print the records as JSON lines")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	filePath = cfg.dataFile(filePath)
+	var from int64
+	if since != "" {
+		t, err := parseSince(since, timeSource.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		from = t.Unix()
+	}
+	records, err := loadAudit(filePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading audit log: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, r := range records {
+		if r.Timestamp < from || operation != "" && r.Operation != operation {
+			continue
+		}
+		if asJSON {
+			data, _ := json.Marshal(r)
+			fmt.Println(string(data))
+			continue
+		}
+		fmt.Printf("%s  %-12s  %s@%s  %s\n", time.Unix(r.Timestamp, 0).Format("2006-01-02 15:04:05"),
+			r.Operation, r.User, r.Host, r.Detail)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/report"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// BackupWindow is a recurring daily window in which backups run against the
+// mounts matching Pattern
+type BackupWindow struct {
+	Pattern string `yaml:"pattern"`
+	// Start and End are local HH:MM times, End before Start crosses midnight
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+	// Days limits the window to the days it starts on (mon..sun), empty is every day
+	Days []string `yaml:"days"`
+}
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parseClock parses HH:MM into minutes after midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// validate checks the window's times and days
+func (w BackupWindow) validate() error {
+	if _, err := parseClock(w.Start); err != nil {
+		return err
+	}
+	if _, err := parseClock(w.End); err != nil {
+		return err
+	}
+	for _, day := range w.Days {
+		if !slices.Contains(weekdayNames, strings.ToLower(day)) {
+			return fmt.Errorf("invalid day %q (want mon..sun)", day)
+		}
+	}
+	return nil
+}
+
+// overlap returns how many seconds of [from, to] fall inside the window
+func (w BackupWindow) overlap(from, to int64) int64 {
+	start, _ := parseClock(w.Start)
+	end, _ := parseClock(w.End)
+	length := time.Duration(end-start) * time.Minute
+	if end <= start {
+		length += 24 * time.Hour
+	}
+
+	var total int64
+	// Start a day early so a window crossing midnight into from is counted
+	day := time.Unix(from, 0).AddDate(0, 0, -1)
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	for ; day.Unix() <= to; day = day.AddDate(0, 0, 1) {
+		if len(w.Days) > 0 && !slices.ContainsFunc(w.Days, func(d string) bool {
+			return strings.ToLower(d) == weekdayNames[day.Weekday()]
+		}) {
+			continue
+		}
+		ws := day.Add(time.Duration(start) * time.Minute).Unix()
+		we := ws + int64(length/time.Second)
+		if lo, hi := max(ws, from), min(we, to); hi > lo {
+			total += hi - lo
+		}
+	}
+	return total
+}
+
+// backupWindowsFor returns the windows whose pattern matches the mount
+func (c *Config) backupWindowsFor(mount nfsMount) []BackupWindow {
+	var windows []BackupWindow
+	for _, w := range c.BackupWindows {
+		if matchesMount(w.Pattern, mount) {
+			windows = append(windows, w)
+		}
+	}
+	return windows
+}
+
+// backupSplit is a mount's growth inside and outside its backup windows
+type backupSplit struct {
+	mount                     string
+	insideDelta, insideSecs   float64
+	outsideDelta, outsideSecs float64
+}
+
+// collectBackupSplit attributes each interval's usage change to the mount's
+// backup windows in proportion to how much of the interval they cover.
+// Intervals that are gaps in collection are left out, as with --gaps skip.
+func collectBackupSplit(st historyStore, from int64, cfg *Config) ([]backupSplit, error) {
+	interval, err := report.ExpectedInterval(st, from)
+	if err != nil {
+		return nil, err
+	}
+
+	type state struct {
+		backupSplit
+		windows   []BackupWindow
+		lastTS    int64
+		lastBytes int64
+	}
+	states := make(map[string]*state)
+	err = store.ScanRange(st, from, 0, func(entry UsageEntry) error {
+		for mount, bytes := range entry.Mounts {
+			if collector.IsSnapshotMount(mount) {
+				continue
+			}
+			s := states[mount]
+			if s == nil {
+				m := nfsMount{MountPoint: mount, Device: entry.Details[mount].Device}
+				states[mount] = &state{backupSplit: backupSplit{mount: mount},
+					windows: cfg.backupWindowsFor(m), lastTS: entry.Timestamp, lastBytes: bytes}
+				continue
+			}
+			dt := entry.Timestamp - s.lastTS
+			if dt > 0 && len(s.windows) > 0 && (interval == 0 || dt <= report.GapFactor*interval) {
+				var inside int64
+				for _, w := range s.windows {
+					inside += w.overlap(s.lastTS, entry.Timestamp)
+				}
+				inside = min(inside, dt)
+				share := float64(inside) / float64(dt)
+				delta := float64(bytes - s.lastBytes)
+				s.insideDelta += delta * share
+				s.insideSecs += float64(inside)
+				s.outsideDelta += delta * (1 - share)
+				s.outsideSecs += float64(dt - inside)
+			}
+			s.lastTS, s.lastBytes = entry.Timestamp, bytes
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []backupSplit
+	for _, s := range states {
+		if len(s.windows) > 0 {
+			result = append(result, s.backupSplit)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].mount < result[j].mount })
+	return result, nil
+}
+
+// printBackupReport prints growth inside and outside backup windows, with
+// per-hour rates so short windows can be compared against the rest of the day
+func printBackupReport(splits []backupSplit) {
+	if len(splits) == 0 {
+		fmt.Println("No recorded mount matches a backup window (see backup_windows in the config)")
+		return
+	}
+	perHour := func(delta, secs float64) string {
+		if secs <= 0 {
+			return "-"
+		}
+		return formatDiff(int64(delta / secs * 3600))
+	}
+	type row struct{ mount, in, inRate, out, outRate string }
+	rows := make([]row, 0, len(splits))
+	widths := []int{len("Mountpoint"), len("In window"), len("Per hour"), len("Outside"), len("Per hour")}
+	for _, s := range splits {
+		r := row{s.mount, formatDiff(int64(s.insideDelta)), perHour(s.insideDelta, s.insideSecs),
+			formatDiff(int64(s.outsideDelta)), perHour(s.outsideDelta, s.outsideSecs)}
+		for i, v := range []string{r.mount, r.in, r.inRate, r.out, r.outRate} {
+			widths[i] = max(widths[i], len(v))
+		}
+		rows = append(rows, r)
+	}
+	line := func(cols ...string) {
+		fmt.Printf("%-*s  %*s  %*s  %*s  %*s\n", widths[0], cols[0], widths[1], cols[1], widths[2], cols[2],
+			widths[3], cols[3], widths[4], cols[4])
+	}
+	line("Mountpoint", "In window", "Per hour", "Outside", "Per hour")
+	line(strings.Repeat("-", widths[0]), strings.Repeat("-", widths[1]), strings.Repeat("-", widths[2]),
+		strings.Repeat("-", widths[3]), strings.Repeat("-", widths[4]))
+	for _, r := range rows {
+		line(r.mount, r.in, r.inRate, r.out, r.outRate)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// stageTimings collects durations for one benchmark stage
+type stageTimings struct {
+	name  string
+	times []time.Duration
+}
+
+func (s *stageTimings) measure(fn func() error) error {
+	start := time.Now()
+	err := fn()
+	s.times = append(s.times, time.Since(start))
+	return err
+}
+
+func (s *stageTimings) stats() (min, avg, max time.Duration) {
+	if len(s.times) == 0 {
+		return 0, 0, 0
+	}
+	min = s.times[0]
+	var total time.Duration
+	for _, t := range s.times {
+		total += t
+		if t < min {
+			min = t
+		}
+		if t > max {
+			max = t
+		}
+	}
+	return min, total / time.Duration(len(s.times)), max
+}
+
+// runBench implements the bench subcommand: it times each stage of a run
+// without touching the real history
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	var filePath, configPath, keyFile string
+	var iterations int
+	fs.StringVar(&filePath, "file", "", "Existing data file to time read-only scans against")
+	fs.StringVar(&filePath, "f", "", "Existing data file to time read-only scans against (shorthand)")
+	fs.StringVar(&configPath, "config", "", "Path to YAML config file")
+	fs.StringVar(&keyFile, "key-file", "", "Path to AES-256 key for the data file")
+	fs.IntVar(&iterations, "iterations", 5, "Number of iterations per stage")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	key, err := loadKey(keyFile, cfg.Encryption)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading encryption key: %v\n", err)
+		os.Exit(1)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "nfsusage-bench-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating temp dir: %v\n", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(tmpDir)
+	ext := ".json"
+	if filePath != "" {
+		ext = filepath.Ext(filePath)
+	}
+	scratch := store.Open(filepath.Join(tmpDir, "bench"+ext), key, cfg.Compact)
+
+	discover := &stageTimings{name: "discover"}
+	collect := &stageTimings{name: "collect"}
+	appendStage := &stageTimings{name: "store-append"}
+	scan := &stageTimings{name: "store-scan"}
+	stages := []*stageTimings{discover, collect, appendStage}
+
+	opts := collectOptions{serverIdentity: identityMounted, mountTimeout: defaultMountTimeout}
+	for i := 0; i < iterations; i++ {
+		var mounts []nfsMount
+		if err := discover.measure(func() (err error) {
+			mounts, err = discoverMounts(cfg)
+			return err
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting NFS mounts: %v\n", err)
+			os.Exit(1)
+		}
+
+		var entry UsageEntry
+		collect.measure(func() error {
+			entry, _ = collectEntry(mounts, cfg, opts)
+			return nil
+		})
+
+		if err := appendStage.measure(func() error {
+			_, err := appendEntry(scratch, entry, key, true)
+			return err
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if filePath != "" {
+		st := store.Open(filePath, key, cfg.Compact)
+		count := 0
+		for i := 0; i < iterations; i++ {
+			if err := scan.measure(func() (err error) {
+				_, count, err = lastEntry(st)
+				return err
+			}); err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", filePath, err)
+				os.Exit(1)
+			}
+		}
+		scan.name = fmt.Sprintf("store-scan (%d entries)", count)
+		stages = append(stages, scan)
+	}
+
+	nameWidth := len("Stage")
+	for _, s := range stages {
+		if len(s.name) > nameWidth {
+			nameWidth = len(s.name)
+		}
+	}
+	fmt.Printf("%-*s  %10s  %10s  %10s\n", nameWidth, "Stage", "Min", "Avg", "Max")
+	for _, s := range stages {
+		min, avg, max := s.stats()
+		fmt.Printf("%-*s  %10s  %10s  %10s\n", nameWidth, s.name, min.Round(time.Microsecond), avg.Round(time.Microsecond), max.Round(time.Microsecond))
+	}
+}
+
+// startPprof serves the net/http/pprof handlers on addr in the background
+func startPprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving pprof: %v\n", err)
+		}
+	}()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/report"
+)
+
+// serverOutput groups the table output by NFS server, set from --by-server
+var serverOutput bool
+
+// rowHeading heads the first column of the usage tables
+func rowHeading() string {
+	if serverOutput {
+		return "Server"
+	}
+	return "Mountpoint"
+}
+
+// unknownServer groups mounts whose detail records no server
+const unknownServer = "(unknown)"
+
+// mountServer returns the server a mount is grouped under: its normalized
+// identity, else the host of its device
+func mountServer(detail MountDetail) string {
+	if detail.Server != "" {
+		return detail.Server
+	}
+	if detail.Device != "" {
+		return collector.ServerHost(detail.Device)
+	}
+	return unknownServer
+}
+
+// groupByServer folds an entry's mounts into one row per NFS server. Mounts
+// of the same filesystem (same fsid, or same export when it is unknown) are
+// counted once, since statfs reports the whole filesystem for each of them,
+// so the total can be less than the entry's. Free space and size are only
+// summed for servers where every filesystem reports them. Mounts recorded
+// before their device was take it from known, e.g. the current entry.
+func groupByServer(entry UsageEntry, known map[string]MountDetail) UsageEntry {
+	grouped := UsageEntry{
+		Timestamp: entry.Timestamp,
+		Host:      entry.Host,
+		Mounts:    make(map[string]int64),
+		Details:   make(map[string]MountDetail),
+		Partial:   entry.Partial,
+		Missing:   entry.Missing,
+		Absent:    entry.Absent,
+		Stale:     entry.Stale,
+	}
+	seen := make(map[string]bool)
+	for mount, used := range entry.Mounts {
+		detail := entry.Details[mount]
+		if detail.Server == "" && detail.Device == "" {
+			detail.Server, detail.Device = known[mount].Server, known[mount].Device
+		}
+		server := mountServer(detail)
+		fs := detail.FSID
+		if fs == "" {
+			fs = detail.Device
+		}
+		if fs == "" {
+			fs = mount
+		}
+		if seen[server+"\x00"+fs] {
+			continue
+		}
+		seen[server+"\x00"+fs] = true
+
+		row, ok := grouped.Details[server]
+		if !ok {
+			row = MountDetail{Server: server, Available: new(int64), Size: new(int64), ReadOnly: true}
+		}
+		grouped.Mounts[server] += used
+		grouped.Total += used
+		row.ReadOnly = row.ReadOnly && detail.ReadOnly
+		if row.Available != nil && detail.Available != nil && !detail.Elastic {
+			*row.Available += *detail.Available
+		} else {
+			row.Available = nil
+		}
+		if capacity, ok := report.CapacityBytes(entry, mount); ok && row.Size != nil && !detail.Elastic {
+			*row.Size += capacity
+		} else {
+			row.Size = nil
+		}
+		if detail.Inodes != nil {
+			if row.Inodes == nil {
+				row.Inodes = &InodeUsage{}
+			}
+			row.Inodes.Used += detail.Inodes.Used
+			row.Inodes.Free += detail.Inodes.Free
+		}
+		grouped.Details[server] = row
+	}
+	return grouped
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/report"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// Check states, ordered by severity, with the Nagios plugin exit codes
+const (
+	checkOK = iota
+	checkWarning
+	checkCritical
+	checkUnknown
+)
+
+var checkStateNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// fullness is a --warn or --crit threshold: a percentage used such as 80%,
+// or a byte size such as 50GiB meaning at most that much free, as check_disk
+type fullness struct {
+	pct  float64
+	free int64
+}
+
+func (f *fullness) String() string {
+	if f.free > 0 {
+		return formatBytes(f.free)
+	}
+	return fmt.Sprintf("%g%%", f.pct)
+}
+
+func (f *fullness) Set(value string) error {
+	if pct, ok := strings.CutSuffix(value, "%"); ok || strings.IndexFunc(value, unicode.IsLetter) < 0 {
+		n, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+		if err != nil || n < 0 || n > 100 {
+			return fmt.Errorf("invalid percentage %q", value)
+		}
+		*f = fullness{pct: n}
+		return nil
+	}
+	free, err := report.ParseSize(value)
+	if err != nil {
+		return err
+	}
+	*f = fullness{free: free}
+	return nil
+}
+
+// exceeded reports whether a mount is past the threshold, with the reason
+func (f fullness) exceeded(entry UsageEntry, mount string) (string, bool) {
+	if f.pct > 0 {
+		if pct, ok := report.UsedPercent(entry, mount); ok && pct >= f.pct {
+			return fmt.Sprintf("%.0f%% used", pct), true
+		}
+	}
+	if avail := entry.Details[mount].Available; f.free > 0 && avail != nil && *avail <= f.free {
+		return fmt.Sprintf("%s free", formatBytes(*avail)), true
+	}
+	return "", false
+}
+
+// CheckConfig holds defaults for the check subcommand, --warn and --crit on
+// the command line win over them
+type CheckConfig struct {
+	// Warn and Crit take the same values as --warn and --crit, e.g. 80% or 50GiB
+	Warn string `yaml:"warn"`
+	Crit string `yaml:"crit"`
+}
+
+// checkThresholds are the limits check compares each mount against, zero disables one
+type checkThresholds struct {
+	warn, crit             fullness
+	growthWarn, growthCrit int64
+	// fullWarn and fullCrit alert when the forecast says a mount fills within them
+	fullWarn, fullCrit time.Duration
+	minFit             float64
+}
+
+// mountCheck is the outcome for one mount
+type mountCheck struct {
+	mount  string
+	state  int
+	reason string
+	// ignored mounts are reported but never raise the status (config no_alert)
+	ignored bool
+}
+
+// noAlert reports whether the mount is monitored but never alerts on
+func (c *Config) noAlert(mount nfsMount) bool {
+	for _, pattern := range c.NoAlert {
+		if matchesMount(pattern, mount) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateChecks compares the newest entry against the thresholds, using base
+// for growth (nil skips growth checks) and forecasts for the time until full
+// (nil skips forecast checks)
+func evaluateChecks(entry UsageEntry, base *UsageEntry, forecasts map[string]mountForecast, cfg *Config, t checkThresholds) []mountCheck {
+	var checks []mountCheck
+	for mount, used := range entry.Mounts {
+		if collector.IsSnapshotMount(mount) {
+			continue
+		}
+		detail := entry.Details[mount]
+		c := mountCheck{mount: mount, ignored: cfg.noAlert(nfsMount{MountPoint: mount, Device: detail.Device})}
+		raise := func(state int, format string, args ...interface{}) {
+			if state > c.state {
+				c.state, c.reason = state, fmt.Sprintf(format, args...)
+			}
+		}
+
+		if reason, ok := t.crit.exceeded(entry, mount); ok {
+			raise(checkCritical, "%s", reason)
+		} else if reason, ok := t.warn.exceeded(entry, mount); ok {
+			raise(checkWarning, "%s", reason)
+		}
+		// Read-only mounts can't grow from here, growth is the server's business
+		if old, ok := base.MountBytes(mount); ok && !detail.ReadOnly {
+			growth := used - old
+			switch {
+			case t.growthCrit > 0 && growth >= t.growthCrit:
+				raise(checkCritical, "grew %s", formatDiff(growth))
+			case t.growthWarn > 0 && growth >= t.growthWarn:
+				raise(checkWarning, "grew %s", formatDiff(growth))
+			}
+		}
+		if f, ok := forecasts[mount]; ok && !detail.ReadOnly {
+			if days, ok := f.daysToFull(time.Unix(entry.Timestamp, 0), t.minFit); ok {
+				left := time.Duration(days * 24 * float64(time.Hour))
+				switch {
+				case t.fullCrit > 0 && left <= t.fullCrit:
+					raise(checkCritical, "full in %.0fd (forecast)", days)
+				case t.fullWarn > 0 && left <= t.fullWarn:
+					raise(checkWarning, "full in %.0fd (forecast)", days)
+				}
+			}
+		}
+		checks = append(checks, c)
+	}
+	sort.Slice(checks, func(i, j int) bool { return checks[i].mount < checks[j].mount })
+	return checks
+}
+
+// checkStatus is the overall state, the worst of the mounts that may alert
+func checkStatus(checks []mountCheck) int {
+	status := checkOK
+	for _, c := range checks {
+		if !c.ignored && c.state > status {
+			status = c.state
+		}
+	}
+	return status
+}
+
+// checkPerfdata returns the performance data of a mount: percent used, and
+// bytes free when a threshold is a size, with the thresholds as Nagios ranges
+func checkPerfdata(entry UsageEntry, mount string, t checkThresholds) []string {
+	var perf []string
+	if pct, ok := report.UsedPercent(entry, mount); ok {
+		perf = append(perf, fmt.Sprintf("'%s'=%.1f%%;%s;%s;0;100", mount, pct, pctRange(t.warn), pctRange(t.crit)))
+	}
+	if avail := entry.Details[mount].Available; avail != nil && (t.warn.free > 0 || t.crit.free > 0) {
+		perf = append(perf, fmt.Sprintf("'%s free'=%dB;%s;%s;0", mount, *avail, freeRange(t.warn), freeRange(t.crit)))
+	}
+	return perf
+}
+
+// pctRange and freeRange render a threshold as a Nagios range, empty when
+// the threshold is of the other kind: percent alerts above, free below
+func pctRange(f fullness) string {
+	if f.pct > 0 {
+		return fmt.Sprintf("%g", f.pct)
+	}
+	return ""
+}
+
+func freeRange(f fullness) string {
+	if f.free > 0 {
+		return fmt.Sprintf("%d:", f.free)
+	}
+	return ""
+}
+
+// runCheck implements the check subcommand, a Nagios/Icinga compatible plugin
+// evaluating the newest recorded entry. It exits 0 OK, 1 WARNING, 2 CRITICAL
+// or 3 UNKNOWN.
+func runCheck(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	var filePath, configPath, keyFile, growthWarn, growthCrit, fullWarn, fullCrit, forecastWindow string
+	var minFit float64
+	warn, crit := fullness{pct: 85}, fullness{pct: 95}
+	var growthWindow, maxAge time.Duration
+	fs.StringVar(&filePath, "file", "", "Path to JSON file for storing usage data (default: config file, else CWD/nfsusage.json)")
+	fs.StringVar(&filePath, "f", "", "Path to JSON file for storing usage data (shorthand)")
+	fs.StringVar(&configPath, "config", "", "Path to YAML config file")
+	fs.StringVar(&keyFile, "key-file", "", "Path to AES-256 key for the data file")
+	fs.Var(&warn, "warn", "WARNING at this percent used, e.g. 80%, or with this much free, e.g. 50GiB (0 disables, default: config check.warn or 85%)")
+	fs.Var(&crit, "crit", "CRITICAL at this percent used, e.g. 95%, or with this much free, e.g. 10GiB (0 disables, default: config check.crit or 95%)")
+	fs.StringVar(&growthWarn, "growth-warn", "", "Growth over --growth-window for WARNING, e.g. 100GiB")
+	fs.StringVar(&growthCrit, "growth-crit", "", "Growth over --growth-window for CRITICAL, e.g. 500GiB")
+	fs.DurationVar(&growthWindow, "growth-window", 24*time.Hour, "Window for --growth-warn and --growth-crit")
+	fs.StringVar(&fullWarn, "full-warn", "", "WARNING when the forecast says a mount fills within this, e.g. 30d")
+	fs.StringVar(&fullCrit, "full-crit", "", "CRITICAL when the forecast says a mount fills within this, e.g. 7d")
+	fs.StringVar(&forecastWindow, "forecast-window", "30d", "History the --full-warn and --full-crit forecasts are fit to")
+	fs.Float64Var(&minFit, "min-fit", defaultMinFit, "Ignore forecasts whose R² is below this")
+	fs.DurationVar(&maxAge, "max-age", time.Hour, "UNKNOWN when the newest entry is older than this")
+	fs.Parse(args)
+
+	unknown := func(format string, args ...interface{}) {
+		fmt.Printf("NFSUSAGE UNKNOWN - %s\n", fmt.Sprintf(format, args...))
+		os.Exit(checkUnknown)
+	}
+	t := checkThresholds{warn: warn, crit: crit, minFit: minFit}
+	var err error
+	if fullWarn != "" {
+		if t.fullWarn, err = parseAge(fullWarn); err != nil {
+			unknown("--full-warn: %v", err)
+		}
+	}
+	if fullCrit != "" {
+		if t.fullCrit, err = parseAge(fullCrit); err != nil {
+			unknown("--full-crit: %v", err)
+		}
+	}
+	window, err := parseAge(forecastWindow)
+	if err != nil {
+		unknown("--forecast-window: %v", err)
+	}
+	if growthWarn != "" {
+		if t.growthWarn, err = report.ParseSize(growthWarn); err != nil {
+			unknown("--growth-warn: %v", err)
+		}
+	}
+	if growthCrit != "" {
+		if t.growthCrit, err = report.ParseSize(growthCrit); err != nil {
+			unknown("--growth-crit: %v", err)
+		}
+	}
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		unknown("loading config: %v", err)
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if cfg.Check.Warn != "" && !set["warn"] {
+		if err := t.warn.Set(cfg.Check.Warn); err != nil {
+			unknown("config check.warn: %v", err)
+		}
+	}
+	if cfg.Check.Crit != "" && !set["crit"] {
+		if err := t.crit.Set(cfg.Check.Crit); err != nil {
+			unknown("config check.crit: %v", err)
+		}
+	}
+	key, err := loadKey(keyFile, cfg.Encryption)
+	if err != nil {
+		unknown("loading encryption key: %v", err)
+	}
+	filePath = cfg.dataFile(filePath)
+
+	st, err := openSeriesStore(filePath, key, cfg.Compact)
+	if err != nil {
+		unknown("loading remap rules: %v", err)
+	}
+	newest, _, err := lastEntry(st)
+	if err != nil {
+		unknown("loading data: %v", err)
+	}
+	if newest == nil {
+		unknown("no entries recorded in %s", filePath)
+	}
+	if age := timeSource.Now().Sub(time.Unix(newest.Timestamp, 0)); maxAge > 0 && age > maxAge {
+		unknown("newest entry is %s old", age.Round(time.Second))
+	}
+
+	var base *UsageEntry
+	if t.growthWarn > 0 || t.growthCrit > 0 {
+		from := time.Unix(newest.Timestamp, 0).Add(-growthWindow).Unix()
+		err := store.ScanRange(st, from, 0, func(e UsageEntry) error {
+			base = &e
+			return store.ErrStopScan
+		})
+		if err = store.IgnoreStop(err); err != nil {
+			unknown("loading data: %v", err)
+		}
+	}
+
+	var forecasts map[string]mountForecast
+	if t.fullWarn > 0 || t.fullCrit > 0 {
+		from := time.Unix(newest.Timestamp, 0).Add(-window).Unix()
+		if forecasts, err = forecastMounts(st, from, cfg); err != nil {
+			unknown("forecasting: %v", err)
+		}
+	}
+
+	checks := evaluateChecks(*newest, base, forecasts, cfg, t)
+	status := checkStatus(checks)
+	var problems, perfdata []string
+	ignored := 0
+	for _, c := range checks {
+		if c.ignored {
+			ignored++
+		} else if c.state != checkOK {
+			problems = append(problems, fmt.Sprintf("%s %s", c.mount, c.reason))
+		}
+		perfdata = append(perfdata, checkPerfdata(*newest, c.mount, t)...)
+	}
+
+	summary := fmt.Sprintf("%d mounts ok", len(checks)-len(problems)-ignored)
+	if len(problems) > 0 {
+		summary = strings.Join(problems, ", ")
+	}
+	if ignored > 0 {
+		summary += fmt.Sprintf(" (%d not alerting)", ignored)
+	}
+	fmt.Printf("NFSUSAGE %s - %s | %s\n", checkStateNames[status], summary, strings.Join(perfdata, " "))
+	os.Exit(status)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// fakeTimeEnvVar fixes the time source to an RFC 3339 time, for simulating
+// history and for reproducible output
+const fakeTimeEnvVar = "NFSUSAGE_FAKE_TIME"
+
+// clock is the source of the current time for entry timestamps, report
+// windows, sink retention and comparisons. Durations such as deadlines and
+// the monotonic elapsed time between samples always use the real clock.
+type clock interface {
+	Now() time.Time
+}
+
+// systemClock is the real wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// fakeClock returns a set time that only moves when advanced
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func newFakeClock(t time.Time) *fakeClock {
+	return &fakeClock{t: t}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// Set moves the clock to t
+func (c *fakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	c.t = t
+	c.mu.Unlock()
+}
+
+// Advance moves the clock forward by d
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+// timeSource is the clock used throughout, the real one unless
+// NFSUSAGE_FAKE_TIME is set
+var timeSource clock = systemClock{}
+
+// initTimeSource applies NFSUSAGE_FAKE_TIME
+func initTimeSource() error {
+	value := os.Getenv(fakeTimeEnvVar)
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fakeTimeEnvVar, err)
+	}
+	timeSource = newFakeClock(t)
+	return nil
+}
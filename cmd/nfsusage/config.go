@@ -0,0 +1,392 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/store"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigPath is read when no --config is given and it exists, so cron
+// jobs and units share one set of settings without repeating flags
+const defaultConfigPath = "/etc/nfsusage/config.yaml"
+
+// Config holds settings loaded from the YAML config file
+type Config struct {
+	// File is the data file used when --file is not given
+	File string `yaml:"file"`
+	// Output is the output format used when --output is not given
+	Output string `yaml:"output"`
+	// Check holds the thresholds of the check subcommand
+	Check CheckConfig `yaml:"check"`
+	// Include limits collection to mounts matching any of these patterns, globs
+	// or "re:" regular expressions on the mount point or server:/export
+	Include []string `yaml:"include"`
+	// Exclude skips mounts matching any of these patterns
+	Exclude []string    `yaml:"exclude"`
+	Quirks  []QuirkRule `yaml:"quirks"`
+	// NoAlert lists mounts that are monitored and reported but never change
+	// the status of the check subcommand, e.g. deliberately full archives
+	NoAlert []string `yaml:"no_alert"`
+	// Require lists mount points (or patterns) that must always be mounted
+	Require []string `yaml:"require"`
+	// ServerIdentity selects how servers are normalized: mounted, ip or rdns
+	ServerIdentity string `yaml:"server_identity"`
+	// Latency holds per-pattern probe latency SLOs
+	Latency []LatencyRule `yaml:"latency"`
+	// Aliases maps mount or export paths to names used by --redact-paths alias
+	Aliases map[string]string `yaml:"aliases"`
+	// RedactSalt is mixed into hashed paths so they can't be brute forced from common names
+	RedactSalt string `yaml:"redact_salt"`
+	// Compact delta-encodes entries in .jsonl data files
+	Compact bool `yaml:"compact"`
+	// Locale renders numbers and dates in tables and reports as in this locale,
+	// e.g. de_DE or auto, as --locale
+	Locale string `yaml:"locale"`
+	// MountStats records NFS operation counters with every entry, as --mountstats
+	MountStats bool `yaml:"mountstats"`
+	// Sinks receive every recorded entry in addition to the history file
+	Sinks []SinkConfig `yaml:"sinks"`
+	// QueueDir buffers entries for unreachable push sinks on disk until they
+	// recover. Without it undelivered entries are only retried within one
+	// daemon, a one-shot run loses them when it exits.
+	QueueDir string `yaml:"queue_dir"`
+	// Schedules are the cron schedules used in daemon mode, each a cron
+	// expression or a job such as {cron: "0 2 * * *", dirs: true}
+	Schedules []ScheduleConfig `yaml:"schedules"`
+	// Interval samples at a fixed interval in daemon mode, e.g. 5m, alongside any schedules
+	Interval string `yaml:"interval"`
+	// Generations keeps daily copies of the data file as <file>.1 to <file>.N
+	Generations int `yaml:"generations"`
+	// BackupCommand runs daily and before rewrites with NFSUSAGE_FILE set to
+	// the data file, e.g. to copy it off the host
+	BackupCommand string `yaml:"backup_command"`
+	// Retain prunes entries older than this on write, e.g. 90d
+	Retain string `yaml:"retain"`
+	// MaxEntries prunes the oldest entries beyond this count on write
+	MaxEntries int `yaml:"max_entries"`
+	// DeadAfter retires mounts not measured for this long, e.g. 30d, so they
+	// stop being exported as metrics; 0 never retires them
+	DeadAfter string `yaml:"dead_after"`
+	// ArchiveDead moves the csv and rrd sink files of retired mounts into an
+	// archive subdirectory of the sink
+	ArchiveDead bool `yaml:"archive_dead"`
+	// Downsample keeps one entry per day for entries older than this, e.g. 7d
+	Downsample string `yaml:"downsample"`
+	// Policies are automated responses evaluated by the daemon after each collection
+	Policies []PolicyConfig `yaml:"policies"`
+	// Limits bounds concurrency, file descriptors, memory and collection time
+	Limits LimitsConfig `yaml:"limits"`
+	// Encryption configures AES-GCM encryption of the history store
+	Encryption EncryptionConfig `yaml:"encryption"`
+	// MeasureTrash measures trash and quarantine directories with du on every
+	// collection, which is slow on large trees
+	MeasureTrash bool `yaml:"measure_trash"`
+	// Trash lists trash and quarantine directory globs relative to each mount,
+	// default .Trash* and lost+found
+	Trash []string `yaml:"trash"`
+	// DirMounts turns on directory mode for matching mounts: each top-level
+	// directory is measured with du and recorded, for report --drilldown
+	DirMounts []string `yaml:"dir_mounts"`
+	// Snapshots enables snapshot space measurement and reconciliation per mount
+	Snapshots []SnapshotRule `yaml:"snapshots"`
+	// BackupWindows are the daily backup windows used by report --backup
+	BackupWindows []BackupWindow `yaml:"backup_windows"`
+	// Forecast selects the forecast subcommand's model per mount pattern
+	Forecast []ForecastRule `yaml:"forecast"`
+	// ReportPresets adds or overrides report --preset bundles by name
+	ReportPresets map[string]ReportPreset `yaml:"report_presets"`
+	// Tenants label mounts on the fleet server and scope read tokens to them
+	Tenants []TenantConfig `yaml:"tenants"`
+	// Ganesha lists NFS-Ganesha servers queried over dbus with --ganesha
+	Ganesha []GaneshaServer `yaml:"ganesha"`
+}
+
+// ScheduleConfig is a daemon schedule and the job it runs, by default a full
+// collection as configured
+type ScheduleConfig struct {
+	Cron string `yaml:"cron"`
+	// Mounts limits the job to mounts matching these patterns, e.g. an hourly
+	// capacity check of a few exports. The other mounts keep their last
+	// recorded usage in the job's entry.
+	Mounts []string `yaml:"mounts"`
+	// Dirs makes the job a scan of the top-level directories of its mounts,
+	// e.g. nightly for report --drilldown. It adds no entry of its own; the
+	// newest scan is recorded with the following collections.
+	Dirs bool `yaml:"dirs"`
+}
+
+// UnmarshalYAML accepts a bare cron expression as a full collection
+func (s *ScheduleConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*s = ScheduleConfig{}
+		return node.Decode(&s.Cron)
+	}
+	type plain ScheduleConfig
+	return node.Decode((*plain)(s))
+}
+
+// QuirkRule applies filesystem quirks to mounts whose mount point or device matches Pattern
+type QuirkRule struct {
+	Pattern string `yaml:"pattern"`
+	// Elastic marks filesystems that report fake/elastic capacity, so only
+	// used bytes are meaningful and capacity/percent values are suppressed
+	Elastic bool `yaml:"elastic"`
+}
+
+// loadConfig reads the YAML config file. An empty path reads
+// defaultConfigPath if it exists and yields an empty config otherwise.
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		if _, err := os.Stat(defaultConfigPath); err != nil {
+			return cfg, nil
+		}
+		path = defaultConfigPath
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// dataFile returns the data file to use: --file when given, then the
+// config's file, then nfsusage.json in the current directory
+func (c *Config) dataFile(filePath string) string {
+	if filePath != "" {
+		return filePath
+	}
+	if c.File != "" {
+		return c.File
+	}
+	return defaultFilePath()
+}
+
+// sampleInterval parses interval, zero when unset
+func (c *Config) sampleInterval() (time.Duration, error) {
+	if c.Interval == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.Interval)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid interval %q", c.Interval)
+	}
+	return d, nil
+}
+
+// backupPolicy returns the data file backups to take, generations from the
+// command line override the config when set
+func (c *Config) backupPolicy(generations int) backupPolicy {
+	if generations == 0 {
+		generations = c.Generations
+	}
+	return backupPolicy{generations: generations, command: c.BackupCommand}
+}
+
+// retentionPolicy returns the history retention, flags from the command line
+// override the config when set
+func (c *Config) retentionPolicy(retain, downsample string, maxEntries int) (store.RetentionPolicy, error) {
+	if retain == "" {
+		retain = c.Retain
+	}
+	if downsample == "" {
+		downsample = c.Downsample
+	}
+	if maxEntries == 0 {
+		maxEntries = c.MaxEntries
+	}
+	p := store.RetentionPolicy{MaxEntries: maxEntries}
+	if maxEntries < 0 {
+		return p, fmt.Errorf("max_entries must not be negative")
+	}
+	var err error
+	if retain != "" {
+		if p.MaxAge, err = parseAge(retain); err != nil || p.MaxAge < 0 {
+			return p, fmt.Errorf("invalid retain %q", retain)
+		}
+	}
+	if downsample != "" {
+		if p.DownsampleAfter, err = parseAge(downsample); err != nil || p.DownsampleAfter < 0 {
+			return p, fmt.Errorf("invalid downsample %q", downsample)
+		}
+	}
+	return p, nil
+}
+
+// deadMountPolicy returns when mounts are retired, --dead-after overrides the
+// config when set
+func (c *Config) deadMountPolicy(deadAfter string) (deadMountPolicy, error) {
+	if deadAfter == "" {
+		deadAfter = c.DeadAfter
+	}
+	p := deadMountPolicy{after: defaultDeadAfter}
+	if deadAfter != "" {
+		after, err := parseAge(deadAfter)
+		if err != nil || after < 0 {
+			return p, fmt.Errorf("invalid dead_after %q", deadAfter)
+		}
+		p.after = after
+	}
+	if c.ArchiveDead {
+		for _, sc := range c.Sinks {
+			switch sc.Type {
+			case "csv":
+				p.archive = append(p.archive, sinkFiles{sc.Path, ".csv"})
+			case "rrd":
+				p.archive = append(p.archive, sinkFiles{sc.Path, ".rrd"})
+			}
+		}
+	}
+	return p, nil
+}
+
+// regexPrefix marks a mount pattern as a regular expression instead of a glob
+const regexPrefix = "re:"
+
+// mountRegexps caches compiled regex patterns, they are matched per mount on
+// every collection
+var mountRegexps sync.Map
+
+// compilePattern returns the regular expression of a "re:" pattern, or nil
+// for a glob
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	expr, ok := strings.CutPrefix(pattern, regexPrefix)
+	if !ok {
+		return nil, nil
+	}
+	if re, ok := mountRegexps.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	mountRegexps.Store(expr, re)
+	return re, nil
+}
+
+// validatePattern checks a mount pattern, a glob or a "re:" regex
+func validatePattern(pattern string) error {
+	if strings.HasPrefix(pattern, regexPrefix) {
+		_, err := compilePattern(pattern)
+		return err
+	}
+	_, err := filepath.Match(pattern, "")
+	return err
+}
+
+// matchesMount reports whether a pattern matches the mount point or device
+// (server:/export). Patterns are globs, or regular expressions after "re:",
+// which match anywhere unless anchored.
+func matchesMount(pattern string, mount nfsMount) bool {
+	if re, err := compilePattern(pattern); re != nil || err != nil {
+		return re != nil && (re.MatchString(mount.MountPoint) || re.MatchString(mount.Device))
+	}
+	if ok, _ := filepath.Match(pattern, mount.MountPoint); ok {
+		return true
+	}
+	ok, _ := filepath.Match(pattern, mount.Device)
+	return ok
+}
+
+// filterMounts applies the include and exclude patterns to discovered mounts
+func (c *Config) filterMounts(mounts []nfsMount) []nfsMount {
+	var filtered []nfsMount
+	for _, mount := range mounts {
+		if c.wantMount(mount) {
+			filtered = append(filtered, mount)
+		}
+	}
+	return filtered
+}
+
+// wantMount reports whether a mount passes the include and exclude patterns
+func (c *Config) wantMount(mount nfsMount) bool {
+	if len(c.Include) > 0 {
+		included := false
+		for _, pattern := range c.Include {
+			if matchesMount(pattern, mount) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, pattern := range c.Exclude {
+		if matchesMount(pattern, mount) {
+			return false
+		}
+	}
+	return true
+}
+
+// dirMode reports whether directory sizes are recorded for the mount
+func (c *Config) dirMode(mount nfsMount) bool {
+	for _, pattern := range c.DirMounts {
+		if matchesMount(pattern, mount) {
+			return true
+		}
+	}
+	return false
+}
+
+// absentMounts returns the required patterns that no discovered mount matches
+func (c *Config) absentMounts(mounts []nfsMount) []string {
+	var absent []string
+	for _, pattern := range c.Require {
+		found := false
+		for _, mount := range mounts {
+			if matchesMount(pattern, mount) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			absent = append(absent, pattern)
+		}
+	}
+	return absent
+}
+
+// isElastic reports whether a mount's capacity should be treated as fake/elastic.
+// EFS always is, other filesystems (e.g. some Ganesha exports) via quirk rules.
+func (c *Config) isElastic(mount nfsMount, provider *ProviderInfo) bool {
+	if provider != nil && provider.Name == "efs" {
+		return true
+	}
+	for _, rule := range c.Quirks {
+		if rule.Elastic && matchesMount(rule.Pattern, mount) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAge parses a duration that may also use d (days) and w (weeks) suffixes,
+// e.g. 90d or 2w, as well as anything time.ParseDuration accepts
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			value, err := strconv.ParseFloat(n, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(value * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/report"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// mountCoverage describes how well a mount is sampled over a window
+type mountCoverage struct {
+	mount      string
+	samples    int
+	first      int64
+	last       int64
+	gaps       int
+	gapSeconds int64
+	// coverage is the share of the window not lost to gaps, in percent
+	coverage float64
+}
+
+// collectCoverage counts samples and gaps per mount for entries at or after
+// from. Time before a mount first appears or after it disappears counts as
+// uncovered, so mounts added late or removed early show reduced coverage.
+func collectCoverage(st historyStore, from int64) ([]mountCoverage, error) {
+	interval, err := report.ExpectedInterval(st, from)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]*mountCoverage)
+	var start, end int64
+	err = store.ScanRange(st, from, 0, func(entry UsageEntry) error {
+		if start == 0 {
+			start = entry.Timestamp
+		}
+		end = entry.Timestamp
+		for mount := range entry.Mounts {
+			if collector.IsSnapshotMount(mount) {
+				continue
+			}
+			c := stats[mount]
+			if c == nil {
+				c = &mountCoverage{mount: mount, first: entry.Timestamp}
+				stats[mount] = c
+			} else if delta := entry.Timestamp - c.last; interval > 0 && delta > report.GapFactor*interval {
+				c.gaps++
+				c.gapSeconds += delta - interval
+			}
+			c.samples++
+			c.last = entry.Timestamp
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]mountCoverage, 0, len(stats))
+	for _, c := range stats {
+		if span := end - start; span > 0 {
+			uncovered := c.gapSeconds + (c.first - start) + (end - c.last)
+			c.coverage = float64(span-uncovered) / float64(span) * 100
+		} else {
+			c.coverage = 100
+		}
+		result = append(result, *c)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].mount < result[j].mount })
+	return result, nil
+}
+
+// printCoverageReport prints sample counts, gaps and coverage per mount so
+// trends computed from sparse data can be recognized as less trustworthy
+func printCoverageReport(stats []mountCoverage) {
+	mountWidth := len("Mountpoint")
+	for _, c := range stats {
+		if len(c.mount) > mountWidth {
+			mountWidth = len(c.mount)
+		}
+	}
+	fmt.Printf("%-*s  %7s  %-16s  %-16s  %4s  %10s  %8s\n", mountWidth, "Mountpoint", "Samples", "First", "Last", "Gaps", "Gap time", "Coverage")
+	fmt.Printf("%-*s  %7s  %-16s  %-16s  %4s  %10s  %8s\n", mountWidth, strings.Repeat("-", mountWidth), "-------", strings.Repeat("-", 16), strings.Repeat("-", 16), "----", "----------", "--------")
+	for _, c := range stats {
+		gapTime := "-"
+		if c.gapSeconds > 0 {
+			gapTime = (time.Duration(c.gapSeconds) * time.Second).Round(time.Minute).String()
+		}
+		fmt.Printf("%-*s  %7d  %-16s  %-16s  %4d  %10s  %7.1f%%\n", mountWidth, c.mount, c.samples,
+			formatDateTime(time.Unix(c.first, 0)), formatDateTime(time.Unix(c.last, 0)),
+			c.gaps, gapTime, c.coverage)
+	}
+}
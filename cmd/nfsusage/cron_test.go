@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	bits := func(values ...int) uint64 {
+		var b uint64
+		for _, v := range values {
+			b |= 1 << uint(v)
+		}
+		return b
+	}
+	tests := []struct {
+		field    string
+		min, max int
+		want     uint64
+		wantErr  bool
+	}{
+		{"*", 0, 5, bits(0, 1, 2, 3, 4, 5), false},
+		{"3", 0, 59, bits(3), false},
+		{"1,4,7", 0, 59, bits(1, 4, 7), false},
+		{"8-11", 0, 23, bits(8, 9, 10, 11), false},
+		{"*/15", 0, 59, bits(0, 15, 30, 45), false},
+		{"10-20/5", 0, 59, bits(10, 15, 20), false},
+		{"50/4", 0, 59, bits(50, 54, 58), false},
+		{"1-3,*/10", 0, 23, bits(0, 1, 2, 3, 10, 20), false},
+		{"7", 0, 7, bits(7), false},
+		{"60", 0, 59, 0, true},
+		{"0", 1, 31, 0, true},
+		{"5-2", 0, 59, 0, true},
+		{"*/0", 0, 59, 0, true},
+		{"*/x", 0, 59, 0, true},
+		{"a-3", 0, 59, 0, true},
+		{"1-b", 0, 59, 0, true},
+		{"", 0, 59, 0, true},
+		{"1,,2", 0, 59, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCronField(tt.field, tt.min, tt.max)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCronField(%q, %d, %d) error = %v, wantErr %v", tt.field, tt.min, tt.max, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseCronField(%q, %d, %d) = %b, want %b", tt.field, tt.min, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Saturday 2024-06-01 10:07 UTC
+	from := time.Date(2024, 6, 1, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		spec    string
+		want    time.Time
+		wantErr bool
+	}{
+		{"*/15 * * * *", time.Date(2024, 6, 1, 10, 15, 0, 0, time.UTC), false},
+		{"0 2 * * *", time.Date(2024, 6, 2, 2, 0, 0, 0, time.UTC), false},
+		{"30 8-18 * * 1-5", time.Date(2024, 6, 3, 8, 30, 0, 0, time.UTC), false},
+		{"0 0 * * 7", time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC), false},
+		// Day of month and day of week restricted: either matches
+		{"0 0 15 * 1", time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC), false},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC), false},
+		{"0 0 31 4 *", time.Time{}, false},
+		{"* * * *", time.Time{}, true},
+		{"61 * * * *", time.Time{}, true},
+		{"* 24 * * *", time.Time{}, true},
+		{"* * 0 * *", time.Time{}, true},
+		{"* * * 13 *", time.Time{}, true},
+		{"* * * * 8", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCron(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := c.next(from); !got.Equal(tt.want) {
+			t.Errorf("parseCron(%q).next(%s) = %s, want %s", tt.spec, from, got, tt.want)
+		}
+	}
+}
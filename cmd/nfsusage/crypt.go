@@ -0,0 +1,18 @@
+package main
+
+import "github.com/jessegalley/nfsusage/pkg/store"
+
+// EncryptionConfig selects where the AES-256 store key comes from
+type EncryptionConfig struct {
+	KeyFile string `yaml:"key_file"`
+	// KeyCommand is run through sh and must print the key, e.g. a KMS decrypt call
+	KeyCommand string `yaml:"key_command"`
+}
+
+// loadKey returns the store key from --key-file, else as the config says
+func loadKey(keyFile string, cfg EncryptionConfig) ([]byte, error) {
+	if keyFile == "" {
+		keyFile = cfg.KeyFile
+	}
+	return store.LoadKey(keyFile, cfg.KeyCommand)
+}
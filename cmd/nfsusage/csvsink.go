@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	csvHeader     = "timestamp,used,free"
+	csvDefaultMax = 1000
+)
+
+// csvSink keeps one small rolling CSV per mount for tools that only ingest
+// simple per-series files
+type csvSink struct {
+	dir     string
+	maxRows int
+}
+
+func (s csvSink) send(entry UsageEntry) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	maxRows := s.maxRows
+	if maxRows <= 0 {
+		maxRows = csvDefaultMax
+	}
+
+	ts := time.Unix(entry.Timestamp, 0).UTC().Format(time.RFC3339)
+	for mount, used := range entry.Mounts {
+		// free is left empty for elastic filesystems, their capacity is meaningless
+		free := ""
+		if avail := entry.Details[mount].Available; avail != nil {
+			free = fmt.Sprintf("%d", *avail)
+		}
+		row := fmt.Sprintf("%s,%d,%s", ts, used, free)
+		if err := appendCSVRow(filepath.Join(s.dir, mountFileName(mount, ".csv")), row, maxRows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mountFileNamer escapes the characters mountFileName gives a meaning
+var mountFileNamer = strings.NewReplacer("%", "%25", "_", "%5F", "/", "_")
+
+// mountFileName maps a mount point to a file name, e.g. /mnt/data ->
+// mnt_data.csv. Underscores and percent signs are percent-encoded so
+// /mnt/a_b (mnt_a%5Fb) and /mnt/a/b (mnt_a_b) get files of their own.
+func mountFileName(mount, ext string) string {
+	name := mountFileNamer.Replace(strings.Trim(mount, "/"))
+	if name == "" {
+		name = "root"
+	}
+	return name + ext
+}
+
+// appendCSVRow adds row to the file, keeping only the newest maxRows rows, and
+// rewrites it atomically so readers never see a partial file
+func appendCSVRow(path, row string, maxRows int) error {
+	var rows []string
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" && line != csvHeader {
+			rows = append(rows, line)
+		}
+	}
+	rows = append(rows, row)
+	if len(rows) > maxRows {
+		rows = rows[len(rows)-maxRows:]
+	}
+
+	tmp := path + ".tmp"
+	content := csvHeader + "\n" + strings.Join(rows, "\n") + "\n"
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// stringList is a flag.Value collecting repeated string flags
+type stringList []string
+
+func (s *stringList) String() string {
+	return fmt.Sprint(*s)
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// schedule decides when the daemon collects next
+type schedule interface {
+	next(t time.Time) time.Time
+}
+
+// intervalSchedule fires every interval, aligned to multiples of it so
+// samples from several hosts line up (5m fires at :00, :05, ...)
+type intervalSchedule struct {
+	every time.Duration
+}
+
+func (s intervalSchedule) next(t time.Time) time.Time {
+	return t.Truncate(s.every).Add(s.every)
+}
+
+// collectJob is what a scheduled collection measures, the zero job collects
+// every mount as configured
+type collectJob struct {
+	// mounts limits the job to matching mounts, like include
+	mounts []string
+	// dirs makes the job a directory scan of its mounts instead of a capacity
+	// sample, see scanDirs
+	dirs bool
+}
+
+// covers reports whether the job collects mount
+func (j collectJob) covers(mount nfsMount) bool {
+	if len(j.mounts) == 0 {
+		return true
+	}
+	for _, pattern := range j.mounts {
+		if matchesMount(pattern, mount) {
+			return true
+		}
+	}
+	return false
+}
+
+// jobMounts returns the mounts any of jobs collects, all of them without jobs
+func jobMounts(jobs []collectJob, mounts []nfsMount) []nfsMount {
+	if len(jobs) == 0 {
+		return mounts
+	}
+	var covered []nfsMount
+	for _, mount := range mounts {
+		for _, job := range jobs {
+			if job.covers(mount) {
+				covered = append(covered, mount)
+				break
+			}
+		}
+	}
+	return covered
+}
+
+// splitJobs separates the capacity jobs from the directory scans
+func splitJobs(jobs []collectJob) (capacity, scans []collectJob) {
+	for _, job := range jobs {
+		if job.dirs {
+			scans = append(scans, job)
+		} else {
+			capacity = append(capacity, job)
+		}
+	}
+	return capacity, scans
+}
+
+// carryMounts fills in the mounts a job left out from prev, the newest
+// stored entry, so they keep their last usage instead of showing up as
+// removed in comparisons, the latest cache, metrics and sinks
+func carryMounts(entry *UsageEntry, prev *UsageEntry, mounts, measured []nfsMount) {
+	if prev == nil {
+		return
+	}
+	done := make(map[string]bool, len(measured))
+	for _, mount := range measured {
+		done[mount.MountPoint] = true
+	}
+	for _, mount := range mounts {
+		mp := mount.MountPoint
+		if done[mp] {
+			continue
+		}
+		if used, ok := prev.Mounts[mp]; ok {
+			entry.Mounts[mp] = used
+			entry.Total += used
+			if detail, ok := prev.Details[mp]; ok {
+				// Directory sizes come from the newest scan instead
+				detail.Dirs = nil
+				entry.Details[mp] = detail
+			}
+		}
+		if used, ok := prev.Mounts[snapshotKey(mp)]; ok {
+			entry.Mounts[snapshotKey(mp)] = used
+		}
+		if reason, ok := prev.Unmeasured[mp]; ok {
+			if entry.Unmeasured == nil {
+				entry.Unmeasured = make(map[string]string)
+			}
+			entry.Unmeasured[mp] = reason
+		}
+	}
+}
+
+// jobSchedule is a schedule and the job it runs when it fires
+type jobSchedule struct {
+	schedule
+	job collectJob
+}
+
+// nextRun returns the earliest next fire time across all schedules and the
+// jobs of every schedule firing then
+func nextRun(schedules []jobSchedule, now time.Time) (time.Time, []collectJob) {
+	var earliest time.Time
+	var jobs []collectJob
+	for _, s := range schedules {
+		t := s.next(now)
+		if t.IsZero() {
+			continue
+		}
+		if earliest.IsZero() || t.Before(earliest) {
+			earliest, jobs = t, nil
+		}
+		if t.Equal(earliest) {
+			jobs = append(jobs, s.job)
+		}
+	}
+	return earliest, jobs
+}
+
+// parseSchedules parses cron schedules and adds a fixed sampling interval
+// when it is not 0, requiring at least one of them. The interval runs a
+// full collection.
+func parseSchedules(specs []ScheduleConfig, interval time.Duration) ([]jobSchedule, error) {
+	if len(specs) == 0 && interval == 0 {
+		return nil, fmt.Errorf("daemon mode requires --interval or at least one --schedule")
+	}
+	var schedules []jobSchedule
+	if interval != 0 {
+		if interval < time.Second {
+			return nil, fmt.Errorf("interval %s is shorter than 1s", interval)
+		}
+		schedules = append(schedules, jobSchedule{schedule: intervalSchedule{interval}})
+	}
+	for _, spec := range specs {
+		s, err := parseCron(spec.Cron)
+		if err != nil {
+			return nil, err
+		}
+		for _, pattern := range spec.Mounts {
+			if err := validatePattern(pattern); err != nil {
+				return nil, fmt.Errorf("schedule %q: mount pattern %q: %v", spec.Cron, pattern, err)
+			}
+		}
+		schedules = append(schedules, jobSchedule{s, collectJob{mounts: spec.Mounts, dirs: spec.Dirs}})
+	}
+	return schedules, nil
+}
+
+// scheduleFlags turns --schedule cron expressions into full collections
+func scheduleFlags(specs []string) []ScheduleConfig {
+	schedules := make([]ScheduleConfig, len(specs))
+	for i, spec := range specs {
+		schedules[i] = ScheduleConfig{Cron: spec}
+	}
+	return schedules
+}
+
+// daemonHooks are the actions triggered by the daemon loop
+type daemonHooks struct {
+	// collect runs the jobs of the schedules that fired, none for a full collection
+	collect func(jobs []collectJob)
+	// reload re-reads the config and returns the schedules to use from now on
+	reload func() ([]jobSchedule, error)
+	// shutdown runs once before the daemon exits, nil skips it
+	shutdown func()
+	// configChanged fires when the config file changes on disk, nil disables watching
+	configChanged <-chan struct{}
+}
+
+// watchFile polls path every interval and signals when its size or mtime changes.
+// Polling avoids an inotify dependency and also notices files replaced by
+// config management tools that write a new file and rename it into place.
+func watchFile(path string, interval time.Duration) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	go func() {
+		var lastMod time.Time
+		var lastSize int64
+		if info, err := os.Stat(path); err == nil {
+			lastMod, lastSize = info.ModTime(), info.Size()
+		}
+		for range time.Tick(interval) {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if info.ModTime().Equal(lastMod) && info.Size() == lastSize {
+				continue
+			}
+			lastMod, lastSize = info.ModTime(), info.Size()
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changed
+}
+
+// runDaemon calls collect whenever one of the schedules fires, until SIGTERM or
+// SIGINT. Schedules firing at the same time trigger a single collection
+// running all of their jobs.
+// Collections run on this goroutine, so a signal arriving mid-collection is
+// only handled once that entry has been recorded; shutdown then runs last.
+// SIGHUP forces an immediate out-of-schedule collection, SIGUSR1 or a change
+// of the config file reloads the config; a config that fails to load keeps
+// the previous one active.
+func runDaemon(schedules []jobSchedule, hooks daemonHooks) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGUSR1)
+
+	reload := func(reason string) {
+		reloaded, err := hooks.reload()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reloading config, keeping previous: %v\n", err)
+			return
+		}
+		schedules = reloaded
+		fmt.Fprintf(os.Stderr, "%s, config reloaded\n", reason)
+	}
+
+	for {
+		at, jobs := nextRun(schedules, time.Now())
+		if jobs == nil {
+			fmt.Fprintln(os.Stderr, "Error: no schedule will ever fire")
+			os.Exit(1)
+		}
+
+		timer := time.NewTimer(time.Until(at))
+		select {
+		case sig := <-signals:
+			timer.Stop()
+			switch sig {
+			case syscall.SIGHUP:
+				fmt.Fprintln(os.Stderr, "Received SIGHUP, collecting now")
+				hooks.collect(nil)
+			case syscall.SIGUSR1:
+				reload("Received SIGUSR1")
+			default:
+				fmt.Fprintf(os.Stderr, "Received %s, shutting down\n", sig)
+				if hooks.shutdown != nil {
+					hooks.shutdown()
+				}
+				return
+			}
+		case <-hooks.configChanged:
+			timer.Stop()
+			reload("Config file changed")
+		case <-timer.C:
+			hooks.collect(jobs)
+		}
+	}
+}
+
+// sampleClock measures the time between daemon samples on the monotonic
+// clock, which NTP steps and slews don't affect
+type sampleClock struct {
+	last time.Time
+	// written is the timestamp of the entry the daemon stored last
+	written int64
+}
+
+// elapsed returns the seconds since the daemon's last stored sample, 0 when
+// there is none or prevTS, the newest entry in the history, is not it: an
+// interval to another writer's entry can't be measured here.
+func (c *sampleClock) elapsed(now time.Time, prevTS int64) float64 {
+	if c.last.IsZero() || prevTS != c.written {
+		return 0
+	}
+	return now.Sub(c.last).Seconds()
+}
+
+// stored advances the clock to the sample started at now, stored as ts
+func (c *sampleClock) stored(now time.Time, ts int64) {
+	c.last, c.written = now, ts
+}
+
+// recordOptions controls how daemon collections are recorded
+type recordOptions struct {
+	allowRegression bool
+	// recordEmpty stores an empty entry when no mounts are found, so a broken
+	// automounter shows up in the history instead of as a silent gap
+	recordEmpty bool
+	clock       *sampleClock
+}
+
+// daemonCollect performs one collection in daemon mode for the jobs of the
+// schedules that fired, all mounts without jobs, reporting errors instead of
+// exiting so the next scheduled run still happens. Directory scans only
+// update the scans recorded with later entries. It returns the collected
+// entry, nil when there was nothing to collect.
+func daemonCollect(st historyStore, cfg *Config, opts collectOptions, key []byte, sinks []*sinkRunner, rec recordOptions, jobs []collectJob) *UsageEntry {
+	nfsMounts, err := discoverMounts(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting NFS mounts: %v\n", err)
+		finishRun(runFailed, exitFatal, 0, &DiscoveryError{err})
+		return nil
+	}
+	absent := cfg.absentMounts(nfsMounts)
+	if len(absent) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", &AbsentMountsError{absent})
+	}
+	capacity, scans := splitJobs(jobs)
+	if len(scans) > 0 {
+		if err := scanDirs(st.File(), key, jobMounts(scans, nfsMounts), opts.parallel); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Error saving directory scans: %v\n", err)
+		}
+		if len(capacity) == 0 {
+			finishRun(runOK, 0, 0, nil)
+			return nil
+		}
+	}
+	measured := jobMounts(capacity, nfsMounts)
+	if len(nfsMounts) == 0 {
+		fmt.Fprintln(os.Stderr, "No NFS mounts found")
+		if !rec.recordEmpty {
+			finishRun(runOK, 0, 0, nil)
+			return nil
+		}
+	} else if len(measured) == 0 {
+		fmt.Fprintln(os.Stderr, "No NFS mounts match the schedule")
+		finishRun(runOK, 0, 0, nil)
+		return nil
+	}
+
+	limit, _ := hardDeadline(cfg.Limits)
+	stopWatchdog := startWatchdog(limit)
+	start := time.Now()
+	entry, mountErrs := collectEntry(measured, cfg, opts)
+	prev, _, _ := lastEntry(st)
+	if len(measured) < len(nfsMounts) {
+		carryMounts(&entry, prev, nfsMounts, measured)
+	}
+	attachDirScans(&entry, readDirScans(st.File(), key))
+	if prev != nil {
+		entry.Elapsed = rec.clock.elapsed(start, prev.Timestamp)
+	}
+	entry.Absent = absent
+	_, err = appendEntry(st, entry, key, rec.allowRegression)
+	if err == nil {
+		rec.clock.stored(start, entry.Timestamp)
+	}
+	stopWatchdog()
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Error %v\n", err)
+		finishRun(runFailed, exitFatal, len(entry.Mounts), err)
+	case len(absent) > 0:
+		finishRun(runDegraded, 0, len(entry.Mounts), &AbsentMountsError{absent})
+	case len(mountErrs) > 0:
+		finishRun(runDegraded, 0, len(entry.Mounts), mountErrs[0])
+	default:
+		finishRun(runOK, 0, len(entry.Mounts), nil)
+	}
+	// Sinks still get the entry when the history file could not be written
+	deliverAll(sinks, entry)
+	fmt.Fprintf(os.Stderr, "Recorded %d mounts, total %s\n", len(entry.Mounts), formatBytes(entry.Total))
+	return &entry
+}
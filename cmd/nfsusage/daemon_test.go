@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNextRunJobs(t *testing.T) {
+	hourly, err := parseCron("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nightly, err := parseCron("0 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	capacity := collectJob{mounts: []string{"/home/*"}}
+	scan := collectJob{dirs: true}
+	schedules := []jobSchedule{{hourly, capacity}, {nightly, scan}}
+
+	tests := []struct {
+		now      time.Time
+		wantAt   time.Time
+		wantJobs []collectJob
+	}{
+		{time.Date(2024, 6, 1, 0, 30, 0, 0, time.UTC), time.Date(2024, 6, 1, 1, 0, 0, 0, time.UTC), []collectJob{capacity}},
+		{time.Date(2024, 6, 1, 1, 30, 0, 0, time.UTC), time.Date(2024, 6, 1, 2, 0, 0, 0, time.UTC), []collectJob{capacity, scan}},
+	}
+	for _, tt := range tests {
+		at, jobs := nextRun(schedules, tt.now)
+		if !at.Equal(tt.wantAt) || !reflect.DeepEqual(jobs, tt.wantJobs) {
+			t.Errorf("nextRun(%s) = %s, %+v, want %s, %+v", tt.now, at, jobs, tt.wantAt, tt.wantJobs)
+		}
+	}
+}
+
+func TestCarryMounts(t *testing.T) {
+	mounts := []nfsMount{{MountPoint: "/home/a"}, {MountPoint: "/scratch"}, {MountPoint: "/new"}}
+	prev := &UsageEntry{
+		Mounts: map[string]int64{"/home/a": 10, "/scratch": 100, "/scratch/.snapshot": 7, "/gone": 5},
+		Total:  115,
+		Details: map[string]MountDetail{
+			"/scratch": {Server: "filer01", Dirs: map[string]int64{"old": 1}},
+		},
+	}
+	tests := []struct {
+		name      string
+		jobs      []collectJob
+		wantMount map[string]int64
+		wantTotal int64
+	}{
+		{"full collection", nil, map[string]int64{"/home/a": 11, "/scratch": 101, "/new": 1}, 113},
+		{"home only", []collectJob{{mounts: []string{"/home/*"}}},
+			map[string]int64{"/home/a": 11, "/scratch": 100, "/scratch/.snapshot": 7}, 111},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			measured := jobMounts(tt.jobs, mounts)
+			entry := UsageEntry{Mounts: make(map[string]int64), Details: make(map[string]MountDetail)}
+			for _, mount := range measured {
+				used := prev.Mounts[mount.MountPoint] + 1
+				entry.Mounts[mount.MountPoint] = used
+				entry.Total += used
+			}
+			carryMounts(&entry, prev, mounts, measured)
+			if !reflect.DeepEqual(entry.Mounts, tt.wantMount) || entry.Total != tt.wantTotal {
+				t.Errorf("carried mounts %v total %d, want %v total %d", entry.Mounts, entry.Total, tt.wantMount, tt.wantTotal)
+			}
+			if d, ok := entry.Details["/scratch"]; len(tt.jobs) > 0 && (!ok || d.Server != "filer01" || d.Dirs != nil) {
+				t.Errorf("carried detail %+v, want the previous one without directory sizes", d)
+			}
+		})
+	}
+}
+
+func TestSampleClock(t *testing.T) {
+	var c sampleClock
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	if got := c.elapsed(start, 0); got != 0 {
+		t.Errorf("first sample: elapsed %v, want 0", got)
+	}
+	c.stored(start, 1000)
+
+	// a failed append doesn't advance the clock
+	if got := c.elapsed(start.Add(time.Minute), 1000); got != 60 {
+		t.Errorf("elapsed %v, want 60", got)
+	}
+	if got := c.elapsed(start.Add(2*time.Minute), 1000); got != 120 {
+		t.Errorf("after a failed append: elapsed %v, want 120", got)
+	}
+	c.stored(start.Add(2*time.Minute), 1120)
+
+	// another writer stored an entry since the daemon's last one
+	if got := c.elapsed(start.Add(3*time.Minute), 1150); got != 0 {
+		t.Errorf("after another writer: elapsed %v, want 0", got)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/report"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// Defaults bounding what one data API request may read
+const (
+	defaultMaxPoints = 10000
+	// defaultHistoryRange is the range served when the request gives no from
+	defaultHistoryRange = 24 * time.Hour
+)
+
+// apiLimits bound data API responses, so a careless dashboard query can't
+// pull a host's whole history into memory
+type apiLimits struct {
+	// maxRange is the widest from..to span one request may cover
+	maxRange time.Duration
+	// maxPoints caps the points of one page, and is the default page size
+	maxPoints int
+}
+
+// historyPoint is one entry, or the last entry of a step, in /api/v1/history
+type historyPoint struct {
+	Timestamp int64            `json:"timestamp"`
+	Total     int64            `json:"total"`
+	Mounts    map[string]int64 `json:"mounts"`
+}
+
+// historyPage is one page of /api/v1/history. NextFrom is set when the range
+// holds more points, request it as from to get the next page. Annotations are
+// those overlapping the whole from..to range, so every page carries them.
+type historyPage struct {
+	Host        string         `json:"host"`
+	From        int64          `json:"from"`
+	To          int64          `json:"to"`
+	Step        int64          `json:"step,omitempty"`
+	Points      []historyPoint `json:"points"`
+	NextFrom    int64          `json:"next_from,omitempty"`
+	Annotations []Annotation   `json:"annotations,omitempty"`
+}
+
+// parseAPITime parses a from or to parameter: unix seconds, RFC 3339, or an
+// age such as 7d before now
+func parseAPITime(value string, now time.Time) (int64, error) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Unix(), nil
+	}
+	if d, err := parseAge(value); err == nil && d >= 0 {
+		return now.Add(-d).Unix(), nil
+	}
+	return 0, fmt.Errorf("invalid time %q (want unix seconds, RFC 3339 or an age such as 7d)", value)
+}
+
+// parseHistoryQuery reads host, from, to, step and limit, enforcing limits
+func parseHistoryQuery(r *http.Request, limits apiLimits, now time.Time) (page historyPage, limit int, err error) {
+	q := r.URL.Query()
+	page.Host = q.Get("host")
+	if page.Host == "" {
+		return page, 0, fmt.Errorf("host is required")
+	}
+	page.To = now.Unix()
+	if v := q.Get("to"); v != "" {
+		if page.To, err = parseAPITime(v, now); err != nil {
+			return page, 0, fmt.Errorf("to: %v", err)
+		}
+	}
+	page.From = page.To - int64(min(defaultHistoryRange, limits.maxRange)/time.Second)
+	if v := q.Get("from"); v != "" {
+		if page.From, err = parseAPITime(v, now); err != nil {
+			return page, 0, fmt.Errorf("from: %v", err)
+		}
+	}
+	if page.From > page.To {
+		return page, 0, fmt.Errorf("from is after to")
+	}
+	if span := time.Duration(page.To-page.From) * time.Second; span > limits.maxRange {
+		return page, 0, fmt.Errorf("range of %s exceeds the maximum of %s, narrow from and to", span, limits.maxRange)
+	}
+	if v := q.Get("step"); v != "" {
+		step, err := parseAge(v)
+		if err != nil || step < time.Second {
+			return page, 0, fmt.Errorf("invalid step %q (want a duration of at least 1s, e.g. 1h or 1d)", v)
+		}
+		page.Step = int64(step / time.Second)
+	}
+	limit = limits.maxPoints
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			return page, 0, fmt.Errorf("invalid limit %q", v)
+		}
+		if limit > limits.maxPoints {
+			return page, 0, fmt.Errorf("limit %d exceeds the maximum of %d", limit, limits.maxPoints)
+		}
+	}
+	return page, limit, nil
+}
+
+// readHistory fills page with up to limit points between page.From and
+// page.To. With a step, each step-aligned bucket contributes its last entry,
+// its closing usage as in the monthly report.
+func readHistory(st historyStore, page *historyPage, limit int, tenant *TenantConfig) error {
+	page.Points = []historyPoint{}
+	var pending *historyPoint
+	bucket := int64(-1)
+	err := store.ScanRange(st, page.From, page.To, func(entry UsageEntry) error {
+		if entry.Timestamp < page.From || entry.Timestamp > page.To {
+			return nil
+		}
+		entry = restrictEntry(collector.WithoutSnapshots(entry), tenant)
+		point := historyPoint{Timestamp: entry.Timestamp, Total: entry.Total, Mounts: entry.Mounts}
+		if page.Step == 0 {
+			if len(page.Points) == limit {
+				page.NextFrom = entry.Timestamp
+				return store.ErrStopScan
+			}
+			page.Points = append(page.Points, point)
+			return nil
+		}
+		b := entry.Timestamp - entry.Timestamp%page.Step
+		if b != bucket {
+			if pending != nil {
+				page.Points = append(page.Points, *pending)
+			}
+			if len(page.Points) == limit {
+				page.NextFrom, pending = b, nil
+				return store.ErrStopScan
+			}
+			bucket = b
+		}
+		pending = &point
+		return nil
+	})
+	if pending != nil {
+		page.Points = append(page.Points, *pending)
+	}
+	return err
+}
+
+// handleHistory serves one host's entries over a time range as JSON, limited
+// to the mounts the caller's tenant may see. Ranges wider than --max-range
+// are refused and pages hold at most --max-points points; follow next_from
+// for the rest, or pass step to downsample on the server.
+func (s *fleetServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tenant, ok := s.authorizeRead(w, r)
+	if !ok {
+		return
+	}
+	page, limit, err := parseHistoryQuery(r, s.limits, timeSource.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	path := filepath.Join(s.dataDir, hostFileName(page.Host)+".jsonl")
+	if _, err := os.Stat(path); err != nil {
+		http.Error(w, "unknown host "+page.Host, http.StatusNotFound)
+		return
+	}
+	filter, err := report.ParseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		http.Error(w, "filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := readHistory(report.WithFilter(store.Open(path, nil, true), filter), &page, limit, tenant); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if page.Annotations, err = loadAnnotations(s.dataDir, page.Host, page.From, page.To, tenant); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range page.Points {
+		page.Points[i].Mounts = s.redact.mounts(page.Points[i].Mounts)
+	}
+	s.redactAnnotations(page.Annotations)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// paginate applies the limit and offset parameters of a list endpoint to n
+// rows, returning the slice bounds. The full count is sent as X-Total-Count.
+func (s *fleetServer) paginate(w http.ResponseWriter, r *http.Request, n int) (lo, hi int, ok bool) {
+	limit, offset := s.limits.maxPoints, 0
+	var err error
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > s.limits.maxPoints {
+			http.Error(w, fmt.Sprintf("invalid limit %q (1 to %d)", v, s.limits.maxPoints), http.StatusBadRequest)
+			return 0, 0, false
+		}
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			http.Error(w, fmt.Sprintf("invalid offset %q", v), http.StatusBadRequest)
+			return 0, 0, false
+		}
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(n))
+	lo = min(offset, n)
+	return lo, min(lo+limit, n), true
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/report"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// eventMountRetired is recorded when a mount hasn't been measured for
+// dead_after and is no longer exported
+const eventMountRetired = "mount_retired"
+
+// defaultDeadAfter is how long a mount that is no longer measured keeps being
+// exported as absent before it is retired
+const defaultDeadAfter = 30 * 24 * time.Hour
+
+// deadMountPolicy retires mounts that haven't been measured for a while, so
+// decommissioned exports stop showing up in metrics. Their entries stay in
+// the history and in reports.
+type deadMountPolicy struct {
+	// after retires a mount not measured for this long, 0 never does
+	after time.Duration
+	// archive lists per-mount sink files whose files are moved into an
+	// archive subdirectory when their mount is retired
+	archive []sinkFiles
+}
+
+// sinkFiles is a sink directory holding one file per mount
+type sinkFiles struct {
+	dir, ext string
+}
+
+// deadMounts is set from --dead-after, dead_after and archive_dead
+var deadMounts = deadMountPolicy{after: defaultDeadAfter}
+
+// sightingsPath returns the sidecar recording when each mount was last measured
+func sightingsPath(filePath string) string {
+	return filePath + ".seen"
+}
+
+// loadSightings returns when each mount of a store was last measured. A store
+// without the sidecar has none.
+func loadSightings(st historyStore) (map[string]int64, error) {
+	seen := make(map[string]int64)
+	data, err := os.ReadFile(sightingsPath(st.File()))
+	if os.IsNotExist(err) {
+		return seen, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if store.IsEncrypted(data) {
+		if data, err = store.Decrypt(st.EncryptionKey(), data); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(data, &seen); err != nil {
+		return nil, fmt.Errorf("%s: %v", sightingsPath(st.File()), err)
+	}
+	return seen, nil
+}
+
+// saveSightings replaces the sidecar, encrypted like the store it belongs to
+func saveSightings(st historyStore, seen map[string]int64) error {
+	data, err := json.Marshal(seen)
+	if err != nil {
+		return err
+	}
+	perm := os.FileMode(0644)
+	if key := st.EncryptionKey(); key != nil {
+		if data, err = store.Encrypt(key, data); err != nil {
+			return err
+		}
+		perm = 0600
+	}
+	return store.WriteFileAtomic(sightingsPath(st.File()), data, perm)
+}
+
+// trackMounts records the mounts measured in a newly stored entry and retires
+// those not measured within the policy. Stale mounts don't count as measured,
+// a hard mount of a decommissioned server hangs forever. Failures are only
+// warned about, like pruning they must not fail the write.
+func trackMounts(st historyStore, entry UsageEntry) {
+	seen, err := loadSightings(st)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error reading mount sightings: %v\n", err)
+		return
+	}
+	for mount := range collector.WithoutSnapshots(entry).Mounts {
+		seen[mount] = max(seen[mount], entry.Timestamp)
+	}
+	var retired []string
+	for mount, last := range seen {
+		if deadMounts.dead(last, entry.Timestamp) {
+			retired = append(retired, mount)
+			delete(seen, mount)
+		}
+	}
+	sort.Strings(retired)
+	for _, mount := range retired {
+		recordEvent(st.File(), eventMountRetired, "%s not measured for %s, no longer exported", mount, deadMounts.after)
+		deadMounts.archiveMount(mount)
+	}
+	if err := saveSightings(st, seen); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error recording mount sightings: %v\n", err)
+	}
+}
+
+// dead reports whether a mount last measured at last is retired by now
+func (p deadMountPolicy) dead(last, now int64) bool {
+	return p.after > 0 && now-last > int64(p.after/time.Second)
+}
+
+// archiveMount moves a retired mount's per-mount sink files into the sink's
+// archive subdirectory, so the live directory only holds current mounts
+func (p deadMountPolicy) archiveMount(mount string) {
+	for _, s := range p.archive {
+		name := mountFileName(mount, s.ext)
+		src := filepath.Join(s.dir, name)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		archive := filepath.Join(s.dir, "archive")
+		err := os.MkdirAll(archive, 0755)
+		if err == nil {
+			err = os.Rename(src, filepath.Join(archive, name))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: error archiving %s: %v\n", src, err)
+		}
+	}
+}
+
+// renderSightings renders the presence of every mount measured within the
+// dead mount policy: 1 when the newest entry measured it, 0 when it is gone
+// but not yet retired
+func renderSightings(seen map[string]int64, newest UsageEntry, redact *redactor, now time.Time) string {
+	mounts := make([]string, 0, len(seen))
+	for mount, last := range seen {
+		if !deadMounts.dead(last, now.Unix()) {
+			mounts = append(mounts, mount)
+		}
+	}
+	sort.Strings(mounts)
+
+	var b strings.Builder
+	b.WriteString("# HELP nfsusage_mount_present Whether the newest collection measured the mount, 0 until an unmeasured mount is retired after dead_after.\n")
+	b.WriteString("# TYPE nfsusage_mount_present gauge\n")
+	for _, mount := range mounts {
+		present := 0
+		if _, ok := newest.Mounts[mount]; ok {
+			present = 1
+		}
+		fmt.Fprintf(&b, "nfsusage_mount_present{mount=\"%s\"} %d\n", report.EscapeLabel(redact.path(mount)), present)
+	}
+	b.WriteString("# HELP nfsusage_mount_last_seen_timestamp_seconds Unix time the mount was last measured.\n")
+	b.WriteString("# TYPE nfsusage_mount_last_seen_timestamp_seconds gauge\n")
+	for _, mount := range mounts {
+		fmt.Fprintf(&b, "nfsusage_mount_last_seen_timestamp_seconds{mount=\"%s\"} %d\n", report.EscapeLabel(redact.path(mount)), seen[mount])
+	}
+	return b.String()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/report"
+)
+
+// backendClient is one host mounting a backend filesystem
+type backendClient struct {
+	Host     string `json:"host"`
+	Mount    string `json:"mount"`
+	Device   string `json:"device"`
+	LastSeen int64  `json:"last_seen"`
+}
+
+// backendRow is one backend filesystem in the fleet, counted once however
+// many hosts, exports or subdirectory mounts reach it
+type backendRow struct {
+	Server    string          `json:"server"`
+	FSID      string          `json:"fsid,omitempty"`
+	Export    string          `json:"export"`
+	UsedBytes int64           `json:"used_bytes"`
+	SizeBytes *int64          `json:"size_bytes,omitempty"`
+	Clients   []backendClient `json:"clients"`
+	// seen is when UsedBytes was measured, the newest observation wins
+	seen int64
+}
+
+// backendIdentity returns the server and the key identifying the filesystem
+// behind a mount. The statfs fsid identifies it across exports and
+// subdirectory mounts; entries recorded before fsids fall back to the export.
+// fsids are only unique per server, so the server address is part of the key.
+func backendIdentity(detail MountDetail) (server, key string) {
+	server = detail.ServerAddr
+	if server == "" {
+		server = detail.Server
+	}
+	if server == "" {
+		server = collector.ServerHost(detail.Device)
+	}
+	if detail.FSID != "" {
+		return server, server + "\x00fsid:" + detail.FSID
+	}
+	return server, server + "\x00export:" + exportPath(detail.Device)
+}
+
+// collectBackends groups the mounts in the newest entry of every host by
+// backend filesystem
+func collectBackends(dataDir string) ([]backendRow, error) {
+	stores, err := fleetStores(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	backends := make(map[string]*backendRow)
+	for host, st := range stores {
+		newest, _, err := lastEntry(st)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", host, err)
+		}
+		if newest == nil {
+			continue
+		}
+		entry := collector.WithoutSnapshots(*newest)
+		for mount, used := range entry.Mounts {
+			detail := entry.Details[mount]
+			server, key := backendIdentity(detail)
+			b := backends[key]
+			if b == nil {
+				b = &backendRow{Server: server, FSID: detail.FSID, Export: exportPath(detail.Device)}
+				backends[key] = b
+			}
+			b.Clients = append(b.Clients, backendClient{Host: host, Mount: mount, Device: detail.Device, LastSeen: entry.Timestamp})
+			if entry.Timestamp > b.seen {
+				b.seen, b.UsedBytes, b.SizeBytes = entry.Timestamp, used, nil
+				if size, ok := report.CapacityBytes(entry, mount); ok {
+					b.SizeBytes = &size
+				}
+			}
+		}
+	}
+
+	rows := make([]backendRow, 0, len(backends))
+	for _, b := range backends {
+		sort.Slice(b.Clients, func(i, j int) bool {
+			if b.Clients[i].Host != b.Clients[j].Host {
+				return b.Clients[i].Host < b.Clients[j].Host
+			}
+			return b.Clients[i].Mount < b.Clients[j].Mount
+		})
+		// Subdirectory mounts share the fsid, name the backend by its shortest export
+		for _, c := range b.Clients {
+			if export := exportPath(c.Device); len(export) < len(b.Export) {
+				b.Export = export
+			}
+		}
+		rows = append(rows, *b)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Server != rows[j].Server {
+			return rows[i].Server < rows[j].Server
+		}
+		return rows[i].Export < rows[j].Export
+	})
+	return rows, nil
+}
+
+// printBackends prints each backend once with its clients, and the fleet
+// total against the sum a per-host view would double count
+func printBackends(rows []backendRow) {
+	serverWidth, exportWidth := len("Server"), len("Export")
+	for _, r := range rows {
+		serverWidth = max(serverWidth, len(r.Server))
+		exportWidth = max(exportWidth, len(r.Export))
+	}
+	fmt.Printf("%-*s  %-*s  %12s  %12s  %s\n", serverWidth, "Server", exportWidth, "Export", "Used", "Size", "Clients")
+	fmt.Printf("%-*s  %-*s  %12s  %12s  %s\n", serverWidth, strings.Repeat("-", serverWidth), exportWidth, strings.Repeat("-", exportWidth), strings.Repeat("-", 12), strings.Repeat("-", 12), "-------")
+
+	var total, counted int64
+	for _, r := range rows {
+		size := "-"
+		if r.SizeBytes != nil {
+			size = formatBytes(*r.SizeBytes)
+		}
+		clients := make([]string, len(r.Clients))
+		for i, c := range r.Clients {
+			clients[i] = c.Host + ":" + c.Mount
+		}
+		fmt.Printf("%-*s  %-*s  %12s  %12s  %s\n", serverWidth, r.Server, exportWidth, r.Export, formatBytes(r.UsedBytes), size, strings.Join(clients, ", "))
+		total += r.UsedBytes
+		counted += r.UsedBytes * int64(len(r.Clients))
+	}
+	fmt.Printf("\nFleet total %s in %d filesystems", formatBytes(total), len(rows))
+	if counted > total {
+		fmt.Printf(" (summing per host would report %s)", formatBytes(counted))
+	}
+	fmt.Println()
+}
+
+// handleBackends serves the deduplicated backends as JSON. Tenants only see
+// the backends and clients of their own mounts.
+func (s *fleetServer) handleBackends(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := s.authorizeRead(w, r)
+	if !ok {
+		return
+	}
+	rows, err := collectBackends(s.dataDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	visibleRows := []backendRow{}
+	for _, row := range rows {
+		var clients []backendClient
+		for _, c := range row.Clients {
+			if visible(tenant, nfsMount{MountPoint: c.Mount, Device: c.Device}) {
+				clients = append(clients, c)
+			}
+		}
+		if len(clients) > 0 {
+			for i := range clients {
+				clients[i].Mount, clients[i].Device = s.redact.path(clients[i].Mount), s.redact.path(clients[i].Device)
+			}
+			row.Clients = clients
+			row.Export = s.redact.path(row.Export)
+			visibleRows = append(visibleRows, row)
+		}
+	}
+	lo, hi, ok := s.paginate(w, r, len(visibleRows))
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visibleRows[lo:hi])
+}
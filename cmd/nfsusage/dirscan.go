@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// dirScansPath returns the sidecar keeping the newest directory scan of each
+// mount taken by a dirs schedule
+func dirScansPath(filePath string) string {
+	return filePath + ".dirs"
+}
+
+// readDirScans returns the directory sizes of the newest scans by mount, nil
+// when there are none or they can't be read
+func readDirScans(filePath string, key []byte) map[string]map[string]int64 {
+	data, err := os.ReadFile(dirScansPath(filePath))
+	if err != nil {
+		return nil
+	}
+	if store.IsEncrypted(data) {
+		if data, err = store.Decrypt(key, data); err != nil {
+			return nil
+		}
+	}
+	var scans map[string]map[string]int64
+	if json.Unmarshal(data, &scans) != nil {
+		return nil
+	}
+	return scans
+}
+
+// scanDirs measures the top-level directories of mounts and saves them as
+// their newest scans, encrypted like the store. Scans that fail keep the
+// previous one.
+func scanDirs(filePath string, key []byte, mounts []nfsMount, workers int) error {
+	sizes := make([]map[string]int64, len(mounts))
+	runParallel(len(mounts), workers, func(i int) {
+		sizes[i] = measureDirs(mounts[i].MountPoint)
+	})
+	scans := readDirScans(filePath, key)
+	if scans == nil {
+		scans = make(map[string]map[string]int64)
+	}
+	for i, mount := range mounts {
+		if sizes[i] != nil {
+			scans[mount.MountPoint] = sizes[i]
+		}
+	}
+
+	data, err := json.Marshal(scans)
+	if err != nil {
+		return err
+	}
+	perm := os.FileMode(0644)
+	if key != nil {
+		if data, err = store.Encrypt(key, data); err != nil {
+			return err
+		}
+		perm = 0600
+	}
+	return store.WriteFileAtomic(dirScansPath(filePath), data, perm)
+}
+
+// attachDirScans records the newest scans with the measured mounts of entry
+// that were not measured in directory mode already
+func attachDirScans(entry *UsageEntry, scans map[string]map[string]int64) {
+	for mount, dirs := range scans {
+		if detail, ok := entry.Details[mount]; ok && detail.Dirs == nil {
+			detail.Dirs = dirs
+			entry.Details[mount] = detail
+		}
+	}
+}
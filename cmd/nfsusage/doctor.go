@@ -0,0 +1,330 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// doctorResult accumulates diagnostics and remembers whether anything failed
+type doctorResult struct {
+	failed bool
+}
+
+func (d *doctorResult) ok(check, format string, args ...interface{}) {
+	fmt.Printf("[ OK ] %-7s %s\n", check, fmt.Sprintf(format, args...))
+}
+
+func (d *doctorResult) warn(check, format string, args ...interface{}) {
+	fmt.Printf("[WARN] %-7s %s\n", check, fmt.Sprintf(format, args...))
+}
+
+func (d *doctorResult) fail(check, format string, args ...interface{}) {
+	d.failed = true
+	fmt.Printf("[FAIL] %-7s %s\n", check, fmt.Sprintf(format, args...))
+}
+
+// runDoctor implements the doctor subcommand
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	var filePath, configPath, keyFile string
+	var timeout time.Duration
+	fs.StringVar(&filePath, "file", "", "Path to JSON file for storing usage data (default: config file, else CWD/nfsusage.json)")
+	fs.StringVar(&filePath, "f", "", "Path to JSON file for storing usage data (shorthand)")
+	fs.StringVar(&configPath, "config", "", "Path to YAML config file")
+	fs.StringVar(&keyFile, "key-file", "", "Path to AES-256 key for the data file")
+	fs.DurationVar(&timeout, "timeout", 5*time.Second, "Timeout for each mount and server check")
+	fs.Parse(args)
+
+	d := &doctorResult{}
+	cfg := doctorConfig(d, configPath)
+	key, err := loadKey(keyFile, cfg.Encryption)
+	if err != nil {
+		d.fail("key", "%v (check --key-file, %s or encryption.key_command)", err, store.KeyEnvVar)
+	}
+	filePath = cfg.dataFile(filePath)
+
+	entries := doctorStore(d, filePath, key, cfg)
+	doctorClock(d, entries)
+	doctorSinks(d, cfg)
+	doctorSources(d)
+	doctorMounts(d, cfg, timeout)
+
+	if d.failed {
+		os.Exit(1)
+	}
+}
+
+// doctorConfig loads and sanity checks the config file
+func doctorConfig(d *doctorResult, configPath string) *Config {
+	if configPath == "" {
+		if _, err := os.Stat(defaultConfigPath); err != nil {
+			d.ok("config", "no config file given and no %s, using defaults", defaultConfigPath)
+			return &Config{}
+		}
+		configPath = defaultConfigPath
+	}
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		d.fail("config", "%s: %v (fix the YAML syntax)", configPath, err)
+		return &Config{}
+	}
+
+	problems := 0
+	if cfg.ServerIdentity != "" && !validIdentityMode(cfg.ServerIdentity) {
+		d.fail("config", "%v", errInvalidIdentity(cfg.ServerIdentity))
+		problems++
+	}
+	for _, pattern := range append(cfg.Include, cfg.Exclude...) {
+		if err := validatePattern(pattern); err != nil {
+			d.fail("config", "include/exclude pattern %q: %v", pattern, err)
+			problems++
+		}
+	}
+	for _, rule := range cfg.Quirks {
+		if err := validatePattern(rule.Pattern); err != nil {
+			d.fail("config", "quirk pattern %q: %v", rule.Pattern, err)
+			problems++
+		}
+	}
+	for _, rule := range cfg.Latency {
+		if err := validatePattern(rule.Pattern); err != nil {
+			d.fail("config", "latency pattern %q: %v", rule.Pattern, err)
+			problems++
+		}
+		if rule.MaxMs <= 0 {
+			d.warn("config", "latency rule %q has no max_ms and is ignored", rule.Pattern)
+		}
+	}
+	if _, err := parsePolicies(cfg.Policies); err != nil {
+		d.fail("config", "%v", err)
+		problems++
+	}
+	for _, pc := range cfg.Policies {
+		if pc.Run == "" && pc.Alert == "" {
+			d.warn("config", "policy %q has neither run nor alert and only logs", pc.Name)
+		}
+	}
+	if err := validateTenants(cfg.Tenants); err != nil {
+		d.fail("config", "%v", err)
+		problems++
+	}
+	if err := validateForecastRules(cfg.Forecast); err != nil {
+		d.fail("config", "%v", err)
+		problems++
+	}
+	for _, g := range cfg.Ganesha {
+		if g.Server == "" {
+			d.fail("config", "ganesha entry without server")
+			problems++
+		}
+	}
+	if _, err := cfg.retentionPolicy("", "", 0); err != nil {
+		d.fail("config", "%v", err)
+		problems++
+	}
+	if _, err := cfg.deadMountPolicy(""); err != nil {
+		d.fail("config", "%v", err)
+		problems++
+	}
+	if cfg.Generations < 0 {
+		d.fail("config", "generations must not be negative")
+		problems++
+	}
+	if _, err := cfg.sampleInterval(); err != nil {
+		d.fail("config", "%v", err)
+		problems++
+	}
+	if cfg.Output != "" && !validOutput(cfg.Output) {
+		d.fail("config", "invalid output %q (want %s, %s, %s or %s)", cfg.Output, outputTable, outputJSON, outputCSV, outputMOTD)
+		problems++
+	}
+	for _, t := range []struct{ name, value string }{{"check.warn", cfg.Check.Warn}, {"check.crit", cfg.Check.Crit}} {
+		var f fullness
+		if t.value == "" {
+			continue
+		}
+		if err := f.Set(t.value); err != nil {
+			d.fail("config", "%s: %v", t.name, err)
+			problems++
+		}
+	}
+	if _, err := hardDeadline(cfg.Limits); err != nil {
+		d.fail("config", "limits: %v", err)
+		problems++
+	}
+	for _, rule := range cfg.Snapshots {
+		if _, err := parseCron(rule.Schedule); err != nil {
+			d.fail("config", "snapshot rule %q: %v", rule.Pattern, err)
+			problems++
+		}
+	}
+	for _, w := range cfg.BackupWindows {
+		if err := w.validate(); err != nil {
+			d.fail("config", "backup window %q: %v", w.Pattern, err)
+			problems++
+		}
+	}
+	if problems == 0 {
+		d.ok("config", "%s parsed", configPath)
+	}
+	return cfg
+}
+
+// doctorStore checks that the store can be read and that its directory is writable
+func doctorStore(d *doctorResult, filePath string, key []byte, cfg *Config) []UsageEntry {
+	dir := filepath.Dir(filePath)
+	probe, err := os.CreateTemp(dir, ".nfsusage-doctor-*")
+	if err != nil {
+		d.fail("store", "directory %s is not writable: %v (fix ownership/permissions or use --file)", dir, err)
+	} else {
+		probe.Close()
+		os.Remove(probe.Name())
+	}
+
+	entries, err := store.Open(filePath, key, cfg.Compact).Load()
+	if os.IsNotExist(err) {
+		d.warn("store", "%s does not exist yet, it will be created on the first run", filePath)
+		return nil
+	} else if err != nil {
+		d.fail("store", "%s: %v", filePath, err)
+		return nil
+	}
+	d.ok("store", "%s: %d entries", filePath, len(entries))
+
+	if problems := store.Validate(filePath, entries, key); len(problems) > 0 {
+		d.warn("store", "%d integrity problems, run 'nfsusage validate' for details", len(problems))
+	}
+	return entries
+}
+
+// doctorClock checks that the clock has not moved before the newest recorded entry
+func doctorClock(d *doctorResult, entries []UsageEntry) {
+	now := time.Now()
+	if now.Year() < 2020 {
+		d.fail("clock", "system time %s is implausible (is NTP running?)", now.Format(time.RFC3339))
+		return
+	}
+	if len(entries) == 0 {
+		d.ok("clock", "%s", now.Format(time.RFC3339))
+		return
+	}
+	newest := time.Unix(entries[len(entries)-1].Timestamp, 0)
+	if newest.After(now) {
+		d.fail("clock", "newest entry %s is in the future (clock moved backwards?)", newest.Format(time.RFC3339))
+		return
+	}
+	d.ok("clock", "%s, newest entry %s ago", now.Format(time.RFC3339), now.Sub(newest).Round(time.Second))
+}
+
+// doctorMounts checks mount discovery, per-mount statfs and server reachability
+func doctorMounts(d *doctorResult, cfg *Config, timeout time.Duration) {
+	mounts, err := discoverMounts(cfg)
+	if err != nil {
+		d.fail("mounts", "cannot read /proc/mounts: %v", err)
+		return
+	}
+	for _, pattern := range cfg.absentMounts(mounts) {
+		d.fail("mounts", "required mount %s is not mounted", pattern)
+	}
+	if len(mounts) == 0 {
+		d.warn("mounts", "no NFS mounts found")
+		return
+	}
+	d.ok("mounts", "%d NFS mounts found", len(mounts))
+
+	probed := make(map[string]bool)
+	for _, mount := range mounts {
+		done := make(chan error, 1)
+		go func(mountPoint string) {
+			_, err := collector.Statfs(mountPoint)
+			done <- err
+		}(mount.MountPoint)
+
+		select {
+		case err := <-done:
+			if err != nil {
+				d.fail("mounts", "%s: statfs %s, the mount will be missing from entries", mount.MountPoint, accessReason(err))
+			}
+		case <-time.After(timeout):
+			d.fail("mounts", "%s: statfs did not return within %s (stale or hung mount?)", mount.MountPoint, timeout)
+		}
+
+		host := collector.ServerHost(mount.Device)
+		if host == "" || probed[host] {
+			continue
+		}
+		probed[host] = true
+		addr := mount.ServerAddr
+		if addr == "" {
+			addr = host
+		}
+		if p := probeServer(addr, timeout); !p.Reachable {
+			d.fail("server", "%s unreachable on port %s: %s", host, nfsPort, p.Error)
+		} else if !p.RPC {
+			d.warn("server", "%s accepts connections but NULL RPC failed: %s", host, p.Error)
+		} else {
+			d.ok("server", "%s responded in %.1fms", host, p.LatencyMs)
+		}
+	}
+}
+
+// doctorSources checks the optional kernel interfaces collection reads and
+// names what entries will lack without each of them
+func doctorSources(d *doctorResult) {
+	unavailable := unavailableSources()
+	for _, src := range dataSources {
+		if reason, ok := unavailable[src.name]; ok {
+			d.warn("sources", "%s", reason)
+		} else {
+			d.ok("sources", "%s readable", src.path)
+		}
+	}
+}
+
+// doctorSinks checks that every configured sink is usable without sending data:
+// local sink directories must be writable and HTTP endpoints must answer
+func doctorSinks(d *doctorResult, cfg *Config) {
+	if _, err := newSinkRunners(cfg, ""); err != nil {
+		d.fail("sinks", "%v", err)
+		return
+	}
+	for i, sc := range cfg.Sinks {
+		name := fmt.Sprintf("%s[%d]", sc.Type, i)
+		switch sc.Type {
+		case "textfile", "csv", "rrd", "whisper":
+			dir := filepath.Dir(sc.Path)
+			if sc.Type != "textfile" {
+				dir = sc.Path
+			}
+			if sc.Type == "rrd" {
+				if _, err := exec.LookPath("rrdtool"); err != nil {
+					d.fail("sinks", "%s: rrdtool not found in PATH", name)
+					continue
+				}
+			}
+			tmp, err := os.CreateTemp(dir, ".nfsusage-doctor-*")
+			if err != nil {
+				d.fail("sinks", "%s: %s is not writable: %v", name, dir, err)
+				continue
+			}
+			tmp.Close()
+			os.Remove(tmp.Name())
+			d.ok("sinks", "%s: %s writable", name, dir)
+		default:
+			resp, err := sinkHTTPClient.Head(sc.URL)
+			if err != nil {
+				d.fail("sinks", "%s: %v (check the url and firewall)", name, err)
+				continue
+			}
+			resp.Body.Close()
+			d.ok("sinks", "%s: %s reachable (%s)", name, sc.URL, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// dirChange is one top-level directory's part of a mount's change
+type dirChange struct {
+	name       string
+	start, end int64
+}
+
+// mountDrilldown explains a mount's change between two entries by directory
+type mountDrilldown struct {
+	mount      string
+	start, end int64
+	dirs       []dirChange
+}
+
+// delta is the mount's change between the two entries
+func (m mountDrilldown) delta() int64 {
+	return m.end - m.start
+}
+
+// collectDrilldown compares the directory sizes of every mount recorded in
+// directory mode in both the first entry at or after from and the newest one.
+// Directories are sorted by the size of their contribution, largest first.
+func collectDrilldown(st historyStore, from int64) ([]mountDrilldown, *UsageEntry, *UsageEntry, error) {
+	var first, last *UsageEntry
+	err := store.ScanRange(st, from, 0, func(entry UsageEntry) error {
+		e := collector.WithoutSnapshots(entry)
+		if first == nil {
+			first = &e
+		}
+		last = &e
+		return nil
+	})
+	if err != nil || last == nil {
+		return nil, first, last, err
+	}
+
+	var result []mountDrilldown
+	for mount, end := range last.Mounts {
+		endDirs := last.Details[mount].Dirs
+		startDirs := first.Details[mount].Dirs
+		if endDirs == nil || startDirs == nil {
+			continue
+		}
+		m := mountDrilldown{mount: mount, start: first.Mounts[mount], end: end}
+		for name, size := range endDirs {
+			m.dirs = append(m.dirs, dirChange{name, startDirs[name], size})
+		}
+		for name, size := range startDirs {
+			if _, ok := endDirs[name]; !ok {
+				m.dirs = append(m.dirs, dirChange{name, size, 0})
+			}
+		}
+		sort.Slice(m.dirs, func(i, j int) bool {
+			di, dj := abs64(m.dirs[i].end-m.dirs[i].start), abs64(m.dirs[j].end-m.dirs[j].start)
+			if di != dj {
+				return di > dj
+			}
+			return m.dirs[i].name < m.dirs[j].name
+		})
+		result = append(result, m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].mount < result[j].mount })
+	return result, first, last, nil
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// printDrilldown prints, per mount, the directories accounting for its change.
+// Directories that didn't change are left out, top limits the rest and
+// whatever the listed directories don't explain is shown as "(other)".
+func printDrilldown(mounts []mountDrilldown, first, last *UsageEntry, top int) {
+	if len(mounts) == 0 {
+		fmt.Println("No mount has directory data at both ends of the window (see dir_mounts in the config)")
+		return
+	}
+	fmt.Printf("Changes from %s to %s\n", formatDateTime(time.Unix(first.Timestamp, 0)),
+		formatDateTime(time.Unix(last.Timestamp, 0)))
+	for _, m := range mounts {
+		fmt.Printf("\n%s: %s -> %s (%s)\n", m.mount, formatBytes(m.start), formatBytes(m.end), formatDiff(m.delta()))
+
+		var listed []dirChange
+		for _, d := range m.dirs {
+			if d.end != d.start && (top <= 0 || len(listed) < top) {
+				listed = append(listed, d)
+			}
+		}
+		if len(listed) == 0 {
+			fmt.Println("  no directory changed")
+			continue
+		}
+		nameWidth := len("Directory")
+		for _, d := range listed {
+			nameWidth = max(nameWidth, len(d.name))
+		}
+		share := func(delta int64) string {
+			if m.delta() == 0 {
+				return "-"
+			}
+			return fmt.Sprintf("%.0f%%", math.Round(float64(delta)/float64(m.delta())*100))
+		}
+		fmt.Printf("  %-*s  %12s  %12s  %12s  %6s\n", nameWidth, "Directory", "Before", "After", "Change", "Share")
+		fmt.Printf("  %-*s  %12s  %12s  %12s  %6s\n", nameWidth, strings.Repeat("-", nameWidth), "------", "-----", "------", "-----")
+		var explained int64
+		for _, d := range listed {
+			delta := d.end - d.start
+			explained += delta
+			fmt.Printf("  %-*s  %12s  %12s  %12s  %6s\n", nameWidth, d.name, formatBytes(d.start), formatBytes(d.end),
+				formatDiff(delta), share(delta))
+		}
+		if rest := m.delta() - explained; rest != 0 {
+			fmt.Printf("  %-*s  %12s  %12s  %12s  %6s\n", nameWidth, "(other)", "", "", formatDiff(rest), share(rest))
+		}
+	}
+}
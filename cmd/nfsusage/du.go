@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"syscall"
+)
+
+// walkBytes computes what du -sxB1 reports for path without running du: the
+// allocated blocks of everything below it on the same filesystem, counting
+// hard linked files once
+func walkBytes(path string) (int64, error) {
+	root, err := statOf(path)
+	if err != nil {
+		return 0, err
+	}
+	type inode struct{ dev, ino uint64 }
+	seen := make(map[inode]bool)
+	var total int64
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		st, err := statOf(p)
+		if err != nil {
+			return err
+		}
+		if uint64(st.Dev) != uint64(root.Dev) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if st.Nlink > 1 && !d.IsDir() {
+			key := inode{uint64(st.Dev), uint64(st.Ino)}
+			if seen[key] {
+				return nil
+			}
+			seen[key] = true
+		}
+		total += int64(st.Blocks) * 512
+		return nil
+	})
+	return total, err
+}
+
+// statOf lstats path
+func statOf(path string) (*syscall.Stat_t, error) {
+	var st syscall.Stat_t
+	if err := syscall.Lstat(path, &st); err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: path, Err: err}
+	}
+	return &st, nil
+}
@@ -0,0 +1,27 @@
+//go:build !scratch
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// duBytes returns the disk usage of path in bytes, staying on its filesystem.
+// It runs du when it is installed and walks the tree itself otherwise.
+func duBytes(path string) (int64, error) {
+	if _, err := exec.LookPath("du"); err != nil {
+		return walkBytes(path)
+	}
+	output, err := exec.Command("du", "-sxB1", path).Output()
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected du output %q", output)
+	}
+	return strconv.ParseInt(fields[0], 10, 64)
+}
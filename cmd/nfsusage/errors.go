@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Exit codes. Which failures lead to a non-zero exit is decided by --fail-on.
+const (
+	exitFatal       = 1
+	exitPartial     = 3
+	exitMountErrors = 4
+	exitNoMounts    = 5
+	exitAbsent      = 6
+)
+
+// --fail-on policies
+const (
+	failOnNone  = "none"
+	failOnStore = "store"
+	failOnAny   = "any"
+)
+
+// DiscoveryError means the mount table could not be read, nothing was recorded
+type DiscoveryError struct {
+	Err error
+}
+
+func (e *DiscoveryError) Error() string { return "getting NFS mounts: " + e.Err.Error() }
+func (e *DiscoveryError) Unwrap() error { return e.Err }
+
+// MountError means a single mount could not be measured, the rest were recorded
+type MountError struct {
+	MountPoint string
+	Err        error
+}
+
+func (e *MountError) Error() string { return fmt.Sprintf("measuring %s: %v", e.MountPoint, e.Err) }
+func (e *MountError) Unwrap() error { return e.Err }
+
+// StoreError means the history could not be read or written
+type StoreError struct {
+	Op  string
+	Err error
+}
+
+func (e *StoreError) Error() string { return e.Op + ": " + e.Err.Error() }
+func (e *StoreError) Unwrap() error { return e.Err }
+
+// AbsentMountsError means mounts listed under require in the config were not found
+type AbsentMountsError struct {
+	Patterns []string
+}
+
+func (e *AbsentMountsError) Error() string {
+	return "required mounts not mounted: " + strings.Join(e.Patterns, ", ")
+}
+
+// TimeRegressionError means the new entry is older than the newest stored one,
+// e.g. after an NTP step or a VM snapshot restore
+type TimeRegressionError struct {
+	Last  int64
+	Entry int64
+}
+
+func (e *TimeRegressionError) Error() string {
+	return fmt.Sprintf("clock moved backwards: entry at %s is %ds older than the newest stored entry at %s",
+		time.Unix(e.Entry, 0).Format(time.RFC3339), e.Last-e.Entry, time.Unix(e.Last, 0).Format(time.RFC3339))
+}
+
+// validFailOn reports whether policy is a known --fail-on value
+func validFailOn(policy string) bool {
+	return policy == failOnNone || policy == failOnStore || policy == failOnAny
+}
+
+// exitCode maps the outcome of a run to an exit code under policy:
+//
+//	none   always 0, errors are only reported on stderr
+//	store  1 when nothing was recorded (discovery or store failure), 6 when
+//	       required mounts are absent, 3 for deadline-truncated entries
+//	any    as store, plus 4 when individual mounts failed
+func exitCode(policy string, err error, mountErrs []*MountError, partial bool) int {
+	if policy == failOnNone {
+		return 0
+	}
+	var discoveryErr *DiscoveryError
+	var storeErr *StoreError
+	if errors.As(err, &discoveryErr) || errors.As(err, &storeErr) {
+		return exitFatal
+	}
+	var absentErr *AbsentMountsError
+	if errors.As(err, &absentErr) {
+		return exitAbsent
+	}
+	if partial {
+		return exitPartial
+	}
+	if policy == failOnAny && len(mountErrs) > 0 {
+		return exitMountErrors
+	}
+	return 0
+}
+
+// exitOnError reports err and exits according to the --fail-on policy
+func exitOnError(policy string, err error) {
+	fmt.Fprintf(os.Stderr, "Error %v\n", err)
+	code := exitCode(policy, err, nil, false)
+	finishRun(runFailed, code, 0, err)
+	os.Exit(code)
+}
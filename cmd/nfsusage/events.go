@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Event kinds recorded next to the store
+const (
+	eventTimeRegression = "time_regression"
+)
+
+// storeEvent is one line of the events sidecar, recording things that happened
+// to the history that the entries themselves can't show
+type storeEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Kind      string `json:"kind"`
+	Message   string `json:"message"`
+}
+
+// eventsPath returns the events sidecar for a data file
+func eventsPath(filePath string) string {
+	return filePath + ".events"
+}
+
+// recordEvent appends an event to the data file's events sidecar. Failures
+// are only warned about, an event must never stop a collection.
+func recordEvent(filePath, kind, format string, args ...interface{}) {
+	event := storeEvent{Timestamp: timeSource.Now().Unix(), Kind: kind, Message: fmt.Sprintf(format, args...)}
+	data, err := json.Marshal(event)
+	if err == nil {
+		var file *os.File
+		file, err = os.OpenFile(eventsPath(filePath), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err == nil {
+			_, err = file.Write(append(data, '\n'))
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error recording %s event: %v\n", kind, err)
+	}
+}
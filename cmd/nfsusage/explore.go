@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// exploreRanges are the time ranges offered by the explore picker
+var exploreRanges = []struct {
+	label string
+	age   time.Duration
+}{
+	{"last 24 hours", 24 * time.Hour},
+	{"last 7 days", 7 * 24 * time.Hour},
+	{"last 30 days", 30 * 24 * time.Hour},
+	{"last 90 days", 90 * 24 * time.Hour},
+	{"last 365 days", 365 * 24 * time.Hour},
+	{"all history", 0},
+}
+
+// picker chooses one or more of options
+type picker interface {
+	pick(prompt string, options []string, multi bool) ([]string, error)
+}
+
+// fzfPicker delegates to fzf, which gives fuzzy search over long autofs paths
+type fzfPicker struct{}
+
+func (fzfPicker) pick(prompt string, options []string, multi bool) ([]string, error) {
+	args := []string{"--prompt", prompt + "> ", "--height", "40%", "--reverse"}
+	if multi {
+		args = append(args, "--multi", "--header", "TAB selects several, ENTER confirms")
+	}
+	cmd := exec.Command("fzf", args...)
+	cmd.Stdin = strings.NewReader(strings.Join(options, "\n") + "\n")
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("nothing selected")
+	}
+	return strings.Split(strings.TrimRight(string(output), "\n"), "\n"), nil
+}
+
+// menuPicker is the built-in fallback: a numbered list that can be narrowed by
+// typing part of a path
+type menuPicker struct {
+	in *bufio.Reader
+}
+
+func (m menuPicker) pick(prompt string, options []string, multi bool) ([]string, error) {
+	shown := options
+	for {
+		for i, option := range shown {
+			fmt.Printf("%3d) %s\n", i+1, option)
+		}
+		hint := "number"
+		if multi {
+			hint = "numbers (1,3-5), 'all'"
+		}
+		fmt.Printf("%s: %s, or text to filter: ", prompt, hint)
+		answer, err := m.in.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if answer == "" && err != nil {
+			return nil, fmt.Errorf("nothing selected")
+		}
+
+		if multi && answer == "all" {
+			return shown, nil
+		}
+		if picked, ok := parseSelection(answer, shown, multi); ok {
+			return picked, nil
+		}
+		var filtered []string
+		for _, option := range options {
+			if strings.Contains(strings.ToLower(option), strings.ToLower(answer)) {
+				filtered = append(filtered, option)
+			}
+		}
+		if len(filtered) == 0 {
+			fmt.Printf("No match for %q\n", answer)
+			continue
+		}
+		shown = filtered
+	}
+}
+
+// parseSelection parses "2" or, for multi, "1,3-5" into the chosen options
+func parseSelection(answer string, options []string, multi bool) ([]string, bool) {
+	var picked []string
+	for _, part := range strings.Split(answer, ",") {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		start, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, false
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(hi); err != nil {
+				return nil, false
+			}
+		}
+		if start < 1 || end > len(options) || start > end {
+			return nil, false
+		}
+		picked = append(picked, options[start-1:end]...)
+	}
+	if len(picked) == 0 || (!multi && len(picked) > 1) {
+		return nil, false
+	}
+	return picked, true
+}
+
+// historyMounts returns every mount that appears anywhere in the history
+func historyMounts(st historyStore) ([]string, error) {
+	seen := make(map[string]bool)
+	err := st.Scan(func(entry UsageEntry) error {
+		for mount := range entry.Mounts {
+			if !collector.IsSnapshotMount(mount) {
+				seen[mount] = true
+			}
+		}
+		return nil
+	})
+	mounts := make([]string, 0, len(seen))
+	for mount := range seen {
+		mounts = append(mounts, mount)
+	}
+	sort.Strings(mounts)
+	return mounts, err
+}
+
+// selectMounts returns a copy of entry restricted to mounts, with the total recomputed
+func selectMounts(entry UsageEntry, mounts []string) UsageEntry {
+	selected := UsageEntry{Timestamp: entry.Timestamp, Mounts: make(map[string]int64)}
+	for _, mount := range mounts {
+		if bytes, ok := entry.Mounts[mount]; ok {
+			selected.Mounts[mount] = bytes
+			selected.Total += bytes
+		}
+	}
+	return selected
+}
+
+// runExplore implements the explore subcommand: interactively pick mounts and
+// a time range from the history and compare them, without collecting
+func runExplore(args []string) {
+	fs := flag.NewFlagSet("explore", flag.ExitOnError)
+	var filePath, configPath, keyFile, numberFormatSpec string
+	var noFzf bool
+	fs.StringVar(&filePath, "file", "", "Path to JSON file for storing usage data (default: config file, else CWD/nfsusage.json)")
+	fs.StringVar(&filePath, "f", "", "Path to JSON file for storing usage data (shorthand)")
+	fs.StringVar(&configPath, "config", "", "Path to YAML config file")
+	fs.StringVar(&keyFile, "key-file", "", "Path to AES-256 key for the data file")
+	fs.StringVar(&numberFormatSpec, "format-numbers", "", "Number formatting: comma separated thousands, decimals=N, width=N, unit=GiB|TiB")
+	fs.BoolVar(&noFzf, "no-fzf", false, "Use the built-in menu even when fzf is installed")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	key, err := loadKey(keyFile, cfg.Encryption)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading encryption key: %v\n", err)
+		os.Exit(1)
+	}
+	if numFmt, err = parseNumberFormat(numberFormatSpec); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --format-numbers: %v\n", err)
+		os.Exit(1)
+	}
+	filePath = cfg.dataFile(filePath)
+
+	st, err := openSeriesStore(filePath, key, cfg.Compact)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading remap rules: %v\n", err)
+		os.Exit(1)
+	}
+	mounts, err := historyMounts(st)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+		os.Exit(1)
+	}
+	if len(mounts) == 0 {
+		fmt.Fprintln(os.Stderr, "No entries recorded yet")
+		os.Exit(1)
+	}
+
+	var p picker = menuPicker{in: bufio.NewReader(os.Stdin)}
+	if _, err := exec.LookPath("fzf"); err == nil && !noFzf {
+		p = fzfPicker{}
+	}
+
+	picked, err := p.pick("mounts", mounts, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	labels := make([]string, len(exploreRanges))
+	for i, r := range exploreRanges {
+		labels[i] = r.label
+	}
+	rangeChoice, err := p.pick("range", labels, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	newest, _, err := lastEntry(st)
+	if err != nil || newest == nil {
+		fmt.Fprintf(os.Stderr, "Error loading newest entry: %v\n", err)
+		os.Exit(1)
+	}
+	var from int64
+	for _, r := range exploreRanges {
+		if r.label == rangeChoice[0] && r.age > 0 {
+			from = time.Unix(newest.Timestamp, 0).Add(-r.age).Unix()
+		}
+	}
+	var base *UsageEntry
+	err = store.ScanRange(st, from, 0, func(entry UsageEntry) error {
+		base = &entry
+		return store.ErrStopScan
+	})
+	if err = store.IgnoreStop(err); err != nil || base == nil {
+		fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println()
+	printComparison(formatDate(time.Unix(base.Timestamp, 0)), selectMounts(*base, picked), selectMounts(*newest, picked))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/jessegalley/nfsusage/pkg/report"
+)
+
+// runExport implements the export subcommand, which converts the stored
+// history for backfilling into other systems
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var filePath, configPath, keyFile, format, output, redactMode string
+	fs.StringVar(&filePath, "file", "", "Path to JSON file for storing usage data (default: config file, else CWD/nfsusage.json)")
+	fs.StringVar(&filePath, "f", "", "Path to JSON file for storing usage data (shorthand)")
+	fs.StringVar(&configPath, "config", "", "Path to YAML config file")
+	fs.StringVar(&keyFile, "key-file", "", "Path to AES-256 key for the data file")
+	fs.StringVar(&format, "format", "openmetrics", "Export format: openmetrics (for promtool tsdb create-blocks-from openmetrics)")
+	fs.StringVar(&output, "output", "", "Write to this file instead of stdout")
+	fs.StringVar(&output, "o", "", "Write to this file instead of stdout (shorthand)")
+	fs.StringVar(&redactMode, "redact-paths", "", "Redact mount and export paths in output: hash or alias")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	key, err := loadKey(keyFile, cfg.Encryption)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading encryption key: %v\n", err)
+		os.Exit(1)
+	}
+	redact, err := newRedactor(redactMode, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if format != "openmetrics" {
+		fmt.Fprintf(os.Stderr, "Error: unknown export format %q\n", format)
+		os.Exit(1)
+	}
+	filePath = cfg.dataFile(filePath)
+
+	out := os.Stdout
+	if output != "" {
+		if out, err = os.Create(output); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	w := bufio.NewWriter(out)
+	st, err := openSeriesStore(filePath, key, cfg.Compact)
+	if err == nil {
+		err = exportOpenMetrics(w, st, redact)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if output != "" {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error exporting data: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// exportOpenMetrics writes the history in the OpenMetrics text format using the
+// same metric names as the textfile sink, so backfilled blocks line up with
+// live scrapes. Each family must be contiguous, so the store is scanned once
+// per family instead of being loaded whole.
+func exportOpenMetrics(w io.Writer, st historyStore, redact *redactor) error {
+	fmt.Fprintln(w, "# HELP nfsusage_used_bytes Used bytes per NFS mount.")
+	fmt.Fprintln(w, "# TYPE nfsusage_used_bytes gauge")
+	err := st.Scan(func(entry UsageEntry) error {
+		entry = redact.entry(entry)
+		mounts := make([]string, 0, len(entry.Mounts))
+		for mount := range entry.Mounts {
+			mounts = append(mounts, mount)
+		}
+		sort.Strings(mounts)
+		for _, mount := range mounts {
+			fmt.Fprintf(w, "nfsusage_used_bytes{%s} %d %d\n", report.MetricLabels(mount, entry.Details[mount]), entry.Mounts[mount], entry.Timestamp)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "# HELP nfsusage_total_used_bytes Used bytes summed over all NFS mounts.")
+	fmt.Fprintln(w, "# TYPE nfsusage_total_used_bytes gauge")
+	err = st.Scan(func(entry UsageEntry) error {
+		_, err := fmt.Fprintf(w, "nfsusage_total_used_bytes %d %d\n", entry.Total, entry.Timestamp)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, "# EOF")
+	return err
+}
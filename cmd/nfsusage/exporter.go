@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/report"
+)
+
+// metricsExporter serves the newest recorded entry on /metrics, and which
+// mounts are gone but not yet retired. Scrapes read the latest entry cache,
+// so they never measure mounts or read the history.
+type metricsExporter struct {
+	store  func() historyStore
+	redact *redactor
+}
+
+func (e *metricsExporter) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	st := e.store()
+	newest, _, err := lastEntry(st)
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, fmt.Sprintf("loading data: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if newest == nil {
+		http.Error(w, "no entries recorded yet", http.StatusServiceUnavailable)
+		return
+	}
+	seen, err := loadSightings(st)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("loading mount sightings: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, report.Metrics(e.redact.entry(collector.WithoutSnapshots(*newest))))
+	fmt.Fprint(w, renderSightings(seen, *newest, e.redact, timeSource.Now()))
+}
+
+// serveMetrics serves /metrics on addr until the listener fails
+func serveMetrics(addr string, e *metricsExporter) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", e.handleMetrics)
+	fmt.Fprintf(os.Stderr, "Serving metrics on %s/metrics\n", addr)
+	return newHTTPServer(addr, mux).ListenAndServe()
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// maxIngestBytes bounds the size of one posted entry
+const maxIngestBytes = 16 << 20
+
+// fleetServer aggregates entries posted by the webhook sinks of many hosts,
+// keeping one JSONL store per host in dataDir
+type fleetServer struct {
+	dataDir string
+	token   string
+	// cfg holds the tenants whose tokens may read their own mounts
+	cfg *Config
+	// limits bound the data read by one API request
+	limits apiLimits
+	// redact applies --redact-paths to every path the API returns
+	redact *redactor
+	// mu serializes appends, each host's checksum chain must stay linear
+	mu sync.Mutex
+}
+
+// Timeouts of the HTTP servers, so slow or stalled clients can't hold
+// connections open forever
+const (
+	httpReadHeaderTimeout = 10 * time.Second
+	httpReadTimeout       = time.Minute
+	httpWriteTimeout      = 5 * time.Minute
+	httpIdleTimeout       = 2 * time.Minute
+)
+
+// newHTTPServer returns a server for handler on addr with timeouts set
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		ReadTimeout:       httpReadTimeout,
+		WriteTimeout:      httpWriteTimeout,
+		IdleTimeout:       httpIdleTimeout,
+	}
+}
+
+// hostFileName makes a host name safe to use as a file name. Other bytes than
+// letters, digits, '.', '-' and '_' are percent-encoded, so distinct hosts
+// never share a file and hostFromFileName can reverse it.
+func hostFileName(host string) string {
+	var b strings.Builder
+	for i := 0; i < len(host); i++ {
+		c := host[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// hostFromFileName reverses hostFileName
+func hostFromFileName(name string) string {
+	if host, err := url.PathUnescape(name); err == nil {
+		return host
+	}
+	return name
+}
+
+// fleetStores opens the store of every host in dataDir, keyed by host
+func fleetStores(dataDir string) (map[string]historyStore, error) {
+	paths, err := filepath.Glob(filepath.Join(dataDir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	stores := make(map[string]historyStore, len(paths))
+	for _, path := range paths {
+		stores[hostFromFileName(strings.TrimSuffix(filepath.Base(path), ".jsonl"))] = store.Open(path, nil, true)
+	}
+	return stores, nil
+}
+
+// handleIngest accepts one UsageEntry as JSON, as sent by the webhook sink
+func (s *fleetServer) handleIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeWrite(w, r) {
+		return
+	}
+
+	var entry UsageEntry
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxIngestBytes)).Decode(&entry); err != nil {
+		http.Error(w, "invalid entry: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if entry.Host == "" {
+		// Entries from hosts older than the host field are filed by address
+		entry.Host, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	// The sender's chain doesn't apply here, the entry is resealed per host
+	entry.Checksum = ""
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := store.Open(filepath.Join(s.dataDir, hostFileName(entry.Host)+".jsonl"), nil, true)
+	if _, err := appendEntry(st, entry, nil, false); err != nil {
+		fmt.Fprintf(os.Stderr, "Error storing entry from %s: %v\n", entry.Host, err)
+		status := http.StatusInternalServerError
+		var regression *TimeRegressionError
+		if errors.As(err, &regression) {
+			// Resending can't succeed, the sink must drop the entry
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeWrite checks the server token required to store data, writing
+// the error response when it doesn't match
+func (s *fleetServer) authorizeWrite(w http.ResponseWriter, r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	presented, hasToken := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !hasToken || !tokenMatches(s.token, presented) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// handleInventory serves the inventory as JSON, labelled with tenants and
+// limited to the mounts the caller's tenant may see
+func (s *fleetServer) handleInventory(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := s.authorizeRead(w, r)
+	if !ok {
+		return
+	}
+	rows, err := collectInventory(s.dataDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	visibleRows := []inventoryRow{}
+	for _, row := range rows {
+		mount := nfsMount{MountPoint: row.Mount, Device: row.Export}
+		if visible(tenant, mount) {
+			row.Tenants = s.cfg.tenantLabels(mount)
+			row.Mount, row.Export = s.redact.path(row.Mount), s.redact.path(row.Export)
+			visibleRows = append(visibleRows, row)
+		}
+	}
+	lo, hi, ok := s.paginate(w, r, len(visibleRows))
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visibleRows[lo:hi])
+}
+
+// inventoryRow records that a host mounts an export
+type inventoryRow struct {
+	Export    string `json:"export"`
+	Host      string `json:"host"`
+	Mount     string `json:"mount"`
+	LastSeen  int64  `json:"last_seen"`
+	UsedBytes int64  `json:"used_bytes"`
+	// Tenants are the tenants owning the mount, set by the server
+	Tenants []string `json:"tenants,omitempty"`
+}
+
+// collectInventory lists which hosts mount which exports and when each was
+// last seen, from the newest entry each host recorded with the export
+func collectInventory(dataDir string) ([]inventoryRow, error) {
+	stores, err := fleetStores(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var rows []inventoryRow
+	for host, st := range stores {
+		seen := make(map[string]*inventoryRow)
+		err := st.Scan(func(entry UsageEntry) error {
+			for mount, used := range entry.Mounts {
+				export := entry.Details[mount].Device
+				if export == "" {
+					export = "(unknown)"
+				}
+				key := export + "\x00" + mount
+				row := seen[key]
+				if row == nil {
+					row = &inventoryRow{Export: export, Host: host, Mount: mount}
+					seen[key] = row
+				}
+				row.LastSeen, row.UsedBytes = entry.Timestamp, used
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", host, err)
+		}
+		for _, row := range seen {
+			rows = append(rows, *row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Export != rows[j].Export {
+			return rows[i].Export < rows[j].Export
+		}
+		if rows[i].Host != rows[j].Host {
+			return rows[i].Host < rows[j].Host
+		}
+		return rows[i].Mount < rows[j].Mount
+	})
+	return rows, nil
+}
+
+// printInventory prints the inventory grouped by export
+func printInventory(rows []inventoryRow) {
+	exportWidth, hostWidth, mountWidth := len("Export"), len("Host"), len("Mountpoint")
+	for _, r := range rows {
+		exportWidth = max(exportWidth, len(r.Export))
+		hostWidth = max(hostWidth, len(r.Host))
+		mountWidth = max(mountWidth, len(r.Mount))
+	}
+	fmt.Printf("%-*s  %-*s  %-*s  %-16s  %s\n", exportWidth, "Export", hostWidth, "Host", mountWidth, "Mountpoint", "Last seen", "Used")
+	fmt.Printf("%-*s  %-*s  %-*s  %-16s  %s\n", exportWidth, strings.Repeat("-", exportWidth), hostWidth, strings.Repeat("-", hostWidth), mountWidth, strings.Repeat("-", mountWidth), strings.Repeat("-", 16), "----")
+	last := ""
+	for _, r := range rows {
+		export := r.Export
+		if export == last {
+			export = ""
+		}
+		last = r.Export
+		fmt.Printf("%-*s  %-*s  %-*s  %-16s  %s\n", exportWidth, export, hostWidth, r.Host, mountWidth, r.Mount,
+			formatDateTime(time.Unix(r.LastSeen, 0)), formatBytes(r.UsedBytes))
+	}
+}
+
+// runServe implements the serve subcommand, the fleet aggregator. Hosts send
+// their entries with a webhook sink pointed at /api/v1/entries.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	var listen, dataDir, token, configPath, maxRange, redactMode string
+	var maxPoints int
+	fs.StringVar(&listen, "listen", ":9190", "Address to listen on")
+	fs.StringVar(&configPath, "config", "", "Path to YAML config file with tenants scoping read tokens")
+	fs.StringVar(&dataDir, "data-dir", "fleet", "Directory holding one store per host")
+	fs.StringVar(&token, "token", "", "Bearer token hosts must send (or set NFSUSAGE_TOKEN)")
+	fs.StringVar(&maxRange, "max-range", "90d", "Widest time range one history request may cover")
+	fs.IntVar(&maxPoints, "max-points", defaultMaxPoints, "Most points or rows one API response may hold")
+	fs.StringVar(&redactMode, "redact-paths", "", "Redact mount and export paths in API responses: hash or alias")
+	fs.Parse(args)
+
+	if token == "" {
+		token = os.Getenv("NFSUSAGE_TOKEN")
+	}
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	if err := validateTenants(cfg.Tenants); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if deadMounts, err = cfg.deadMountPolicy(""); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	redact, err := newRedactor(redactMode, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	limits := apiLimits{maxPoints: maxPoints}
+	if limits.maxRange, err = parseAge(maxRange); err != nil || limits.maxRange <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: invalid --max-range %q\n", maxRange)
+		os.Exit(1)
+	}
+	if maxPoints < 1 {
+		fmt.Fprintf(os.Stderr, "Error: --max-points must be at least 1\n")
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	s := &fleetServer{dataDir: dataDir, token: token, cfg: cfg, limits: limits, redact: redact}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/entries", s.handleIngest)
+	mux.HandleFunc("/api/v1/inventory", s.handleInventory)
+	mux.HandleFunc("/api/v1/backends", s.handleBackends)
+	mux.HandleFunc("/api/v1/usage", s.handleUsage)
+	mux.HandleFunc("/api/v1/history", s.handleHistory)
+	mux.HandleFunc("/api/v1/annotations", s.handleAnnotations)
+	fmt.Fprintf(os.Stderr, "Listening on %s, storing in %s\n", listen, dataDir)
+	if err := newHTTPServer(listen, mux).ListenAndServe(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runInventory implements the inventory subcommand on the aggregator: which
+// hosts mount which exports, and when they were last seen doing so
+func runInventory(args []string) {
+	fs := flag.NewFlagSet("inventory", flag.ExitOnError)
+	var dataDir, numberFormatSpec string
+	var asJSON, dedup bool
+	fs.StringVar(&dataDir, "data-dir", "fleet", "Directory holding one store per host (see serve)")
+	fs.BoolVar(&dedup, "dedup", false, "List each backend filesystem once, by fsid or export, with the hosts mounting it")
+	fs.StringVar(&numberFormatSpec, "format-numbers", "", "Number formatting: comma separated thousands, decimals=N, width=N, unit=GiB|TiB")
+	fs.BoolVar(&asJSON, "json", false, "Print JSON instead of a table")
+	fs.Parse(args)
+
+	var err error
+	if numFmt, err = parseNumberFormat(numberFormatSpec); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --format-numbers: %v\n", err)
+		os.Exit(1)
+	}
+	if dedup {
+		rows, err := collectBackends(dataDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+			os.Exit(1)
+		}
+		if asJSON {
+			data, _ := json.MarshalIndent(rows, "", "  ")
+			fmt.Println(string(data))
+			return
+		}
+		printBackends(rows)
+		return
+	}
+	rows, err := collectInventory(dataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+		os.Exit(1)
+	}
+	if asJSON {
+		data, _ := json.MarshalIndent(rows, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+	printInventory(rows)
+}
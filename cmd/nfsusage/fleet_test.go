@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestFleet returns a fleet server storing in a temporary directory
+func newTestFleet(t *testing.T, token string, cfg *Config) *fleetServer {
+	t.Helper()
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	return &fleetServer{dataDir: t.TempDir(), token: token, cfg: cfg, limits: apiLimits{maxPoints: defaultMaxPoints}}
+}
+
+// ingest posts entry to s with the bearer token and returns the status
+func ingest(t *testing.T, s *fleetServer, token string, entry UsageEntry) int {
+	t.Helper()
+	body, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/entries", bytes.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.handleIngest(rec, req)
+	return rec.Code
+}
+
+func TestHandleIngest(t *testing.T) {
+	entry := func(host string, ts int64) UsageEntry {
+		return UsageEntry{Host: host, Timestamp: ts, Mounts: map[string]int64{"/mnt/a": ts}, Total: ts}
+	}
+	tests := []struct {
+		name string
+		// sent are posted in order with token, want is the status of the last
+		sent  []UsageEntry
+		token string
+		want  int
+	}{
+		{"stored", []UsageEntry{entry("web01", 100)}, "secret", http.StatusNoContent},
+		{"missing token", []UsageEntry{entry("web01", 100)}, "", http.StatusUnauthorized},
+		{"wrong token", []UsageEntry{entry("web01", 100)}, "secreT", http.StatusUnauthorized},
+		{"hashed token", []UsageEntry{entry("web01", 100)}, "secret", http.StatusNoContent},
+		{"newer entry", []UsageEntry{entry("web01", 100), entry("web01", 200)}, "secret", http.StatusNoContent},
+		{"time regression", []UsageEntry{entry("web01", 200), entry("web01", 100)}, "secret", http.StatusConflict},
+		{"other host older", []UsageEntry{entry("web01", 200), entry("web02", 100)}, "secret", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configured := "secret"
+			if tt.name == "hashed token" {
+				configured = "sha256:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"
+			}
+			s := newTestFleet(t, configured, nil)
+			var got int
+			for _, e := range tt.sent {
+				got = ingest(t, s, tt.token, e)
+			}
+			if got != tt.want {
+				t.Errorf("status %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostFileName(t *testing.T) {
+	hosts := []string{"web01", "web01.example.com", "a_b", "a/b", "a%2Fb", "a b", "a:b", "..", "ä"}
+	seen := make(map[string]string)
+	for _, host := range hosts {
+		name := hostFileName(host)
+		if other, ok := seen[name]; ok {
+			t.Errorf("hosts %q and %q share file name %q", other, host, name)
+		}
+		seen[name] = host
+		if got := hostFromFileName(name); got != host {
+			t.Errorf("hostFromFileName(%q) = %q, want %q", name, got, host)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/report"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// Forecast models, see ForecastRule
+const (
+	modelLinear      = "linear"
+	modelHoltWinters = "holt-winters"
+)
+
+// forecastStep is the spacing of the daily series the seasonal model is fit to
+const forecastStep = 86400
+
+// forecastMaxDays bounds how far ahead the fill date is searched
+const forecastMaxDays = 3650
+
+// forecastMinSamples is the fewest samples a fill date is trusted from,
+// whatever the fit
+const forecastMinSamples = 5
+
+// ForecastRule selects the forecast model for mounts whose mount point or
+// device matches Pattern. Mounts matching no rule use linear regression.
+type ForecastRule struct {
+	Pattern string `yaml:"pattern"`
+	// Model is linear or holt-winters (additive trend and season)
+	Model string `yaml:"model"`
+	// Season is the cycle length for holt-winters in whole days, e.g. 7d or
+	// 2w (default 7d)
+	Season string `yaml:"season"`
+}
+
+// forecastModel is a validated ForecastRule
+type forecastModel struct {
+	name string
+	// season is the cycle length in daily steps
+	season int
+}
+
+// parseForecastModel validates a model name and season
+func parseForecastModel(name, season string) (forecastModel, error) {
+	m := forecastModel{name: name, season: 7}
+	if m.name == "" {
+		m.name = modelLinear
+	}
+	if m.name != modelLinear && m.name != modelHoltWinters {
+		return m, fmt.Errorf("unknown model %q (want %s or %s)", name, modelLinear, modelHoltWinters)
+	}
+	if season != "" {
+		d, err := parseAge(season)
+		if err != nil || d < 2*24*time.Hour || d%(24*time.Hour) != 0 {
+			return m, fmt.Errorf("season %q must be a whole number of days, at least 2d", season)
+		}
+		m.season = int(d / (24 * time.Hour))
+	}
+	return m, nil
+}
+
+// forecastModelFor returns the model of the first rule matching the mount
+func (c *Config) forecastModelFor(mount nfsMount) (forecastModel, error) {
+	for _, rule := range c.Forecast {
+		if matchesMount(rule.Pattern, mount) {
+			m, err := parseForecastModel(rule.Model, rule.Season)
+			if err != nil {
+				return m, fmt.Errorf("forecast rule %q: %v", rule.Pattern, err)
+			}
+			return m, nil
+		}
+	}
+	return forecastModel{name: modelLinear}, nil
+}
+
+// forecastSeries is one mount's samples over the window
+type forecastSeries struct {
+	mount      string
+	device     string
+	timestamps []int64
+	bytes      []int64
+	// free is the free space at the newest sample, nil when unknown
+	free *int64
+}
+
+// mountForecast is the projection for one mount
+type mountForecast struct {
+	mount string
+	model string
+	// note explains a fallback, e.g. too little history for the season
+	note   string
+	used   int64
+	future int64
+	// perDay is the growth per day, the trend for holt-winters
+	perDay float64
+	// full is when usage reaches the current capacity, zero when it doesn't
+	// within forecastMaxDays or the capacity is unknown
+	full time.Time
+	// fit is the coefficient of determination (R²) of the model over the
+	// window, for holt-winters of its one-step-ahead predictions
+	fit     float64
+	fitted  bool
+	samples int
+}
+
+// lowConfidence reports whether the fill date shouldn't be relied on: the
+// model explains less than minFit of the variation or there are too few samples
+func (f mountForecast) lowConfidence(minFit float64) bool {
+	return !f.fitted || f.samples < forecastMinSamples || f.fit < minFit
+}
+
+// defaultMinFit is the R² below which a fill date is low confidence unless
+// --min-fit says otherwise
+const defaultMinFit = 0.5
+
+// daysToFull returns the forecast days from now until the mount is full,
+// false when it isn't projected to fill or the fit is low confidence
+func (f mountForecast) daysToFull(now time.Time, minFit float64) (float64, bool) {
+	if f.full.IsZero() || f.lowConfidence(minFit) {
+		return 0, false
+	}
+	return max(0, f.full.Sub(now).Hours()/24), true
+}
+
+// forecastMounts fits each mount's usage at or after from with the model the
+// config selects for it, keyed by mount
+func forecastMounts(st historyStore, from int64, cfg *Config) (map[string]mountForecast, error) {
+	series, err := collectForecastSeries(st, from)
+	if err != nil {
+		return nil, err
+	}
+	forecasts := make(map[string]mountForecast, len(series))
+	for _, s := range series {
+		m, err := cfg.forecastModelFor(nfsMount{MountPoint: s.mount, Device: s.device})
+		if err != nil {
+			return nil, err
+		}
+		forecasts[s.mount] = s.forecast(m, 0)
+	}
+	return forecasts, nil
+}
+
+// collectForecastSeries gathers each mount's samples at or after from
+func collectForecastSeries(st historyStore, from int64) ([]*forecastSeries, error) {
+	series := make(map[string]*forecastSeries)
+	err := store.ScanRange(st, from, 0, func(entry UsageEntry) error {
+		for mount, bytes := range entry.Mounts {
+			if collector.IsSnapshotMount(mount) {
+				continue
+			}
+			s := series[mount]
+			if s == nil {
+				s = &forecastSeries{mount: mount}
+				series[mount] = s
+			}
+			detail := entry.Details[mount]
+			s.device, s.free = detail.Device, detail.Available
+			s.timestamps = append(s.timestamps, entry.Timestamp)
+			s.bytes = append(s.bytes, bytes)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*forecastSeries, 0, len(series))
+	for _, s := range series {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].mount < result[j].mount })
+	return result, nil
+}
+
+// forecast projects a series horizon seconds past its newest sample
+func (s *forecastSeries) forecast(m forecastModel, horizon int64) mountForecast {
+	n := len(s.bytes)
+	f := mountForecast{mount: s.mount, model: m.name, used: s.bytes[n-1], future: s.bytes[n-1], samples: n}
+	last := s.timestamps[n-1]
+	capacity := int64(-1)
+	if s.free != nil {
+		capacity = f.used + *s.free
+	}
+
+	if m.name == modelHoltWinters {
+		daily := s.daily()
+		if len(daily) >= 2*m.season {
+			hw, fit := fitHoltWinters(daily, m.season)
+			steps := int(math.Ceil(float64(horizon) / forecastStep))
+			f.future = int64(math.Round(hw.predict(steps)))
+			f.perDay, f.fit, f.fitted = hw.trend, fit, true
+			if capacity >= 0 {
+				for h := 1; h <= forecastMaxDays; h++ {
+					if hw.predict(h) >= float64(capacity) {
+						f.full = time.Unix(last+int64(h)*forecastStep, 0)
+						break
+					}
+				}
+			}
+			return f
+		}
+		f.model = modelLinear
+		f.note = fmt.Sprintf("needs %d days of history", 2*m.season)
+	}
+
+	slope, intercept, fit, ok := report.LinearFit(s.timestamps, s.bytes)
+	if !ok {
+		f.note = "too few samples"
+		return f
+	}
+	f.perDay, f.fit, f.fitted = slope*86400, fit, true
+	f.future = int64(math.Round(intercept + slope*float64(last+horizon)))
+	if capacity >= 0 && slope > 0 {
+		at := (float64(capacity) - intercept) / slope
+		if at < float64(last) {
+			at = float64(last)
+		}
+		if at-float64(last) <= forecastMaxDays*86400 {
+			f.full = time.Unix(int64(at), 0)
+		}
+	}
+	return f
+}
+
+// daily resamples the series to one value per forecastStep ending at the
+// newest sample, using the newest sample in each step and interpolating
+// linearly across steps without one
+func (s *forecastSeries) daily() []float64 {
+	last := s.timestamps[len(s.timestamps)-1]
+	steps := int((last-s.timestamps[0])/forecastStep) + 1
+	values := make([]float64, steps)
+	have := make([]bool, steps)
+	for i, ts := range s.timestamps {
+		k := steps - 1 - int((last-ts)/forecastStep)
+		values[k], have[k] = float64(s.bytes[i]), true
+	}
+	prev := -1
+	for k := range values {
+		if !have[k] {
+			continue
+		}
+		if prev >= 0 && k-prev > 1 {
+			for j := prev + 1; j < k; j++ {
+				values[j] = values[prev] + (values[k]-values[prev])*float64(j-prev)/float64(k-prev)
+			}
+		}
+		prev = k
+	}
+	return values
+}
+
+// holtWinters is a fitted additive Holt-Winters model
+type holtWinters struct {
+	level, trend float64
+	// seasonal holds the last season's offsets, seasonal[i] applies to steps
+	// i+1 seasons ahead of the end of the series modulo the season
+	seasonal []float64
+}
+
+// predict returns the value h steps after the end of the series
+func (hw holtWinters) predict(h int) float64 {
+	m := len(hw.seasonal)
+	return hw.level + float64(h)*hw.trend + hw.seasonal[(h-1)%m]
+}
+
+// fitHoltWinters fits an additive Holt-Winters model with season length m,
+// choosing the smoothing parameters from a coarse grid by one-step-ahead
+// squared error, and returns it with the R² of those predictions (clamped
+// at 0). y must hold at least two seasons.
+func fitHoltWinters(y []float64, m int) (holtWinters, float64) {
+	grid := []float64{0.1, 0.3, 0.5, 0.7, 0.9}
+	var best holtWinters
+	bestSSE := math.Inf(1)
+	for _, alpha := range grid {
+		for _, beta := range grid {
+			for _, gamma := range grid {
+				hw, sse := runHoltWinters(y, m, alpha, beta, gamma)
+				if sse < bestSSE {
+					best, bestSSE = hw, sse
+				}
+			}
+		}
+	}
+
+	var mean, total float64
+	for _, v := range y[m:] {
+		mean += v
+	}
+	mean /= float64(len(y) - m)
+	for _, v := range y[m:] {
+		total += (v - mean) * (v - mean)
+	}
+	if total == 0 {
+		return best, 1
+	}
+	return best, max(0, 1-bestSSE/total)
+}
+
+// runHoltWinters smooths y with the given parameters, initialised from the
+// first two seasons, and returns the final state with the squared error of
+// its one-step-ahead predictions
+func runHoltWinters(y []float64, m int, alpha, beta, gamma float64) (holtWinters, float64) {
+	var first, second float64
+	for i := 0; i < m; i++ {
+		first += y[i]
+		second += y[m+i]
+	}
+	first /= float64(m)
+	second /= float64(m)
+	level, trend := first, (second-first)/float64(m)
+	seasonal := make([]float64, m)
+	for i := 0; i < m; i++ {
+		seasonal[i] = y[i] - first
+	}
+
+	var sse float64
+	for t := m; t < len(y); t++ {
+		s := seasonal[t%m]
+		predicted := level + trend + s
+		sse += (y[t] - predicted) * (y[t] - predicted)
+		prevLevel := level
+		level = alpha*(y[t]-s) + (1-alpha)*(level+trend)
+		trend = beta*(level-prevLevel) + (1-beta)*trend
+		seasonal[t%m] = gamma*(y[t]-level) + (1-gamma)*s
+	}
+	// Rotate so index 0 is the offset for the step after the series ends
+	rotated := make([]float64, m)
+	for i := range rotated {
+		rotated[i] = seasonal[(len(y)+i)%m]
+	}
+	return holtWinters{level: level, trend: trend, seasonal: rotated}, sse
+}
+
+// printForecast prints the projection per mount with its fit (R²). Fill dates
+// from low-confidence fits are marked, or left out with suppress.
+func printForecast(forecasts []mountForecast, horizon string, minFit float64, suppress bool) {
+	mountWidth, modelWidth := len("Mountpoint"), len("Model")
+	for _, f := range forecasts {
+		mountWidth = max(mountWidth, len(f.mount))
+		modelWidth = max(modelWidth, len(f.model))
+	}
+	future := "In " + horizon
+	fmt.Printf("%-*s  %-*s  %12s  %12s  %12s  %4s  %s\n", mountWidth, "Mountpoint", modelWidth, "Model", "Used", future, "Growth/day", "Fit", "Full on")
+	fmt.Printf("%-*s  %-*s  %12s  %12s  %12s  %4s  %-11s\n", mountWidth, strings.Repeat("-", mountWidth), modelWidth, strings.Repeat("-", modelWidth), strings.Repeat("-", 12), strings.Repeat("-", 12), strings.Repeat("-", 12), "----", strings.Repeat("-", 11))
+	marked := false
+	for _, f := range forecasts {
+		fit, full := "-", "-"
+		if f.fitted {
+			fit = fmt.Sprintf("%.2f", f.fit)
+		}
+		if !f.full.IsZero() {
+			full = formatDate(f.full)
+			if f.lowConfidence(minFit) {
+				if suppress {
+					full = "-"
+				} else {
+					full += "?"
+				}
+				marked = true
+			}
+		}
+		line := fmt.Sprintf("%-*s  %-*s  %12s  %12s  %12s  %4s  %-11s", mountWidth, f.mount, modelWidth, f.model, formatBytes(f.used), formatBytes(f.future), formatDiff(int64(f.perDay)), fit, full)
+		if f.note != "" {
+			line += "  (" + f.note + ")"
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+	if marked {
+		if suppress {
+			fmt.Printf("\nFill dates with R² below %.2f or fewer than %d samples are not shown\n", minFit, forecastMinSamples)
+		} else {
+			fmt.Printf("\n? low confidence: R² below %.2f or fewer than %d samples, don't plan purchases on it\n", minFit, forecastMinSamples)
+		}
+	}
+}
+
+// runForecast implements the forecast subcommand, which projects each
+// mount's usage with linear regression or, for mounts with strong cycles,
+// a seasonal Holt-Winters model chosen per mount pattern in the config
+func runForecast(args []string) {
+	fs := flag.NewFlagSet("forecast", flag.ExitOnError)
+	var filePath, configPath, keyFile, window, horizon, model, redactMode string
+	var minFit float64
+	var suppress bool
+	fs.StringVar(&filePath, "file", "", "Path to JSON file for storing usage data (default: config file, else CWD/nfsusage.json)")
+	fs.StringVar(&filePath, "f", "", "Path to JSON file for storing usage data (shorthand)")
+	fs.StringVar(&configPath, "config", "", "Path to YAML config file")
+	fs.StringVar(&keyFile, "key-file", "", "Path to AES-256 key for the data file")
+	fs.StringVar(&window, "window", "90d", "History the models are fit to, e.g. 90d or 8w (0 for the whole history)")
+	fs.StringVar(&horizon, "horizon", "30d", "How far ahead to project usage, e.g. 30d")
+	fs.StringVar(&model, "model", "", "Use this model for every mount instead of the config's forecast rules: linear or holt-winters")
+	fs.StringVar(&redactMode, "redact-paths", "", "Redact mount paths in output: hash or alias")
+	fs.Float64Var(&minFit, "min-fit", defaultMinFit, "R² below which a fill date is marked as low confidence")
+	fs.BoolVar(&suppress, "suppress-low-fit", false, "Leave low-confidence fill dates out instead of marking them")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	var override *forecastModel
+	if model != "" {
+		m, err := parseForecastModel(model, "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --model: %v\n", err)
+			os.Exit(1)
+		}
+		override = &m
+	}
+	ahead, err := parseAge(horizon)
+	if err != nil || ahead <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: invalid --horizon %q\n", horizon)
+		os.Exit(1)
+	}
+	var from int64
+	if window != "0" && window != "" {
+		age, err := parseAge(window)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --window: %v\n", err)
+			os.Exit(1)
+		}
+		from = timeSource.Now().Add(-age).Unix()
+	}
+	key, err := loadKey(keyFile, cfg.Encryption)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading encryption key: %v\n", err)
+		os.Exit(1)
+	}
+	redact, err := newRedactor(redactMode, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	filePath = cfg.dataFile(filePath)
+
+	st, err := openSeriesStore(filePath, key, cfg.Compact)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading remap rules: %v\n", err)
+		os.Exit(1)
+	}
+	series, err := collectForecastSeries(st, from)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+		os.Exit(1)
+	}
+	if len(series) == 0 {
+		fmt.Fprintf(os.Stderr, "No entries within the last %s\n", window)
+		os.Exit(1)
+	}
+
+	forecasts := make([]mountForecast, 0, len(series))
+	for _, s := range series {
+		m, err := cfg.forecastModelFor(nfsMount{MountPoint: s.mount, Device: s.device})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if override != nil {
+			m.name = override.name
+		}
+		f := s.forecast(m, int64(ahead/time.Second))
+		f.mount = redact.path(f.mount)
+		forecasts = append(forecasts, f)
+	}
+	printForecast(forecasts, horizon, minFit, suppress)
+}
+
+// validateForecastRules checks the config's forecast rules, for doctor
+func validateForecastRules(rules []ForecastRule) error {
+	for _, rule := range rules {
+		if err := validatePattern(rule.Pattern); err != nil {
+			return fmt.Errorf("forecast pattern %q: %v", rule.Pattern, err)
+		}
+		if _, err := parseForecastModel(rule.Model, rule.Season); err != nil {
+			return fmt.Errorf("forecast rule %q: %v", rule.Pattern, err)
+		}
+	}
+	return nil
+}
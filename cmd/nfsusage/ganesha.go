@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+// ganeshaTimeout bounds each command run against a Ganesha server
+const ganeshaTimeout = 10 * time.Second
+
+// GaneshaServer is an NFS-Ganesha server, e.g. in front of Ceph or Gluster,
+// whose dbus interface is queried for the exports mounted from it
+type GaneshaServer struct {
+	// Server is the host as it appears in the mount's device
+	Server string `yaml:"server"`
+	// Via prefixes the commands run on the server, e.g. "ssh filer01"; empty
+	// runs them on this host
+	Via string `yaml:"via"`
+}
+
+// command runs name with args on the server and returns its output
+func (g GaneshaServer) command(name string, args ...string) ([]byte, error) {
+	argv := append(strings.Fields(g.Via), name)
+	argv = append(argv, args...)
+	ctx, cancel := context.WithTimeout(context.Background(), ganeshaTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %v: %s", name, err, msg)
+		}
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return output, nil
+}
+
+// dbus calls a method of Ganesha's dbus interface on the server
+func (g GaneshaServer) dbus(object, method string) ([]byte, error) {
+	return g.command("dbus-send", "--system", "--print-reply", "--dest=org.ganesha.nfsd", object, method)
+}
+
+// usage measures an export path with df on the server. It only works where
+// the path is a local or mounted filesystem there, as with FSAL_VFS, not for
+// exports reached through a userspace client library.
+func (g GaneshaServer) usage(path string) (used, size int64, err error) {
+	output, err := g.command("df", "-P", "-B1", "--", path)
+	if err != nil {
+		return 0, 0, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) < 3 {
+		return 0, 0, fmt.Errorf("unexpected df output %q", output)
+	}
+	if size, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("unexpected df output %q", output)
+	}
+	if used, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("unexpected df output %q", output)
+	}
+	return used, size, nil
+}
+
+// matchGaneshaExport returns the export a mount of exportPath is served
+// from: the export with the longest path containing it
+func matchGaneshaExport(exports []collector.GaneshaExport, exportPath string) (collector.GaneshaExport, bool) {
+	var best collector.GaneshaExport
+	found := false
+	for _, e := range exports {
+		path := strings.TrimSuffix(e.Path, "/")
+		if exportPath != path && !strings.HasPrefix(exportPath, path+"/") {
+			continue
+		}
+		if !found || len(e.Path) > len(best.Path) {
+			best, found = e, true
+		}
+	}
+	return best, found
+}
+
+// enrichGanesha records what the configured Ganesha servers report about the
+// exports behind the entry's mounts. Each server is queried once; failures
+// only cost the enrichment and are warned about.
+func enrichGanesha(entry *UsageEntry, mounts []nfsMount, servers []GaneshaServer) {
+	byServer := make(map[string][]nfsMount)
+	for _, mount := range mounts {
+		if _, ok := entry.Details[mount.MountPoint]; ok {
+			host := collector.ServerHost(mount.Device)
+			byServer[host] = append(byServer[host], mount)
+		}
+	}
+	for _, g := range servers {
+		if len(byServer[g.Server]) == 0 {
+			continue
+		}
+		output, err := g.dbus("/org/ganesha/nfsd/ExportMgr", "org.ganesha.nfsd.exportmgr.ShowExports")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Error listing Ganesha exports on %s: %v\n", g.Server, err)
+			continue
+		}
+		exports, err := collector.ParseGaneshaExports(bytes.NewReader(output))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Error parsing Ganesha exports of %s: %v\n", g.Server, err)
+			continue
+		}
+		clients := 0
+		if output, err = g.dbus("/org/ganesha/nfsd/ClientMgr", "org.ganesha.nfsd.clientmgr.ShowClients"); err == nil {
+			clients, err = collector.ParseGaneshaClients(bytes.NewReader(output))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Error listing Ganesha clients on %s: %v\n", g.Server, err)
+		}
+
+		measured := make(map[int]*collector.GaneshaInfo)
+		for _, mount := range byServer[g.Server] {
+			export, ok := matchGaneshaExport(exports, exportPath(mount.Device))
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Warning: %s is not a Ganesha export of %s\n", exportPath(mount.Device), g.Server)
+				continue
+			}
+			info := measured[export.ID]
+			if info == nil {
+				info = &collector.GaneshaInfo{ExportID: export.ID, ServerClients: clients}
+				if used, size, err := g.usage(export.Path); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: Error measuring Ganesha export %s on %s: %v\n", export.Path, g.Server, err)
+				} else {
+					info.Used, info.Size = &used, &size
+				}
+				measured[export.ID] = info
+			}
+			detail := entry.Details[mount.MountPoint]
+			detail.Ganesha = info
+			entry.Details[mount.MountPoint] = detail
+		}
+	}
+}
+
+// printGanesha prints the server side of Ganesha exports next to the usage
+// measured by this client. The client count is the server's, shared by all
+// of its exports.
+func printGanesha(entry UsageEntry) {
+	var mounts []string
+	mountWidth := len("Mountpoint")
+	for mount, detail := range entry.Details {
+		if detail.Ganesha == nil {
+			continue
+		}
+		mounts = append(mounts, mount)
+		if len(mount) > mountWidth {
+			mountWidth = len(mount)
+		}
+	}
+	if len(mounts) == 0 {
+		fmt.Println("No Ganesha exports measured")
+		return
+	}
+	sort.Strings(mounts)
+
+	fmt.Printf("%-*s  %6s  %12s  %12s  %12s  %14s\n", mountWidth, "Mountpoint", "Export", "Client used", "Server used", "Server size", "Server clients")
+	for _, mount := range mounts {
+		g := entry.Details[mount].Ganesha
+		used, size := "-", "-"
+		if g.Used != nil {
+			used = formatBytes(*g.Used)
+		}
+		if g.Size != nil {
+			size = formatBytes(*g.Size)
+		}
+		fmt.Printf("%-*s  %6d  %12s  %12s  %12s  %14d\n", mountWidth, mount, g.ExportID, formatBytes(entry.Mounts[mount]), used, size, g.ServerClients)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// generationAge is how long appends go between backups; rewrites always
+// take one first since they replace the whole history
+const generationAge = 24 * time.Hour
+
+// backupPolicy keeps copies of the data file so a bad rewrite or a corrupting
+// bug doesn't destroy the only copy of the history
+type backupPolicy struct {
+	// generations keeps <file>.1 (newest) to <file>.N, 0 disables them
+	generations int
+	// command runs through sh with NFSUSAGE_FILE set to the data file
+	command string
+}
+
+// backups is set from --generations and the config
+var backups backupPolicy
+
+// generationPath returns the path of generation n of a data file
+func generationPath(filePath string, n int) string {
+	return fmt.Sprintf("%s.%d", filePath, n)
+}
+
+// backupStampPath records when the last backup was taken
+func backupStampPath(filePath string) string {
+	return filePath + ".lastbackup"
+}
+
+// backupDue reports whether an append should take a backup, i.e. no backup
+// was taken within generationAge
+func backupDue(filePath string) bool {
+	info, err := os.Stat(backupStampPath(filePath))
+	return err != nil || timeSource.Now().Sub(info.ModTime()) >= generationAge
+}
+
+// backup keeps a generation and runs the backup command before the data file
+// is modified. force is set for rewrites, otherwise a backup is taken at most
+// every generationAge. A missing data file has nothing to back up.
+func (p backupPolicy) backup(filePath string, force bool) error {
+	if p.generations <= 0 && p.command == "" {
+		return nil
+	}
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return nil
+	}
+	if !force && !backupDue(filePath) {
+		return nil
+	}
+	if p.generations > 0 {
+		if err := rotateGenerations(filePath, p.generations); err != nil {
+			return fmt.Errorf("keeping generation: %v", err)
+		}
+	}
+	if p.command != "" {
+		cmd := exec.Command("sh", "-c", p.command)
+		cmd.Env = append(os.Environ(), "NFSUSAGE_FILE="+filePath)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("backup command: %v", err)
+		}
+	}
+	now := timeSource.Now()
+	if err := os.WriteFile(backupStampPath(filePath), nil, 0644); err != nil {
+		return err
+	}
+	return os.Chtimes(backupStampPath(filePath), now, now)
+}
+
+// rotateGenerations shifts <file>.1 to <file>.n-1 up by one, dropping <file>.n,
+// and copies the data file and its manifest to <file>.1. Copies rather than
+// links because .jsonl stores are appended to in place.
+func rotateGenerations(filePath string, n int) error {
+	for _, sidecar := range []func(string) string{func(p string) string { return p }, store.ManifestPath} {
+		if err := os.Remove(sidecar(generationPath(filePath, n))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for i := n - 1; i >= 1; i-- {
+			err := os.Rename(sidecar(generationPath(filePath, i)), sidecar(generationPath(filePath, i+1)))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		err := copyFileAtomic(sidecar(filePath), sidecar(generationPath(filePath, 1)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFileAtomic copies src to dst through a synced temporary file, keeping
+// src's permissions
+func copyFileAtomic(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
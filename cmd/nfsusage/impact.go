@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// impactRow summarizes one host's use of an export over its recorded history
+type impactRow struct {
+	Host      string `json:"host"`
+	Mount     string `json:"mount"`
+	Export    string `json:"export"`
+	Entries   int    `json:"entries"`
+	FirstSeen int64  `json:"first_seen"`
+	LastSeen  int64  `json:"last_seen"`
+	FirstUsed int64  `json:"first_used_bytes"`
+	UsedBytes int64  `json:"used_bytes"`
+}
+
+// growth is the usage change between the first and last entry
+func (r impactRow) growth() int64 {
+	return r.UsedBytes - r.FirstUsed
+}
+
+// perDay is the average growth per day, zero for a single entry
+func (r impactRow) perDay() int64 {
+	days := float64(r.LastSeen-r.FirstSeen) / 86400
+	if days <= 0 {
+		return 0
+	}
+	return int64(float64(r.growth()) / days)
+}
+
+// collectImpact finds every host and mount that recorded an export matching
+// pattern, which is either an exact device or a glob such as "nas1:/vol/*"
+func collectImpact(dataDir, pattern string) ([]impactRow, error) {
+	stores, err := fleetStores(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var rows []impactRow
+	for host, st := range stores {
+		seen := make(map[string]*impactRow)
+		err := st.Scan(func(entry UsageEntry) error {
+			for mount, used := range entry.Mounts {
+				export := entry.Details[mount].Device
+				if ok, _ := filepath.Match(pattern, export); !ok && export != pattern {
+					continue
+				}
+				key := export + "\x00" + mount
+				row := seen[key]
+				if row == nil {
+					row = &impactRow{Host: host, Mount: mount, Export: export, FirstSeen: entry.Timestamp, FirstUsed: used}
+					seen[key] = row
+				}
+				row.Entries++
+				row.LastSeen, row.UsedBytes = entry.Timestamp, used
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", host, err)
+		}
+		for _, row := range seen {
+			rows = append(rows, *row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].LastSeen != rows[j].LastSeen {
+			return rows[i].LastSeen > rows[j].LastSeen
+		}
+		return rows[i].Host+rows[i].Mount < rows[j].Host+rows[j].Mount
+	})
+	return rows, nil
+}
+
+// printImpact prints the hosts referencing an export, most recently seen first,
+// and flags hosts that have stopped reporting it
+func printImpact(pattern string, rows []impactRow, stale time.Duration) {
+	if len(rows) == 0 {
+		fmt.Printf("No host has recorded export %s\n", pattern)
+		return
+	}
+
+	hostWidth, mountWidth := len("Host"), len("Mountpoint")
+	for _, r := range rows {
+		hostWidth = max(hostWidth, len(r.Host))
+		mountWidth = max(mountWidth, len(r.Mount))
+	}
+	fmt.Printf("Impact of decommissioning %s\n\n", pattern)
+	fmt.Printf("%-*s  %-*s  %-16s  %-16s  %7s  %12s  %12s  %12s\n", hostWidth, "Host", mountWidth, "Mountpoint",
+		"First seen", "Last seen", "Entries", "Used", "Growth", "Per day")
+	fmt.Printf("%s\n", strings.Repeat("-", hostWidth+mountWidth+87))
+
+	now := timeSource.Now()
+	active := 0
+	var totalUsed, totalGrowth int64
+	for _, r := range rows {
+		note := ""
+		if now.Sub(time.Unix(r.LastSeen, 0)) > stale {
+			note = "  (not seen recently)"
+		} else {
+			active++
+			totalUsed += r.UsedBytes
+			totalGrowth += r.perDay()
+		}
+		fmt.Printf("%-*s  %-*s  %-16s  %-16s  %7d  %12s  %12s  %12s%s\n", hostWidth, r.Host, mountWidth, r.Mount,
+			formatDateTime(time.Unix(r.FirstSeen, 0)), formatDateTime(time.Unix(r.LastSeen, 0)),
+			r.Entries, formatBytes(r.UsedBytes), formatDiff(r.growth()), formatDiff(r.perDay()), note)
+	}
+	fmt.Printf("\n%d of %d host mounts seen within %s, currently using %s, growing %s per day\n",
+		active, len(rows), stale, formatBytes(totalUsed), formatDiff(totalGrowth))
+}
+
+// runImpact implements the impact subcommand: everything the fleet data knows
+// about one export, to decide whether it can be decommissioned
+func runImpact(args []string) {
+	fs := flag.NewFlagSet("impact", flag.ExitOnError)
+	var dataDir, numberFormatSpec string
+	var stale time.Duration
+	var asJSON bool
+	fs.StringVar(&dataDir, "data-dir", "fleet", "Directory holding one store per host (see serve)")
+	fs.DurationVar(&stale, "stale", 24*time.Hour, "Hosts not seen with the export for this long count as no longer mounting it")
+	fs.StringVar(&numberFormatSpec, "format-numbers", "", "Number formatting: comma separated thousands, decimals=N, width=N, unit=GiB|TiB")
+	fs.BoolVar(&asJSON, "json", false, "Print JSON instead of a table")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: nfsusage impact [flags] EXPORT\n\nEXPORT is a device such as nas1:/vol/home, or a glob.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	pattern := fs.Arg(0)
+
+	var err error
+	if numFmt, err = parseNumberFormat(numberFormatSpec); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --format-numbers: %v\n", err)
+		os.Exit(1)
+	}
+	rows, err := collectImpact(dataDir, pattern)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+		os.Exit(1)
+	}
+	if asJSON {
+		data, _ := json.MarshalIndent(rows, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+	printImpact(pattern, rows, stale)
+}
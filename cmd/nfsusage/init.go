@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// scratchPattern matches mount point names that are usually not worth tracking
+var scratchPattern = regexp.MustCompile(`(?i)(scratch|tmp|temp|cache)`)
+
+// prompter asks yes/no and free text questions, answering defaults when assumeYes is set
+type prompter struct {
+	in        *bufio.Reader
+	assumeYes bool
+}
+
+func (p *prompter) confirm(question string, def bool) bool {
+	hint := "[Y/n]"
+	if !def {
+		hint = "[y/N]"
+	}
+	if p.assumeYes {
+		answer := "n"
+		if def {
+			answer = "y"
+		}
+		fmt.Printf("%s %s %s\n", question, hint, answer)
+		return def
+	}
+	fmt.Printf("%s %s ", question, hint)
+	answer, err := p.in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return def
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	}
+	return def
+}
+
+func (p *prompter) ask(question, def string) string {
+	if p.assumeYes {
+		fmt.Printf("%s [%s]\n", question, def)
+		return def
+	}
+	fmt.Printf("%s [%s] ", question, def)
+	answer, _ := p.in.ReadString('\n')
+	if answer = strings.TrimSpace(answer); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// proposePatterns suggests include patterns (one glob per parent directory) and
+// exclude patterns (scratch-like mount names) for the discovered mounts
+func proposePatterns(mounts []nfsMount) (include, exclude []string) {
+	seen := make(map[string]bool)
+	for _, mount := range mounts {
+		if scratchPattern.MatchString(filepath.Base(mount.MountPoint)) {
+			exclude = append(exclude, mount.MountPoint)
+			continue
+		}
+		pattern := mount.MountPoint
+		if parent := filepath.Dir(mount.MountPoint); parent != "/" {
+			pattern = filepath.Join(parent, "*")
+		}
+		if !seen[pattern] {
+			seen[pattern] = true
+			include = append(include, pattern)
+		}
+	}
+	sort.Strings(include)
+	sort.Strings(exclude)
+	return include, exclude
+}
+
+// renderStarterConfig renders a commented starter config
+func renderStarterConfig(include, exclude []string, filePath string) string {
+	var b strings.Builder
+	b.WriteString("# nfsusage configuration, generated by 'nfsusage init'\n")
+	fmt.Fprintf(&b, "# Read from %s when --config is not given\n\n", defaultConfigPath)
+	b.WriteString("# Data file used when --file is not given\n")
+	fmt.Fprintf(&b, "file: %q\n\n", filePath)
+	b.WriteString("# Only mounts matching one of these globs (mount point or server:/export) are tracked\n")
+	writeYAMLList(&b, "include", include)
+	b.WriteString("\n# Mounts matching these globs are skipped\n")
+	writeYAMLList(&b, "exclude", exclude)
+	b.WriteString("\n# How servers are identified when grouping: mounted, ip or rdns\n")
+	b.WriteString("server_identity: mounted\n")
+	b.WriteString("\n# Filesystems reporting fake capacity, EFS is detected automatically\n")
+	b.WriteString("# quirks:\n#   - pattern: \"/mnt/ganesha/*\"\n#     elastic: true\n")
+	b.WriteString("\n# Output format when --output is not given: table, json, csv or motd\n")
+	b.WriteString("# output: table\n")
+	b.WriteString("\n# Sampling interval in daemon mode\n")
+	b.WriteString("# interval: 15m\n")
+	b.WriteString("\n# Thresholds of 'nfsusage check', a percentage used or the free space left\n")
+	b.WriteString("# check:\n#   warn: 85%\n#   crit: 50GiB\n")
+	return b.String()
+}
+
+func writeYAMLList(b *strings.Builder, key string, values []string) {
+	if len(values) == 0 {
+		fmt.Fprintf(b, "%s: []\n", key)
+		return
+	}
+	fmt.Fprintf(b, "%s:\n", key)
+	for _, v := range values {
+		fmt.Fprintf(b, "  - %q\n", v)
+	}
+}
+
+// runInit implements the init subcommand
+func runInit(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	var configPath, filePath, systemdDir string
+	var interval time.Duration
+	var assumeYes bool
+	fs.StringVar(&configPath, "config", "/etc/nfsusage/config.yaml", "Path of the config file to create")
+	fs.StringVar(&filePath, "file", "/var/lib/nfsusage/nfsusage.json", "Path of the JSON data file")
+	fs.StringVar(&systemdDir, "systemd-dir", "/etc/systemd/system", "Directory for the systemd service and timer")
+	fs.DurationVar(&interval, "interval", 15*time.Minute, "Collection interval for the systemd timer")
+	fs.BoolVar(&assumeYes, "yes", false, "Accept all proposed defaults without prompting")
+	fs.Parse(args)
+
+	p := &prompter{in: bufio.NewReader(os.Stdin), assumeYes: assumeYes}
+
+	mounts, err := collector.ReadMounts()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting NFS mounts: %v\n", err)
+		os.Exit(1)
+	}
+	mounts = crossCheckNFSFS(mounts)
+	fmt.Printf("Found %d NFS mounts\n", len(mounts))
+	for _, mount := range mounts {
+		fmt.Printf("  %s (%s)\n", mount.MountPoint, mount.Device)
+	}
+	fmt.Println()
+
+	proposedInclude, proposedExclude := proposePatterns(mounts)
+	var include, exclude []string
+	for _, pattern := range proposedInclude {
+		if p.confirm(fmt.Sprintf("Track mounts matching %s?", pattern), true) {
+			include = append(include, pattern)
+		}
+	}
+	for _, pattern := range proposedExclude {
+		if p.confirm(fmt.Sprintf("Skip %s?", pattern), true) {
+			exclude = append(exclude, pattern)
+		}
+	}
+
+	configPath = p.ask("Config file", configPath)
+	filePath = p.ask("Data file", filePath)
+	if _, err := os.Stat(configPath); err == nil && !p.confirm(configPath+" exists, overwrite?", false) {
+		fmt.Println("Keeping existing config")
+	} else {
+		if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating config directory: %v\n", err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(configPath, []byte(renderStarterConfig(include, exclude, filePath)), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing config: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote %s\n", configPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating data directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	if p.confirm(fmt.Sprintf("Install systemd service and timer (every %s) in %s?", interval, systemdDir), true) {
+		binary, err := os.Executable()
+		if err != nil {
+			binary = "/usr/local/bin/nfsusage"
+		}
+		units := renderSystemdUnits([]string{binary, "collect", "--config", configPath, "--file", filePath}, interval, []string{filepath.Dir(filePath)})
+		if err := writeSystemdUnits(systemdDir, units); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing systemd units: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote nfsusage.service and nfsusage.timer, enable with: systemctl daemon-reload && systemctl enable --now nfsusage.timer\n")
+	}
+
+	if len(mounts) > 0 && p.confirm("Record an initial snapshot now?", true) {
+		cfg, err := loadConfig(configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+			os.Exit(1)
+		}
+		entry, _ := collectEntry(cfg.filterMounts(mounts), cfg, collectOptions{serverIdentity: identityMounted, mountTimeout: defaultMountTimeout})
+		if _, err := appendEntry(store.Open(filePath, nil, cfg.Compact), entry, nil, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Error %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println()
+		printCurrent(entry)
+	}
+}
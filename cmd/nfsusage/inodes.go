@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// inodeOutput shows inode counts instead of bytes in the table output, set
+// from --inodes
+var inodeOutput bool
+
+// formatInodes formats an inode count
+func formatInodes(n int64) string {
+	return numFmt.count(n, "")
+}
+
+// formatInodeDiff formats an inode count difference with +/- prefix
+func formatInodeDiff(diff int64) string {
+	if diff >= 0 {
+		return numFmt.count(diff, "+")
+	}
+	return numFmt.count(-diff, "-")
+}
+
+// inodePercent returns the share of a mount's inodes in use
+func inodePercent(inodes *InodeUsage) string {
+	if inodes == nil || inodes.Used+inodes.Free == 0 {
+		return "-"
+	}
+	return formatPercent("%.1f", float64(inodes.Used)/float64(inodes.Used+inodes.Free)*100)
+}
+
+// printInodes prints used and free inodes per mount. Mounts whose server
+// reports no inodes are shown with dashes.
+func printInodes(entry UsageEntry) {
+	mounts := make([]string, 0, len(entry.Mounts))
+	for mount := range entry.Mounts {
+		mounts = append(mounts, mount)
+	}
+	sort.Strings(mounts)
+
+	rows := [][4]string{{rowHeading(), "Used", "Free", "Use%"}}
+	var used, free int64
+	for _, mount := range mounts {
+		inodes := entry.Details[mount].Inodes
+		if inodes == nil {
+			rows = append(rows, [4]string{mountLabel(entry, mount), "-", "-", "-"})
+			continue
+		}
+		rows = append(rows, [4]string{mountLabel(entry, mount), formatInodes(inodes.Used), formatInodes(inodes.Free), inodePercent(inodes)})
+		used += inodes.Used
+		free += inodes.Free
+	}
+	rows = append(rows, [4]string{"total", formatInodes(used), formatInodes(free), inodePercent(&InodeUsage{Used: used, Free: free})})
+	printInodeRows(rows)
+}
+
+// printInodeComparison prints the change in used inodes per mount since base,
+// label heads the column of the entry being compared against
+func printInodeComparison(label string, base, current UsageEntry) {
+	mounts := make(map[string]bool)
+	for mount := range current.Mounts {
+		mounts[mount] = true
+	}
+	for mount := range base.Mounts {
+		mounts[mount] = true
+	}
+	names := make([]string, 0, len(mounts))
+	for mount := range mounts {
+		names = append(names, mount)
+	}
+	sort.Strings(names)
+
+	rows := [][4]string{{rowHeading(), label, "Current", "Difference"}}
+	var oldTotal, newTotal int64
+	for _, mount := range names {
+		oldInodes, newInodes := base.Details[mount].Inodes, current.Details[mount].Inodes
+		row := [4]string{mountLabel(current, mount), "-", "-", "-"}
+		if _, ok := current.Mounts[mount]; !ok {
+			row[0], row[2] = mount, "(removed)"
+		}
+		if oldInodes != nil {
+			row[1] = formatInodes(oldInodes.Used)
+			oldTotal += oldInodes.Used
+		}
+		if newInodes != nil {
+			row[2] = formatInodes(newInodes.Used)
+			newTotal += newInodes.Used
+		}
+		if oldInodes != nil && newInodes != nil {
+			row[3] = formatInodeDiff(newInodes.Used - oldInodes.Used)
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows, [4]string{"total", formatInodes(oldTotal), formatInodes(newTotal), formatInodeDiff(newTotal - oldTotal)})
+	printInodeRows(rows)
+}
+
+// printInodeRows prints a header row and data rows, the first column left
+// aligned and the others right aligned
+func printInodeRows(rows [][4]string) {
+	var widths [4]int
+	for _, r := range rows {
+		for i, v := range r {
+			widths[i] = max(widths[i], len(v))
+		}
+	}
+	for i, r := range rows {
+		fmt.Printf("%-*s  %*s  %*s  %*s\n", widths[0], r[0], widths[1], r[1], widths[2], r[2], widths[3], r[3])
+		if i == 0 {
+			fmt.Printf("%s  %s  %s  %s\n", strings.Repeat("-", widths[0]), strings.Repeat("-", widths[1]), strings.Repeat("-", widths[2]), strings.Repeat("-", widths[3]))
+		}
+	}
+}
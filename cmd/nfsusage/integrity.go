@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// runValidate implements the validate subcommand
+func runValidate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	var filePath, configPath, keyFile string
+	fs.StringVar(&filePath, "file", "", "Path to JSON file for storing usage data (default: config file, else CWD/nfsusage.json)")
+	fs.StringVar(&filePath, "f", "", "Path to JSON file for storing usage data (shorthand)")
+	fs.StringVar(&configPath, "config", "", "Path to YAML config file")
+	fs.StringVar(&keyFile, "key-file", "", "Path to AES-256 key for the data file")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	key, err := loadKey(keyFile, cfg.Encryption)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading encryption key: %v\n", err)
+		os.Exit(1)
+	}
+	filePath = cfg.dataFile(filePath)
+
+	entries, err := store.Open(filePath, key, cfg.Compact).Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+		os.Exit(1)
+	}
+
+	problems := store.Validate(filePath, entries, key)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+	fmt.Printf("%s: %d entries OK\n", filePath, len(entries))
+}
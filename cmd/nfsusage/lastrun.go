@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+// Run outcomes recorded in the last-run state file
+const (
+	runRunning  = "running"
+	runOK       = "ok"
+	runPartial  = "partial"
+	runDegraded = "degraded"
+	runFailed   = "failed"
+	runPanic    = "panic"
+)
+
+// lastRun is the state file written next to the data file on every collection,
+// so fleet automation can spot silently failing collectors without logs
+type lastRun struct {
+	Started  int64  `json:"started"`
+	Finished int64  `json:"finished,omitempty"`
+	Outcome  string `json:"outcome"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+	Mounts   int    `json:"mounts"`
+	PID      int    `json:"pid"`
+	Daemon   bool   `json:"daemon,omitempty"`
+	// Stack is the goroutine stack of a panic
+	Stack string `json:"stack,omitempty"`
+}
+
+// lastRunPath returns the state file for a data file
+func lastRunPath(filePath string) string {
+	return filePath + ".lastrun"
+}
+
+// runTracker is a collection in progress and the state file it updates
+type runTracker struct {
+	path  string
+	state lastRun
+}
+
+// currentRun is the collection in progress, nil outside collections
+var currentRun *runTracker
+
+// beginRun records that a collection started
+func beginRun(filePath string, daemon bool) {
+	currentRun = &runTracker{lastRunPath(filePath), lastRun{Started: time.Now().Unix(), Outcome: runRunning, PID: os.Getpid(), Daemon: daemon}}
+	writeLastRun(currentRun.path, currentRun.state)
+}
+
+// finishRun records how the current collection ended, it does nothing
+// outside a collection
+func finishRun(outcome string, code, mounts int, err error) {
+	if currentRun == nil {
+		return
+	}
+	s := &currentRun.state
+	s.Finished, s.Outcome, s.ExitCode, s.Mounts = time.Now().Unix(), outcome, code, mounts
+	if err != nil {
+		s.Error = err.Error()
+	}
+	writeLastRun(currentRun.path, *s)
+	currentRun = nil
+}
+
+// recoverRun records a panic in the current collection and re-panics. It
+// must be deferred directly.
+func recoverRun() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if currentRun != nil {
+		currentRun.state.Stack = string(debug.Stack())
+		finishRun(runPanic, 2, 0, fmt.Errorf("panic: %v", r))
+	}
+	panic(r)
+}
+
+// runOutcome maps a finished collection's exit code to its outcome
+func runOutcome(code int) string {
+	switch code {
+	case 0:
+		return runOK
+	case exitPartial:
+		return runPartial
+	case exitFatal:
+		return runFailed
+	default:
+		return runDegraded
+	}
+}
+
+// writeLastRun replaces the state file atomically, failures are only warned about
+func writeLastRun(path string, state lastRun) {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err == nil {
+		var tmp *os.File
+		tmp, err = os.CreateTemp(filepath.Dir(path), ".nfsusage-lastrun-*")
+		if err == nil {
+			_, err = tmp.Write(append(data, '\n'))
+			if cerr := tmp.Close(); err == nil {
+				err = cerr
+			}
+			if err == nil {
+				err = os.Rename(tmp.Name(), path)
+			}
+			if err != nil {
+				os.Remove(tmp.Name())
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error writing %s: %v\n", path, err)
+	}
+}
+
+// runLastRun implements the last-run subcommand. It exits 0 when the last
+// collection succeeded, 1 when it failed or panicked and 2 when there is no
+// state or it is older than --max-age.
+func runLastRun(args []string) {
+	fs := flag.NewFlagSet("last-run", flag.ExitOnError)
+	var filePath, configPath string
+	var maxAge time.Duration
+	var asJSON bool
+	fs.StringVar(&filePath, "file", "", "Path to the data file (default: config file, else CWD/nfsusage.json)")
+	fs.StringVar(&filePath, "f", "", "Path to the data file (shorthand)")
+	fs.StringVar(&configPath, "config", "", "Path to YAML config file")
+	fs.DurationVar(&maxAge, "max-age", 0, "Exit 2 when the last run started longer ago than this (0 disables)")
+	fs.BoolVar(&asJSON, "json", false, "Print the state file as JSON")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	filePath = cfg.dataFile(filePath)
+	path := lastRunPath(filePath)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: no last run recorded: %v\n", err)
+		os.Exit(2)
+	}
+	var state lastRun
+	if err := json.Unmarshal(data, &state); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing %s: %v\n", path, err)
+		os.Exit(2)
+	}
+
+	if asJSON {
+		fmt.Print(string(data))
+	} else {
+		started := time.Unix(state.Started, 0)
+		fmt.Printf("Outcome:  %s (exit %d)\n", state.Outcome, state.ExitCode)
+		fmt.Printf("Started:  %s (%s ago)\n", started.Format(time.RFC3339), time.Since(started).Round(time.Second))
+		if state.Finished != 0 {
+			fmt.Printf("Duration: %s\n", time.Unix(state.Finished, 0).Sub(started))
+		}
+		fmt.Printf("Mounts:   %d\n", state.Mounts)
+		if state.Error != "" {
+			fmt.Printf("Error:    %s\n", state.Error)
+		}
+		if state.Stack != "" {
+			fmt.Printf("\n%s", state.Stack)
+		}
+	}
+
+	switch {
+	case maxAge > 0 && time.Since(time.Unix(state.Started, 0)) > maxAge:
+		fmt.Fprintf(os.Stderr, "Last run is older than %s\n", maxAge)
+		os.Exit(2)
+	case state.Outcome == runFailed || state.Outcome == runPanic:
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// LatencyRule sets the maximum acceptable probe latency for matching mounts
+type LatencyRule struct {
+	Pattern string  `yaml:"pattern"`
+	MaxMs   float64 `yaml:"max_ms"`
+}
+
+// latencyThreshold returns the first matching latency threshold for a mount, or 0
+func (c *Config) latencyThreshold(mount nfsMount) float64 {
+	for _, rule := range c.Latency {
+		if matchesMount(rule.Pattern, mount) {
+			return rule.MaxMs
+		}
+	}
+	return 0
+}
+
+// latencyStats summarizes SLO breaches for a single mount over the history
+type latencyStats struct {
+	mount       string
+	thresholdMs float64
+	samples     int
+	breaches    int
+	worstMs     float64
+	lastBreach  int64
+}
+
+// computeLatencyStats scans the history and counts probe samples per mount that
+// exceeded the configured threshold. Unreachable servers always count as breaches.
+func computeLatencyStats(st historyStore, cfg *Config, r *redactor) ([]latencyStats, error) {
+	stats := make(map[string]*latencyStats)
+	err := st.Scan(func(entry UsageEntry) error {
+		for mount, detail := range entry.Details {
+			probe, ok := entry.Servers[detail.Server]
+			if !ok {
+				continue
+			}
+			threshold := cfg.latencyThreshold(nfsMount{MountPoint: mount, Device: detail.Device})
+			if threshold <= 0 {
+				continue
+			}
+
+			s := stats[mount]
+			if s == nil {
+				s = &latencyStats{mount: mount, thresholdMs: threshold}
+				stats[mount] = s
+			}
+			s.samples++
+			if probe.LatencyMs > s.worstMs {
+				s.worstMs = probe.LatencyMs
+			}
+			if !probe.RPC || probe.LatencyMs > threshold {
+				s.breaches++
+				s.lastBreach = entry.Timestamp
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]latencyStats, 0, len(stats))
+	for _, s := range stats {
+		s.mount = r.path(s.mount)
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].mount < result[j].mount })
+	return result, nil
+}
+
+// printLatencyReport prints latency SLO breaches per mount with aligned columns
+func printLatencyReport(stats []latencyStats) {
+	if len(stats) == 0 {
+		fmt.Fprintln(os.Stderr, "No latency thresholds configured or no probe data recorded")
+		return
+	}
+
+	mountWidth := len("Mountpoint")
+	for _, s := range stats {
+		if len(s.mount) > mountWidth {
+			mountWidth = len(s.mount)
+		}
+	}
+
+	fmt.Printf("%-*s  %10s  %7s  %8s  %10s  %s\n", mountWidth, "Mountpoint", "Threshold", "Samples", "Breaches", "Worst", "Last breach")
+	for _, s := range stats {
+		last := "-"
+		if s.lastBreach > 0 {
+			last = formatDateTime(time.Unix(s.lastBreach, 0))
+		}
+		fmt.Printf("%-*s  %8.1fms  %7d  %8d  %8.1fms  %s\n", mountWidth, s.mount, s.thresholdMs, s.samples, s.breaches, s.worstMs, last)
+	}
+}
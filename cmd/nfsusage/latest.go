@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/report"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// runStatus implements the status subcommand: the newest recorded entry,
+// read from the latest entry cache when it is current
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	var filePath, configPath, keyFile string
+	var asJSON, metrics bool
+	var maxAge time.Duration
+	fs.StringVar(&filePath, "file", "", "Path to the data file (default: config file, else CWD/nfsusage.json)")
+	fs.StringVar(&filePath, "f", "", "Path to the data file (shorthand)")
+	fs.StringVar(&configPath, "config", "", "Path to YAML config file")
+	fs.StringVar(&keyFile, "key-file", "", "Path to AES-256 key for the data file")
+	fs.BoolVar(&asJSON, "json", false, "Print the entry as --output json")
+	fs.BoolVar(&metrics, "metrics", false, "Print the entry in the Prometheus text format")
+	fs.DurationVar(&maxAge, "max-age", 0, "Exit 2 when the newest entry is older than this (0 disables)")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	key, err := loadKey(keyFile, cfg.Encryption)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading encryption key: %v\n", err)
+		os.Exit(1)
+	}
+	filePath = cfg.dataFile(filePath)
+
+	st := store.Open(filePath, key, cfg.Compact)
+	newest, _, err := lastEntry(st)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+		os.Exit(1)
+	}
+	if newest == nil {
+		fmt.Fprintf(os.Stderr, "Error: no entries recorded in %s\n", filePath)
+		os.Exit(2)
+	}
+	entry := collector.WithoutSnapshots(*newest)
+	entry.Unmeasured, entry.Unavailable = newest.Unmeasured, newest.Unavailable
+
+	switch {
+	case metrics:
+		if deadMounts, err = cfg.deadMountPolicy(""); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		seen, err := loadSightings(st)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading mount sightings: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(report.Metrics(entry))
+		fmt.Print(renderSightings(seen, entry, nil, timeSource.Now()))
+	case asJSON:
+		data, err := report.JSON(entry, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding output: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	default:
+		printCurrent(entry)
+		printDegraded(entry)
+	}
+
+	if age := timeSource.Now().Sub(time.Unix(entry.Timestamp, 0)); maxAge > 0 && age > maxAge {
+		fmt.Fprintf(os.Stderr, "Newest entry is %s old\n", age.Round(time.Second))
+		os.Exit(2)
+	}
+}
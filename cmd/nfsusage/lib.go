@@ -0,0 +1,23 @@
+package main
+
+import (
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// The CLI refers to the library types by the names they had before
+// collection and storage moved to pkg/
+type (
+	UsageEntry    = collector.UsageEntry
+	MountDetail   = collector.MountDetail
+	InodeUsage    = collector.InodeUsage
+	ServerProbe   = collector.ServerProbe
+	ProviderInfo  = collector.ProviderInfo
+	TransportInfo = collector.TransportInfo
+	NFSStats      = collector.NFSStats
+	NFSOpStats    = collector.NFSOpStats
+	nfsMount      = collector.Mount
+
+	historyStore      = store.Store
+	CorruptStoreError = store.CorruptStoreError
+)
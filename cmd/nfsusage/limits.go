@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultMaxCommands caps concurrent statfs calls when limits.max_commands is unset
+const defaultMaxCommands = 16
+
+// LimitsConfig bounds what nfsusage may consume, so it never becomes the
+// thing destabilizing a busy NFS client
+type LimitsConfig struct {
+	// MaxCommands caps concurrently running statfs calls, including ones
+	// abandoned on hung mounts after --deadline
+	MaxCommands int `yaml:"max_commands"`
+	// MaxOpenFiles lowers the open file descriptor limit (RLIMIT_NOFILE)
+	MaxOpenFiles uint64 `yaml:"max_open_files"`
+	// MaxMemoryMB is a soft memory limit for the Go runtime
+	MaxMemoryMB int64 `yaml:"max_memory_mb"`
+	// HardDeadline aborts the process when one collection takes longer, e.g. 5m
+	HardDeadline string `yaml:"hard_deadline"`
+}
+
+// errCommandRunning is returned for mounts whose previous statfs never returned
+var errCommandRunning = errors.New("previous statfs still running (hung mount?)")
+
+// commandLimiter hands out slots for blocking filesystem calls and remembers
+// which mounts still have one running, so a hung mount never gets a second one
+type commandLimiter struct {
+	mu      sync.Mutex
+	slots   chan struct{}
+	running map[string]bool
+}
+
+var commands = newCommandLimiter(defaultMaxCommands)
+
+func newCommandLimiter(max int) *commandLimiter {
+	return &commandLimiter{slots: make(chan struct{}, max), running: make(map[string]bool)}
+}
+
+// acquire takes a slot for path, waiting until deadline at most (zero waits
+// forever). The returned release must be called when the command returns.
+func (l *commandLimiter) acquire(path string, deadline time.Time) (func(), error) {
+	l.mu.Lock()
+	if l.running[path] {
+		l.mu.Unlock()
+		return nil, errCommandRunning
+	}
+	l.running[path] = true
+	l.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+	case <-timeout:
+		l.mu.Lock()
+		delete(l.running, path)
+		l.mu.Unlock()
+		return nil, errDeadline
+	}
+	return func() {
+		<-l.slots
+		l.mu.Lock()
+		delete(l.running, path)
+		l.mu.Unlock()
+	}, nil
+}
+
+// applyLimits validates and applies the limits to this process. Commands
+// still running when the command limit changes release their slot in the
+// previous limiter.
+func applyLimits(l LimitsConfig) error {
+	if l.MaxCommands < 0 || l.MaxMemoryMB < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if _, err := hardDeadline(l); err != nil {
+		return err
+	}
+	max := l.MaxCommands
+	if max == 0 {
+		max = defaultMaxCommands
+	}
+	if cap(commands.slots) != max {
+		commands = newCommandLimiter(max)
+	}
+	if l.MaxMemoryMB > 0 {
+		debug.SetMemoryLimit(l.MaxMemoryMB << 20)
+	}
+	if l.MaxOpenFiles > 0 {
+		var rlimit syscall.Rlimit
+		if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+			return fmt.Errorf("reading open file limit: %v", err)
+		}
+		rlimit.Cur = min(l.MaxOpenFiles, rlimit.Max)
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+			return fmt.Errorf("setting open file limit: %v", err)
+		}
+	}
+	return nil
+}
+
+// hardDeadline parses limits.hard_deadline, zero when unset
+func hardDeadline(l LimitsConfig) (time.Duration, error) {
+	if l.HardDeadline == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(l.HardDeadline)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid hard_deadline %q", l.HardDeadline)
+	}
+	return d, nil
+}
+
+// startWatchdog aborts the process if the returned stop function isn't called
+// within limit. A collection stuck that long is blocked in the kernel on a
+// hung mount and can't be cancelled; exiting lets the service manager restart
+// a clean process. Zero disables the watchdog.
+func startWatchdog(limit time.Duration) (stop func()) {
+	if limit <= 0 {
+		return func() {}
+	}
+	timer := time.AfterFunc(limit, func() {
+		err := fmt.Errorf("collection exceeded hard deadline of %s, aborting", limit)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		finishRun(runFailed, exitFatal, 0, err)
+		os.Exit(exitFatal)
+	})
+	return func() { timer.Stop() }
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// localeFormat is how a locale writes numbers and dates in tables and reports.
+// Machine-readable output (JSON, CSV, metrics) is never localized.
+type localeFormat struct {
+	decimalSep   string
+	thousandsSep string
+	// dateLayout is a Go layout of the same width as 2006-01-02, so columns
+	// keep their alignment
+	dateLayout string
+}
+
+// locales maps language_TERRITORY, or a bare language, to its format
+var locales = map[string]localeFormat{
+	"C":     {".", ",", "2006-01-02"},
+	"en":    {".", ",", "2006-01-02"},
+	"en_US": {".", ",", "01/02/2006"},
+	"en_GB": {".", ",", "02/01/2006"},
+	"en_IE": {".", ",", "02/01/2006"},
+	"de":    {",", ".", "02.01.2006"},
+	"de_CH": {".", "'", "02.01.2006"},
+	"fr":    {",", " ", "02/01/2006"},
+	"fr_CH": {",", " ", "02.01.2006"},
+	"nl":    {",", ".", "02-01-2006"},
+	"es":    {",", ".", "02/01/2006"},
+	"it":    {",", ".", "02/01/2006"},
+	"pt":    {",", ".", "02/01/2006"},
+	"da":    {",", ".", "02.01.2006"},
+	"nb":    {",", " ", "02.01.2006"},
+	"fi":    {",", " ", "02.01.2006"},
+	"sv":    {",", " ", "2006-01-02"},
+	"pl":    {",", " ", "02.01.2006"},
+	"cs":    {",", " ", "02.01.2006"},
+	"ja":    {".", ",", "2006/01/02"},
+}
+
+// dateLayout is the date format of tables and reports, set by --locale
+var dateLayout = "2006-01-02"
+
+// formatDate renders the date of t in the locale's order
+func formatDate(t time.Time) string {
+	return t.Format(dateLayout)
+}
+
+// formatDateTime renders t as a date and a 24 hour time
+func formatDateTime(t time.Time) string {
+	return t.Format(dateLayout + " 15:04")
+}
+
+// formatPercent renders a percentage with a float verb such as %.1f and the
+// locale's decimal separator
+func formatPercent(verb string, value float64) string {
+	s := fmt.Sprintf(verb+"%%", value)
+	if numFmt.decimalSep != "." {
+		s = strings.Replace(s, ".", numFmt.decimalSep, 1)
+	}
+	return s
+}
+
+// localeFromEnv picks the locale the way the C library does, LC_ALL first,
+// then the category variable, then LANG
+func localeFromEnv(category string) string {
+	for _, name := range []string{"LC_ALL", category, "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return "C"
+}
+
+// lookupLocale resolves a locale name such as de_DE.UTF-8, de_DE or de
+func lookupLocale(name string) (localeFormat, bool) {
+	name, _, _ = strings.Cut(name, ".")
+	name, _, _ = strings.Cut(name, "@")
+	if name == "" || name == "POSIX" {
+		name = "C"
+	}
+	name = strings.ReplaceAll(name, "-", "_")
+	if f, ok := locales[name]; ok {
+		return f, true
+	}
+	lang, _, _ := strings.Cut(name, "_")
+	f, ok := locales[strings.ToLower(lang)]
+	return f, ok
+}
+
+// applyLocale sets the separators of numFmt and the date layout from --locale.
+// "auto" reads LC_NUMERIC and LC_TIME from the environment, falling back to
+// the defaults for unknown locales; an unknown name given explicitly is an
+// error. Grouping still needs thousands in --format-numbers.
+func applyLocale(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	numeric, timeFmt := spec, spec
+	if spec == "auto" {
+		numeric, timeFmt = localeFromEnv("LC_NUMERIC"), localeFromEnv("LC_TIME")
+	}
+	n, ok := lookupLocale(numeric)
+	if !ok && spec != "auto" {
+		return fmt.Errorf("unknown locale %q (known: %s)", spec, knownLocales())
+	}
+	if ok {
+		numFmt.decimalSep = n.decimalSep
+		if numFmt.thousandsSep != "" {
+			numFmt.thousandsSep = n.thousandsSep
+		}
+	}
+	if t, ok := lookupLocale(timeFmt); ok {
+		dateLayout = t.dateLayout
+	}
+	return nil
+}
+
+// knownLocales lists the locale names applyLocale accepts besides auto
+func knownLocales() string {
+	names := make([]string, 0, len(locales))
+	for name := range locales {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,1205 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/report"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+func main() {
+	defer recoverRun()
+	if err := initTimeSource(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	// A bare invocation is "collect", as before subcommands existed
+	command, args := "collect", os.Args[1:]
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "collect", "compare":
+			command, args = os.Args[1], os.Args[2:]
+		case "prune":
+			runPrune(os.Args[2:])
+			return
+		case "validate":
+			runValidate(os.Args[2:])
+			return
+		case "doctor":
+			runDoctor(os.Args[2:])
+			return
+		case "init":
+			runInit(os.Args[2:])
+			return
+		case "bench":
+			runBench(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "report":
+			runReport(os.Args[2:])
+			return
+		case "forecast":
+			runForecast(os.Args[2:])
+			return
+		case "explore":
+			runExplore(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		case "inventory":
+			runInventory(os.Args[2:])
+			return
+		case "last-run":
+			runLastRun(os.Args[2:])
+			return
+		case "status":
+			runStatus(os.Args[2:])
+			return
+		case "audit":
+			runAudit(os.Args[2:])
+			return
+		case "remap":
+			runRemap(os.Args[2:])
+			return
+		case "check":
+			runCheck(os.Args[2:])
+			return
+		case "impact":
+			runImpact(os.Args[2:])
+			return
+		case "schema":
+			fmt.Print(report.Schema)
+			return
+		case "install-systemd":
+			runInstallSystemd(os.Args[2:])
+			return
+		}
+	}
+
+	var filePath string
+	var configPath string
+	var compare compareMode
+	var since string
+	var cloudWatch bool
+	var ganesha bool
+	var serverIdentity string
+	var probe bool
+	var showTransport bool
+	var showMountStats bool
+	var latencyReport bool
+	var redactMode string
+	var keyFile string
+	var probeTimeout time.Duration
+	var parallel int
+	var mountTimeout time.Duration
+	var daemon bool
+	var deadline time.Duration
+	var watchInterval time.Duration
+	var compact bool
+	var pprofAddr string
+	var listenAddr string
+	var failOn string
+	var numberFormatSpec string
+	var locale string
+	var filterSpec string
+	var minDiffSpec string
+	var minDiffHide bool
+	var wide bool
+	var inodes bool
+	var byServer bool
+	var noRecord bool
+	var renderFixturePath string
+	var noAutoRecover bool
+	var csvDir string
+	var csvMaxRows int
+	var rrdDir string
+	var summary bool
+	var allowRegression bool
+	var emptyOK, emptyFail, recordEmpty bool
+	var output string
+	var motdWidth, motdTop int
+	var summaryThreshold float64
+	var scheduleSpecs stringList
+	var includes, excludes stringList
+	var interval time.Duration
+	var generations, maxEntries int
+	var retain, downsample, deadAfter string
+	var storeURL string
+
+	flag.StringVar(&filePath, "file", "", "Path to data file, .jsonl for an append-only store, .db for SQLite (default: config file, else CWD/nfsusage.json)")
+	flag.StringVar(&filePath, "f", "", "Path to JSON file for storing usage data (shorthand)")
+	flag.StringVar(&storeURL, "store", "", "Storage backend URL, e.g. sqlite:///var/lib/nfsusage.db (alternative to --file)")
+	flag.StringVar(&configPath, "config", "", "Path to YAML config file (default: "+defaultConfigPath+" if it exists)")
+	flag.BoolVar(&compact, "compact", false, "Delta-encode new entries in .jsonl data files")
+	flag.StringVar(&keyFile, "key-file", "", "Path to AES-256 key for encrypting the data file (or set "+store.KeyEnvVar+")")
+	flag.IntVar(&parallel, "parallel", 1, "Measure this many mounts at once (at most limits.max_commands statfs calls run together)")
+	flag.DurationVar(&mountTimeout, "mount-timeout", defaultMountTimeout, "Give up on a mount whose statfs takes longer than this and record it as stale (0 waits forever)")
+	flag.DurationVar(&deadline, "deadline", 0, "Stop collecting after this long and record a partial entry (exits 3); each mount gets an equal share of the time left")
+	flag.StringVar(&failOn, "fail-on", failOnStore, "Failures that cause a non-zero exit: none, store (nothing recorded: 1, partial: 3) or any (also failed mounts: 4)")
+	flag.BoolVar(&allowRegression, "allow-time-regression", false, "Record entries older than the newest stored one (clock moved backwards) instead of refusing")
+	flag.BoolVar(&emptyFail, "empty-fail", false, "Exit 5 when no NFS mounts are found")
+	flag.BoolVar(&emptyFail, "strict-no-mounts", false, "Exit 5 when no NFS mounts are found (same as --empty-fail)")
+	flag.BoolVar(&emptyOK, "empty-ok", false, "Exit 0 when no NFS mounts are found (default)")
+	flag.BoolVar(&noRecord, "no-record", false, "Measure and show usage without appending an entry or touching the data file (as compare does)")
+	flag.BoolVar(&recordEmpty, "record-empty", false, "Record an empty entry when no NFS mounts are found so the outage is trended")
+	flag.StringVar(&retain, "retain", "", "Prune entries older than this on write, e.g. 90d (default: config retain)")
+	flag.IntVar(&maxEntries, "max-entries", 0, "Prune the oldest entries beyond this count on write (default: config max_entries)")
+	flag.StringVar(&downsample, "downsample", "", "Keep one entry per day for entries older than this, e.g. 7d (default: config downsample)")
+	flag.StringVar(&deadAfter, "dead-after", "", "Stop exporting mounts not measured for this long, e.g. 30d; 0 never does (default: config dead_after or 30d)")
+	flag.IntVar(&generations, "generations", 0, "Keep this many daily copies of the data file as <file>.1 to <file>.N (default: config generations)")
+	flag.BoolVar(&noAutoRecover, "no-auto-recover", false, "Fail when the data file is corrupt instead of moving it to .corrupt-<timestamp> and starting afresh")
+	flag.BoolVar(&daemon, "daemon", false, "Run continuously, collecting every --interval and on each --schedule")
+	flag.DurationVar(&interval, "interval", 0, "Sampling interval in daemon mode, e.g. 5m (default: config interval)")
+	flag.DurationVar(&watchInterval, "watch-config", 10*time.Second, "How often the daemon checks the config file for changes (0 disables)")
+	flag.StringVar(&listenAddr, "listen", "", "Serve the newest entry as Prometheus metrics on this address (e.g. :9101), alongside --daemon or on its own")
+	flag.StringVar(&pprofAddr, "pprof", "", "Serve pprof profiles on this address in daemon mode (e.g. :6060)")
+	flag.Var(&includes, "include", "Only collect mounts matching this glob, or regex after re:, on the mount point or server:/export (repeatable)")
+	flag.Var(&excludes, "exclude", "Skip mounts matching this glob, or regex after re:, on the mount point or server:/export (repeatable)")
+	flag.Var(&scheduleSpecs, "schedule", "Cron expression for daemon collections (repeatable)")
+	flag.Var(&compare, "compare", "Compare current usage with the oldest entry, --compare=lastmonth with the same day last month or --compare=previous with the last run, or --compare=capacity shows size and free space (no history needed)")
+	flag.Var(&compare, "c", "Compare current usage with oldest entry (shorthand)")
+	flag.StringVar(&since, "since", "", "Compare current usage with the stored entry closest to this point: a duration back (24h, 7d, 2w) or a date (2024-01-01)")
+	flag.StringVar(&serverIdentity, "server-identity", "", "Server identity for grouping: mounted, ip or rdns (default: mounted)")
+	flag.BoolVar(&probe, "probe", false, "Probe each NFS server (TCP 2049 + NULL RPC) and record the result")
+	flag.DurationVar(&probeTimeout, "probe-timeout", 2*time.Second, "Timeout for each server probe")
+	flag.StringVar(&numberFormatSpec, "format-numbers", "", "Number formatting: comma separated thousands, decimals=N, width=N, unit=GiB|TiB")
+	flag.StringVar(&filterSpec, "filter", "", `Only show mounts matching an expression, e.g. 'server=="filer01" && pct_used>80' (all are still recorded)`)
+	flag.StringVar(&locale, "locale", "", "Decimal separator and date order of a locale such as de_DE, or auto for LC_NUMERIC and LC_TIME (default: config locale)")
+	flag.StringVar(&minDiffSpec, "min-diff", "", "Show changes smaller than this (e.g. 1GiB) as unchanged in comparisons, --output motd and --summary")
+	flag.BoolVar(&minDiffHide, "min-diff-hide", false, "Leave mounts with changes below --min-diff out of comparisons instead")
+	flag.BoolVar(&wide, "wide", false, "Mark read-only and elastic mounts in the table output")
+	flag.BoolVar(&inodes, "inodes", false, "Show used and free inodes instead of bytes in the table output, or compare used inodes with --compare")
+	flag.BoolVar(&byServer, "by-server", false, "Group the table output by NFS server, counting mounts of the same filesystem once")
+	flag.BoolVar(&wide, "w", false, "Mark read-only and elastic mounts in the table output (shorthand)")
+	flag.StringVar(&csvDir, "csv-dir", "", "Also keep a rolling timestamp,used,free CSV per mount in this directory")
+	flag.IntVar(&csvMaxRows, "csv-max-rows", csvDefaultMax, "Rows kept in each --csv-dir file")
+	flag.StringVar(&rrdDir, "rrd-dir", "", "Also create and update an .rrd file per mount in this directory (requires rrdtool)")
+	flag.StringVar(&redactMode, "redact-paths", "", "Redact mount and export paths in output, metrics and sinks: hash or alias")
+	flag.StringVar(&output, "output", "", "Output format: table, json (see 'nfsusage schema'), csv (one row per mount) or motd (compact block for /etc/update-motd.d) (default: config output, else table)")
+	flag.IntVar(&motdWidth, "motd-width", 72, "Maximum line width for --output motd")
+	flag.IntVar(&motdTop, "motd-top", 5, "Number of mounts shown by --output motd")
+	flag.BoolVar(&summary, "summary", false, "Print a one-line summary after the output (mounts over --summary-threshold, weekly growth)")
+	flag.Float64Var(&summaryThreshold, "summary-threshold", 85, "Percent used above which --summary counts a mount")
+	flag.StringVar(&renderFixturePath, "render-fixture", "", "Development: render the entries in this fixture file with --output and exit, without collecting")
+	flag.BoolVar(&latencyReport, "latency-report", false, "Report probe latency SLO breaches per mount over the history")
+	flag.BoolVar(&showTransport, "transport", false, "Show NFS version, nconnect and transport counts per mount")
+	flag.BoolVar(&showMountStats, "mountstats", false, "Record NFS operation counts, RTT, retransmits and bytes per mount from mountstats and show them")
+	flag.BoolVar(&cloudWatch, "cloudwatch", false, "Enrich EFS mounts with CloudWatch StorageBytes (requires aws CLI)")
+	flag.BoolVar(&ganesha, "ganesha", false, "Record and show the export id, server-side usage and the server's client count of exports from the ganesha servers in the config (dbus-send and df on the server; usage only for exports of filesystems mounted on the server, not FSAL_CEPH or FSAL_GLUSTER)")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: nfsusage [collect|compare] [flags]")
+		fmt.Fprintln(os.Stderr, "       nfsusage report|prune|status|check|doctor|... [flags]")
+		fmt.Fprintln(os.Stderr, "collect measures the NFS mounts and records an entry, compare measures them")
+		fmt.Fprintln(os.Stderr, "and compares with the history without recording (default --compare=previous).")
+		flag.PrintDefaults()
+	}
+	flag.CommandLine.Parse(args)
+	if compare != "" && isCompareMode(flag.Arg(0)) {
+		// "--compare lastmonth" stops flag parsing at the mode, resume after it
+		compare.Set(flag.Arg(0))
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+	readOnly := command == "compare" || noRecord
+	if command == "compare" && compare == "" && since == "" {
+		compare = comparePrevious
+	}
+	if since != "" {
+		if compare != "" {
+			fmt.Fprintf(os.Stderr, "Error: --since and --compare are mutually exclusive\n")
+			os.Exit(1)
+		}
+		if _, err := parseSince(since, timeSource.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	if compact {
+		cfg.Compact = true
+	}
+	// addFlagSinks applies --csv-dir, --rrd-dir, --include and --exclude on top
+	// of the config, also after a reload
+	addFlagSinks := func(c *Config) {
+		c.Include = append(c.Include, includes...)
+		c.Exclude = append(c.Exclude, excludes...)
+		if csvDir != "" {
+			c.Sinks = append(c.Sinks, SinkConfig{Type: "csv", Path: csvDir, MaxRows: csvMaxRows})
+		}
+		if rrdDir != "" {
+			c.Sinks = append(c.Sinks, SinkConfig{Type: "rrd", Path: rrdDir})
+		}
+	}
+	for _, pattern := range append(includes, excludes...) {
+		if err := validatePattern(pattern); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: include/exclude pattern %q: %v\n", pattern, err)
+			os.Exit(exitFatal)
+		}
+	}
+	addFlagSinks(cfg)
+	if err := applyLimits(cfg.Limits); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: limits: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	if numFmt, err = parseNumberFormat(numberFormatSpec); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --format-numbers: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	if locale == "" {
+		locale = cfg.Locale
+	}
+	if err := applyLocale(locale); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --locale: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	filter, err := report.ParseFilter(filterSpec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --filter: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	if minDiffSpec != "" {
+		if minDiff.min, err = report.ParseSize(minDiffSpec); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --min-diff: %v\n", err)
+			os.Exit(exitFatal)
+		}
+	}
+	minDiff.hide = minDiffHide
+	if output == "" {
+		output = cfg.Output
+	}
+	if output == "" {
+		output = outputTable
+	}
+	if !validOutput(output) {
+		fmt.Fprintf(os.Stderr, "Error: invalid --output %q (want %s, %s, %s or %s)\n", output, outputTable, outputJSON, outputCSV, outputMOTD)
+		os.Exit(exitFatal)
+	}
+	if byServer && output != outputTable {
+		fmt.Fprintln(os.Stderr, "Error: --by-server only applies to --output table")
+		os.Exit(exitFatal)
+	}
+	wideOutput = wide
+	inodeOutput = inodes
+	serverOutput = byServer
+	autoRecover = !noAutoRecover
+	backups = cfg.backupPolicy(generations)
+	if retention, err = cfg.retentionPolicy(retain, downsample, maxEntries); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	if deadMounts, err = cfg.deadMountPolicy(deadAfter); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	if renderFixturePath != "" {
+		fixture, err := loadRenderFixture(renderFixturePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading fixture: %v\n", err)
+			os.Exit(exitFatal)
+		}
+		if err := renderOutput(output, fixture.Current, fixture.Base, fixture.Label, motdWidth, motdTop); err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding output: %v\n", err)
+			os.Exit(exitFatal)
+		}
+		return
+	}
+	if emptyOK && emptyFail {
+		fmt.Fprintln(os.Stderr, "Error: --empty-ok and --empty-fail are mutually exclusive")
+		os.Exit(exitFatal)
+	}
+	if !validFailOn(failOn) {
+		fmt.Fprintf(os.Stderr, "Error: invalid --fail-on %q (want %s, %s or %s)\n", failOn, failOnNone, failOnStore, failOnAny)
+		os.Exit(exitFatal)
+	}
+	if ganesha && len(cfg.Ganesha) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: --ganesha needs the servers to query under ganesha in the config")
+		os.Exit(exitFatal)
+	}
+	if serverIdentity == "" {
+		serverIdentity = cfg.ServerIdentity
+	}
+	if serverIdentity == "" {
+		serverIdentity = identityMounted
+	}
+	if !validIdentityMode(serverIdentity) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", errInvalidIdentity(serverIdentity))
+		os.Exit(1)
+	}
+
+	redact, err := newRedactor(redactMode, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	key, err := loadKey(keyFile, cfg.Encryption)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading encryption key: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Set default file path
+	if storeURL != "" {
+		if filePath != "" {
+			fmt.Fprintf(os.Stderr, "Error: --store and --file are mutually exclusive\n")
+			os.Exit(1)
+		}
+		if filePath, err = store.ParseURL(storeURL); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	filePath = cfg.dataFile(filePath)
+
+	opts := collectOptions{
+		serverIdentity: serverIdentity,
+		cloudWatch:     cloudWatch,
+		ganesha:        ganesha,
+		probe:          probe,
+		probeTimeout:   probeTimeout,
+		mountStats:     showMountStats || cfg.MountStats,
+		parallel:       parallel,
+		mountTimeout:   mountTimeout,
+	}
+
+	if readOnly && (daemon || listenAddr != "" || recordEmpty) {
+		fmt.Fprintln(os.Stderr, "Error: --daemon, --listen and --record-empty record entries, they don't apply to compare or --no-record")
+		os.Exit(exitFatal)
+	}
+
+	if listenAddr != "" {
+		exporter := &metricsExporter{
+			// compact only affects writing, so reloads can't change how this reads
+			store:  func() historyStore { return store.Open(filePath, key, false) },
+			redact: redact,
+		}
+		if !daemon {
+			// Entries are recorded by other runs, e.g. from cron
+			if err := serveMetrics(listenAddr, exporter); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		go func() {
+			if err := serveMetrics(listenAddr, exporter); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	if daemon {
+		schedulesFromFlags := len(scheduleSpecs) > 0 || interval > 0
+		specs := scheduleFlags(scheduleSpecs)
+		if !schedulesFromFlags {
+			specs = cfg.Schedules
+			if interval, err = cfg.sampleInterval(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		schedules, err := parseSchedules(specs, interval)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		sinks, err := newSinkRunners(cfg, redactMode)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		policies, err := parsePolicies(cfg.Policies)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		engine := newPolicyEngine(policies, cfg, filePath, key)
+		rec := recordOptions{allowRegression: allowRegression, recordEmpty: recordEmpty, clock: &sampleClock{}}
+		hooks := daemonHooks{
+			collect: func(jobs []collectJob) {
+				beginRun(filePath, true)
+				if entry := daemonCollect(store.Open(filePath, key, cfg.Compact), cfg, opts, key, sinks, rec, jobs); entry != nil {
+					engine.evaluate(*entry)
+				}
+			},
+			reload: func() ([]jobSchedule, error) {
+				newCfg, err := loadConfig(configPath)
+				if err != nil {
+					return nil, err
+				}
+				addFlagSinks(newCfg)
+				if err := applyLimits(newCfg.Limits); err != nil {
+					return nil, fmt.Errorf("limits: %v", err)
+				}
+				newSinks, err := newSinkRunners(newCfg, redactMode)
+				if err != nil {
+					return nil, err
+				}
+				if !schedulesFromFlags {
+					newInterval, err := newCfg.sampleInterval()
+					if err != nil {
+						return nil, err
+					}
+					if schedules, err = parseSchedules(newCfg.Schedules, newInterval); err != nil {
+						return nil, err
+					}
+				}
+				newPolicies, err := parsePolicies(newCfg.Policies)
+				if err != nil {
+					return nil, err
+				}
+				carryQueues(sinks, newSinks)
+				cfg, sinks = newCfg, newSinks
+				backups = cfg.backupPolicy(generations)
+				if retention, err = cfg.retentionPolicy(retain, downsample, maxEntries); err != nil {
+					return nil, err
+				}
+				if deadMounts, err = cfg.deadMountPolicy(deadAfter); err != nil {
+					return nil, err
+				}
+				engine = engine.reload(newPolicies, cfg)
+				return schedules, nil
+			},
+			// Entries are written synchronously, so only sink queues can still be pending
+			shutdown: func() {
+				flushAll(sinks)
+			},
+		}
+		if pprofAddr != "" {
+			startPprof(pprofAddr)
+		}
+		if configPath != "" && watchInterval > 0 {
+			hooks.configChanged = watchFile(configPath, watchInterval)
+		}
+		runDaemon(schedules, hooks)
+		return
+	}
+
+	sinks, err := newSinkRunners(cfg, redactMode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !readOnly {
+		beginRun(filePath, false)
+	}
+	// Get NFS mounts
+	nfsMounts, err := discoverMounts(cfg)
+	if err != nil {
+		exitOnError(failOn, &DiscoveryError{err})
+	}
+
+	var absentErr error
+	absent := cfg.absentMounts(nfsMounts)
+	if len(absent) > 0 {
+		absentErr = &AbsentMountsError{absent}
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", absentErr)
+	}
+
+	if len(nfsMounts) == 0 {
+		fmt.Fprintln(os.Stderr, "No NFS mounts found")
+		if recordEmpty {
+			entry, _ := collectEntry(nil, cfg, opts)
+			entry.Absent = absent
+			if _, err := appendEntry(store.Open(filePath, key, cfg.Compact), entry, key, allowRegression); err != nil {
+				exitOnError(failOn, err)
+			}
+			deliverAll(sinks, entry)
+		}
+		code := 0
+		if absentErr != nil {
+			code = exitCode(failOn, absentErr, nil, false)
+		} else if emptyFail {
+			code = exitNoMounts
+		}
+		finishRun(runOutcome(code), code, 0, absentErr)
+		os.Exit(code)
+	}
+
+	if deadline > 0 {
+		opts.deadline = time.Now().Add(deadline)
+	}
+	limit, _ := hardDeadline(cfg.Limits)
+	stopWatchdog := startWatchdog(limit)
+	currentEntry, mountErrs := collectEntry(nfsMounts, cfg, opts)
+	currentEntry.Absent = absent
+
+	st := store.Open(filePath, key, cfg.Compact)
+	var previous *UsageEntry
+	var count int
+	if readOnly {
+		// count includes the unrecorded entry, as after appending
+		previous, count, err = lastEntry(st)
+		stopWatchdog()
+		if err != nil && !os.IsNotExist(err) {
+			exitOnError(failOn, &StoreError{"loading existing data", err})
+		}
+		count++
+	} else {
+		if compare == comparePrevious {
+			// Read before appending, from the latest entry cache when it is current.
+			// Errors are left to appendEntry, which reads the same entry.
+			previous, _, _ = lastEntry(st)
+		}
+		count, err = appendEntry(st, currentEntry, key, allowRegression)
+		stopWatchdog()
+		if err != nil {
+			exitOnError(failOn, err)
+		}
+		deliverAll(sinks, currentEntry)
+	}
+
+	// Output to stdout
+	var base *UsageEntry
+	baseLabel := ""
+	if output == outputMOTD {
+		if base, err = weekAgoEntry(st, currentEntry); err != nil {
+			exitOnError(failOn, &StoreError{"reading history", err})
+		}
+	} else if compare == compareCapacity {
+		capacityOutput = true
+	} else if compare == comparePrevious {
+		if previous != nil {
+			filtered := collector.WithoutSnapshots(*previous)
+			base, baseLabel = &filtered, "Previous"
+		}
+	} else if compare != "" && count > 1 {
+		if base, baseLabel, err = compareBase(st, currentEntry, compare); err != nil {
+			exitOnError(failOn, err)
+		}
+	} else if since != "" && count > 1 {
+		if base, baseLabel, err = sinceBase(st, currentEntry, since); err != nil {
+			exitOnError(failOn, err)
+		}
+	}
+	if compare != "" && base == nil && count <= 1 && output == outputTable {
+		// A first run has nothing to diff against, capacity is still useful
+		fmt.Fprintln(os.Stderr, "No earlier entry to compare with yet, showing usage against capacity")
+		capacityOutput = true
+	}
+	if base != nil && output == outputTable && !inodes {
+		rates, err := growthSince(st, base.Timestamp)
+		if err != nil {
+			exitOnError(failOn, &StoreError{"reading history", err})
+		}
+		compareRates = make(map[string]report.Rate, len(rates))
+		for mount, rate := range rates {
+			compareRates[redact.path(mount)] = rate
+		}
+	}
+	shown := filter.Entry(currentEntry)
+	if base != nil {
+		redacted := redact.entry(filter.Baseline(*base, currentEntry, shown))
+		base = &redacted
+	}
+	if err := renderOutput(output, redact.entry(shown), base, baseLabel, motdWidth, motdTop); err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding output: %v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	if showTransport {
+		fmt.Println()
+		printTransport(redact.entry(shown))
+	}
+
+	if ganesha {
+		fmt.Println()
+		printGanesha(redact.entry(shown))
+	}
+
+	if showMountStats {
+		fmt.Println()
+		printMountStats(redact.entry(shown), base)
+	}
+
+	if latencyReport {
+		fmt.Println()
+		stats, err := computeLatencyStats(st, cfg, redact)
+		if err != nil {
+			exitOnError(failOn, &StoreError{"reading history", err})
+		}
+		printLatencyReport(stats)
+	}
+
+	if summary {
+		line, err := summaryLine(st, currentEntry, summaryThreshold)
+		if err != nil {
+			exitOnError(failOn, &StoreError{"reading history", err})
+		}
+		fmt.Println()
+		fmt.Println(line)
+	}
+
+	if currentEntry.Partial {
+		fmt.Fprintf(os.Stderr, "Warning: partial entry, %d mounts not measured within %s: %s\n",
+			len(currentEntry.Missing), deadline, strings.Join(currentEntry.Missing, ", "))
+	}
+	code := exitCode(failOn, absentErr, mountErrs, currentEntry.Partial)
+	var runErr error = absentErr
+	if absentErr == nil && len(mountErrs) > 0 {
+		runErr = mountErrs[0]
+	}
+	outcome := runOutcome(code)
+	if code == 0 && (runErr != nil || currentEntry.Partial) {
+		// --fail-on none still records what went wrong
+		outcome = runDegraded
+	}
+	finishRun(outcome, code, len(currentEntry.Mounts), runErr)
+	os.Exit(code)
+}
+
+// collectOptions controls what is gathered per mount during a collection
+type collectOptions struct {
+	serverIdentity string
+	cloudWatch     bool
+	// ganesha queries the configured NFS-Ganesha servers about their exports
+	ganesha      bool
+	probe        bool
+	probeTimeout time.Duration
+	// deadline bounds the whole collection, zero means no limit
+	deadline time.Time
+	// mountStats records the NFS operation counters of each mount
+	mountStats bool
+	// parallel is the number of mounts measured at once
+	parallel int
+	// mountTimeout bounds the statfs of one mount, zero means no limit
+	mountTimeout time.Duration
+}
+
+// collectEntry measures usage and metadata for each mount, returning the
+// mounts that could not be measured alongside the entry. Mounts are measured
+// on opts.parallel workers and recorded in the order given.
+func collectEntry(nfsMounts []nfsMount, cfg *Config, opts collectOptions) (UsageEntry, []*MountError) {
+	entry := UsageEntry{
+		Timestamp: timeSource.Now().Unix(),
+		Mounts:    make(map[string]int64),
+		Total:     0,
+		Details:   make(map[string]MountDetail),
+	}
+	entry.Host, _ = os.Hostname()
+
+	if len(nfsMounts) > 0 {
+		entry.Unavailable = unavailableSources()
+	}
+	for name, reason := range entry.Unavailable {
+		fmt.Fprintf(os.Stderr, "Warning: %s unavailable: %s\n", name, reason)
+	}
+
+	var mountErrs []*MountError
+	resolver := newServerResolver(opts.serverIdentity)
+	mountStats, _ := collector.ReadMountStats()
+	results := make([]mountResult, len(nfsMounts))
+	var started atomic.Int64
+	runParallel(len(nfsMounts), opts.parallel, func(i int) {
+		mountOpts := opts
+		left := len(nfsMounts) - int(started.Add(1)) + 1
+		mountOpts.deadline = mountDeadline(opts.deadline, left, opts.parallel)
+		results[i] = collectMount(nfsMounts[i], cfg, mountOpts, resolver, mountStats)
+	})
+	for i, mount := range nfsMounts {
+		r := results[i]
+		if r.err == errDeadline {
+			entry.Partial = true
+			entry.Missing = append(entry.Missing, mount.MountPoint)
+			continue
+		}
+		if errors.Is(r.err, errStaleMount) {
+			entry.Stale = append(entry.Stale, mount.MountPoint)
+		}
+		if r.err != nil {
+			mountErr := &MountError{mount.MountPoint, r.err}
+			fmt.Fprintf(os.Stderr, "Warning: Error %v\n", mountErr)
+			mountErrs = append(mountErrs, mountErr)
+			if entry.Unmeasured == nil {
+				entry.Unmeasured = make(map[string]string)
+			}
+			entry.Unmeasured[mount.MountPoint] = accessReason(r.err)
+			continue
+		}
+		entry.Mounts[mount.MountPoint] = r.used
+		entry.Total += r.used
+		entry.Details[mount.MountPoint] = r.detail
+		if r.snapshotUsed != nil {
+			entry.Mounts[snapshotKey(mount.MountPoint)] = *r.snapshotUsed
+		}
+	}
+
+	if opts.ganesha {
+		enrichGanesha(&entry, nfsMounts, cfg.Ganesha)
+	}
+	if opts.probe {
+		entry.Servers = probeServers(entry, opts.probeTimeout)
+	}
+	return entry, mountErrs
+}
+
+// mountDeadline gives a mount starting now its share of the time left until
+// deadline, with left mounts still to measure on workers, so one hung mount
+// cannot starve the rest. Time a mount does not use goes to the next ones.
+func mountDeadline(deadline time.Time, left, workers int) time.Time {
+	if deadline.IsZero() {
+		return deadline
+	}
+	workers = max(workers, 1)
+	rounds := (left + workers - 1) / workers
+	return time.Now().Add(time.Until(deadline) / time.Duration(max(rounds, 1)))
+}
+
+// mountResult is what collecting one mount produced. err is errDeadline when
+// the collection deadline passed first.
+type mountResult struct {
+	used   int64
+	detail MountDetail
+	// snapshotUsed is the snapshot space, for mounts with a snapshot rule
+	snapshotUsed *int64
+	err          error
+}
+
+// collectMount measures usage and metadata of one mount. It runs on a
+// collection worker, concurrently with other mounts.
+func collectMount(mount nfsMount, cfg *Config, opts collectOptions, resolver *serverResolver, mountStats map[string]*collector.MountStats) mountResult {
+	deadline := opts.deadline
+	perMount := false
+	if opts.mountTimeout > 0 {
+		if d := time.Now().Add(opts.mountTimeout); deadline.IsZero() || d.Before(deadline) {
+			deadline, perMount = d, true
+		}
+	}
+	usage, err := statfsBefore(mount.MountPoint, deadline)
+	if err == errDeadline && perMount {
+		err = fmt.Errorf("%w: statfs did not return within %s (see --mount-timeout)", errStaleMount, opts.mountTimeout)
+	} else if err == errCommandRunning {
+		err = fmt.Errorf("%w: %v", errStaleMount, err)
+	}
+	if err != nil {
+		return mountResult{err: err}
+	}
+
+	detail := collector.Describe(mount, usage)
+	detail.Elastic = cfg.isElastic(mount, detail.Provider)
+	if detail.Elastic {
+		detail.Available, detail.Size = nil, nil
+	}
+	detail.Server = resolver.identity(detail.Server, mount.ServerAddr)
+	if stats := mountStats[mount.MountPoint]; stats != nil {
+		detail.Transport.Xprts = stats.Xprts
+		if opts.mountStats {
+			detail.NFSStats = stats.NFS
+		}
+	}
+	if opts.cloudWatch && detail.Provider != nil {
+		if err := enrichCloudWatch(detail.Provider); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Error getting CloudWatch metrics for %s: %v\n", mount.MountPoint, err)
+		}
+	}
+	if cfg.dirMode(mount) {
+		detail.Dirs = measureDirs(mount.MountPoint)
+	}
+	if cfg.MeasureTrash {
+		detail.Trash = measureTrash(mount.MountPoint, cfg.trashPatterns())
+	}
+	return mountResult{used: usage.Used, detail: detail, snapshotUsed: snapshotSpace(mount, cfg, deadline)}
+}
+
+// runParallel calls fn for 0..n-1 on at most workers goroutines and waits for
+// all of them. Fewer than two workers runs the calls in order.
+func runParallel(n, workers int, fn func(i int)) {
+	if workers < 2 || n < 2 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// appendEntry seals entry against the newest stored entry and appends it,
+// returning the number of entries now stored. Only the newest entry is kept
+// in memory unless legacy entries without checksums need sealing. Entries
+// older than the newest stored one are refused unless allowRegression is set,
+// in which case they are recorded along with a clock event.
+func appendEntry(st historyStore, entry UsageEntry, key []byte, allowRegression bool) (int, error) {
+	prev, count, err := lastEntry(st)
+	if err != nil && repairTornStore(st, err) {
+		prev, count, err = lastEntry(st)
+	}
+	if err != nil && recoverCorruptStore(st, err) {
+		prev, count, err = nil, 0, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return 0, &StoreError{"loading existing data", err}
+	}
+
+	if prev != nil && entry.Timestamp < prev.Timestamp {
+		regression := &TimeRegressionError{Last: prev.Timestamp, Entry: entry.Timestamp}
+		if !allowRegression {
+			return 0, &StoreError{"refusing entry (see --allow-time-regression)", regression}
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", regression)
+		recordEvent(st.File(), eventTimeRegression, "%v", regression)
+	}
+
+	if prev != nil && prev.Checksum == "" {
+		// Legacy history without checksums is sealed in full once
+		backup := func() error { return backups.backup(st.File(), true) }
+		count, err := store.SealLegacy(st, entry, key, backup)
+		if err != nil {
+			return 0, &StoreError{"sealing legacy entries", err}
+		}
+		recordAudit(st.File(), auditSeal, "sealed %d legacy entries with checksums", count-1)
+		trackMounts(st, entry)
+		prune(st, key, count)
+		return count, nil
+	}
+
+	prevSum := ""
+	if prev != nil {
+		prevSum = prev.Checksum
+	}
+	if entry.Checksum, err = store.EntryChecksum(prevSum, entry, key); err != nil {
+		return 0, &StoreError{"sealing entries", err}
+	}
+	if err := backups.backup(st.File(), false); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error backing up %s: %v\n", st.File(), err)
+	}
+	if err := st.Append(prev, count, entry); err != nil {
+		if repairTornStore(st, err) {
+			return appendEntry(st, entry, key, allowRegression)
+		}
+		return 0, &StoreError{"saving data", err}
+	}
+	cacheLatest(st, entry)
+	recordRemaps(st.File(), prev, entry)
+	trackMounts(st, entry)
+	prune(st, key, count+1)
+	return count + 1, nil
+}
+
+// prune applies the retention policy after a write. The entry is already
+// stored, so a failure only delays pruning until the next write.
+func prune(st historyStore, key []byte, count int) {
+	if err := pruneStore(st, key, count); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error pruning %s: %v\n", st.File(), err)
+	}
+}
+
+// cacheLatest updates the latest entry cache after a successful write. A
+// failure only costs speed: the stale cache no longer matches the manifest.
+func cacheLatest(st historyStore, entry UsageEntry) {
+	if err := store.WriteLatest(st.File(), entry, st.EncryptionKey()); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error caching latest entry: %v\n", err)
+	}
+}
+
+// defaultFilePath returns nfsusage.json in the current directory
+func defaultFilePath() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting current directory: %v\n", err)
+		os.Exit(1)
+	}
+	return filepath.Join(cwd, "nfsusage.json")
+}
+
+// discoverMounts finds NFS mounts and applies the configured include/exclude patterns
+func discoverMounts(cfg *Config) ([]nfsMount, error) {
+	mounts, err := collector.ReadMounts()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.filterMounts(crossCheckNFSFS(mounts)), nil
+}
+
+// errDeadline is returned for mounts that could not be measured before the deadline
+var errDeadline = errors.New("collection deadline exceeded")
+
+// defaultMountTimeout is how long a mount's statfs may take before the mount
+// is recorded as stale
+const defaultMountTimeout = 10 * time.Second
+
+// errStaleMount wraps the errors of mounts whose statfs hangs, typically a hard
+// mount of an unreachable server. They are recorded in UsageEntry.Stale.
+var errStaleMount = errors.New("stale mount")
+
+// statfsBefore runs collector.Statfs but gives up at deadline. A statfs stuck on a
+// hung mount is abandoned rather than waited for.
+func statfsBefore(mountPoint string, deadline time.Time) (collector.Usage, error) {
+	if deadline.IsZero() {
+		return collector.Statfs(mountPoint)
+	}
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return collector.Usage{}, errDeadline
+	}
+
+	type result struct {
+		usage collector.Usage
+		err   error
+	}
+	release, err := commands.acquire(mountPoint, deadline)
+	if err != nil {
+		return collector.Usage{}, err
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer release()
+		usage, err := collector.Statfs(mountPoint)
+		done <- result{usage, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.usage, r.err
+	case <-time.After(remaining):
+		return collector.Usage{}, errDeadline
+	}
+}
+
+// formatBytes converts bytes to human readable format (GiB/TiB)
+func formatBytes(bytes int64) string {
+	return numFmt.bytes(bytes, "")
+}
+
+// formatDiff formats a byte difference with +/- prefix
+func formatDiff(diff int64) string {
+	if diff >= 0 {
+		return numFmt.bytes(diff, "+")
+	}
+	return numFmt.bytes(-diff, "-")
+}
+
+// wideOutput marks mount flags in the table output, set from --wide
+var wideOutput bool
+
+// mountLabel returns the mount as shown in tables, with its flags in wide output
+func mountLabel(entry UsageEntry, mount string) string {
+	if !wideOutput {
+		return mount
+	}
+	detail := entry.Details[mount]
+	var flags []string
+	if detail.ReadOnly {
+		flags = append(flags, "ro")
+	}
+	if detail.Elastic {
+		flags = append(flags, "elastic")
+	}
+	if len(flags) == 0 {
+		return mount
+	}
+	return mount + " [" + strings.Join(flags, ",") + "]"
+}
+
+// printCurrent prints the current usage with aligned columns
+func printCurrent(entry UsageEntry) {
+	// Calculate max mount point width
+	maxMountWidth := len("total")
+	for mount := range entry.Mounts {
+		if len(mountLabel(entry, mount)) > maxMountWidth {
+			maxMountWidth = len(mountLabel(entry, mount))
+		}
+	}
+
+	// Print mounts with the share of their capacity in use
+	mounts := make([]string, 0, len(entry.Mounts))
+	for mount := range entry.Mounts {
+		mounts = append(mounts, mount)
+	}
+	sort.Strings(mounts)
+	bytesWidth := len(formatBytes(entry.Total))
+	for _, mount := range mounts {
+		bytesWidth = max(bytesWidth, len(formatBytes(entry.Mounts[mount])))
+	}
+	var used, capacity int64
+	for _, mount := range mounts {
+		pct := "-"
+		if p, ok := report.UsedPercent(entry, mount); ok {
+			pct = formatPercent("%.1f", p)
+			used += entry.Mounts[mount]
+			capacity += entry.Mounts[mount] + *entry.Details[mount].Available
+		}
+		fmt.Printf("%-*s  %*s  %6s\n", maxMountWidth, mountLabel(entry, mount), bytesWidth, formatBytes(entry.Mounts[mount]), pct)
+	}
+	pct := "-"
+	if capacity > 0 {
+		pct = formatPercent("%.1f", float64(used)/float64(capacity)*100)
+	}
+	fmt.Printf("%-*s  %*s  %6s\n", maxMountWidth, "total", bytesWidth, formatBytes(entry.Total), pct)
+}
+
+// capacityOutput shows used against size and free space instead of a
+// comparison, set by --compare capacity and on hosts without history
+var capacityOutput bool
+
+// printCapacity prints each mount's usage against its capacity, which needs
+// no history. Elastic mounts have no meaningful size and show dashes.
+func printCapacity(entry UsageEntry) {
+	type row struct {
+		mount, used, size, free, pct string
+	}
+	mounts := make([]string, 0, len(entry.Mounts))
+	for mount := range entry.Mounts {
+		mounts = append(mounts, mount)
+	}
+	sort.Strings(mounts)
+
+	var rows []row
+	var used, size, free int64
+	for _, mount := range mounts {
+		r := row{mountLabel(entry, mount), formatBytes(entry.Mounts[mount]), "-", "-", "-"}
+		if capacity, ok := report.CapacityBytes(entry, mount); ok && !entry.Details[mount].Elastic {
+			r.size = formatBytes(capacity)
+			size += capacity
+			used += entry.Mounts[mount]
+		}
+		if avail := entry.Details[mount].Available; avail != nil && !entry.Details[mount].Elastic {
+			r.free = formatBytes(*avail)
+			free += *avail
+		}
+		if p, ok := report.UsedPercent(entry, mount); ok {
+			r.pct = formatPercent("%.1f", p)
+		}
+		rows = append(rows, r)
+	}
+	total := row{"total", formatBytes(entry.Total), "-", "-", "-"}
+	if size > 0 {
+		total.size, total.free = formatBytes(size), formatBytes(free)
+		total.pct = formatPercent("%.1f", float64(used)/float64(used+free)*100)
+	}
+	rows = append(rows, total)
+
+	widths := [5]int{len(rowHeading()), len("Used"), len("Size"), len("Free"), len("Use%")}
+	for _, r := range rows {
+		for i, v := range []string{r.mount, r.used, r.size, r.free, r.pct} {
+			widths[i] = max(widths[i], len(v))
+		}
+	}
+	line := func(r row) {
+		fmt.Printf("%-*s  %*s  %*s  %*s  %*s\n", widths[0], r.mount, widths[1], r.used, widths[2], r.size, widths[3], r.free, widths[4], r.pct)
+	}
+	line(row{rowHeading(), "Used", "Size", "Free", "Use%"})
+	fmt.Printf("%s  %s  %s  %s  %s\n", strings.Repeat("-", widths[0]), strings.Repeat("-", widths[1]),
+		strings.Repeat("-", widths[2]), strings.Repeat("-", widths[3]), strings.Repeat("-", widths[4]))
+	for _, r := range rows {
+		line(r)
+	}
+}
+
+// printComparison prints comparison between oldest and current entries with aligned columns,
+// label heads the column of the entry being compared against
+func printComparison(label string, oldest, current UsageEntry) {
+	// Build rows first to calculate column widths
+	type row struct {
+		mount, oldest, current, diff, growth, full string
+	}
+	var rows []row
+
+	// Collect all mounts from current entry
+	var totalGrowth float64
+	for mount, currBytes := range current.Mounts {
+		oldBytes := oldest.Mounts[mount]
+		diff := currBytes - oldBytes
+		if minDiff.hide && !minDiff.significant(diff) {
+			continue
+		}
+		r := row{mountLabel(current, mount), formatBytes(oldBytes), formatBytes(currBytes), minDiff.format(diff), "-", "-"}
+		if rate, ok := compareRates[mount]; ok && rate.Samples > 1 {
+			r.growth, r.full = formatDiff(int64(rate.PerDay)), rate.FullIn()
+			totalGrowth += rate.PerDay
+		}
+		rows = append(rows, r)
+	}
+
+	// Collect mounts that existed in oldest but not in current
+	for mount, oldBytes := range oldest.Mounts {
+		if _, exists := current.Mounts[mount]; !exists {
+			rows = append(rows, row{mount, formatBytes(oldBytes), "(removed)", formatDiff(-oldBytes), "-", "-"})
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].mount < rows[j].mount })
+
+	// Add total row
+	diff := current.Total - oldest.Total
+	rows = append(rows, row{"total", formatBytes(oldest.Total), formatBytes(current.Total), minDiff.format(diff), formatDiff(int64(totalGrowth)), "-"})
+	// Calculate column widths
+	mountWidth := len(rowHeading())
+	oldestWidth := len(label)
+	currentWidth := len("Current")
+	diffWidth := len("Difference")
+
+	for _, r := range rows {
+		if len(r.mount) > mountWidth {
+			mountWidth = len(r.mount)
+		}
+		if len(r.oldest) > oldestWidth {
+			oldestWidth = len(r.oldest)
+		}
+		if len(r.current) > currentWidth {
+			currentWidth = len(r.current)
+		}
+		if len(r.diff) > diffWidth {
+			diffWidth = len(r.diff)
+		}
+	}
+
+	growthWidth, fullWidth := len("Growth/day"), len("Full in")
+	for _, r := range rows {
+		growthWidth = max(growthWidth, len(r.growth))
+		fullWidth = max(fullWidth, len(r.full))
+	}
+
+	// Print header, with the growth columns when rates are known
+	header := fmt.Sprintf("%-*s  %*s  %*s  %*s", mountWidth, rowHeading(), oldestWidth, label, currentWidth, "Current", diffWidth, "Difference")
+	rule := fmt.Sprintf("%-*s  %*s  %*s  %*s", mountWidth, strings.Repeat("-", mountWidth), oldestWidth, strings.Repeat("-", oldestWidth), currentWidth, strings.Repeat("-", currentWidth), diffWidth, strings.Repeat("-", diffWidth))
+	if compareRates != nil {
+		header += fmt.Sprintf("  %*s  %*s", growthWidth, "Growth/day", fullWidth, "Full in")
+		rule += fmt.Sprintf("  %s  %s", strings.Repeat("-", growthWidth), strings.Repeat("-", fullWidth))
+	}
+	fmt.Println(header)
+	fmt.Println(rule)
+
+	// Print rows
+	for _, r := range rows {
+		line := fmt.Sprintf("%-*s  %*s  %*s  %*s", mountWidth, r.mount, oldestWidth, r.oldest, currentWidth, r.current, diffWidth, r.diff)
+		if compareRates != nil {
+			line += fmt.Sprintf("  %*s  %*s", growthWidth, r.growth, fullWidth, r.full)
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/report"
+)
+
+// motdBarWidth is the number of characters in each usage bar
+const motdBarWidth = 10
+
+// renderMOTD renders a compact block for /etc/update-motd.d: the top mounts by
+// fullness (by size for mounts without capacity) with weekly growth, never
+// wider than width characters. base may be nil when there is no history.
+func renderMOTD(entry UsageEntry, base *UsageEntry, width, top int) string {
+	type row struct {
+		mount string
+		pct   float64
+		hasPc bool
+		used  int64
+	}
+	var rows []row
+	for mount, used := range entry.Mounts {
+		pct, ok := report.UsedPercent(entry, mount)
+		rows = append(rows, row{mount, pct, ok, used})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].hasPc != rows[j].hasPc {
+			return rows[i].hasPc
+		}
+		if rows[i].pct != rows[j].pct {
+			return rows[i].pct > rows[j].pct
+		}
+		return rows[i].used > rows[j].used
+	})
+	if top > 0 && len(rows) > top {
+		rows = rows[:top]
+	}
+
+	bars := make([]string, len(rows))
+	sizes := make([]string, len(rows))
+	growths := make([]string, len(rows))
+	sizeWidth, growthWidth := 0, 0
+	for i, r := range rows {
+		bars[i] = strings.Repeat(" ", motdBarWidth+7)
+		if r.hasPc {
+			filled := int(r.pct/100*motdBarWidth + 0.5)
+			if filled > motdBarWidth {
+				filled = motdBarWidth
+			}
+			bars[i] = fmt.Sprintf("[%s%s] %3.0f%%", strings.Repeat("#", filled), strings.Repeat(".", motdBarWidth-filled), r.pct)
+		}
+		sizes[i] = formatBytes(r.used)
+		if base != nil {
+			if old, ok := base.Mounts[r.mount]; ok {
+				growths[i] = minDiff.format(r.used-old) + "/wk"
+			}
+		}
+		sizeWidth = max(sizeWidth, len(sizes[i]))
+		growthWidth = max(growthWidth, len(growths[i]))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "NFS usage (%s)\n", formatDateTime(time.Unix(entry.Timestamp, 0)))
+	for i, r := range rows {
+		tail := fmt.Sprintf(" %s %*s %*s", bars[i], sizeWidth, sizes[i], growthWidth, growths[i])
+		line := "  " + fitLeft(r.mount, width-2-len(tail)) + tail
+		b.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+
+	total := "total " + formatBytes(entry.Total)
+	if base != nil {
+		total += ", " + minDiff.format(entry.Total-base.Total) + " this week"
+	}
+	b.WriteString(strings.TrimRight("  "+fitLeft(total, width-2), " ") + "\n")
+	return b.String()
+}
+
+// fitLeft pads s to width, shortening it from the left with "..." so the
+// distinctive end of long autofs paths stays visible
+func fitLeft(s string, width int) string {
+	if width < 4 {
+		width = 4
+	}
+	if len(s) > width {
+		s = "..." + s[len(s)-width+3:]
+	}
+	return fmt.Sprintf("%-*s", width, s)
+}
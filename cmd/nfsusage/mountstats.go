@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+// printMountStats prints NFS activity per mount: since base when it has
+// statistics for the mount, otherwise since the mount was made
+func printMountStats(entry UsageEntry, base *UsageEntry) {
+	var mounts []string
+	mountWidth := len("Mountpoint")
+	for mount, detail := range entry.Details {
+		if detail.NFSStats != nil {
+			mounts = append(mounts, mount)
+			mountWidth = max(mountWidth, len(mount))
+		}
+	}
+	sort.Strings(mounts)
+	if len(mounts) == 0 {
+		fmt.Println("No NFS statistics recorded (see --mountstats)")
+		return
+	}
+
+	fmt.Printf("%-*s  %-10s  %10s  %8s  %10s  %10s  %7s  %8s\n", mountWidth, "Mountpoint", "Since", "Ops", "Ops/s", "Read", "Written", "Retrans", "Avg RTT")
+	for _, mount := range mounts {
+		stats := *entry.Details[mount].NFSStats
+		since, seconds := "mount", int64(0)
+		if base != nil {
+			if delta, ok := collector.StatsDelta(&stats, base.Details[mount].NFSStats); ok {
+				stats = delta
+				since = time.Unix(base.Timestamp, 0).Format("01-02 15:04")
+				seconds = entry.Timestamp - base.Timestamp
+			}
+		}
+		t := stats.Totals()
+		rate, rtt := "-", "-"
+		if seconds > 0 {
+			rate = fmt.Sprintf("%.1f", float64(t.Ops)/float64(seconds))
+		}
+		if t.Ops > 0 {
+			rtt = fmt.Sprintf("%.1fms", float64(t.RTTMillis)/float64(t.Ops))
+		}
+		fmt.Printf("%-*s  %-10s  %10d  %8s  %10s  %10s  %7d  %8s\n", mountWidth, mount, since, t.Ops, rate,
+			formatBytes(stats.ReadBytes), formatBytes(stats.WriteBytes), t.Retrans, rtt)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+// nfsVolume is a single entry from /proc/fs/nfsfs/volumes
+type nfsVolume struct {
+	ServerAddr string
+	Dev        string
+	FSID       string
+}
+
+// mountInfo is the subset of /proc/self/mountinfo needed to match volumes to mount points
+type mountInfo struct {
+	Dev        string
+	MountPoint string
+	FSType     string
+	Source     string
+	Options    string
+}
+
+// readNFSVolumes parses /proc/fs/nfsfs/volumes into volumes keyed by device number
+func readNFSVolumes() (map[string]nfsVolume, error) {
+	file, err := os.Open("/proc/fs/nfsfs/volumes")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	volumes := make(map[string]nfsVolume)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// NV SERVER   PORT DEV          FSID                              FSC
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] == "NV" {
+			continue
+		}
+		volumes[fields[3]] = nfsVolume{
+			ServerAddr: decodeHexAddr(fields[1]),
+			Dev:        fields[3],
+			FSID:       fields[4],
+		}
+	}
+	return volumes, scanner.Err()
+}
+
+// decodeHexAddr converts the hex server address used in /proc/fs/nfsfs to an IP string
+func decodeHexAddr(s string) string {
+	b, err := hex.DecodeString(s)
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return ""
+	}
+	return net.IP(b).String()
+}
+
+// readMountInfo parses /proc/self/mountinfo
+func readMountInfo() ([]mountInfo, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var infos []mountInfo
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// 36 35 0:53 / /mnt/data rw,relatime shared:1 - nfs4 server:/data rw,vers=4.2,...
+		line := scanner.Text()
+		pre, post, ok := strings.Cut(line, " - ")
+		if !ok {
+			continue
+		}
+		preFields := strings.Fields(pre)
+		postFields := strings.Fields(post)
+		if len(preFields) < 5 || len(postFields) < 2 {
+			continue
+		}
+		info := mountInfo{
+			Dev:        preFields[2],
+			MountPoint: preFields[4],
+			FSType:     postFields[0],
+			Source:     postFields[1],
+		}
+		if len(postFields) >= 3 {
+			info.Options = postFields[2]
+		}
+		infos = append(infos, info)
+	}
+	return infos, scanner.Err()
+}
+
+// crossCheckNFSFS adds mounts that the NFS client knows about but that /proc/mounts
+// listed with an unusual fstype, and fills in the server address for every mount.
+// Missing /proc/fs/nfsfs (nfs module not loaded) leaves the mounts unchanged.
+func crossCheckNFSFS(mounts []nfsMount) []nfsMount {
+	volumes, err := readNFSVolumes()
+	if err != nil || len(volumes) == 0 {
+		return mounts
+	}
+	infos, err := readMountInfo()
+	if err != nil {
+		return mounts
+	}
+
+	known := make(map[string]int)
+	for i, mount := range mounts {
+		known[mount.MountPoint] = i
+	}
+
+	for _, info := range infos {
+		volume, ok := volumes[info.Dev]
+		if !ok || collector.IsSnapshotMount(info.MountPoint) {
+			continue
+		}
+		if i, exists := known[info.MountPoint]; exists {
+			mounts[i].ServerAddr = volume.ServerAddr
+			continue
+		}
+		mounts = append(mounts, nfsMount{
+			Device:     info.Source,
+			MountPoint: info.MountPoint,
+			FSType:     info.FSType,
+			Options:    info.Options,
+			ServerAddr: volume.ServerAddr,
+		})
+		known[info.MountPoint] = len(mounts) - 1
+	}
+	return mounts
+}
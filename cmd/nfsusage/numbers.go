@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// numberFormat controls how byte values are rendered in tables
+type numberFormat struct {
+	decimals     int
+	thousandsSep string
+	decimalSep   string
+	// width right-aligns the signed number to a fixed number of characters
+	width int
+	// unit forces GiB or TiB for every value, empty picks per value
+	unit string
+}
+
+// numFmt is the format used by formatBytes and formatDiff, set from --format-numbers
+var numFmt = numberFormat{decimals: 2, decimalSep: "."}
+
+// parseNumberFormat parses --format-numbers, a comma separated list of
+// thousands, decimals=N, width=N and unit=GiB|TiB
+func parseNumberFormat(spec string) (numberFormat, error) {
+	f := numberFormat{decimals: 2, decimalSep: "."}
+	for _, opt := range strings.Split(spec, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		var err error
+		switch key {
+		case "":
+		case "thousands":
+			f.thousandsSep = ","
+		case "decimals":
+			if f.decimals, err = strconv.Atoi(value); err != nil || f.decimals < 0 {
+				return f, fmt.Errorf("invalid decimals %q", value)
+			}
+		case "width":
+			if f.width, err = strconv.Atoi(value); err != nil || f.width < 0 {
+				return f, fmt.Errorf("invalid width %q", value)
+			}
+		case "unit":
+			if value != "GiB" && value != "TiB" {
+				return f, fmt.Errorf("invalid unit %q (want GiB or TiB)", value)
+			}
+			f.unit = value
+		default:
+			return f, fmt.Errorf("unknown number format option %q", key)
+		}
+	}
+	return f, nil
+}
+
+// bytes renders a non-negative byte count with an optional sign prefix
+func (f numberFormat) bytes(bytes int64, sign string) string {
+	const (
+		GiB = 1024 * 1024 * 1024
+		TiB = 1024 * GiB
+	)
+
+	unit := f.unit
+	if unit == "" {
+		unit = "GiB"
+		if bytes >= TiB {
+			unit = "TiB"
+		}
+	}
+	divisor := float64(GiB)
+	if unit == "TiB" {
+		divisor = TiB
+	}
+
+	number := sign + f.number(float64(bytes)/divisor)
+	if pad := f.width - len(number); pad > 0 {
+		number = strings.Repeat(" ", pad) + number
+	}
+	return number + " " + unit
+}
+
+// number renders value with the configured decimals and separators
+func (f numberFormat) number(value float64) string {
+	s := strconv.FormatFloat(value, 'f', f.decimals, 64)
+	intPart, fracPart, hasFrac := strings.Cut(s, ".")
+	intPart = f.group(intPart)
+	if hasFrac {
+		return intPart + f.decimalSep + fracPart
+	}
+	return intPart
+}
+
+// group inserts the thousands separator into a string of digits
+func (f numberFormat) group(digits string) string {
+	if f.thousandsSep == "" || len(digits) <= 3 {
+		return digits
+	}
+	var b strings.Builder
+	lead := len(digits) % 3
+	if lead > 0 {
+		b.WriteString(digits[:lead])
+	}
+	for i := lead; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteString(f.thousandsSep)
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
+
+// count renders a non-negative count such as inodes with an optional sign
+// prefix, exact however large
+func (f numberFormat) count(n int64, sign string) string {
+	number := sign + f.group(strconv.FormatInt(n, 10))
+	if pad := f.width - len(number); pad > 0 {
+		number = strings.Repeat(" ", pad) + number
+	}
+	return number
+}
+
+// diffPolicy hides insignificant changes in comparisons, set from --min-diff
+type diffPolicy struct {
+	min int64
+	// hide drops insignificant rows instead of showing them as unchanged
+	hide bool
+}
+
+// minDiff is the policy used by printComparison, --output motd and --summary
+var minDiff diffPolicy
+
+// significant reports whether a change is at least the minimum
+func (p diffPolicy) significant(diff int64) bool {
+	return diff >= p.min || -diff >= p.min
+}
+
+// format renders a change, insignificant ones as zero
+func (p diffPolicy) format(diff int64) string {
+	if !p.significant(diff) {
+		diff = 0
+	}
+	return formatDiff(diff)
+}
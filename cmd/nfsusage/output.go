@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	_ "embed"
+	"github.com/jessegalley/nfsusage/pkg/report"
+)
+
+// Output formats for --output
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+	outputMOTD  = "motd"
+)
+
+// validOutput reports whether format is one of the --output formats
+func validOutput(format string) bool {
+	return format == outputTable || format == outputJSON || format == outputCSV || format == outputMOTD
+}
+
+// renderOutput prints current in the --output format. base is the entry to
+// compare against, for motd the entry a week earlier; nil shows usage only.
+func renderOutput(format string, current UsageEntry, base *UsageEntry, baseLabel string, motdWidth, motdTop int) error {
+	switch format {
+	case outputMOTD:
+		fmt.Print(renderMOTD(current, base, motdWidth, motdTop))
+	case outputJSON:
+		data, err := report.JSON(current, base)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case outputCSV:
+		data, err := report.CSV(current, base)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+	default:
+		if serverOutput {
+			if base != nil {
+				grouped := groupByServer(*base, current.Details)
+				base = &grouped
+			}
+			current = groupByServer(current, nil)
+		}
+		switch {
+		case inodeOutput && base != nil:
+			printInodeComparison(baseLabel, *base, current)
+		case inodeOutput:
+			printInodes(current)
+		case capacityOutput:
+			printCapacity(current)
+		case base != nil:
+			printComparison(baseLabel, *base, current)
+		default:
+			printCurrent(current)
+		}
+	}
+	return nil
+}
+
+// renderFixture is the input of --render-fixture and the golden output tests
+type renderFixture struct {
+	Current UsageEntry  `json:"current"`
+	Base    *UsageEntry `json:"base,omitempty"`
+	// Label is the comparison column header, e.g. the base entry's date
+	Label string `json:"label,omitempty"`
+}
+
+// loadRenderFixture reads a render fixture file
+func loadRenderFixture(path string) (*renderFixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fixture renderFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if fixture.Label == "" {
+		fixture.Label = "Oldest"
+	}
+	return &fixture, nil
+}
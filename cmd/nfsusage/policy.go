@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/report"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// eventPolicyFired is recorded each time a policy triggers
+const eventPolicyFired = "policy_fired"
+
+// Policy severities, carried in alerts and hook environments
+var policySeverities = []string{"info", "warning", "critical"}
+
+// PolicyConfig is one automated response, evaluated by the daemon after
+// every collection: when a matching mount meets the condition for For
+// consecutive samples, run the hook and send the alert once. The policy
+// re-arms when the condition clears.
+type PolicyConfig struct {
+	Name string `yaml:"name"`
+	// Mount is a glob matched against the mount point or device, empty matches all
+	Mount string `yaml:"mount"`
+	// When is "<metric> <op> <value>" with metric pct, used, free, inode_pct,
+	// growth (since the previous sample) or days_to_full (forecast) and op >,
+	// >=, < or <=, e.g. "pct > 90" or "days_to_full < 30"
+	When string `yaml:"when"`
+	// Window is the history days_to_full forecasts are fit to (default 30d)
+	Window string `yaml:"window"`
+	// For is how many consecutive samples must match (default 1)
+	For int `yaml:"for"`
+	// Run is a shell command, with NFSUSAGE_POLICY, NFSUSAGE_MOUNT,
+	// NFSUSAGE_VALUE and NFSUSAGE_SEVERITY set
+	Run string `yaml:"run"`
+	// Alert is a URL the event is POSTed to as JSON
+	Alert string `yaml:"alert"`
+	// Severity is info, warning or critical (default warning)
+	Severity string `yaml:"severity"`
+}
+
+// policyCondition is a parsed PolicyConfig.When
+type policyCondition struct {
+	metric string
+	op     string
+	value  float64
+}
+
+// parsePolicyCondition parses "<metric> <op> <value>". Percentages may carry
+// a % sign, byte metrics accept sizes such as 500GiB.
+func parsePolicyCondition(when string) (policyCondition, error) {
+	fields := strings.Fields(when)
+	if len(fields) != 3 {
+		return policyCondition{}, fmt.Errorf("condition %q must be \"<metric> <op> <value>\"", when)
+	}
+	c := policyCondition{metric: fields[0], op: fields[1]}
+	switch c.op {
+	case ">", ">=", "<", "<=":
+	default:
+		return c, fmt.Errorf("condition %q: unknown operator %q", when, c.op)
+	}
+	switch c.metric {
+	case "pct", "inode_pct":
+		v, err := strconv.ParseFloat(strings.TrimSuffix(fields[2], "%"), 64)
+		if err != nil {
+			return c, fmt.Errorf("condition %q: invalid percentage %q", when, fields[2])
+		}
+		c.value = v
+	case "days_to_full":
+		v, err := strconv.ParseFloat(strings.TrimSuffix(fields[2], "d"), 64)
+		if err != nil {
+			return c, fmt.Errorf("condition %q: invalid number of days %q", when, fields[2])
+		}
+		c.value = v
+	case "used", "free", "growth":
+		v, err := report.ParseSize(strings.TrimPrefix(fields[2], "-"))
+		if err != nil {
+			return c, fmt.Errorf("condition %q: %v", when, err)
+		}
+		c.value = float64(v)
+		if strings.HasPrefix(fields[2], "-") {
+			c.value = -c.value
+		}
+	default:
+		return c, fmt.Errorf("condition %q: unknown metric %q (want pct, used, free, inode_pct, growth or days_to_full)", when, c.metric)
+	}
+	return c, nil
+}
+
+// measure returns the condition's metric for a mount, false when the entries
+// don't have it (no capacity, no inodes, no previous sample or no confident
+// forecast)
+func (c policyCondition) measure(entry UsageEntry, prev *UsageEntry, forecasts map[string]mountForecast, mount string) (float64, bool) {
+	detail := entry.Details[mount]
+	switch c.metric {
+	case "pct":
+		return report.UsedPercent(entry, mount)
+	case "used":
+		return float64(entry.Mounts[mount]), true
+	case "free":
+		if detail.Available == nil {
+			return 0, false
+		}
+		return float64(*detail.Available), true
+	case "inode_pct":
+		if detail.Inodes == nil || detail.Inodes.Used+detail.Inodes.Free == 0 {
+			return 0, false
+		}
+		return float64(detail.Inodes.Used) / float64(detail.Inodes.Used+detail.Inodes.Free) * 100, true
+	case "growth":
+		if prev == nil {
+			return 0, false
+		}
+		old, ok := prev.Mounts[mount]
+		if !ok {
+			return 0, false
+		}
+		return float64(entry.Mounts[mount] - old), true
+	case "days_to_full":
+		f, ok := forecasts[mount]
+		if !ok {
+			return 0, false
+		}
+		return f.daysToFull(time.Unix(entry.Timestamp, 0), defaultMinFit)
+	}
+	return 0, false
+}
+
+// matches reports whether value meets the condition
+func (c policyCondition) matches(value float64) bool {
+	switch c.op {
+	case ">":
+		return value > c.value
+	case ">=":
+		return value >= c.value
+	case "<":
+		return value < c.value
+	default:
+		return value <= c.value
+	}
+}
+
+// format renders a measured value in the metric's unit
+func (c policyCondition) format(value float64) string {
+	switch c.metric {
+	case "pct", "inode_pct":
+		return fmt.Sprintf("%.1f%%", value)
+	case "growth":
+		return formatDiff(int64(value))
+	case "days_to_full":
+		return fmt.Sprintf("%.0f days", value)
+	}
+	return formatBytes(int64(value))
+}
+
+// policyForecastWindow is the default PolicyConfig.Window
+const policyForecastWindow = 30 * 24 * time.Hour
+
+// policy is a validated PolicyConfig
+type policy struct {
+	PolicyConfig
+	cond   policyCondition
+	window time.Duration
+}
+
+// parsePolicies validates the configured policies
+func parsePolicies(configs []PolicyConfig) ([]policy, error) {
+	var policies []policy
+	names := make(map[string]bool)
+	for i, pc := range configs {
+		if pc.Name == "" {
+			pc.Name = fmt.Sprintf("policy%d", i+1)
+		}
+		if names[pc.Name] {
+			return nil, fmt.Errorf("policy %s: duplicate name", pc.Name)
+		}
+		names[pc.Name] = true
+		cond, err := parsePolicyCondition(pc.When)
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: %v", pc.Name, err)
+		}
+		window := policyForecastWindow
+		if pc.Window != "" {
+			if window, err = parseAge(pc.Window); err != nil || window <= 0 {
+				return nil, fmt.Errorf("policy %s: invalid window %q", pc.Name, pc.Window)
+			}
+		}
+		if pc.For == 0 {
+			pc.For = 1
+		}
+		if pc.For < 0 {
+			return nil, fmt.Errorf("policy %s: for must be positive", pc.Name)
+		}
+		if pc.Severity == "" {
+			pc.Severity = "warning"
+		}
+		if !slices.Contains(policySeverities, pc.Severity) {
+			return nil, fmt.Errorf("policy %s: unknown severity %q (want %s)", pc.Name, pc.Severity, strings.Join(policySeverities, ", "))
+		}
+		policies = append(policies, policy{PolicyConfig: pc, cond: cond, window: window})
+	}
+	return policies, nil
+}
+
+// policyEvent is what a triggered policy reports, the JSON POSTed to its alert URL
+type policyEvent struct {
+	Policy    string `json:"policy"`
+	Severity  string `json:"severity"`
+	Mount     string `json:"mount"`
+	Condition string `json:"condition"`
+	Value     string `json:"value"`
+	Samples   int    `json:"samples"`
+	Host      string `json:"host,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// policyEngine keeps the per mount streaks between daemon collections
+type policyEngine struct {
+	policies []policy
+	cfg      *Config
+	filePath string
+	key      []byte
+	prev     *UsageEntry
+	// streak counts consecutive matching samples per policy and mount
+	streak map[string]int
+}
+
+func newPolicyEngine(policies []policy, cfg *Config, filePath string, key []byte) *policyEngine {
+	return &policyEngine{policies: policies, cfg: cfg, filePath: filePath, key: key, streak: make(map[string]int)}
+}
+
+// reload returns an engine for new policies, keeping the streaks of policies
+// whose name is unchanged
+func (e *policyEngine) reload(policies []policy, cfg *Config) *policyEngine {
+	next := newPolicyEngine(policies, cfg, e.filePath, e.key)
+	next.prev = e.prev
+	for key, n := range e.streak {
+		name, _, _ := strings.Cut(key, "\x00")
+		if slices.ContainsFunc(policies, func(p policy) bool { return p.Name == name }) {
+			next.streak[key] = n
+		}
+	}
+	return next
+}
+
+// evaluate checks every policy against a new entry and triggers the ones
+// whose streak just reached For. Mounts missing from the entry keep their
+// streak, so a partial collection doesn't re-arm a policy.
+func (e *policyEngine) evaluate(entry UsageEntry) {
+	entry = collector.WithoutSnapshots(entry)
+	mounts := make([]string, 0, len(entry.Mounts))
+	for mount := range entry.Mounts {
+		mounts = append(mounts, mount)
+	}
+	sort.Strings(mounts)
+
+	// Forecasts are fit once per window used by a days_to_full policy
+	forecasts := make(map[time.Duration]map[string]mountForecast)
+	for _, p := range e.policies {
+		if _, ok := forecasts[p.window]; ok || p.cond.metric != "days_to_full" {
+			continue
+		}
+		from := time.Unix(entry.Timestamp, 0).Add(-p.window).Unix()
+		f, err := forecastMounts(store.Open(e.filePath, e.key, e.cfg.Compact), from, e.cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: policy %s: forecasting: %v\n", p.Name, err)
+		}
+		forecasts[p.window] = f
+	}
+
+	for _, p := range e.policies {
+		for _, mount := range mounts {
+			m := nfsMount{MountPoint: mount, Device: entry.Details[mount].Device}
+			if p.Mount != "" && !matchesMount(p.Mount, m) || e.cfg.noAlert(m) {
+				continue
+			}
+			key := p.Name + "\x00" + mount
+			value, ok := p.cond.measure(entry, e.prev, forecasts[p.window], mount)
+			if !ok || !p.cond.matches(value) {
+				delete(e.streak, key)
+				continue
+			}
+			e.streak[key]++
+			if e.streak[key] == p.For {
+				e.trigger(p, policyEvent{
+					Policy: p.Name, Severity: p.Severity, Mount: mount, Condition: p.When,
+					Value: p.cond.format(value), Samples: p.For, Host: entry.Host, Timestamp: entry.Timestamp,
+				})
+			}
+		}
+	}
+	e.prev = &entry
+}
+
+// trigger runs a policy's actions. Failures are reported and never stop the daemon.
+func (e *policyEngine) trigger(p policy, ev policyEvent) {
+	fmt.Fprintf(os.Stderr, "Policy %s (%s): %s %s is %s for %d samples\n", p.Name, p.Severity, ev.Mount, p.cond.metric, ev.Value, ev.Samples)
+	recordEvent(e.filePath, eventPolicyFired, "policy %s (%s): %s %s", p.Name, p.Severity, ev.Mount, ev.Value)
+	if p.Run != "" {
+		cmd := exec.Command("sh", "-c", p.Run)
+		cmd.Env = append(os.Environ(),
+			"NFSUSAGE_POLICY="+p.Name,
+			"NFSUSAGE_MOUNT="+ev.Mount,
+			"NFSUSAGE_VALUE="+ev.Value,
+			"NFSUSAGE_SEVERITY="+p.Severity,
+		)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: policy %s: hook failed: %v\n", p.Name, err)
+		}
+	}
+	if p.Alert != "" {
+		data, err := json.Marshal(ev)
+		if err == nil {
+			err = postBody(p.Alert, "application/json", "", "", data)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: policy %s: alert failed: %v\n", p.Name, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/jessegalley/nfsusage/pkg/report"
+)
+
+// ReportPreset bundles the report flags one audience usually wants. Empty
+// fields leave the flag at its default.
+type ReportPreset struct {
+	// Reports lists the sections to print: monthly, composition, coverage, rates, backup, snapshots, trash, drilldown
+	Reports       []string `yaml:"reports"`
+	Window        string   `yaml:"window"`
+	Months        int      `yaml:"months"`
+	Gaps          string   `yaml:"gaps"`
+	Smooth        string   `yaml:"smooth"`
+	FormatNumbers string   `yaml:"format_numbers"`
+	Locale        string   `yaml:"locale"`
+}
+
+// builtinPresets are available without any config; report_presets in the
+// config file overrides them by name or adds new ones
+var builtinPresets = map[string]ReportPreset{
+	// sre: is collection healthy and is anything growing fast right now
+	"sre": {Reports: []string{"coverage", "rates"}, Window: "7d", Gaps: report.GapsFlag},
+	// capacity: long-term trend for planning purchases
+	"capacity": {Reports: []string{"monthly", "rates"}, Window: "90d", Months: 12, Gaps: report.GapsInterpolate, FormatNumbers: "thousands"},
+	// chargeback: who uses what share, billed monthly
+	"chargeback": {Reports: []string{"composition", "monthly"}, Window: "30d", Months: 3, FormatNumbers: "thousands,unit=GiB"},
+	// backup: churn inside backup windows and whether every day was sampled
+	"backup": {Reports: []string{"backup", "rates", "coverage"}, Window: "14d", Gaps: report.GapsSkip},
+}
+
+// reportSections are the valid entries of ReportPreset.Reports
+var reportSections = []string{"monthly", "composition", "coverage", "rates", "backup", "snapshots", "trash", "drilldown"}
+
+// lookupPreset returns the named preset, preferring the config file's definition
+func lookupPreset(name string, cfg *Config) (ReportPreset, error) {
+	p, ok := cfg.ReportPresets[name]
+	if !ok {
+		p, ok = builtinPresets[name]
+	}
+	if !ok {
+		return p, fmt.Errorf("unknown preset %q (have %s)", name, strings.Join(presetNames(cfg), ", "))
+	}
+	for _, section := range p.Reports {
+		if !slices.Contains(reportSections, section) {
+			return p, fmt.Errorf("preset %q: unknown report %q (want %s)", name, section, strings.Join(reportSections, ", "))
+		}
+	}
+	return p, nil
+}
+
+// presetNames lists the built-in and configured preset names, sorted
+func presetNames(cfg *Config) []string {
+	var names []string
+	for name := range builtinPresets {
+		names = append(names, name)
+	}
+	for name := range cfg.ReportPresets {
+		if _, ok := builtinPresets[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
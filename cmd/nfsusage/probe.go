@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+const (
+	nfsPort    = "2049"
+	nfsProgram = 100003
+)
+
+// probeServer connects to the NFS port and issues a NULL RPC call. Reachable is
+// set once the TCP connection succeeds, RPC once a well-formed reply comes back.
+func probeServer(addr string, timeout time.Duration) ServerProbe {
+	probe := ServerProbe{Address: addr}
+	start := time.Now()
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(addr, nfsPort), timeout)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	defer conn.Close()
+	probe.Reachable = true
+
+	conn.SetDeadline(start.Add(timeout))
+	if err := nullRPC(conn); err != nil {
+		probe.Error = err.Error()
+	} else {
+		probe.RPC = true
+	}
+	probe.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	return probe
+}
+
+// nullRPC sends an ONC RPC NULL call (procedure 0) and validates the reply header.
+// Any accepted reply, including a version mismatch, proves the server is serving RPC.
+func nullRPC(conn net.Conn) error {
+	xid := uint32(time.Now().UnixNano())
+
+	// call body: xid, CALL, rpcvers 2, prog, vers 3, proc 0, AUTH_NULL cred + verf
+	call := make([]byte, 4+40)
+	binary.BigEndian.PutUint32(call[0:], 0x80000000|40)
+	for i, v := range []uint32{xid, 0, 2, nfsProgram, 3, 0, 0, 0, 0, 0} {
+		binary.BigEndian.PutUint32(call[4+i*4:], v)
+	}
+	if _, err := conn.Write(call); err != nil {
+		return err
+	}
+
+	// reply: record marker, xid, REPLY, reply_stat
+	reply := make([]byte, 16)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint32(reply[4:]) != xid {
+		return fmt.Errorf("rpc reply xid mismatch")
+	}
+	if binary.BigEndian.Uint32(reply[8:]) != 1 {
+		return fmt.Errorf("rpc reply has unexpected message type")
+	}
+	if binary.BigEndian.Uint32(reply[12:]) != 0 {
+		return fmt.Errorf("rpc call denied")
+	}
+	return nil
+}
+
+// probeServers probes each unique server once, keyed by server identity
+func probeServers(entry UsageEntry, timeout time.Duration) map[string]ServerProbe {
+	probes := make(map[string]ServerProbe)
+	for _, detail := range entry.Details {
+		if detail.Server == "" {
+			continue
+		}
+		if _, done := probes[detail.Server]; done {
+			continue
+		}
+		addr := detail.ServerAddr
+		if addr == "" {
+			addr = collector.ServerHost(detail.Device)
+		}
+		probes[detail.Server] = probeServer(addr, timeout)
+	}
+	return probes
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// enrichCloudWatch fills in MeteredBytes from the CloudWatch StorageBytes metric
+// using the aws CLI. Only EFS publishes a usable storage metric.
+func enrichCloudWatch(info *ProviderInfo) error {
+	if info.Name != "efs" {
+		return nil
+	}
+
+	end := time.Now().UTC()
+	start := end.Add(-3 * time.Hour)
+	cmd := exec.Command("aws", "cloudwatch", "get-metric-statistics",
+		"--region", info.Region,
+		"--namespace", "AWS/EFS",
+		"--metric-name", "StorageBytes",
+		"--dimensions", "Name=FileSystemId,Value="+info.FileSystemID, "Name=StorageClass,Value=Total",
+		"--start-time", start.Format(time.RFC3339),
+		"--end-time", end.Format(time.RFC3339),
+		"--period", "3600",
+		"--statistics", "Average",
+		"--output", "json")
+	output, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Datapoints []struct {
+			Timestamp time.Time `json:"Timestamp"`
+			Average   float64   `json:"Average"`
+		} `json:"Datapoints"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return fmt.Errorf("error parsing CloudWatch output: %v", err)
+	}
+	if len(result.Datapoints) == 0 {
+		return fmt.Errorf("no StorageBytes datapoints for %s", info.FileSystemID)
+	}
+
+	// Datapoints are not returned in order, pick the most recent one
+	latest := result.Datapoints[0]
+	for _, dp := range result.Datapoints[1:] {
+		if dp.Timestamp.After(latest.Timestamp) {
+			latest = dp
+		}
+	}
+	info.MeteredBytes = int64(latest.Average)
+	return nil
+}
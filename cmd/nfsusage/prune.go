@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// runPrune implements the prune subcommand: apply the retention policy to the
+// history now instead of after the next write
+func runPrune(args []string) {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	var filePath, configPath, keyFile, retain, downsample string
+	var maxEntries, generations int
+	var dryRun bool
+	fs.StringVar(&filePath, "file", "", "Path to the data file (default: config file, else CWD/nfsusage.json)")
+	fs.StringVar(&filePath, "f", "", "Path to the data file (shorthand)")
+	fs.StringVar(&configPath, "config", "", "Path to YAML config file")
+	fs.StringVar(&keyFile, "key-file", "", "Path to AES-256 key for the data file")
+	fs.StringVar(&retain, "retain", "", "Prune entries older than this, e.g. 90d (default: config retain)")
+	fs.IntVar(&maxEntries, "max-entries", 0, "Prune the oldest entries beyond this count (default: config max_entries)")
+	fs.StringVar(&downsample, "downsample", "", "Keep one entry per day for entries older than this, e.g. 7d (default: config downsample)")
+	fs.IntVar(&generations, "generations", 0, "Keep this many daily copies of the data file as <file>.1 to <file>.N (default: config generations)")
+	fs.BoolVar(&dryRun, "dry-run", false, "Print how many entries would be pruned without changing the data file")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: nfsusage prune [flags]")
+		fmt.Fprintln(os.Stderr, "Drop history entries outside the retention policy without collecting.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	if retention, err = cfg.retentionPolicy(retain, downsample, maxEntries); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if !retention.Enabled() {
+		fmt.Fprintln(os.Stderr, "Error: no retention policy, set --retain, --max-entries or --downsample (or retain, max_entries or downsample in the config)")
+		os.Exit(1)
+	}
+	backups = cfg.backupPolicy(generations)
+	key, err := loadKey(keyFile, cfg.Encryption)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading encryption key: %v\n", err)
+		os.Exit(1)
+	}
+	filePath = cfg.dataFile(filePath)
+	st := store.Open(filePath, key, cfg.Compact)
+
+	if dryRun {
+		entries, err := st.Load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+			os.Exit(1)
+		}
+		kept := retention.Prune(entries, timeSource.Now())
+		fmt.Printf("Would prune %d of %d entries from %s\n", len(entries)-len(kept), len(entries), filePath)
+		return
+	}
+	pruned, total, err := applyRetention(st, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error pruning %s: %v\n", filePath, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Pruned %d of %d entries from %s\n", pruned, total, filePath)
+}
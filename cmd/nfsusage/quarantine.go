@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// eventStoreQuarantined is recorded when a corrupt data file is set aside
+const eventStoreQuarantined = "store_quarantined"
+
+// eventStoreRepaired is recorded when a torn final line is truncated
+const eventStoreRepaired = "store_repaired"
+
+// autoRecover moves corrupt data files aside and starts a fresh history
+// instead of failing the collection, cleared by --no-auto-recover
+var autoRecover = true
+
+// quarantineStore renames a corrupt data file and its index, manifest and cache to
+// <path>.corrupt-<timestamp> so the next append starts a fresh history. The
+// events sidecar stays with the path and records where the old data went.
+func quarantineStore(filePath string, cause error) (string, error) {
+	dest := filePath + ".corrupt-" + timeSource.Now().UTC().Format("20060102T150405Z")
+	if err := os.Rename(filePath, dest); err != nil {
+		return "", err
+	}
+	for _, sidecar := range []func(string) string{store.IndexPath, store.ManifestPath, store.LatestPath} {
+		if err := os.Rename(sidecar(filePath), sidecar(dest)); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Warning: error moving %s: %v\n", sidecar(filePath), err)
+		}
+	}
+	recordEvent(filePath, eventStoreQuarantined, "%v; moved to %s and started a fresh history", cause, dest)
+	recordAudit(filePath, auditQuarantine, "moved corrupt data to %s", dest)
+	return dest, nil
+}
+
+// recoverCorruptStore quarantines the store when err says it is corrupt and
+// auto recovery is on, reporting whether the caller can start afresh
+func recoverCorruptStore(st historyStore, err error) bool {
+	var corrupt *CorruptStoreError
+	if !autoRecover || !errors.As(err, &corrupt) {
+		return false
+	}
+	dest, qerr := quarantineStore(st.File(), err)
+	if qerr != nil {
+		fmt.Fprintf(os.Stderr, "Error quarantining corrupt data file: %v\n", qerr)
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "Warning: *** %s is corrupt (%v) ***\n", st.File(), err)
+	fmt.Fprintf(os.Stderr, "Warning: *** moved it to %s and started a fresh history (see --no-auto-recover) ***\n", dest)
+	return true
+}
+
+// repairTornStore truncates a torn final record when err says the store is
+// corrupt and the damage is confined to it, as after a crash mid-append. It
+// reports whether the store was repaired and can be read again.
+func repairTornStore(st historyStore, err error) bool {
+	var corrupt *CorruptStoreError
+	repairer, ok := st.(store.TailRepairer)
+	if !ok || !errors.As(err, &corrupt) {
+		return false
+	}
+	repaired, rerr := repairer.RepairTail()
+	if rerr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error repairing %s: %v\n", st.File(), rerr)
+		return false
+	}
+	if !repaired {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s ended in a torn entry (%v), truncated it to the last complete one\n", st.File(), err)
+	recordEvent(st.File(), eventStoreRepaired, "%v; truncated the torn final entry", err)
+	recordAudit(st.File(), auditRepair, "truncated a torn final entry")
+	return true
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jessegalley/nfsusage/pkg/report"
+)
+
+// compareRates holds each mount's growth over the compared period, set when
+// comparing and shown as Growth/day and Full in columns; nil hides them
+var compareRates map[string]report.Rate
+
+// growthSince fits a line through each mount's usage at or after from and
+// returns the growth per day with the newest free space, keyed by mount
+func growthSince(st historyStore, from int64) (map[string]report.Rate, error) {
+	series, err := collectForecastSeries(st, from)
+	if err != nil {
+		return nil, err
+	}
+	rates := make(map[string]report.Rate, len(series))
+	for _, s := range series {
+		r := report.Rate{Mount: s.mount, Samples: len(s.bytes), Free: s.free}
+		if slope, _, _, ok := report.LinearFit(s.timestamps, s.bytes); ok {
+			r.PerDay = slope * 86400
+		}
+		rates[s.mount] = r
+	}
+	return rates, nil
+}
+
+// printRatesReport prints growth per day and the projected time until full
+// for each mount, marking flagged rates
+func printRatesReport(rates []report.Rate) {
+	mountWidth := len("Mountpoint")
+	rateWidth := len("Growth/day")
+	for _, r := range rates {
+		mountWidth = max(mountWidth, len(r.Mount))
+		rateWidth = max(rateWidth, len(formatDiff(int64(r.PerDay))))
+	}
+	fmt.Printf("%-*s  %7s  %*s  %7s  %4s\n", mountWidth, "Mountpoint", "Samples", rateWidth, "Growth/day", "Full in", "Gaps")
+	fmt.Printf("%-*s  %7s  %*s  %7s  %4s\n", mountWidth, strings.Repeat("-", mountWidth), "-------", rateWidth, strings.Repeat("-", rateWidth), "-------", "----")
+	flagged := false
+	for _, r := range rates {
+		mark := ""
+		if r.Flagged {
+			mark = " !"
+			flagged = true
+		}
+		fmt.Printf("%-*s  %7d  %*s  %7s  %4d%s\n", mountWidth, r.Mount, r.Samples, rateWidth, formatDiff(int64(r.PerDay)), r.FullIn(), r.Gaps, mark)
+	}
+	if flagged {
+		fmt.Printf("\n! rate averages over gaps in collection and may understate bursts (try --gaps %s)\n", report.GapsSkip)
+	}
+}
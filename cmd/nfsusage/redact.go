@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Path redaction modes for --redact-paths
+const (
+	redactHash  = "hash"
+	redactAlias = "alias"
+)
+
+// redactor replaces mount paths and export paths in output so usage data can be
+// shared externally. A nil redactor leaves everything untouched.
+type redactor struct {
+	mode    string
+	aliases map[string]string
+	salt    string
+}
+
+// newRedactor returns a redactor for mode, or nil when redaction is disabled
+func newRedactor(mode string, cfg *Config) (*redactor, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case redactHash, redactAlias:
+		return &redactor{mode: mode, aliases: cfg.Aliases, salt: cfg.RedactSalt}, nil
+	}
+	return nil, fmt.Errorf("invalid redact mode %q (want %s or %s)", mode, redactHash, redactAlias)
+}
+
+// path returns the redacted form of a mount or export path. Alias mode uses the
+// configured aliases and falls back to hashing paths that have none.
+func (r *redactor) path(p string) string {
+	if r == nil || p == "total" {
+		return p
+	}
+	if r.mode == redactAlias {
+		if alias, ok := r.aliases[p]; ok {
+			return alias
+		}
+	}
+	sum := sha256.Sum256([]byte(r.salt + p))
+	return "mount-" + hex.EncodeToString(sum[:])[:10]
+}
+
+// entry returns a copy of the entry with every mount path, device and
+// directory name redacted, including paths quoted in failure reasons
+func (r *redactor) entry(e UsageEntry) UsageEntry {
+	if r == nil {
+		return e
+	}
+	text := r.textReplacer(e)
+	redacted := e
+	redacted.Mounts = r.mounts(e.Mounts)
+	if e.Details != nil {
+		redacted.Details = make(map[string]MountDetail, len(e.Details))
+		for mount, detail := range e.Details {
+			if detail.Device != "" {
+				detail.Device = r.path(detail.Device)
+			}
+			detail.Dirs = r.children(mount, detail.Dirs)
+			detail.Trash = r.children(mount, detail.Trash)
+			redacted.Details[r.path(mount)] = detail
+		}
+	}
+	redacted.Missing = r.paths(e.Missing)
+	redacted.Absent = r.paths(e.Absent)
+	redacted.Stale = r.paths(e.Stale)
+	if e.Unmeasured != nil {
+		redacted.Unmeasured = make(map[string]string, len(e.Unmeasured))
+		for mount, reason := range e.Unmeasured {
+			redacted.Unmeasured[r.path(mount)] = text.Replace(reason)
+		}
+	}
+	if e.Unavailable != nil {
+		redacted.Unavailable = make(map[string]string, len(e.Unavailable))
+		for source, reason := range e.Unavailable {
+			redacted.Unavailable[source] = text.Replace(reason)
+		}
+	}
+	return redacted
+}
+
+// paths returns a copy of a list of mount paths with each one redacted
+func (r *redactor) paths(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	redacted := make([]string, len(list))
+	for i, p := range list {
+		redacted[i] = r.path(p)
+	}
+	return redacted
+}
+
+// children redacts a map keyed by the names of directories in mount, hashing
+// them with the mount so equal names in different mounts stay apart
+func (r *redactor) children(mount string, m map[string]int64) map[string]int64 {
+	if m == nil {
+		return nil
+	}
+	redacted := make(map[string]int64, len(m))
+	for name, bytes := range m {
+		redacted[r.path(strings.TrimSuffix(mount, "/")+"/"+name)] = bytes
+	}
+	return redacted
+}
+
+// textReplacer redacts the mount paths and devices of e wherever they appear
+// in free text, longest first so a mount nested in another is replaced whole
+func (r *redactor) textReplacer(e UsageEntry) *strings.Replacer {
+	seen := make(map[string]bool)
+	for mount := range e.Mounts {
+		seen[mount] = true
+	}
+	for mount, detail := range e.Details {
+		seen[mount] = true
+		if detail.Device != "" {
+			seen[detail.Device] = true
+		}
+	}
+	for mount := range e.Unmeasured {
+		seen[mount] = true
+	}
+	for _, list := range [][]string{e.Missing, e.Absent, e.Stale} {
+		for _, mount := range list {
+			seen[mount] = true
+		}
+	}
+	paths := make([]string, 0, len(seen))
+	for p := range seen {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if len(paths[i]) != len(paths[j]) {
+			return len(paths[i]) > len(paths[j])
+		}
+		return paths[i] < paths[j]
+	})
+	pairs := make([]string, 0, 2*len(paths))
+	for _, p := range paths {
+		pairs = append(pairs, p, r.path(p))
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// mounts returns a copy of a map keyed by mount path with the keys redacted
+func (r *redactor) mounts(m map[string]int64) map[string]int64 {
+	if r == nil || m == nil {
+		return m
+	}
+	redacted := make(map[string]int64, len(m))
+	for mount, bytes := range m {
+		redacted[r.path(mount)] = bytes
+	}
+	return redacted
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRedactEntry(t *testing.T) {
+	secret := []string{"/proj/apollo", "filer01:/export/apollo", "apollo-results", "/proj/zeus", "/proj/hermes", "/proj/athena"}
+	entry := UsageEntry{
+		Mounts: map[string]int64{"/proj/apollo": 10, "/proj/apollo/.snapshot": 1},
+		Total:  10,
+		Details: map[string]MountDetail{"/proj/apollo": {
+			Device: "filer01:/export/apollo",
+			Dirs:   map[string]int64{"apollo-results": 5},
+			Trash:  map[string]int64{".trash": 1},
+		}},
+		Missing:     []string{"/proj/zeus"},
+		Absent:      []string{"/proj/hermes"},
+		Stale:       []string{"/proj/athena"},
+		Unmeasured:  map[string]string{"/proj/athena": "stat /proj/athena: permission denied"},
+		Unavailable: map[string]string{"mountstats": "no stats for filer01:/export/apollo on /proj/apollo"},
+	}
+	tests := []struct {
+		mode string
+		// want are strings the redacted entry must contain
+		want []string
+	}{
+		{redactHash, []string{"mount-"}},
+		{redactAlias, []string{`"apollo"`, "stat apollo-home: permission denied"}},
+	}
+	cfg := &Config{Aliases: map[string]string{"/proj/apollo": "apollo", "/proj/athena": "apollo-home"}}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			r, err := newRedactor(tt.mode, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := json.Marshal(r.entry(entry))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := string(data)
+			for _, s := range secret {
+				if strings.Contains(out, s) {
+					t.Errorf("redacted entry still contains %q: %s", s, out)
+				}
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(out, s) {
+					t.Errorf("redacted entry lacks %q: %s", s, out)
+				}
+			}
+		})
+	}
+}
+
+func TestSinkRedactMode(t *testing.T) {
+	tests := []struct {
+		global, sink string
+		want         string
+	}{
+		{"", "", ""},
+		{redactHash, "", redactHash},
+		{redactHash, redactAlias, redactAlias},
+		{redactHash, sinkRedactNone, ""},
+		{"", redactAlias, redactAlias},
+	}
+	for _, tt := range tests {
+		cfg := &Config{Sinks: []SinkConfig{{Type: "textfile", Path: "/tmp/nfsusage.prom", Redact: tt.sink}}}
+		runners, err := newSinkRunners(cfg, tt.global)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if r := runners[0].redact; r != nil {
+			got = r.mode
+		}
+		if got != tt.want {
+			t.Errorf("global %q, sink %q: redact mode %q, want %q", tt.global, tt.sink, got, tt.want)
+		}
+	}
+}
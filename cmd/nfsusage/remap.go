@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// eventSeriesRemapped is recorded when a collection sees a filesystem move to
+// another mount point
+const eventSeriesRemapped = "series_remapped"
+
+// remapRule merges the series of mount point From into To in reports, so a
+// remounted or re-pathed export keeps one continuous series
+type remapRule struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	FSID string `json:"fsid,omitempty"`
+	Time int64  `json:"time"`
+	// Auto is set for rules added because a collection saw the fsid move
+	Auto bool `json:"auto,omitempty"`
+}
+
+// remapPath returns the sidecar holding the remap rules of a data file
+func remapPath(filePath string) string {
+	return filePath + ".remap"
+}
+
+// loadRemaps reads the remap rules, a missing file has none
+func loadRemaps(filePath string) ([]remapRule, error) {
+	data, err := os.ReadFile(remapPath(filePath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var rules []remapRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %v", remapPath(filePath), err)
+	}
+	return rules, nil
+}
+
+// saveRemaps writes the remap rules
+func saveRemaps(filePath string, rules []remapRule) error {
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(remapPath(filePath), append(data, '\n'), 0644)
+}
+
+// seriesRemap maps old mount points to the one their series continues under
+type seriesRemap map[string]string
+
+// newSeriesRemap resolves chains of rules (a -> b -> c) so every mount maps
+// straight to its final name. Later rules for the same mount win.
+func newSeriesRemap(rules []remapRule) seriesRemap {
+	direct := make(map[string]string)
+	for _, rule := range rules {
+		direct[rule.From] = rule.To
+	}
+	m := make(seriesRemap)
+	for from := range direct {
+		to, seen := from, map[string]bool{from: true}
+		for next, ok := direct[to]; ok && !seen[next]; next, ok = direct[to] {
+			seen[next] = true
+			to = next
+		}
+		if to != from {
+			m[from] = to
+		}
+	}
+	return m
+}
+
+// resolve returns the mount point a mount's series continues under. Snapshot
+// space recorded under <mount>/.snapshot follows its mount.
+func (m seriesRemap) resolve(mount string) string {
+	if to, ok := m[mount]; ok {
+		return to
+	}
+	if base := strings.TrimSuffix(mount, "/"+snapshotDir); base != mount {
+		if to, ok := m[base]; ok {
+			return snapshotKey(to)
+		}
+	}
+	return mount
+}
+
+// entry renames remapped mounts. A mount that is also present under its new
+// name in the same entry is dropped rather than counted twice.
+func (m seriesRemap) entry(e UsageEntry) UsageEntry {
+	if len(m) == 0 {
+		return e
+	}
+	remapped := e
+	remapped.Mounts = make(map[string]int64, len(e.Mounts))
+	remapped.Details = make(map[string]MountDetail, len(e.Details))
+	for mount, used := range e.Mounts {
+		to := m.resolve(mount)
+		if _, ok := e.Mounts[to]; ok && to != mount {
+			if !collector.IsSnapshotMount(mount) {
+				remapped.Total -= used
+			}
+			continue
+		}
+		remapped.Mounts[to] = used
+		if detail, ok := e.Details[mount]; ok {
+			remapped.Details[to] = detail
+		}
+	}
+	return remapped
+}
+
+// seriesStore reads a store with its remap rules applied. It is only used by
+// reports; collection and validation work on the stored mount points.
+type seriesStore struct {
+	historyStore
+	remap seriesRemap
+}
+
+// openSeriesStore opens a store for reports, applying its remap rules
+func openSeriesStore(filePath string, key []byte, compact bool) (historyStore, error) {
+	st := store.Open(filePath, key, compact)
+	rules, err := loadRemaps(filePath)
+	if err != nil || len(rules) == 0 {
+		return st, err
+	}
+	return &seriesStore{historyStore: st, remap: newSeriesRemap(rules)}, nil
+}
+
+func (s *seriesStore) Load() ([]UsageEntry, error) {
+	entries, err := s.historyStore.Load()
+	for i := range entries {
+		entries[i] = s.remap.entry(entries[i])
+	}
+	return entries, err
+}
+
+func (s *seriesStore) Scan(fn func(UsageEntry) error) error {
+	return s.historyStore.Scan(func(e UsageEntry) error {
+		return fn(s.remap.entry(e))
+	})
+}
+
+func (s *seriesStore) ScanRange(from, to int64, fn func(UsageEntry) error) error {
+	return store.ScanRange(s.historyStore, from, to, func(e UsageEntry) error {
+		return fn(s.remap.entry(e))
+	})
+}
+
+// lastEntry returns the newest entry and the number of entries like
+// store.Last, with the remap rules applied for series stores
+func lastEntry(st historyStore) (*UsageEntry, int, error) {
+	if s, ok := st.(*seriesStore); ok {
+		entry, count, err := store.Last(s.historyStore)
+		if entry != nil {
+			*entry = s.remap.entry(*entry)
+		}
+		return entry, count, err
+	}
+	return store.Last(st)
+}
+
+// detectRemaps returns rules for filesystems that moved mount point between
+// two consecutive entries: a mount of prev that is gone, sharing its fsid
+// with exactly one mount that is new in entry
+func detectRemaps(prev, entry UsageEntry) []remapRule {
+	added := make(map[string][]string)
+	for mount, detail := range entry.Details {
+		if _, ok := prev.Mounts[mount]; !ok && detail.FSID != "" {
+			added[detail.FSID] = append(added[detail.FSID], mount)
+		}
+	}
+	var rules []remapRule
+	for mount, detail := range prev.Details {
+		if _, ok := entry.Mounts[mount]; ok || detail.FSID == "" || len(added[detail.FSID]) != 1 {
+			continue
+		}
+		rules = append(rules, remapRule{From: mount, To: added[detail.FSID][0], FSID: detail.FSID, Time: entry.Timestamp, Auto: true})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].From < rules[j].From })
+	return rules
+}
+
+// recordRemaps adds rules for filesystems that moved since prev. Failures are
+// only warned about, the series can still be merged with 'nfsusage remap'.
+func recordRemaps(filePath string, prev *UsageEntry, entry UsageEntry) {
+	if prev == nil {
+		return
+	}
+	found := detectRemaps(*prev, entry)
+	if len(found) == 0 {
+		return
+	}
+	rules, err := loadRemaps(filePath)
+	if err == nil {
+		err = saveRemaps(filePath, append(rules, found...))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error recording remapped mounts: %v\n", err)
+		return
+	}
+	for _, rule := range found {
+		fmt.Fprintf(os.Stderr, "Filesystem %s moved from %s to %s, continuing its series\n", rule.FSID, rule.From, rule.To)
+		recordEvent(filePath, eventSeriesRemapped, "fsid %s moved from %s to %s", rule.FSID, rule.From, rule.To)
+	}
+}
+
+// scanRemaps finds filesystems that moved mount point anywhere in the
+// history, including moves across gaps the per-collection check misses
+func scanRemaps(st historyStore, known seriesRemap) ([]remapRule, error) {
+	lastPath := make(map[string]string)
+	found := make(map[string]remapRule)
+	var prev map[string]int64
+	err := st.Scan(func(e UsageEntry) error {
+		for mount, detail := range e.Details {
+			old, ok := lastPath[detail.FSID]
+			if !ok || old == mount || known.resolve(old) == mount {
+				continue
+			}
+			_, oldPresent := e.Mounts[old]
+			_, wasPresent := prev[mount]
+			if !oldPresent && !wasPresent {
+				found[old] = remapRule{From: old, To: mount, FSID: detail.FSID, Time: e.Timestamp}
+			}
+		}
+		for mount, detail := range e.Details {
+			if detail.FSID != "" {
+				lastPath[detail.FSID] = mount
+			}
+		}
+		prev = e.Mounts
+		return nil
+	})
+	rules := make([]remapRule, 0, len(found))
+	for _, rule := range found {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Time < rules[j].Time })
+	return rules, err
+}
+
+// runRemap implements the remap subcommand
+func runRemap(args []string) {
+	fs := flag.NewFlagSet("remap", flag.ExitOnError)
+	var filePath, configPath, keyFile, remove string
+	var scan bool
+	var added int
+	fs.StringVar(&filePath, "file", "", "Path to the data file (default: config file, else CWD/nfsusage.json)")
+	fs.StringVar(&filePath, "f", "", "Path to the data file (shorthand)")
+	fs.StringVar(&configPath, "config", "", "Path to YAML config file")
+	fs.StringVar(&keyFile, "key-file", "", "Path to AES-256 key for the data file")
+	fs.StringVar(&remove, "remove", "", "Remove the rules for this mount point")
+	fs.BoolVar(&scan, "scan", false, "Search the history for mounts sharing an fsid and add rules for them")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: nfsusage remap [flags] [FROM TO]")
+		fmt.Fprintln(os.Stderr, "Merge the series of mount point FROM into TO in reports, or list the rules.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	filePath = cfg.dataFile(filePath)
+	rules, err := loadRemaps(filePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch {
+	case fs.NArg() == 2:
+		from, to := fs.Arg(0), fs.Arg(1)
+		if newSeriesRemap(append(rules, remapRule{From: from, To: to})).resolve(to) != to {
+			fmt.Fprintf(os.Stderr, "Error: %s is itself remapped, remapping %s to it would loop\n", to, from)
+			os.Exit(1)
+		}
+		rules = append(rules, remapRule{From: from, To: to, Time: timeSource.Now().Unix()})
+	case fs.NArg() != 0:
+		fs.Usage()
+		os.Exit(1)
+	case remove != "":
+		kept := rules[:0]
+		for _, rule := range rules {
+			if rule.From != remove {
+				kept = append(kept, rule)
+			}
+		}
+		if len(kept) == len(rules) {
+			fmt.Fprintf(os.Stderr, "Error: no rule for %s\n", remove)
+			os.Exit(1)
+		}
+		rules = kept
+	case scan:
+		key, err := loadKey(keyFile, cfg.Encryption)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading encryption key: %v\n", err)
+			os.Exit(1)
+		}
+		found, err := scanRemaps(store.Open(filePath, key, cfg.Compact), newSeriesRemap(rules))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading data: %v\n", err)
+			os.Exit(1)
+		}
+		if len(found) == 0 {
+			fmt.Println("No moved filesystems found")
+			return
+		}
+		rules = append(rules, found...)
+		added = len(found)
+	default:
+		printRemaps(rules)
+		return
+	}
+
+	if err := saveRemaps(filePath, rules); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", remapPath(filePath), err)
+		os.Exit(1)
+	}
+	switch {
+	case fs.NArg() == 2:
+		recordAudit(filePath, auditRemapAdd, "%s to %s", fs.Arg(0), fs.Arg(1))
+	case remove != "":
+		recordAudit(filePath, auditRemapDel, "%s", remove)
+	default:
+		for _, rule := range rules[len(rules)-added:] {
+			recordAudit(filePath, auditRemapAdd, "%s to %s (fsid %s, from --scan)", rule.From, rule.To, rule.FSID)
+		}
+	}
+	printRemaps(rules)
+}
+
+// printRemaps lists the remap rules in the order they were added
+func printRemaps(rules []remapRule) {
+	if len(rules) == 0 {
+		fmt.Println("No remap rules")
+		return
+	}
+	fromWidth, toWidth := len("From"), len("To")
+	for _, rule := range rules {
+		fromWidth = max(fromWidth, len(rule.From))
+		toWidth = max(toWidth, len(rule.To))
+	}
+	fmt.Printf("%-*s  %-*s  %s\n", fromWidth, "From", toWidth, "To", "Added")
+	for _, rule := range rules {
+		added := formatDateTime(time.Unix(rule.Time, 0))
+		if rule.Auto {
+			added += " (fsid " + rule.FSID + ")"
+		}
+		fmt.Printf("%-*s  %-*s  %s\n", fromWidth, rule.From, toWidth, rule.To, added)
+	}
+}
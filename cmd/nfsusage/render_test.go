@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/report"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/render")
+
+// captureStdout returns what fn prints to stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- data
+	}()
+	fn()
+	w.Close()
+	os.Stdout = stdout
+	return string(<-done)
+}
+
+// fixtureRates computes the comparison growth rates from a store holding
+// just the fixture's two entries
+func fixtureRates(t *testing.T, base, current UsageEntry) map[string]report.Rate {
+	t.Helper()
+	st := store.Open(filepath.Join(t.TempDir(), "history.jsonl"), nil, false)
+	if err := st.Rewrite([]UsageEntry{base, current}); err != nil {
+		t.Fatal(err)
+	}
+	rates, err := growthSince(st, base.Timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rates
+}
+
+// TestRenderGolden renders every fixture in testdata/render in every output
+// format and compares with the golden files. Run with -update after an
+// intended formatting change and review the diff.
+func TestRenderGolden(t *testing.T) {
+	time.Local = time.UTC
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "render", "*.json"))
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("no fixtures found: %v", err)
+	}
+	formats := []struct {
+		name, output                           string
+		wide, inodes, growth, capacity, server bool
+	}{
+		{"table", outputTable, false, false, false, false, false},
+		{"wide", outputTable, true, false, false, false, false},
+		{"inodes", outputTable, false, true, false, false, false},
+		{"growth", outputTable, false, false, true, false, false},
+		{"capacity", outputTable, false, false, false, true, false},
+		{"server", outputTable, false, false, false, false, true},
+		{"json", outputJSON, false, false, false, false, false},
+		{"csv", outputCSV, false, false, false, false, false},
+		{"motd", outputMOTD, false, false, false, false, false},
+	}
+
+	for _, path := range fixtures {
+		fixture, err := loadRenderFixture(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range formats {
+			name := strings.TrimSuffix(filepath.Base(path), ".json") + "." + f.name
+			t.Run(name, func(t *testing.T) {
+				wideOutput, inodeOutput, capacityOutput, serverOutput = f.wide, f.inodes, f.capacity, f.server
+				if f.growth && fixture.Base != nil {
+					compareRates = fixtureRates(t, *fixture.Base, fixture.Current)
+				}
+				defer func() {
+					wideOutput, inodeOutput, capacityOutput, serverOutput, compareRates = false, false, false, false, nil
+				}()
+				got := captureStdout(t, func() {
+					if err := renderOutput(f.output, fixture.Current, fixture.Base, fixture.Label, 72, 5); err != nil {
+						t.Error(err)
+					}
+				})
+
+				golden := filepath.Join("testdata", "render", name+".golden")
+				if *update {
+					if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("%v (run go test -update to create it)", err)
+				}
+				if !bytes.Equal([]byte(got), want) {
+					t.Errorf("output differs from %s (run go test -update if intended)\ngot:\n%s\nwant:\n%s", golden, got, want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,674 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/report"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// Modes for --compare
+const (
+	compareOldest    = "oldest"
+	compareLastMonth = "lastmonth"
+	comparePrevious  = "previous"
+	// compareCapacity compares usage with capacity, which works without history
+	compareCapacity = "capacity"
+)
+
+// lastMonthWindow is how far (in seconds) from the same day last month an
+// entry may be and still be used by --compare lastmonth
+const lastMonthWindow = 3 * 86400
+
+// compareMode is the --compare flag. It works as a plain switch (compare with
+// the oldest entry) and also accepts a mode: --compare=lastmonth, previous or
+// capacity.
+type compareMode string
+
+func (c *compareMode) String() string { return string(*c) }
+
+func (c *compareMode) Set(value string) error {
+	switch value {
+	case "true", compareOldest:
+		*c = compareOldest
+	case "false":
+		*c = ""
+	case compareLastMonth, comparePrevious, compareCapacity:
+		*c = compareMode(value)
+	default:
+		return fmt.Errorf("invalid compare mode %q (want %s, %s, %s or %s)", value, compareOldest, compareLastMonth, comparePrevious, compareCapacity)
+	}
+	return nil
+}
+
+func (c *compareMode) IsBoolFlag() bool { return true }
+
+// isCompareMode reports whether a positional argument is a mode given as
+// "--compare lastmonth", which the flag package sees as a bare switch
+func isCompareMode(arg string) bool {
+	return arg == compareOldest || arg == compareLastMonth || arg == comparePrevious || arg == compareCapacity
+}
+
+// sameDayLastMonth returns t one calendar month earlier, clamped to the end of
+// shorter months (Mar 31 -> Feb 28)
+func sameDayLastMonth(t time.Time) time.Time {
+	firstOfPrev := time.Date(t.Year(), t.Month()-1, 1, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+	lastDay := firstOfPrev.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return firstOfPrev.AddDate(0, 0, day-1)
+}
+
+// compareBase returns the entry --compare diffs against, with snapshot mounts
+// filtered, and the column label naming it. It is nil when lastmonth has no
+// entry close enough.
+func compareBase(st historyStore, current UsageEntry, mode compareMode) (*UsageEntry, string, error) {
+	if mode == compareLastMonth {
+		target := sameDayLastMonth(time.Unix(current.Timestamp, 0))
+		base, err := nearestEntry(st, target.Unix(), lastMonthWindow)
+		if err != nil {
+			return nil, "", &StoreError{"loading last month's entry", err}
+		}
+		if base == nil {
+			fmt.Fprintf(os.Stderr, "Warning: no entry within %d days of %s\n", lastMonthWindow/86400, formatDate(target))
+			return nil, "", nil
+		}
+		filtered := collector.WithoutSnapshots(*base)
+		return &filtered, formatDate(time.Unix(base.Timestamp, 0)), nil
+	}
+
+	oldest, err := store.First(st)
+	if err != nil {
+		return nil, "", &StoreError{"loading oldest entry", err}
+	}
+	// Filter oldest entry to exclude any .snapshot mounts that may exist in the JSON
+	filtered := collector.WithoutSnapshots(*oldest)
+	return &filtered, "Oldest", nil
+}
+
+// sinceLayouts are the absolute times --since accepts, in local time
+var sinceLayouts = []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04", time.RFC3339}
+
+// parseSince resolves --since relative to now: a duration such as 24h, 7d or
+// 2w back, or a date or time
+func parseSince(spec string, now time.Time) (time.Time, error) {
+	if d, err := parseAge(spec); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("--since %q must be a positive duration", spec)
+		}
+		return now.Add(-d), nil
+	}
+	for _, layout := range sinceLayouts {
+		if t, err := time.ParseInLocation(layout, spec, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q (want a duration such as 24h, 7d or 2w, or a date such as 2024-01-01)", spec)
+}
+
+// sinceBase returns the stored entry closest to the --since point, with
+// snapshot mounts filtered, and the column label naming it. Entries at or
+// after the current one are never picked.
+func sinceBase(st historyStore, current UsageEntry, since string) (*UsageEntry, string, error) {
+	target, err := parseSince(since, time.Unix(current.Timestamp, 0))
+	if err != nil {
+		return nil, "", err
+	}
+	var best *UsageEntry
+	err = st.Scan(func(entry UsageEntry) error {
+		if entry.Timestamp >= current.Timestamp {
+			return store.ErrStopScan
+		}
+		if best != nil && absInt64(entry.Timestamp-target.Unix()) > absInt64(best.Timestamp-target.Unix()) {
+			if entry.Timestamp > target.Unix() {
+				// Past the target and moving away, nothing closer follows
+				return store.ErrStopScan
+			}
+			return nil
+		}
+		e := entry
+		best = &e
+		return nil
+	})
+	if err != nil {
+		return nil, "", &StoreError{"loading the entry to compare with", err}
+	}
+	if best == nil {
+		fmt.Fprintf(os.Stderr, "Warning: no entry before the current one to compare with\n")
+		return nil, "", nil
+	}
+	if best.Timestamp > target.Unix() {
+		fmt.Fprintf(os.Stderr, "Warning: history starts after %s, comparing with the oldest entry\n", formatDateTime(target))
+	}
+	filtered := collector.WithoutSnapshots(*best)
+	return &filtered, formatDateTime(time.Unix(best.Timestamp, 0)), nil
+}
+
+// nearestEntry returns the stored entry closest to target within window
+// seconds either side, or nil when there is none
+func nearestEntry(st historyStore, target, window int64) (*UsageEntry, error) {
+	var best *UsageEntry
+	err := store.ScanRange(st, target-window, target+window, func(entry UsageEntry) error {
+		if best == nil || absInt64(entry.Timestamp-target) < absInt64(best.Timestamp-target) {
+			e := entry
+			best = &e
+		}
+		return nil
+	})
+	return best, err
+}
+
+func absInt64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// monthlyUsage is the last entry recorded in a calendar month
+type monthlyUsage struct {
+	month string
+	entry UsageEntry
+}
+
+// collectMonthly keeps the newest entry of every calendar month, so memory
+// grows with the number of months rather than entries
+func collectMonthly(st historyStore) ([]monthlyUsage, error) {
+	var months []monthlyUsage
+	err := st.Scan(func(entry UsageEntry) error {
+		month := time.Unix(entry.Timestamp, 0).Format("2006-01")
+		if n := len(months); n > 0 && months[n-1].month == month {
+			months[n-1].entry = entry
+		} else {
+			months = append(months, monthlyUsage{month, entry})
+		}
+		return nil
+	})
+	return months, err
+}
+
+// printMonthlyReport prints month-over-month growth per mount, using each
+// month's last entry as its closing usage
+func printMonthlyReport(months []monthlyUsage) {
+	mountSet := make(map[string]bool)
+	for _, m := range months {
+		for mount := range m.entry.Mounts {
+			mountSet[mount] = true
+		}
+	}
+	mounts := make([]string, 0, len(mountSet))
+	for mount := range mountSet {
+		mounts = append(mounts, mount)
+	}
+	sort.Strings(mounts)
+	mounts = append(mounts, "total")
+
+	usage := func(e UsageEntry, mount string) (int64, bool) {
+		if mount == "total" {
+			return e.Total, true
+		}
+		bytes, ok := e.Mounts[mount]
+		return bytes, ok
+	}
+
+	type row struct {
+		mount, month, used, change, growth string
+	}
+	var rows []row
+	for _, mount := range mounts {
+		var prev int64
+		havePrev := false
+		for _, m := range months {
+			used, ok := usage(m.entry, mount)
+			if !ok {
+				havePrev = false
+				continue
+			}
+			r := row{mount, m.month, formatBytes(used), "-", "-"}
+			if havePrev {
+				r.change = formatDiff(used - prev)
+				if prev > 0 {
+					r.growth = formatPercent("%+.1f", float64(used-prev)/float64(prev)*100)
+				}
+			}
+			rows = append(rows, r)
+			prev, havePrev = used, true
+		}
+	}
+
+	headers := row{"Mountpoint", "Month", "Used", "Change", "Growth"}
+	width := make([]int, 5)
+	for _, r := range append([]row{headers}, rows...) {
+		for i, v := range []string{r.mount, r.month, r.used, r.change, r.growth} {
+			if len(v) > width[i] {
+				width[i] = len(v)
+			}
+		}
+	}
+	printRow := func(r row) {
+		fmt.Printf("%-*s  %-*s  %*s  %*s  %*s\n", width[0], r.mount, width[1], r.month, width[2], r.used, width[3], r.change, width[4], r.growth)
+	}
+	printRow(headers)
+	printRow(row{strings.Repeat("-", width[0]), strings.Repeat("-", width[1]), strings.Repeat("-", width[2]), strings.Repeat("-", width[3]), strings.Repeat("-", width[4])})
+	last := ""
+	for _, r := range rows {
+		if r.mount == last {
+			r.mount = ""
+		} else {
+			last = r.mount
+		}
+		printRow(r)
+	}
+}
+
+// runReport implements the report subcommand, which summarizes the stored
+// history without collecting
+func runReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	var filePath, configPath, keyFile, redactMode, numberFormatSpec, locale, filterSpec, window, treemapPath, gaps, smooth, smoothMethod, preset string
+	var monthly, composition, coverage, rates, backup, snapshots, trash, drilldown, treemapDirs bool
+	var months, drilldownTop int
+	fs.StringVar(&filePath, "file", "", "Path to JSON file for storing usage data (default: config file, else CWD/nfsusage.json)")
+	fs.StringVar(&filePath, "f", "", "Path to JSON file for storing usage data (shorthand)")
+	fs.StringVar(&configPath, "config", "", "Path to YAML config file")
+	fs.StringVar(&keyFile, "key-file", "", "Path to AES-256 key for the data file")
+	fs.StringVar(&redactMode, "redact-paths", "", "Redact mount and export paths in output: hash or alias")
+	fs.StringVar(&numberFormatSpec, "format-numbers", "", "Number formatting: comma separated thousands, decimals=N, width=N, unit=GiB|TiB")
+	fs.StringVar(&filterSpec, "filter", "", `Only report mounts matching an expression, e.g. 'server=="filer01" && pct_used>80'`)
+	fs.StringVar(&locale, "locale", "", "Decimal separator and date order of a locale such as de_DE, or auto for LC_NUMERIC and LC_TIME")
+	fs.BoolVar(&monthly, "monthly", false, "Month-over-month growth per mount")
+	fs.IntVar(&months, "months", 12, "Number of most recent months in --monthly (0 for all)")
+	fs.BoolVar(&composition, "composition", false, "Each mount's share of total usage and how it changed over --window")
+	fs.BoolVar(&coverage, "coverage", false, "Samples, gaps and time coverage per mount over --window")
+	fs.BoolVar(&rates, "rates", false, "Growth per day for each mount over --window")
+	fs.BoolVar(&backup, "backup", false, "Growth inside vs outside the backup_windows from the config over --window")
+	fs.BoolVar(&snapshots, "snapshots", false, "Reconcile snapshot space against the snapshot schedules from the config over --window")
+	fs.BoolVar(&trash, "trash", false, "Trash and quarantine directories vs live data per mount over --window (needs measure_trash)")
+	fs.BoolVar(&drilldown, "drilldown", false, "Which top-level directories account for each mount's change over --window (needs dir_mounts)")
+	fs.IntVar(&drilldownTop, "drilldown-top", 10, "Directories listed per mount in --drilldown (0 for all)")
+	fs.StringVar(&gaps, "gaps", report.GapsFlag, "How --rates treats gaps in collection: skip, interpolate or flag")
+	fs.StringVar(&smooth, "smooth", "", "Smooth usage over this window before computing --rates, e.g. 24h, so short-lived spikes don't skew growth and fill dates")
+	fs.StringVar(&smoothMethod, "smooth-method", report.SmoothEMA, "How --smooth averages: ema (exponential moving average) or rolling (mean of the window)")
+	fs.StringVar(&window, "window", "30d", "Window for --composition, --coverage, --rates, --backup, --snapshots, --trash and --drilldown, e.g. 90d or 2w (0 for the whole history)")
+	fs.StringVar(&treemapPath, "treemap", "", "Write an HTML treemap of the newest entry (server, export, mount) to this file")
+	fs.BoolVar(&treemapDirs, "treemap-dirs", false, "Split mounts in --treemap by top-level directory, from dir_mounts data or du (slow on large filesystems)")
+	fs.StringVar(&preset, "preset", "", "Report bundle for an audience: sre, capacity, chargeback, backup or one from report_presets in the config")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	if preset != "" {
+		p, err := lookupPreset(preset, cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --preset: %v\n", err)
+			os.Exit(1)
+		}
+		// Flags given on the command line win over the preset
+		set := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		for _, section := range p.Reports {
+			switch section {
+			case "monthly":
+				monthly = true
+			case "composition":
+				composition = true
+			case "coverage":
+				coverage = true
+			case "rates":
+				rates = true
+			case "backup":
+				backup = true
+			case "snapshots":
+				snapshots = true
+			case "trash":
+				trash = true
+			case "drilldown":
+				drilldown = true
+			}
+		}
+		if p.Window != "" && !set["window"] {
+			window = p.Window
+		}
+		if p.Months != 0 && !set["months"] {
+			months = p.Months
+		}
+		if p.Gaps != "" && !set["gaps"] {
+			gaps = p.Gaps
+		}
+		if p.Smooth != "" && !set["smooth"] {
+			smooth = p.Smooth
+		}
+		if p.FormatNumbers != "" && !set["format-numbers"] {
+			numberFormatSpec = p.FormatNumbers
+		}
+		if p.Locale != "" && !set["locale"] {
+			locale = p.Locale
+		}
+	}
+
+	// Without a report the newest entry is shown, nothing is collected
+	current := !monthly && !composition && !coverage && !rates && !backup && !snapshots && !trash && !drilldown && treemapPath == ""
+	for _, w := range cfg.BackupWindows {
+		if err := w.validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: backup window %q: %v\n", w.Pattern, err)
+			os.Exit(1)
+		}
+	}
+	if !report.ValidGapPolicy(gaps) {
+		fmt.Fprintf(os.Stderr, "Error: invalid --gaps %q (want %s, %s or %s)\n", gaps, report.GapsSkip, report.GapsInterpolate, report.GapsFlag)
+		os.Exit(1)
+	}
+	smoothing := report.Smoothing{Method: smoothMethod}
+	if smoothMethod != report.SmoothEMA && smoothMethod != report.SmoothRolling {
+		fmt.Fprintf(os.Stderr, "Error: invalid --smooth-method %q (want %s or %s)\n", smoothMethod, report.SmoothEMA, report.SmoothRolling)
+		os.Exit(1)
+	}
+	if smooth != "" && smooth != "0" {
+		age, err := parseAge(smooth)
+		if err != nil || age < time.Second {
+			fmt.Fprintf(os.Stderr, "Error: invalid --smooth %q (want a window such as 24h or 7d)\n", smooth)
+			os.Exit(1)
+		}
+		smoothing.Window = int64(age / time.Second)
+	}
+	var from int64
+	if window != "0" && window != "" {
+		age, err := parseAge(window)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --window: %v\n", err)
+			os.Exit(1)
+		}
+		from = timeSource.Now().Add(-age).Unix()
+	}
+
+	key, err := loadKey(keyFile, cfg.Encryption)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading encryption key: %v\n", err)
+		os.Exit(1)
+	}
+	redact, err := newRedactor(redactMode, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if numFmt, err = parseNumberFormat(numberFormatSpec); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --format-numbers: %v\n", err)
+		os.Exit(1)
+	}
+	if locale == "" {
+		locale = cfg.Locale
+	}
+	if err := applyLocale(locale); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --locale: %v\n", err)
+		os.Exit(1)
+	}
+	filter, err := report.ParseFilter(filterSpec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --filter: %v\n", err)
+		os.Exit(1)
+	}
+	filePath = cfg.dataFile(filePath)
+
+	st, err := openSeriesStore(filePath, key, cfg.Compact)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading remap rules: %v\n", err)
+		os.Exit(1)
+	}
+	st = report.WithFilter(st, filter)
+	if current {
+		newest, _, err := lastEntry(st)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+			os.Exit(1)
+		}
+		if newest == nil {
+			fmt.Fprintf(os.Stderr, "Error: no entries recorded in %s\n", filePath)
+			os.Exit(1)
+		}
+		entry := collector.WithoutSnapshots(*newest)
+		entry.Unmeasured, entry.Unavailable = newest.Unmeasured, newest.Unavailable
+		entry = redact.entry(entry)
+		printCurrent(entry)
+		printDegraded(entry)
+		return
+	}
+	if monthly {
+		history, err := collectMonthly(st)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+			os.Exit(1)
+		}
+		if months > 0 && len(history) > months {
+			history = history[len(history)-months:]
+		}
+		for i := range history {
+			history[i].entry = redact.entry(collector.WithoutSnapshots(history[i].entry))
+		}
+		printMonthlyReport(history)
+	}
+
+	if composition {
+		if monthly {
+			fmt.Println()
+		}
+		rows, first, last, err := collectComposition(st, from)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+			os.Exit(1)
+		}
+		if last == nil {
+			fmt.Fprintf(os.Stderr, "No entries within the last %s\n", window)
+			os.Exit(1)
+		}
+		for i := range rows {
+			rows[i].mount = redact.path(rows[i].mount)
+		}
+		printCompositionReport(rows, first, last)
+	}
+
+	if coverage {
+		if monthly || composition {
+			fmt.Println()
+		}
+		stats, err := collectCoverage(st, from)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+			os.Exit(1)
+		}
+		for i := range stats {
+			stats[i].mount = redact.path(stats[i].mount)
+		}
+		printCoverageReport(stats)
+	}
+
+	if rates {
+		if monthly || composition || coverage {
+			fmt.Println()
+		}
+		result, err := report.Rates(st, from, gaps, smoothing)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+			os.Exit(1)
+		}
+		for i := range result {
+			result[i].Mount = redact.path(result[i].Mount)
+		}
+		printRatesReport(result)
+	}
+
+	if backup {
+		if monthly || composition || coverage || rates {
+			fmt.Println()
+		}
+		splits, err := collectBackupSplit(st, from, cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+			os.Exit(1)
+		}
+		for i := range splits {
+			splits[i].mount = redact.path(splits[i].mount)
+		}
+		printBackupReport(splits)
+	}
+
+	if snapshots {
+		if monthly || composition || coverage || rates || backup {
+			fmt.Println()
+		}
+		recs, err := collectSnapshotReconciliation(st, from, cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		for i := range recs {
+			recs[i].mount = redact.path(recs[i].mount)
+		}
+		printSnapshotReport(recs)
+	}
+
+	if trash {
+		if monthly || composition || coverage || rates || backup || snapshots {
+			fmt.Println()
+		}
+		rows, err := collectTrash(st, from)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+			os.Exit(1)
+		}
+		for i := range rows {
+			rows[i].mount = redact.path(rows[i].mount)
+		}
+		printTrashReport(rows)
+	}
+
+	if drilldown {
+		if monthly || composition || coverage || rates || backup || snapshots || trash {
+			fmt.Println()
+		}
+		mounts, first, last, err := collectDrilldown(st, from)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+			os.Exit(1)
+		}
+		if last == nil {
+			fmt.Fprintf(os.Stderr, "No entries within the last %s\n", window)
+			os.Exit(1)
+		}
+		for i := range mounts {
+			mounts[i].mount = redact.path(mounts[i].mount)
+			for j := range mounts[i].dirs {
+				mounts[i].dirs[j].name = redact.path(mounts[i].dirs[j].name)
+			}
+		}
+		printDrilldown(mounts, first, last, drilldownTop)
+	}
+
+	if treemapPath != "" {
+		if treemapDirs && redact != nil {
+			fmt.Fprintln(os.Stderr, "Warning: --treemap-dirs is ignored with --redact-paths")
+		}
+		newest, _, err := lastEntry(st)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading data: %v\n", err)
+			os.Exit(1)
+		}
+		if newest == nil {
+			fmt.Fprintln(os.Stderr, "No entries recorded yet")
+			os.Exit(1)
+		}
+		root := buildUsageTree(collector.WithoutSnapshots(*newest), redact, treemapDirs)
+		if err := writeTreemap(treemapPath, root, newest.Timestamp); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing treemap: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// compositionRow is one mount's share of total usage at the start and end of a window
+type compositionRow struct {
+	mount      string
+	used       int64
+	startShare float64
+	endShare   float64
+	removed    bool
+}
+
+// collectComposition returns each mount's share of total usage in the newest
+// entry and in the first entry at or after from (0 for the whole history)
+func collectComposition(st historyStore, from int64) ([]compositionRow, *UsageEntry, *UsageEntry, error) {
+	var first, last *UsageEntry
+	err := store.ScanRange(st, from, 0, func(entry UsageEntry) error {
+		e := collector.WithoutSnapshots(entry)
+		if first == nil {
+			first = &e
+		}
+		last = &e
+		return nil
+	})
+	if err != nil || last == nil {
+		return nil, first, last, err
+	}
+
+	share := func(e *UsageEntry, mount string) float64 {
+		if e.Total <= 0 {
+			return 0
+		}
+		return float64(e.Mounts[mount]) / float64(e.Total) * 100
+	}
+	var rows []compositionRow
+	for mount, used := range last.Mounts {
+		rows = append(rows, compositionRow{mount, used, share(first, mount), share(last, mount), false})
+	}
+	for mount := range first.Mounts {
+		if _, ok := last.Mounts[mount]; !ok {
+			rows = append(rows, compositionRow{mount, 0, share(first, mount), 0, true})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].endShare != rows[j].endShare {
+			return rows[i].endShare > rows[j].endShare
+		}
+		return rows[i].mount < rows[j].mount
+	})
+	return rows, first, last, nil
+}
+
+// printCompositionReport prints each mount's share of total usage and how it
+// moved over the window, largest share first
+func printCompositionReport(rows []compositionRow, first, last *UsageEntry) {
+	start := formatDate(time.Unix(first.Timestamp, 0))
+	end := formatDate(time.Unix(last.Timestamp, 0))
+	fmt.Printf("Share of total NFS usage, %s to %s\n\n", start, end)
+
+	mountWidth := len("Mountpoint")
+	usedWidth := len("Used")
+	for _, r := range rows {
+		if len(r.mount) > mountWidth {
+			mountWidth = len(r.mount)
+		}
+		if n := len(formatBytes(r.used)); n > usedWidth {
+			usedWidth = n
+		}
+	}
+	fmt.Printf("%-*s  %*s  %7s  %7s  %8s\n", mountWidth, "Mountpoint", usedWidth, "Used", "Share", "Start", "Change")
+	fmt.Printf("%-*s  %*s  %7s  %7s  %8s\n", mountWidth, strings.Repeat("-", mountWidth), usedWidth, strings.Repeat("-", usedWidth), "-------", "-------", "--------")
+	for _, r := range rows {
+		used := formatBytes(r.used)
+		if r.removed {
+			used = "(removed)"
+		}
+		fmt.Printf("%-*s  %*s  %6.1f%%  %6.1f%%  %+6.1fpp\n", mountWidth, r.mount, usedWidth, used, r.endShare, r.startShare, r.endShare-r.startShare)
+	}
+	fmt.Printf("%-*s  %*s  %6.1f%%\n", mountWidth, "total", usedWidth, formatBytes(last.Total), 100.0)
+}
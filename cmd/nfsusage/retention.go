@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// eventHistoryPruned is recorded each time retention drops entries
+const eventHistoryPruned = "history_pruned"
+
+// retention is set from --retain, --max-entries, --downsample and the config
+var retention store.RetentionPolicy
+
+// pruneStore applies the retention policy after a write when it is due
+func pruneStore(st historyStore, key []byte, count int) error {
+	if !retention.Enabled() {
+		return nil
+	}
+	first, err := store.First(st)
+	if err != nil {
+		return err
+	}
+	if !retention.Due(st.File(), first, count, timeSource.Now()) {
+		return nil
+	}
+	_, _, err = applyRetention(st, key)
+	return err
+}
+
+// applyRetention prunes the history now, whether or not it is due, taking a
+// backup first and recording what was dropped
+func applyRetention(st historyStore, key []byte) (int, int, error) {
+	backup := func() error { return backups.backup(st.File(), true) }
+	pruned, total, err := store.ApplyRetention(st, retention, key, timeSource.Now(), backup)
+	if err == nil && pruned > 0 {
+		recordEvent(st.File(), eventHistoryPruned, "pruned %d of %d entries", pruned, total)
+		recordAudit(st.File(), auditPrune, "pruned %d of %d entries", pruned, total)
+	}
+	return pruned, total, err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const rrdDefaultStep = 5 * time.Minute
+
+// rrdSink creates and updates one .rrd file per mount through the rrdtool CLI,
+// so existing Cacti/RRD graphs keep working
+type rrdSink struct {
+	dir  string
+	step time.Duration
+}
+
+func (s rrdSink) send(entry UsageEntry) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	for mount, used := range entry.Mounts {
+		path := filepath.Join(s.dir, mountFileName(mount, ".rrd"))
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if err := s.create(path, entry.Timestamp); err != nil {
+				return err
+			}
+		}
+
+		// U is rrdtool's unknown value, used for elastic filesystems
+		free := "U"
+		if avail := entry.Details[mount].Available; avail != nil {
+			free = fmt.Sprintf("%d", *avail)
+		}
+		update := fmt.Sprintf("%d:%d:%s", entry.Timestamp, used, free)
+		if err := runRRDTool("update", path, update); err != nil {
+			// A replayed entry that is not newer than the last update can never succeed
+			if strings.Contains(err.Error(), "illegal attempt to update") {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// create makes a new rrd with used/free gauges and the usual Cacti
+// daily, weekly, monthly and yearly archives
+func (s rrdSink) create(path string, start int64) error {
+	step := int64(s.step.Seconds())
+	args := []string{path,
+		"--start", fmt.Sprintf("%d", start-1),
+		"--step", fmt.Sprintf("%d", step),
+		fmt.Sprintf("DS:used:GAUGE:%d:0:U", 2*step),
+		fmt.Sprintf("DS:free:GAUGE:%d:0:U", 2*step),
+	}
+	for _, cf := range []string{"AVERAGE", "MAX"} {
+		args = append(args,
+			"RRA:"+cf+":0.5:1:600",
+			"RRA:"+cf+":0.5:6:700",
+			"RRA:"+cf+":0.5:24:775",
+			"RRA:"+cf+":0.5:288:797")
+	}
+	return runRRDTool("create", args...)
+}
+
+// runRRDTool runs an rrdtool command and includes its error output on failure
+func runRRDTool(command string, args ...string) error {
+	output, err := exec.Command("rrdtool", append([]string{command}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("rrdtool %s: %v: %s", command, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
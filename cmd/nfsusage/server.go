@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Server identity modes for normalizing the server part of NFS device strings
+const (
+	identityMounted = "mounted"
+	identityIP      = "ip"
+	identityRDNS    = "rdns"
+)
+
+// validIdentityMode reports whether mode is a known server identity mode
+func validIdentityMode(mode string) bool {
+	return mode == identityMounted || mode == identityIP || mode == identityRDNS
+}
+
+// serverResolver normalizes server hosts to a canonical identity, caching lookups
+// so the same filer mounted many times only costs one resolution per run. It is
+// safe for concurrent use by the collection workers.
+type serverResolver struct {
+	mode  string
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+func newServerResolver(mode string) *serverResolver {
+	return &serverResolver{mode: mode, cache: make(map[string]string)}
+}
+
+// identity returns the canonical server identity for a mount: the lowest
+// address host resolves to, so mounts of a filer behind several A records
+// agree whichever address each kernel connected to. serverAddr, the address
+// the kernel is actually talking to, is only used when resolution fails.
+func (r *serverResolver) identity(host, serverAddr string) string {
+	host = strings.ToLower(host)
+	if r.mode == identityMounted || host == "" {
+		return host
+	}
+
+	key := host + "|" + serverAddr
+	r.mu.Lock()
+	id, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok {
+		return id
+	}
+
+	id = lookupFirstIP(host)
+	if id == "" {
+		id = serverAddr
+	}
+	if r.mode == identityRDNS && id != "" {
+		if names, err := net.LookupAddr(id); err == nil && len(names) > 0 {
+			id = strings.ToLower(strings.TrimSuffix(names[0], "."))
+		}
+	}
+	if id == "" {
+		id = host
+	}
+
+	r.mu.Lock()
+	r.cache[key] = id
+	r.mu.Unlock()
+	return id
+}
+
+// lookupFirstIP resolves host and returns the lowest address, so multiple A records
+// give a stable identity across runs
+func lookupFirstIP(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return ""
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = ip.String()
+	}
+	sort.Strings(addrs)
+	return addrs[0]
+}
+
+// errInvalidIdentity is returned for unknown --server-identity values
+func errInvalidIdentity(mode string) error {
+	return fmt.Errorf("invalid server identity %q (want %s, %s or %s)", mode, identityMounted, identityIP, identityRDNS)
+}
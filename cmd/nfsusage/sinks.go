@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/report"
+)
+
+// SinkConfig configures one output sink
+type SinkConfig struct {
+	// Type is textfile, influx, webhook, csv, rrd or whisper
+	Type string `yaml:"type"`
+	// Path is the .prom file written by the textfile sink, or the directory for
+	// csv, rrd and whisper (the Graphite storage/whisper root)
+	Path string `yaml:"path"`
+	// URL is the endpoint for influx (full /api/v2/write URL) and webhook sinks
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+	// Retries is how many extra attempts are made per delivery (default 2)
+	Retries *int `yaml:"retries"`
+	// Redact overrides --redact-paths for this sink: hash, alias or none.
+	// Empty uses the global mode.
+	Redact string `yaml:"redact"`
+	// MaxQueue bounds how many undelivered entries are kept for retry (default
+	// 100). Without queue_dir they are kept in memory, by the daemon across
+	// config reloads but not past the end of a one-shot run.
+	MaxQueue int `yaml:"max_queue"`
+	// MaxQueueAge drops undelivered entries older than this (e.g. 7d), empty keeps them
+	MaxQueueAge string `yaml:"max_queue_age"`
+	// MaxRows is how many rows each per-mount csv file keeps (default 1000)
+	MaxRows int `yaml:"max_rows"`
+	// Step is the rrd sink's expected collection interval (default 5m)
+	Step string `yaml:"step"`
+	// Schemas is a Graphite storage-schemas.conf used to size new whisper files
+	Schemas string `yaml:"schemas"`
+	// Retentions applies to whisper metrics no schema matches (default 5m:90d,1h:5y)
+	Retentions string `yaml:"retentions"`
+}
+
+// sink delivers entries to one destination
+type sink interface {
+	send(entry UsageEntry) error
+}
+
+// sinkRunner wraps a sink with retries, redaction and a queue of undelivered
+// entries, so a failing sink never affects the others
+type sinkRunner struct {
+	name string
+	// id identifies the destination across config reloads
+	id       string
+	sink     sink
+	retries  int
+	redact   *redactor
+	maxQueue int
+	maxAge   time.Duration
+	queue    []UsageEntry
+	// queuePath persists undelivered entries for push sinks across runs, empty keeps them in memory only
+	queuePath string
+}
+
+var sinkHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// localSink reports whether a sink type writes to local disk and so never needs an on-disk queue
+func localSink(kind string) bool {
+	return kind == "textfile" || kind == "csv" || kind == "rrd" || kind == "whisper"
+}
+
+// sinkRedactNone turns off redaction for a sink when --redact-paths is set
+const sinkRedactNone = "none"
+
+// newSinkRunners builds runners for every configured sink, redacting with
+// redactMode, the --redact-paths mode, unless a sink sets its own
+func newSinkRunners(cfg *Config, redactMode string) ([]*sinkRunner, error) {
+	var runners []*sinkRunner
+	for i, sc := range cfg.Sinks {
+		var s sink
+		switch sc.Type {
+		case "textfile":
+			if sc.Path == "" {
+				return nil, fmt.Errorf("sink %d: textfile requires path", i)
+			}
+			s = textfileSink{path: sc.Path}
+			// Only the newest metrics matter, never replay old ones
+			sc.MaxQueue = 1
+		case "influx":
+			if sc.URL == "" {
+				return nil, fmt.Errorf("sink %d: influx requires url", i)
+			}
+			s = influxSink{url: sc.URL, token: sc.Token}
+		case "webhook":
+			if sc.URL == "" {
+				return nil, fmt.Errorf("sink %d: webhook requires url", i)
+			}
+			s = webhookSink{url: sc.URL, token: sc.Token}
+		case "csv":
+			if sc.Path == "" {
+				return nil, fmt.Errorf("sink %d: csv requires path", i)
+			}
+			s = csvSink{dir: sc.Path, maxRows: sc.MaxRows}
+		case "rrd":
+			if sc.Path == "" {
+				return nil, fmt.Errorf("sink %d: rrd requires path", i)
+			}
+			rs := rrdSink{dir: sc.Path, step: rrdDefaultStep}
+			if sc.Step != "" {
+				step, err := time.ParseDuration(sc.Step)
+				if err != nil || step < time.Second {
+					return nil, fmt.Errorf("sink %d: invalid step %q", i, sc.Step)
+				}
+				rs.step = step
+			}
+			s = rs
+		case "whisper":
+			if sc.Path == "" {
+				return nil, fmt.Errorf("sink %d: whisper requires path", i)
+			}
+			ws, err := newWhisperSink(sc.Path, sc.Schemas, sc.Retentions)
+			if err != nil {
+				return nil, fmt.Errorf("sink %d: %v", i, err)
+			}
+			s = ws
+		default:
+			return nil, fmt.Errorf("sink %d: unknown type %q", i, sc.Type)
+		}
+
+		mode := redactMode
+		if sc.Redact == sinkRedactNone {
+			mode = ""
+		} else if sc.Redact != "" {
+			mode = sc.Redact
+		}
+		r, err := newRedactor(mode, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("sink %d: %v", i, err)
+		}
+		runner := &sinkRunner{name: fmt.Sprintf("%s[%d]", sc.Type, i), id: sc.Type + "|" + sc.URL + "|" + sc.Path, sink: s, retries: 2, redact: r, maxQueue: 100}
+		if sc.Retries != nil {
+			runner.retries = *sc.Retries
+		}
+		if sc.MaxQueue > 0 {
+			runner.maxQueue = sc.MaxQueue
+		}
+		if sc.MaxQueueAge != "" {
+			if runner.maxAge, err = parseAge(sc.MaxQueueAge); err != nil {
+				return nil, fmt.Errorf("sink %d: max_queue_age: %v", i, err)
+			}
+		}
+		if cfg.QueueDir != "" && !localSink(sc.Type) {
+			sum := sha256.Sum256([]byte(sc.Type + "|" + sc.URL))
+			runner.queuePath = filepath.Join(cfg.QueueDir, fmt.Sprintf("%s-%s.jsonl", sc.Type, hex.EncodeToString(sum[:])[:12]))
+		}
+		runners = append(runners, runner)
+	}
+	return runners, nil
+}
+
+// carryQueues moves the undelivered entries of the runners a config reload
+// replaces to the new runners for the same destination, so a reload doesn't
+// drop them. Runners with an on-disk queue find it again by themselves.
+func carryQueues(old, runners []*sinkRunner) {
+	for _, o := range old {
+		if len(o.queue) == 0 || o.queuePath != "" {
+			continue
+		}
+		for _, r := range runners {
+			if r.id != o.id {
+				continue
+			}
+			if r.queuePath == "" {
+				r.queue = append(o.queue, r.queue...)
+				break
+			}
+			// queue_dir was added by the reload, persist the in-memory queue
+			queued, err := loadQueue(r.queuePath)
+			if err == nil {
+				err = saveQueue(r.queuePath, append(queued, o.queue...))
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: sink %s: error writing queue %s: %v\n", r.name, r.queuePath, err)
+			}
+			break
+		}
+	}
+}
+
+// deliver queues entry and drains the queue, stopping at the first entry
+// that still fails after retries
+func (r *sinkRunner) deliver(entry UsageEntry) error {
+	if r.queuePath != "" {
+		queued, err := loadQueue(r.queuePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: sink %s: error reading queue %s: %v\n", r.name, r.queuePath, err)
+		}
+		r.queue = queued
+		defer func() {
+			if err := saveQueue(r.queuePath, r.queue); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: sink %s: error writing queue %s: %v\n", r.name, r.queuePath, err)
+			}
+		}()
+	}
+
+	r.queue = append(r.queue, r.redact.entry(entry))
+	if r.maxAge > 0 {
+		cutoff := entry.Timestamp - int64(r.maxAge.Seconds())
+		expired := 0
+		for expired < len(r.queue) && r.queue[expired].Timestamp < cutoff {
+			expired++
+		}
+		if expired > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: sink %s dropping %d queued entries older than %s\n", r.name, expired, r.maxAge)
+			r.queue = r.queue[expired:]
+		}
+	}
+	if dropped := len(r.queue) - r.maxQueue; dropped > 0 {
+		if r.maxQueue > 1 {
+			fmt.Fprintf(os.Stderr, "Warning: sink %s queue full, dropping %d oldest entries\n", r.name, dropped)
+		}
+		r.queue = r.queue[dropped:]
+	}
+
+	return r.drain()
+}
+
+// drain sends queued entries oldest first, stopping at the first entry that
+// still fails after retries. Entries the receiver rejects are dropped.
+func (r *sinkRunner) drain() error {
+	for len(r.queue) > 0 {
+		var err error
+		var rejected *rejectedError
+		for attempt := 0; attempt <= r.retries; attempt++ {
+			if attempt > 0 {
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+			if err = r.sink.send(r.queue[0]); err == nil || errors.As(err, &rejected) {
+				break
+			}
+		}
+		if rejected != nil {
+			fmt.Fprintf(os.Stderr, "Warning: sink %s dropping entry at %s: %v\n", r.name, formatDateTime(time.Unix(r.queue[0].Timestamp, 0)), err)
+		} else if err != nil {
+			return fmt.Errorf("sink %s: %v (%d entries queued)", r.name, err, len(r.queue))
+		}
+		r.queue = r.queue[1:]
+	}
+	return nil
+}
+
+// loadQueue reads queued entries from a JSON lines file, a missing file is an empty queue
+func loadQueue(path string) ([]UsageEntry, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var queue []UsageEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var entry UsageEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			// Skip a torn last line from a crash rather than losing the whole queue
+			continue
+		}
+		queue = append(queue, entry)
+	}
+	return queue, nil
+}
+
+// saveQueue atomically rewrites the queue file, removing it when the queue is empty
+func saveQueue(path string, queue []UsageEntry) error {
+	if len(queue) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, entry := range queue {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// flush retries the entries still queued, used by the daemon before exiting
+func (r *sinkRunner) flush() error {
+	if r.queuePath != "" {
+		queued, err := loadQueue(r.queuePath)
+		if err != nil {
+			return fmt.Errorf("sink %s: error reading queue %s: %v", r.name, r.queuePath, err)
+		}
+		r.queue = queued
+		defer func() {
+			if err := saveQueue(r.queuePath, r.queue); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: sink %s: error writing queue %s: %v\n", r.name, r.queuePath, err)
+			}
+		}()
+	}
+	err := r.drain()
+	if err != nil && r.queuePath == "" {
+		return fmt.Errorf("%v, lost on exit without queue_dir", err)
+	}
+	return err
+}
+
+// flushAll flushes every sink's queue, reporting failures
+func flushAll(runners []*sinkRunner) {
+	for _, r := range runners {
+		if err := r.flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+}
+
+// deliverAll sends entry to every sink independently, reporting failures
+func deliverAll(runners []*sinkRunner, entry UsageEntry) {
+	for _, r := range runners {
+		if err := r.deliver(entry); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+}
+
+// textfileSink writes metrics for the node_exporter textfile collector
+type textfileSink struct {
+	path string
+}
+
+func (s textfileSink) send(entry UsageEntry) error {
+	// Write to a temp file and rename so node_exporter never reads a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".nfsusage-*.prom")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(report.Metrics(entry)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// influxSink writes line protocol to an InfluxDB v2 write endpoint
+type influxSink struct {
+	url   string
+	token string
+}
+
+func (s influxSink) send(entry UsageEntry) error {
+	url := s.url
+	if !strings.Contains(url, "precision=") {
+		if strings.Contains(url, "?") {
+			url += "&precision=s"
+		} else {
+			url += "?precision=s"
+		}
+	}
+	return postBody(url, "text/plain; charset=utf-8", s.token, "Token", []byte(renderLineProtocol(entry)))
+}
+
+// renderLineProtocol renders an entry as InfluxDB line protocol with second precision
+func renderLineProtocol(entry UsageEntry) string {
+	mounts := make([]string, 0, len(entry.Mounts))
+	for mount := range entry.Mounts {
+		mounts = append(mounts, mount)
+	}
+	sort.Strings(mounts)
+
+	escape := strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+	var b strings.Builder
+	for _, mount := range mounts {
+		fmt.Fprintf(&b, "nfsusage,mount=%s", escape.Replace(mount))
+		if server := entry.Details[mount].Server; server != "" {
+			fmt.Fprintf(&b, ",server=%s", escape.Replace(server))
+		}
+		fmt.Fprintf(&b, " used_bytes=%di %d\n", entry.Mounts[mount], entry.Timestamp)
+	}
+	fmt.Fprintf(&b, "nfsusage_total used_bytes=%di %d\n", entry.Total, entry.Timestamp)
+	return b.String()
+}
+
+// webhookSink POSTs each entry as JSON
+type webhookSink struct {
+	url   string
+	token string
+}
+
+func (s webhookSink) send(entry UsageEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return postBody(s.url, "application/json", s.token, "Bearer", data)
+}
+
+// rejectedError is a delivery the receiver refused for good, such as an entry
+// older than the newest it stored; sending it again can't succeed
+type rejectedError struct {
+	err error
+}
+
+func (e *rejectedError) Error() string { return e.err.Error() }
+
+// postBody POSTs body and treats any non-2xx status as an error, a
+// rejectedError for statuses that retrying won't change
+func postBody(url, contentType, token, scheme string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if token != "" {
+		req.Header.Set("Authorization", scheme+" "+token)
+	}
+	resp, err := sinkHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusConflict ||
+		resp.StatusCode == http.StatusRequestEntityTooLarge || resp.StatusCode == http.StatusUnprocessableEntity:
+		return &rejectedError{fmt.Errorf("%s returned %s", url, resp.Status)}
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
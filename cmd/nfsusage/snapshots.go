@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/report"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// snapshotDir is where NFS filers expose snapshots under a mount
+const snapshotDir = ".snapshot"
+
+// SnapshotRule enables snapshot space measurement for matching mounts and
+// describes the snapshot policy expected to run on the filer
+type SnapshotRule struct {
+	Pattern string `yaml:"pattern"`
+	// Schedule is the cron expression of the filer's snapshot policy
+	Schedule string `yaml:"schedule"`
+	// MaxDeltaGiB flags snapshot periods that grow more than this, 0 compares
+	// against the mount's typical period instead
+	MaxDeltaGiB float64 `yaml:"max_delta_gib"`
+}
+
+// snapshotRule returns the first snapshot rule matching the mount, or nil
+func (c *Config) snapshotRule(mount nfsMount) *SnapshotRule {
+	for i, rule := range c.Snapshots {
+		if matchesMount(rule.Pattern, mount) {
+			return &c.Snapshots[i]
+		}
+	}
+	return nil
+}
+
+// snapshotKey is the entry key snapshot space of a mount is recorded under.
+// It contains .snapshot so totals and reports leave it out like snapshot mounts.
+func snapshotKey(mountPoint string) string {
+	return strings.TrimSuffix(mountPoint, "/") + "/" + snapshotDir
+}
+
+// snapshotSpace measures the snapshot space of mounts with a snapshot rule,
+// nil for other mounts or when it can't be measured
+func snapshotSpace(mount nfsMount, cfg *Config, deadline time.Time) *int64 {
+	if cfg.snapshotRule(mount) == nil {
+		return nil
+	}
+	usage, err := statfsBefore(snapshotKey(mount.MountPoint), deadline)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Error measuring snapshot space of %s: %v\n", mount.MountPoint, err)
+		return nil
+	}
+	return &usage.Used
+}
+
+// snapshotFlag marks a snapshot period that doesn't match the policy
+const (
+	snapshotMissing = "missing"
+	snapshotLarge   = "large"
+)
+
+// snapshotPeriod is the change in snapshot space between two scheduled snapshots
+type snapshotPeriod struct {
+	start, end int64
+	// snapDelta is the change in snapshot space, liveDelta in the live data
+	snapDelta, liveDelta int64
+	flag                 string
+}
+
+// snapshotReconciliation is one mount's snapshot periods over the window
+type snapshotReconciliation struct {
+	mount    string
+	schedule string
+	periods  []snapshotPeriod
+	// measured is false when no snapshot space was ever recorded
+	measured bool
+}
+
+// snapshotSample is the live and snapshot usage of a mount in one entry
+type snapshotSample struct {
+	ts         int64
+	live, snap int64
+}
+
+// collectSnapshotReconciliation splits each rule's mounts' history at the
+// scheduled snapshot times and flags periods where the snapshot space did not
+// move although live data changed (snapshot likely not taken) or grew far more
+// than allowed or usual
+func collectSnapshotReconciliation(st historyStore, from int64, cfg *Config) ([]snapshotReconciliation, error) {
+	series := make(map[string][]snapshotSample)
+	devices := make(map[string]string)
+	err := store.ScanRange(st, from, 0, func(entry UsageEntry) error {
+		for mount, live := range entry.Mounts {
+			if collector.IsSnapshotMount(mount) {
+				continue
+			}
+			devices[mount] = entry.Details[mount].Device
+			snap, ok := entry.Mounts[snapshotKey(mount)]
+			if !ok {
+				continue
+			}
+			series[mount] = append(series[mount], snapshotSample{entry.Timestamp, live, snap})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []snapshotReconciliation
+	for mount, device := range devices {
+		rule := cfg.snapshotRule(nfsMount{MountPoint: mount, Device: device})
+		if rule == nil {
+			continue
+		}
+		schedule, err := parseCron(rule.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot rule %q: %v", rule.Pattern, err)
+		}
+		r := snapshotReconciliation{mount: mount, schedule: rule.Schedule}
+		if samples := series[mount]; len(samples) > 1 {
+			r.measured = true
+			r.periods = snapshotPeriods(samples, schedule, rule.MaxDeltaGiB)
+		}
+		result = append(result, r)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].mount < result[j].mount })
+	return result, nil
+}
+
+// snapshotPeriods measures the samples between consecutive scheduled times
+// and flags the outliers. Periods not covered by samples on both ends are skipped.
+func snapshotPeriods(samples []snapshotSample, schedule *cronSchedule, maxDeltaGiB float64) []snapshotPeriod {
+	// at returns the newest sample at or before ts
+	at := func(ts int64) (snapshotSample, bool) {
+		i := sort.Search(len(samples), func(i int) bool { return samples[i].ts > ts })
+		if i == 0 {
+			return snapshotSample{}, false
+		}
+		return samples[i-1], true
+	}
+
+	var periods []snapshotPeriod
+	last := samples[len(samples)-1].ts
+	start := schedule.next(time.Unix(samples[0].ts, 0).Add(-time.Minute))
+	for !start.IsZero() && start.Unix() < last {
+		end := schedule.next(start)
+		if end.IsZero() || end.Unix() > last {
+			break
+		}
+		// Compare the state just before each scheduled snapshot
+		a, okA := at(start.Unix())
+		b, okB := at(end.Unix())
+		if okA && okB && b.ts > a.ts {
+			periods = append(periods, snapshotPeriod{start: start.Unix(), end: end.Unix(),
+				snapDelta: b.snap - a.snap, liveDelta: b.live - a.live})
+		}
+		start = end
+	}
+
+	limit := int64(maxDeltaGiB * (1 << 30))
+	if limit == 0 {
+		// Without a configured limit, flag periods far above the median growth
+		var deltas []int64
+		for _, p := range periods {
+			if p.snapDelta > 0 {
+				deltas = append(deltas, p.snapDelta)
+			}
+		}
+		if len(deltas) >= 3 {
+			sort.Slice(deltas, func(i, j int) bool { return deltas[i] < deltas[j] })
+			limit = deltas[len(deltas)/2] * report.GapFactor
+		}
+	}
+	for i := range periods {
+		p := &periods[i]
+		switch {
+		case p.snapDelta == 0 && p.liveDelta != 0:
+			p.flag = snapshotMissing
+		case limit > 0 && p.snapDelta > limit:
+			p.flag = snapshotLarge
+		}
+	}
+	return periods
+}
+
+// printSnapshotReport prints a line per mount and each flagged period
+func printSnapshotReport(recs []snapshotReconciliation) {
+	if len(recs) == 0 {
+		fmt.Println("No recorded mount matches a snapshot rule (see snapshots in the config)")
+		return
+	}
+	for i, r := range recs {
+		if i > 0 {
+			fmt.Println()
+		}
+		if !r.measured {
+			fmt.Printf("%s (%s): no snapshot space recorded, is %s readable?\n", r.mount, r.schedule, snapshotKey(r.mount))
+			continue
+		}
+		var flagged []snapshotPeriod
+		for _, p := range r.periods {
+			if p.flag != "" {
+				flagged = append(flagged, p)
+			}
+		}
+		fmt.Printf("%s (%s): %d periods, %d flagged\n", r.mount, r.schedule, len(r.periods), len(flagged))
+		if len(flagged) == 0 {
+			continue
+		}
+		fmt.Printf("  %-16s  %-16s  %12s  %12s  %s\n", "From", "To", "Snapshot", "Live", "Flag")
+		for _, p := range flagged {
+			fmt.Printf("  %-16s  %-16s  %12s  %12s  %s\n", formatDateTime(time.Unix(p.start, 0)),
+				formatDateTime(time.Unix(p.end, 0)), formatDiff(p.snapDelta), formatDiff(p.liveDelta), p.flag)
+		}
+	}
+}
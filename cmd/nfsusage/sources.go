@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"syscall"
+)
+
+// dataSource is a kernel interface collection reads besides the mount table
+// and statfs. Each one is optional: without it entries are still recorded,
+// only with less detail.
+type dataSource struct {
+	name, path string
+	// provides is what entries lack when the source can't be read
+	provides string
+}
+
+var dataSources = []dataSource{
+	{"mountinfo", "/proc/self/mountinfo", "server addresses (needed to match nfsfs volumes to mount points)"},
+	{"nfsfs", "/proc/fs/nfsfs/volumes", "NFS mounts listed with an unusual fstype and server addresses"},
+	{"mountstats", "/proc/self/mountstats", "transport counts and --mountstats counters"},
+}
+
+// unavailableSources returns the data sources that can't be read, keyed by
+// name, with why and what is missing as a result
+func unavailableSources() map[string]string {
+	var unavailable map[string]string
+	for _, src := range dataSources {
+		file, err := os.Open(src.path)
+		if err == nil {
+			_, err = file.Read(make([]byte, 1))
+			file.Close()
+		}
+		if err == nil || errors.Is(err, io.EOF) {
+			continue
+		}
+		reason := accessReason(err)
+		if errors.Is(err, fs.ErrNotExist) {
+			reason += " (/proc not mounted, or the nfs module not loaded)"
+		}
+		if unavailable == nil {
+			unavailable = make(map[string]string)
+		}
+		unavailable[src.name] = fmt.Sprintf("%s %s, no %s", src.path, reason, src.provides)
+	}
+	return unavailable
+}
+
+// accessReason explains a failed read or statfs in terms of the restriction
+// that typically causes it in containers and hardened hosts
+func accessReason(err error) string {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "does not exist"
+	case errors.Is(err, syscall.EACCES):
+		return "permission denied (restricted /proc, e.g. hidepid, or a directory the user can't search)"
+	case errors.Is(err, syscall.EPERM):
+		return "not permitted (blocked by seccomp or a security module such as SELinux or AppArmor)"
+	case errors.Is(err, syscall.ENOSYS):
+		return "not implemented (the syscall is blocked by a seccomp profile)"
+	}
+	return err.Error()
+}
+
+// printDegraded prints what an entry lacks because mounts couldn't be
+// measured or data sources couldn't be read, nothing when it is complete
+func printDegraded(entry UsageEntry) {
+	for _, group := range []struct {
+		title   string
+		reasons map[string]string
+	}{{"Not measured", entry.Unmeasured}, {"Unavailable", entry.Unavailable}} {
+		names := make([]string, 0, len(group.reasons))
+		for name := range group.reasons {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s: %s: %s\n", group.title, name, group.reasons[name])
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/report"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// summaryWindow is how far back --summary looks for the growth figure
+const summaryWindow = 7 * 24 * time.Hour
+
+// summaryLine renders a single line for MOTD snippets and chat bots, e.g.
+// "2 mounts over 85%, total +1.20 TiB this week"
+func summaryLine(st historyStore, entry UsageEntry, threshold float64) (string, error) {
+	over := 0
+	for mount := range entry.Mounts {
+		if pct, ok := report.UsedPercent(entry, mount); ok && pct > threshold {
+			over++
+		}
+	}
+	var parts []string
+	switch over {
+	case 0:
+		parts = append(parts, fmt.Sprintf("no mounts over %g%%", threshold))
+	case 1:
+		parts = append(parts, fmt.Sprintf("1 mount over %g%%", threshold))
+	default:
+		parts = append(parts, fmt.Sprintf("%d mounts over %g%%", over, threshold))
+	}
+
+	base, err := weekAgoEntry(st, entry)
+	if err != nil {
+		return "", err
+	}
+	if base != nil {
+		parts = append(parts, fmt.Sprintf("total %s this week", minDiff.format(entry.Total-base.Total)))
+	} else {
+		parts = append(parts, fmt.Sprintf("total %s", formatBytes(entry.Total)))
+	}
+	return strings.Join(parts, ", "), nil
+}
+
+// weekAgoEntry returns the oldest entry within summaryWindow before entry,
+// with snapshot mounts filtered, or nil when entry is the only one
+func weekAgoEntry(st historyStore, entry UsageEntry) (*UsageEntry, error) {
+	var base *UsageEntry
+	from := time.Unix(entry.Timestamp, 0).Add(-summaryWindow).Unix()
+	err := store.ScanRange(st, from, 0, func(e UsageEntry) error {
+		base = &e
+		return store.ErrStopScan
+	})
+	if err = store.IgnoreStop(err); err != nil || base == nil || base.Timestamp >= entry.Timestamp {
+		return nil, err
+	}
+	filtered := collector.WithoutSnapshots(*base)
+	return &filtered, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// systemdUnits holds the rendered service and timer unit files
+type systemdUnits struct {
+	service string
+	timer   string
+}
+
+// hardeningDirectives sandbox the collector: it only needs to read /proc and
+// mounted filesystems, write its data directory and talk to NFS servers
+var hardeningDirectives = []string{
+	"NoNewPrivileges=yes",
+	"CapabilityBoundingSet=",
+	"ProtectSystem=strict",
+	"ProtectHome=read-only",
+	"PrivateTmp=yes",
+	"PrivateDevices=yes",
+	"ProtectKernelTunables=yes",
+	"ProtectKernelModules=yes",
+	"ProtectKernelLogs=yes",
+	"ProtectControlGroups=yes",
+	"ProtectClock=yes",
+	"ProtectHostname=yes",
+	"RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6",
+	"RestrictNamespaces=yes",
+	"RestrictRealtime=yes",
+	"RestrictSUIDSGID=yes",
+	"LockPersonality=yes",
+	"MemoryDenyWriteExecute=yes",
+	"SystemCallArchitectures=native",
+	"Nice=10",
+	"IOSchedulingClass=idle",
+}
+
+// renderSystemdUnits renders a hardened oneshot service running execArgs and a
+// timer firing it every interval. writable lists the only paths it may write.
+func renderSystemdUnits(execArgs []string, interval time.Duration, writable []string) systemdUnits {
+	var service strings.Builder
+	fmt.Fprintf(&service, "[Unit]\n")
+	fmt.Fprintf(&service, "Description=Record NFS usage snapshot\n")
+	fmt.Fprintf(&service, "After=remote-fs.target network-online.target\n")
+	fmt.Fprintf(&service, "Wants=network-online.target\n\n")
+	fmt.Fprintf(&service, "[Service]\n")
+	fmt.Fprintf(&service, "Type=oneshot\n")
+	fmt.Fprintf(&service, "ExecStart=%s\n", systemdCommandLine(execArgs))
+	// A hung hard mount must not leave runs piling up behind each other
+	fmt.Fprintf(&service, "TimeoutStartSec=%s\n", systemdDuration(interval))
+	for _, directive := range hardeningDirectives {
+		fmt.Fprintf(&service, "%s\n", directive)
+	}
+	if len(writable) > 0 {
+		fmt.Fprintf(&service, "ReadWritePaths=%s\n", strings.Join(writable, " "))
+	}
+
+	var timer strings.Builder
+	fmt.Fprintf(&timer, "[Unit]\n")
+	fmt.Fprintf(&timer, "Description=Record NFS usage every %s\n\n", systemdDuration(interval))
+	fmt.Fprintf(&timer, "[Timer]\n")
+	fmt.Fprintf(&timer, "OnBootSec=2min\n")
+	fmt.Fprintf(&timer, "OnUnitActiveSec=%s\n", systemdDuration(interval))
+	fmt.Fprintf(&timer, "AccuracySec=1min\n\n")
+	fmt.Fprintf(&timer, "[Install]\n")
+	fmt.Fprintf(&timer, "WantedBy=timers.target\n")
+
+	return systemdUnits{service: service.String(), timer: timer.String()}
+}
+
+// systemdCommandLine joins args for ExecStart, quoting those containing spaces
+func systemdCommandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if strings.ContainsAny(arg, " \t\"'\\") {
+			arg = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// systemdDuration formats a duration as a systemd time span (e.g. 15min, 1h 30min)
+func systemdDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	var parts []string
+	if h := d / time.Hour; h > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", h))
+		d -= h * time.Hour
+	}
+	if m := d / time.Minute; m > 0 {
+		parts = append(parts, fmt.Sprintf("%dmin", m))
+		d -= m * time.Minute
+	}
+	if s := d / time.Second; s > 0 || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%ds", s))
+	}
+	return strings.Join(parts, " ")
+}
+
+// writeSystemdUnits writes nfsusage.service and nfsusage.timer into dir
+func writeSystemdUnits(dir string, units systemdUnits) error {
+	if err := os.WriteFile(filepath.Join(dir, "nfsusage.service"), []byte(units.service), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "nfsusage.timer"), []byte(units.timer), 0644)
+}
+
+// runInstallSystemd implements the install-systemd subcommand. Arguments after
+// "--" are passed through to the collector in ExecStart.
+func runInstallSystemd(args []string) {
+	fs := flag.NewFlagSet("install-systemd", flag.ExitOnError)
+	var configPath, filePath, dir, binary string
+	var interval time.Duration
+	var printOnly bool
+	fs.StringVar(&configPath, "config", "", "Config file passed to the collector")
+	fs.StringVar(&filePath, "file", "/var/lib/nfsusage/nfsusage.json", "Data file passed to the collector")
+	fs.StringVar(&dir, "dir", "/etc/systemd/system", "Directory to write the units to")
+	fs.StringVar(&binary, "binary", "", "Path to the nfsusage binary (default: this executable)")
+	fs.DurationVar(&interval, "interval", 15*time.Minute, "Collection interval")
+	fs.BoolVar(&printOnly, "print", false, "Print the units to stdout instead of writing them")
+	fs.Parse(args)
+
+	if interval < time.Minute {
+		fmt.Fprintln(os.Stderr, "Error: --interval must be at least 1m")
+		os.Exit(1)
+	}
+	if binary == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error locating executable: %v\n", err)
+			os.Exit(1)
+		}
+		binary = exe
+	}
+
+	execArgs := []string{binary, "collect"}
+	if configPath != "" {
+		execArgs = append(execArgs, "--config", configPath)
+	}
+	execArgs = append(execArgs, "--file", filePath)
+	execArgs = append(execArgs, fs.Args()...)
+
+	writable := []string{filepath.Dir(filePath)}
+	units := renderSystemdUnits(execArgs, interval, writable)
+
+	if printOnly {
+		fmt.Printf("# nfsusage.service\n%s\n# nfsusage.timer\n%s", units.service, units.timer)
+		return
+	}
+	if err := writeSystemdUnits(dir, units); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing systemd units: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s and %s\n", filepath.Join(dir, "nfsusage.service"), filepath.Join(dir, "nfsusage.timer"))
+	fmt.Println("Enable with: systemctl daemon-reload && systemctl enable --now nfsusage.timer")
+}
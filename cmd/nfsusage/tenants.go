@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/report"
+)
+
+// TenantConfig labels one tenant's mounts on the fleet server and lists the
+// API tokens that may read them. Tenant tokens are read-only and only see
+// matching mounts; the serve --token sees everything and is the only one
+// accepted for ingest.
+type TenantConfig struct {
+	Name string `yaml:"name"`
+	// Mounts are globs matched against the mount point or device (server:/export)
+	Mounts []string `yaml:"mounts"`
+	// Tokens are bearer tokens, plain or as "sha256:<hex digest>" so the
+	// config doesn't hold them in the clear
+	Tokens []string `yaml:"tokens"`
+}
+
+// owns reports whether the mount belongs to the tenant
+func (t *TenantConfig) owns(mount nfsMount) bool {
+	for _, pattern := range t.Mounts {
+		if matchesMount(pattern, mount) {
+			return true
+		}
+	}
+	return false
+}
+
+// accepts reports whether a presented bearer token is one of the tenant's
+func (t *TenantConfig) accepts(presented string) bool {
+	for _, token := range t.Tokens {
+		if tokenMatches(token, presented) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenMatches compares a configured token, plain or "sha256:<hex>", with a
+// presented one in constant time
+func tokenMatches(configured, presented string) bool {
+	if digest, ok := strings.CutPrefix(configured, "sha256:"); ok {
+		sum := sha256.Sum256([]byte(presented))
+		return subtle.ConstantTimeCompare([]byte(strings.ToLower(digest)), []byte(hex.EncodeToString(sum[:]))) == 1
+	}
+	return subtle.ConstantTimeCompare([]byte(configured), []byte(presented)) == 1
+}
+
+// validateTenants checks tenant names, patterns and tokens
+func validateTenants(tenants []TenantConfig) error {
+	names := make(map[string]bool)
+	for _, t := range tenants {
+		if t.Name == "" {
+			return fmt.Errorf("tenant without a name")
+		}
+		if names[t.Name] {
+			return fmt.Errorf("tenant %s: duplicate name", t.Name)
+		}
+		names[t.Name] = true
+		if len(t.Mounts) == 0 {
+			return fmt.Errorf("tenant %s: no mounts", t.Name)
+		}
+		for _, pattern := range t.Mounts {
+			if err := validatePattern(pattern); err != nil {
+				return fmt.Errorf("tenant %s: pattern %q: %v", t.Name, pattern, err)
+			}
+		}
+		for _, token := range t.Tokens {
+			if token == "" {
+				return fmt.Errorf("tenant %s: empty token", t.Name)
+			}
+			if digest, ok := strings.CutPrefix(token, "sha256:"); ok {
+				if b, err := hex.DecodeString(digest); err != nil || len(b) != sha256.Size {
+					return fmt.Errorf("tenant %s: invalid sha256 token digest", t.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// tenantLabels returns the names of the tenants owning a mount
+func (c *Config) tenantLabels(mount nfsMount) []string {
+	var labels []string
+	for i := range c.Tenants {
+		if c.Tenants[i].owns(mount) {
+			labels = append(labels, c.Tenants[i].Name)
+		}
+	}
+	return labels
+}
+
+// authorizeRead checks the bearer token of a read request and returns the
+// tenant it is scoped to, nil for the admin token or when reads are open.
+// Reads are open only when neither a token nor tenants are configured. On
+// failure the error response has been written.
+func (s *fleetServer) authorizeRead(w http.ResponseWriter, r *http.Request) (*TenantConfig, bool) {
+	presented, hasToken := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if s.token != "" && hasToken && tokenMatches(s.token, presented) {
+		return nil, true
+	}
+	if s.token == "" && len(s.cfg.Tenants) == 0 {
+		return nil, true
+	}
+	if hasToken {
+		for i := range s.cfg.Tenants {
+			if s.cfg.Tenants[i].accepts(presented) {
+				return &s.cfg.Tenants[i], true
+			}
+		}
+	}
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+	return nil, false
+}
+
+// visible reports whether a mount may be shown to tenant, every mount for nil
+func visible(tenant *TenantConfig, mount nfsMount) bool {
+	return tenant == nil || tenant.owns(mount)
+}
+
+// restrictEntry keeps only the mounts of entry the tenant may see
+func restrictEntry(entry UsageEntry, tenant *TenantConfig) UsageEntry {
+	if tenant == nil {
+		return entry
+	}
+	restricted := entry
+	restricted.Mounts = make(map[string]int64)
+	restricted.Details = make(map[string]MountDetail)
+	restricted.Total = 0
+	restricted.Missing, restricted.Absent, restricted.Stale = nil, nil, nil
+	for mount, used := range entry.Mounts {
+		detail := entry.Details[mount]
+		if !tenant.owns(nfsMount{MountPoint: mount, Device: detail.Device}) {
+			continue
+		}
+		restricted.Mounts[mount] = used
+		restricted.Details[mount] = detail
+		restricted.Total += used
+	}
+	return restricted
+}
+
+// hostUsage is one host's newest entry in /api/v1/usage, in the --output
+// json format
+type hostUsage struct {
+	Host string `json:"host"`
+	report.Document
+}
+
+// handleUsage serves the newest entry of every host as JSON, limited to the
+// mounts the caller's tenant may see
+func (s *fleetServer) handleUsage(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := s.authorizeRead(w, r)
+	if !ok {
+		return
+	}
+	filter, err := report.ParseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		http.Error(w, "filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	stores, err := fleetStores(s.dataDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hosts := make([]string, 0, len(stores))
+	for host := range stores {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	usage := []hostUsage{}
+	for _, host := range hosts {
+		newest, _, err := lastEntry(stores[host])
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s: %v", host, err), http.StatusInternalServerError)
+			return
+		}
+		if newest == nil {
+			continue
+		}
+		entry := filter.Entry(restrictEntry(collector.WithoutSnapshots(*newest), tenant))
+		if len(entry.Mounts) == 0 {
+			continue
+		}
+		usage = append(usage, hostUsage{Host: host, Document: report.NewDocument(s.redact.entry(entry), nil)})
+	}
+	lo, hi, ok := s.paginate(w, r, len(usage))
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage[lo:hi])
+}
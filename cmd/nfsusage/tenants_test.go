@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAuthorizeRead(t *testing.T) {
+	tenants := []TenantConfig{{Name: "web", Mounts: []string{"/mnt/web*"}, Tokens: []string{"webtoken"}}}
+	tests := []struct {
+		name    string
+		token   string
+		tenants []TenantConfig
+		bearer  string
+		ok      bool
+		tenant  string
+	}{
+		{"open", "", nil, "", true, ""},
+		{"admin token required", "secret", nil, "", false, ""},
+		{"wrong admin token", "secret", nil, "nope", false, ""},
+		{"admin token", "secret", nil, "secret", true, ""},
+		{"tenant token", "secret", tenants, "webtoken", true, "web"},
+		{"tenants without token", "", tenants, "", false, ""},
+		{"tenant token without admin", "", tenants, "webtoken", true, "web"},
+		{"admin with tenants", "secret", tenants, "secret", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestFleet(t, tt.token, &Config{Tenants: tt.tenants})
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/usage", nil)
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			rec := httptest.NewRecorder()
+			tenant, ok := s.authorizeRead(rec, req)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok && rec.Code != http.StatusUnauthorized {
+				t.Errorf("status %d, want %d", rec.Code, http.StatusUnauthorized)
+			}
+			var name string
+			if tenant != nil {
+				name = tenant.Name
+			}
+			if name != tt.tenant {
+				t.Errorf("tenant %q, want %q", name, tt.tenant)
+			}
+		})
+	}
+}
+
+func TestLoadAnnotationsTenantScope(t *testing.T) {
+	s := newTestFleet(t, "secret", nil)
+	for _, entry := range []UsageEntry{
+		{Host: "web01", Timestamp: 100, Mounts: map[string]int64{"/data": 1}, Total: 1,
+			Details: map[string]MountDetail{"/data": {Device: "filer1:/export/web"}}},
+		{Host: "db01", Timestamp: 100, Mounts: map[string]int64{"/data": 2}, Total: 2,
+			Details: map[string]MountDetail{"/data": {Device: "filer1:/export/db"}}},
+	} {
+		if code := ingest(t, s, "secret", entry); code != http.StatusNoContent {
+			t.Fatalf("ingest %s: status %d", entry.Host, code)
+		}
+	}
+	for _, a := range []Annotation{
+		{Timestamp: 100, Text: "fleet", Source: "10.0.0.1"},
+		{Timestamp: 100, Text: "web host", Host: "web01"},
+		{Timestamp: 100, Text: "db host", Host: "db01"},
+		{Timestamp: 100, Text: "web mount", Host: "web01", Mounts: []string{"/data"}},
+		{Timestamp: 100, Text: "db mount", Host: "db01", Mounts: []string{"/data"}},
+		{Timestamp: 100, Text: "any host", Mounts: []string{"/data", "/scratch"}},
+	} {
+		if err := appendAnnotation(s.dataDir, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the tenant is defined by device, so "/data" alone is not enough
+	web := &TenantConfig{Name: "web", Mounts: []string{"filer1:/export/web"}}
+	got, err := loadAnnotations(s.dataDir, "", 0, 200, web)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Annotation{
+		{Timestamp: 100, Text: "fleet"},
+		{Timestamp: 100, Text: "web host", Host: "web01"},
+		{Timestamp: 100, Text: "web mount", Host: "web01", Mounts: []string{"/data"}},
+		{Timestamp: 100, Text: "any host", Mounts: []string{"/data"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tenant sees %+v, want %+v", got, want)
+	}
+
+	all, err := loadAnnotations(s.dataDir, "", 0, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 6 || all[0].Source != "10.0.0.1" {
+		t.Errorf("admin sees %+v, want every annotation unchanged", all)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+)
+
+// printTransport prints transport settings per mount with aligned columns
+func printTransport(entry UsageEntry) {
+	mountWidth := len("Mountpoint")
+	for mount := range entry.Details {
+		if len(mount) > mountWidth {
+			mountWidth = len(mount)
+		}
+	}
+
+	fmt.Printf("%-*s  %-7s  %-5s  %8s  %11s  %5s\n", mountWidth, "Mountpoint", "Version", "Proto", "nconnect", "max_connect", "xprts")
+	for mount, detail := range entry.Details {
+		t := detail.Transport
+		if t == nil {
+			continue
+		}
+		fmt.Printf("%-*s  %-7s  %-5s  %8d  %11d  %5d\n", mountWidth, mount, t.Version, t.Proto, t.Nconnect, t.MaxConnect, t.Xprts)
+	}
+}
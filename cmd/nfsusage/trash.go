@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/report"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// defaultTrash are the trash directories measured when the config lists none
+var defaultTrash = []string{".Trash*", "lost+found"}
+
+// trashPatterns returns the configured trash patterns, or the defaults
+func (c *Config) trashPatterns() []string {
+	if len(c.Trash) > 0 {
+		return c.Trash
+	}
+	return defaultTrash
+}
+
+// measureTrash measures the trash and quarantine paths under a mount with du,
+// keyed by path relative to the mount. Patterns are globs relative to the mount.
+func measureTrash(mountPoint string, patterns []string) map[string]int64 {
+	var trash map[string]int64
+	for _, pattern := range patterns {
+		paths, _ := filepath.Glob(filepath.Join(mountPoint, pattern))
+		for _, path := range paths {
+			if info, err := os.Stat(path); err != nil || !info.IsDir() {
+				continue
+			}
+			size, err := duBytes(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: Error measuring %s: %v\n", path, err)
+				continue
+			}
+			if trash == nil {
+				trash = make(map[string]int64)
+			}
+			rel, _ := filepath.Rel(mountPoint, path)
+			trash[rel] = size
+		}
+	}
+	return trash
+}
+
+// trashRow is one mount's trash at the start and end of a window
+type trashRow struct {
+	mount                string
+	paths                []string
+	startTrash, endTrash int64
+	startLive, endLive   int64
+	// measuredStart is false when the window's first entry has no trash data
+	measuredStart bool
+}
+
+// collectTrash compares each mount's trash and live data (used minus trash)
+// in the first and newest entry at or after from
+func collectTrash(st historyStore, from int64) ([]trashRow, error) {
+	var first, last *UsageEntry
+	err := store.ScanRange(st, from, 0, func(entry UsageEntry) error {
+		e := collector.WithoutSnapshots(entry)
+		if first == nil {
+			first = &e
+		}
+		last = &e
+		return nil
+	})
+	if err != nil || last == nil {
+		return nil, err
+	}
+
+	var rows []trashRow
+	for mount, used := range last.Mounts {
+		detail := last.Details[mount]
+		if detail.Trash == nil {
+			continue
+		}
+		row := trashRow{mount: mount, endTrash: report.TrashBytes(detail)}
+		row.endLive = used - row.endTrash
+		for path := range detail.Trash {
+			row.paths = append(row.paths, path)
+		}
+		sort.Strings(row.paths)
+		if startDetail, ok := first.Details[mount]; ok && startDetail.Trash != nil {
+			row.measuredStart = true
+			row.startTrash = report.TrashBytes(startDetail)
+			row.startLive = first.Mounts[mount] - row.startTrash
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].mount < rows[j].mount })
+	return rows, nil
+}
+
+// printTrashReport prints trash separately from live data per mount, with the
+// change of each over the window so trash explaining growth stands out
+func printTrashReport(rows []trashRow) {
+	if len(rows) == 0 {
+		fmt.Println("No trash directories measured (see trash in the config)")
+		return
+	}
+	mountWidth := len("Mountpoint")
+	for _, r := range rows {
+		mountWidth = max(mountWidth, len(r.mount))
+	}
+	fmt.Printf("%-*s  %12s  %12s  %12s  %12s  %s\n", mountWidth, "Mountpoint", "Live", "Change", "Trash", "Change", "Paths")
+	fmt.Printf("%-*s  %12s  %12s  %12s  %12s  %s\n", mountWidth, strings.Repeat("-", mountWidth),
+		"----", "------", "-----", "------", "-----")
+	for _, r := range rows {
+		liveChange, trashChange := "-", "-"
+		if r.measuredStart {
+			liveChange, trashChange = formatDiff(r.endLive-r.startLive), formatDiff(r.endTrash-r.startTrash)
+		}
+		fmt.Printf("%-*s  %12s  %12s  %12s  %12s  %s\n", mountWidth, r.mount, formatBytes(r.endLive), liveChange,
+			formatBytes(r.endTrash), trashChange, strings.Join(r.paths, ", "))
+	}
+}
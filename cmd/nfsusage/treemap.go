@@ -0,0 +1,257 @@
+package main
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+// treeNode is one rectangle in the treemap: a server, export, mount or directory
+type treeNode struct {
+	Name     string      `json:"name"`
+	Size     int64       `json:"size"`
+	Children []*treeNode `json:"children,omitempty"`
+}
+
+// child returns the named child, creating it if needed
+func (n *treeNode) child(name string) *treeNode {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	c := &treeNode{Name: name}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// exportPath returns the export part of an NFS device string (host:/export)
+func exportPath(device string) string {
+	if i := strings.LastIndex(device, ":/"); i >= 0 {
+		return device[i+1:]
+	}
+	return device
+}
+
+// buildUsageTree groups an entry's mounts by server and export. Mounts of the
+// same export get their own level so bind mounts and subdirectory mounts stay
+// visible; with dirs set each mount is further split by its top-level directories.
+func buildUsageTree(entry UsageEntry, r *redactor, dirs bool) *treeNode {
+	root := &treeNode{Name: "total", Size: entry.Total}
+	mounts := make([]string, 0, len(entry.Mounts))
+	for mount := range entry.Mounts {
+		mounts = append(mounts, mount)
+	}
+	sort.Strings(mounts)
+
+	for _, mount := range mounts {
+		used := entry.Mounts[mount]
+		detail := entry.Details[mount]
+		server := detail.Server
+		if server == "" {
+			server = collector.ServerHost(detail.Device)
+		}
+		if server == "" {
+			server = "unknown"
+		}
+		export := r.path(exportPath(detail.Device))
+		if export == "" {
+			export = r.path(mount)
+		}
+
+		serverNode := root.child(server)
+		exportNode := serverNode.child(export)
+		mountNode := exportNode.child(r.path(mount))
+		serverNode.Size += used
+		exportNode.Size += used
+		mountNode.Size += used
+		if dirs && r == nil {
+			mountNode.Children = topLevelDirs(mount, used, detail.Dirs)
+		}
+	}
+	return root
+}
+
+// topLevelDirs splits a mount by top-level directory, using the sizes recorded
+// in the entry when directory mode is on and measuring with du otherwise.
+// Space du can't attribute (files in the root, unreadable directories) is kept
+// as one "(other)" node so the children still add up to the mount's usage.
+func topLevelDirs(mount string, used int64, recorded map[string]int64) []*treeNode {
+	sizes := recorded
+	if sizes == nil {
+		sizes = measureDirs(mount)
+	}
+	var nodes []*treeNode
+	var sum int64
+	for name, size := range sizes {
+		if size == 0 {
+			continue
+		}
+		nodes = append(nodes, &treeNode{Name: name, Size: size})
+		sum += size
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	if rest := used - sum; rest > 0 && len(nodes) > 0 {
+		nodes = append(nodes, &treeNode{Name: "(other)", Size: rest})
+	}
+	return nodes
+}
+
+// measureDirs measures each top-level directory of a mount like du, skipping
+// directories that can't be measured
+func measureDirs(mount string) map[string]int64 {
+	entries, err := os.ReadDir(mount)
+	if err != nil {
+		return nil
+	}
+	sizes := make(map[string]int64)
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		size, err := duBytes(filepath.Join(mount, e.Name()))
+		if err != nil {
+			continue
+		}
+		sizes[e.Name()] = size
+	}
+	return sizes
+}
+
+// writeTreemap renders a self-contained interactive HTML treemap of root
+func writeTreemap(path string, root *treeNode, timestamp int64) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = treemapTemplate.Execute(file, struct {
+		Title string
+		Data  *treeNode
+	}{"NFS usage " + formatDateTime(time.Unix(timestamp, 0)), root})
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+var treemapTemplate = template.Must(template.New("treemap").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { margin: 0; font: 13px sans-serif; background: #fafafa; }
+#bar { padding: 8px 12px; background: #263238; color: #eceff1; }
+#bar a { color: #80cbc4; cursor: pointer; text-decoration: none; }
+#map { position: absolute; top: 36px; left: 0; right: 0; bottom: 0; }
+.node { position: absolute; box-sizing: border-box; border: 1px solid #fff; overflow: hidden; cursor: pointer; }
+.node span { display: block; padding: 2px 4px; color: #fff; white-space: nowrap; text-shadow: 0 0 2px #000; }
+</style>
+</head>
+<body>
+<div id="bar"></div>
+<div id="map"></div>
+<script>
+const data = {{.Data}};
+const title = {{.Title}};
+const colors = ["#37474f", "#00796b", "#1976d2", "#7b1fa2", "#c2185b", "#f57c00", "#388e3c", "#5d4037"];
+
+function human(b) {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB", "PiB"];
+  let i = 0;
+  while (b >= 1024 && i < units.length - 1) { b /= 1024; i++; }
+  return b.toFixed(i ? 2 : 0) + " " + units[i];
+}
+
+// Squarified layout: rows of rectangles are grown while that improves their worst aspect ratio
+function worst(row, side) {
+  const s = row.reduce((a, r) => a + r.area, 0);
+  let min = Infinity, max = 0;
+  for (const r of row) { min = Math.min(min, r.area); max = Math.max(max, r.area); }
+  return Math.max(side * side * max / (s * s), s * s / (side * side * min));
+}
+
+function place(row, box, out) {
+  const s = row.reduce((a, r) => a + r.area, 0);
+  if (box.w >= box.h) {
+    const rw = s / box.h;
+    let y = box.y;
+    for (const r of row) { const h = r.area / rw; out.push({node: r.node, x: box.x, y: y, w: rw, h: h}); y += h; }
+    return {x: box.x + rw, y: box.y, w: box.w - rw, h: box.h};
+  }
+  const rh = s / box.w;
+  let x = box.x;
+  for (const r of row) { const w = r.area / rh; out.push({node: r.node, x: x, y: box.y, w: w, h: rh}); x += w; }
+  return {x: box.x, y: box.y + rh, w: box.w, h: box.h - rh};
+}
+
+function layout(children, box) {
+  const total = children.reduce((a, c) => a + c.size, 0);
+  if (total <= 0 || box.w <= 0 || box.h <= 0) return [];
+  const items = children.filter(c => c.size > 0).sort((a, b) => b.size - a.size)
+    .map(c => ({node: c, area: c.size / total * box.w * box.h}));
+  const out = [];
+  let row = [];
+  while (items.length) {
+    const side = Math.min(box.w, box.h);
+    if (row.length === 0 || worst(row.concat([items[0]]), side) <= worst(row, side)) {
+      row.push(items.shift());
+    } else {
+      box = place(row, box, out);
+      row = [];
+    }
+  }
+  if (row.length) place(row, box, out);
+  return out;
+}
+
+function draw(parent, node, box, depth) {
+  for (const r of layout(node.children || [], box)) {
+    const div = document.createElement("div");
+    div.className = "node";
+    div.style.left = r.x + "px";
+    div.style.top = r.y + "px";
+    div.style.width = r.w + "px";
+    div.style.height = r.h + "px";
+    div.style.background = colors[depth % colors.length];
+    div.title = r.node.name + "\n" + human(r.node.size);
+    const label = document.createElement("span");
+    label.textContent = r.node.name + " " + human(r.node.size);
+    div.appendChild(label);
+    div.onclick = e => { e.stopPropagation(); zoom(path.concat([r.node])); };
+    parent.appendChild(div);
+    if (r.node.children && r.w > 30 && r.h > 40) {
+      draw(div, r.node, {x: 2, y: 20, w: r.w - 6, h: r.h - 24}, depth + 1);
+    }
+  }
+}
+
+let path = [data];
+function zoom(p) {
+  if (!p[p.length - 1].children) p = p.slice(0, -1);
+  path = p;
+  const bar = document.getElementById("bar");
+  bar.textContent = "";
+  bar.Append(title + "  ");
+  path.forEach((n, i) => {
+    const a = document.createElement("a");
+    a.textContent = n.name + " (" + human(n.size) + ")";
+    a.onclick = () => zoom(path.slice(0, i + 1));
+    if (i) bar.Append(" / ");
+    bar.appendChild(a);
+  });
+  const map = document.getElementById("map");
+  map.textContent = "";
+  draw(map, path[path.length - 1], {x: 0, y: 0, w: map.clientWidth, h: map.clientHeight}, path.length - 1);
+}
+window.onresize = () => zoom(path);
+zoom(path);
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// whisperDefaultRetentions is used when no storage schema matches a metric
+const whisperDefaultRetentions = "5m:90d,1h:5y"
+
+// Whisper on-disk layout: a metadata header, one archive info block per
+// archive, then each archive's ring of 12 byte (interval, value) points.
+// All numbers are big-endian.
+const (
+	whisperMetadataSize    = 16
+	whisperArchiveInfoSize = 12
+	whisperPointSize       = 12
+	whisperAggAverage      = 1
+	whisperDefaultXFF      = 0.5
+)
+
+// whisperArchive describes one archive of a whisper file
+type whisperArchive struct {
+	offset          uint32
+	secondsPerPoint uint32
+	points          uint32
+}
+
+func (a whisperArchive) retention() uint32 {
+	return a.secondsPerPoint * a.points
+}
+
+// whisperSchema is one section of a Graphite storage-schemas.conf
+type whisperSchema struct {
+	name       string
+	pattern    *regexp.Regexp
+	retentions []whisperArchive
+}
+
+// whisperSink writes directly to whisper files under a Graphite storage
+// directory, for air-gapped installs without carbon
+type whisperSink struct {
+	dir      string
+	schemas  []whisperSchema
+	fallback []whisperArchive
+}
+
+// newWhisperSink loads the storage schemas (if any) and the default retentions
+func newWhisperSink(dir, schemasPath, retentions string) (whisperSink, error) {
+	s := whisperSink{dir: dir}
+	if retentions == "" {
+		retentions = whisperDefaultRetentions
+	}
+	var err error
+	if s.fallback, err = parseRetentions(retentions); err != nil {
+		return s, err
+	}
+	if schemasPath != "" {
+		if s.schemas, err = loadStorageSchemas(schemasPath); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// loadStorageSchemas parses a Graphite storage-schemas.conf
+func loadStorageSchemas(path string) ([]whisperSchema, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var schemas []whisperSchema
+	var pattern, retentions string
+	name := ""
+	flush := func() error {
+		if name == "" {
+			return nil
+		}
+		if pattern == "" || retentions == "" {
+			return fmt.Errorf("%s: [%s] needs pattern and retentions", path, name)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: [%s] pattern: %v", path, name, err)
+		}
+		archives, err := parseRetentions(retentions)
+		if err != nil {
+			return fmt.Errorf("%s: [%s] retentions: %v", path, name, err)
+		}
+		schemas = append(schemas, whisperSchema{name: name, pattern: re, retentions: archives})
+		return nil
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			name, pattern, retentions = strings.Trim(line, "[]"), "", ""
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "pattern":
+			pattern = strings.TrimSpace(value)
+		case "retentions":
+			retentions = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return schemas, nil
+}
+
+// parseRetentions parses Graphite retentions like "60s:1d,5m:30d,1h:5y"; the
+// second part may also be a plain number of points
+func parseRetentions(spec string) ([]whisperArchive, error) {
+	var archives []whisperArchive
+	for _, part := range strings.Split(spec, ",") {
+		precision, retention, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid retention %q", part)
+		}
+		spp, err := parseGraphiteSeconds(precision)
+		if err != nil || spp == 0 {
+			return nil, fmt.Errorf("invalid precision %q", precision)
+		}
+		var points uint32
+		if n, err := strconv.ParseUint(retention, 10, 32); err == nil {
+			points = uint32(n)
+		} else {
+			secs, err := parseGraphiteSeconds(retention)
+			if err != nil {
+				return nil, fmt.Errorf("invalid retention %q", retention)
+			}
+			points = secs / spp
+		}
+		if points == 0 {
+			return nil, fmt.Errorf("retention %q holds no points", part)
+		}
+		archives = append(archives, whisperArchive{secondsPerPoint: spp, points: points})
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].secondsPerPoint < archives[j].secondsPerPoint })
+	for i := 1; i < len(archives); i++ {
+		if archives[i].secondsPerPoint%archives[i-1].secondsPerPoint != 0 {
+			return nil, fmt.Errorf("precision %ds does not divide %ds", archives[i-1].secondsPerPoint, archives[i].secondsPerPoint)
+		}
+	}
+	return archives, nil
+}
+
+// parseGraphiteSeconds parses durations with Graphite's s/m/h/d/w/y units
+func parseGraphiteSeconds(s string) (uint32, error) {
+	units := map[byte]uint32{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 7 * 86400, 'y': 365 * 86400}
+	if s == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+	unit, ok := units[s[len(s)-1]]
+	if ok {
+		s = s[:len(s)-1]
+	} else {
+		unit = 1
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(n) * unit, nil
+}
+
+// metricPath maps a mount point to its Graphite metric prefix, e.g. nfsusage.mnt_data
+func metricPath(mount string) string {
+	name := strings.NewReplacer("/", "_", ".", "_", " ", "_").Replace(strings.Trim(mount, "/"))
+	if name == "" {
+		name = "root"
+	}
+	return "nfsusage." + name
+}
+
+func (s whisperSink) send(entry UsageEntry) error {
+	values := map[string]float64{"nfsusage.total.used_bytes": float64(entry.Total)}
+	for mount, used := range entry.Mounts {
+		values[metricPath(mount)+".used_bytes"] = float64(used)
+		if avail := entry.Details[mount].Available; avail != nil {
+			values[metricPath(mount)+".free_bytes"] = float64(*avail)
+		}
+	}
+	for metric, value := range values {
+		path := filepath.Join(s.dir, filepath.FromSlash(strings.ReplaceAll(metric, ".", "/"))+".wsp")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if err := createWhisper(path, s.retentionsFor(metric)); err != nil {
+				return fmt.Errorf("%s: %v", metric, err)
+			}
+		}
+		if err := updateWhisper(path, entry.Timestamp, value); err != nil {
+			return fmt.Errorf("%s: %v", metric, err)
+		}
+	}
+	return nil
+}
+
+// retentionsFor picks the first matching schema like carbon does
+func (s whisperSink) retentionsFor(metric string) []whisperArchive {
+	for _, schema := range s.schemas {
+		if schema.pattern.MatchString(metric) {
+			return schema.retentions
+		}
+	}
+	return s.fallback
+}
+
+// createWhisper creates an empty whisper file with average aggregation
+func createWhisper(path string, archives []whisperArchive) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	header := make([]byte, whisperMetadataSize+whisperArchiveInfoSize*len(archives))
+	maxRetention := uint32(0)
+	offset := uint32(len(header))
+	for i, a := range archives {
+		if a.retention() > maxRetention {
+			maxRetention = a.retention()
+		}
+		info := header[whisperMetadataSize+whisperArchiveInfoSize*i:]
+		binary.BigEndian.PutUint32(info[0:], offset)
+		binary.BigEndian.PutUint32(info[4:], a.secondsPerPoint)
+		binary.BigEndian.PutUint32(info[8:], a.points)
+		offset += a.points * whisperPointSize
+	}
+	binary.BigEndian.PutUint32(header[0:], whisperAggAverage)
+	binary.BigEndian.PutUint32(header[4:], maxRetention)
+	binary.BigEndian.PutUint32(header[8:], math.Float32bits(whisperDefaultXFF))
+	binary.BigEndian.PutUint32(header[12:], uint32(len(archives)))
+
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Truncate(int64(offset)); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readWhisperHeader returns the xFilesFactor and archives of an open whisper file
+func readWhisperHeader(file *os.File) (float32, []whisperArchive, error) {
+	meta := make([]byte, whisperMetadataSize)
+	if _, err := file.ReadAt(meta, 0); err != nil {
+		return 0, nil, fmt.Errorf("reading header: %v", err)
+	}
+	xff := math.Float32frombits(binary.BigEndian.Uint32(meta[8:]))
+	count := binary.BigEndian.Uint32(meta[12:])
+	if count == 0 || count > 64 {
+		return 0, nil, fmt.Errorf("not a whisper file (%d archives)", count)
+	}
+
+	infos := make([]byte, whisperArchiveInfoSize*int(count))
+	if _, err := file.ReadAt(infos, whisperMetadataSize); err != nil {
+		return 0, nil, fmt.Errorf("reading archive info: %v", err)
+	}
+	archives := make([]whisperArchive, count)
+	for i := range archives {
+		info := infos[whisperArchiveInfoSize*i:]
+		archives[i] = whisperArchive{
+			offset:          binary.BigEndian.Uint32(info[0:]),
+			secondsPerPoint: binary.BigEndian.Uint32(info[4:]),
+			points:          binary.BigEndian.Uint32(info[8:]),
+		}
+	}
+	return xff, archives, nil
+}
+
+// updateWhisper stores value at ts in the finest archive that still covers it
+// and propagates averages to the coarser archives. Points older than the
+// file's retention are dropped like carbon does.
+func updateWhisper(path string, ts int64, value float64) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	xff, archives, err := readWhisperHeader(file)
+	if err != nil {
+		return err
+	}
+
+	age := timeSource.Now().Unix() - ts
+	for i, a := range archives {
+		if age < 0 || age >= int64(a.retention()) {
+			continue
+		}
+		interval := uint32(ts) - uint32(ts)%a.secondsPerPoint
+		if err := writeWhisperPoint(file, a, interval, value); err != nil {
+			return err
+		}
+		for j := i + 1; j < len(archives); j++ {
+			ok, err := propagateWhisper(file, archives[j-1], archives[j], uint32(ts), xff)
+			if err != nil || !ok {
+				return err
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// whisperOffset returns where interval lives in an archive's ring, which is
+// anchored at the interval of its first point
+func whisperOffset(file *os.File, a whisperArchive, interval uint32) (int64, error) {
+	base := make([]byte, 4)
+	if _, err := file.ReadAt(base, int64(a.offset)); err != nil {
+		return 0, err
+	}
+	baseInterval := binary.BigEndian.Uint32(base)
+	if baseInterval == 0 {
+		return int64(a.offset), nil
+	}
+	pointDist := (int64(interval) - int64(baseInterval)) / int64(a.secondsPerPoint)
+	index := pointDist % int64(a.points)
+	if index < 0 {
+		index += int64(a.points)
+	}
+	return int64(a.offset) + index*whisperPointSize, nil
+}
+
+func writeWhisperPoint(file *os.File, a whisperArchive, interval uint32, value float64) error {
+	offset, err := whisperOffset(file, a, interval)
+	if err != nil {
+		return err
+	}
+	point := make([]byte, whisperPointSize)
+	binary.BigEndian.PutUint32(point[0:], interval)
+	binary.BigEndian.PutUint64(point[4:], math.Float64bits(value))
+	_, err = file.WriteAt(point, offset)
+	return err
+}
+
+// propagateWhisper averages the higher archive's points that fall into the
+// lower archive's interval around ts, and reports whether enough were known
+// to satisfy the xFilesFactor
+func propagateWhisper(file *os.File, higher, lower whisperArchive, ts uint32, xff float32) (bool, error) {
+	lowerInterval := ts - ts%lower.secondsPerPoint
+	ring := make([]byte, int(higher.points)*whisperPointSize)
+	if _, err := file.ReadAt(ring, int64(higher.offset)); err != nil && err != io.EOF {
+		return false, err
+	}
+	start, err := whisperOffset(file, higher, lowerInterval)
+	if err != nil {
+		return false, err
+	}
+
+	n := int(lower.secondsPerPoint / higher.secondsPerPoint)
+	first := int(start-int64(higher.offset)) / whisperPointSize
+	known, sum := 0, 0.0
+	for i := 0; i < n; i++ {
+		point := ring[((first+i)%int(higher.points))*whisperPointSize:]
+		if binary.BigEndian.Uint32(point) != lowerInterval+uint32(i)*higher.secondsPerPoint {
+			continue
+		}
+		known++
+		sum += math.Float64frombits(binary.BigEndian.Uint64(point[4:]))
+	}
+	if known == 0 || float32(known)/float32(n) < xff {
+		return false, nil
+	}
+	return true, writeWhisperPoint(file, lower, lowerInterval, sum/float64(known))
+}
@@ -0,0 +1,52 @@
+package collector
+
+import "time"
+
+// Describe builds the detail recorded for a mount from its statfs usage.
+// Server is the host part of the device; callers that normalize server
+// identities overwrite it.
+func Describe(mount Mount, usage Usage) MountDetail {
+	detail := MountDetail{
+		Device:     mount.Device,
+		Server:     ServerHost(mount.Device),
+		ServerAddr: mount.ServerAddr,
+		Provider:   MountProvider(mount),
+		Transport:  TransportFromOptions(mount.Options),
+		Available:  &usage.Available,
+		Size:       &usage.Size,
+		FSID:       usage.FSID,
+	}
+	if usage.InodesUsed+usage.InodesFree > 0 {
+		detail.Inodes = &InodeUsage{Used: usage.InodesUsed, Free: usage.InodesFree}
+	}
+	_, detail.ReadOnly = ParseOptions(mount.Options)["ro"]
+	return detail
+}
+
+// Collect measures mounts one after another and returns the entry. Mounts
+// that can't be measured are left out and returned with their error, keyed
+// by mount point. It has no deadline, a hung mount blocks it.
+func Collect(mounts []Mount) (UsageEntry, map[string]error) {
+	entry := UsageEntry{
+		Timestamp: time.Now().Unix(),
+		Mounts:    make(map[string]int64),
+		Details:   make(map[string]MountDetail),
+	}
+	failed := make(map[string]error)
+	stats, _ := ReadMountStats()
+	for _, mount := range mounts {
+		usage, err := Statfs(mount.MountPoint)
+		if err != nil {
+			failed[mount.MountPoint] = err
+			continue
+		}
+		detail := Describe(mount, usage)
+		if s := stats[mount.MountPoint]; s != nil {
+			detail.Transport.Xprts = s.Xprts
+		}
+		entry.Mounts[mount.MountPoint] = usage.Used
+		entry.Details[mount.MountPoint] = detail
+		entry.Total += usage.Used
+	}
+	return entry, failed
+}
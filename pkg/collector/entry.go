@@ -0,0 +1,154 @@
+// Package collector measures the NFS mounts of a host. It finds the mounts in
+// /proc/mounts, measures each with statfs and records the result as a
+// UsageEntry, the unit the store package persists and the report package
+// renders.
+package collector
+
+import (
+	"strings"
+)
+
+// UsageEntry represents a single snapshot of NFS usage
+type UsageEntry struct {
+	Timestamp int64 `json:"timestamp"`
+	// Host is the collecting host, so aggregated entries can be told apart
+	Host    string                 `json:"host,omitempty"`
+	Mounts  map[string]int64       `json:"mounts"`
+	Total   int64                  `json:"total"`
+	Details map[string]MountDetail `json:"details,omitempty"`
+	Servers map[string]ServerProbe `json:"servers,omitempty"`
+	// Partial is set when the collection deadline passed before every mount was measured
+	Partial bool     `json:"partial,omitempty"`
+	Missing []string `json:"missing,omitempty"`
+	// Absent lists required mounts that were not mounted
+	Absent []string `json:"absent,omitempty"`
+	// Stale lists mounts whose statfs didn't return within the mount timeout
+	Stale []string `json:"stale,omitempty"`
+	// Unmeasured lists mounts that were found but could not be measured, with
+	// the reason, so a smaller entry is not mistaken for unmounted filesystems
+	Unmeasured map[string]string `json:"unmeasured,omitempty"`
+	// Unavailable lists data sources that could not be read, with the reason,
+	// e.g. mountstats in a container without access to /proc
+	Unavailable map[string]string `json:"unavailable,omitempty"`
+	// Elapsed is the time in seconds since the previous entry as measured on
+	// the monotonic clock, immune to wall clock adjustments. Only the daemon
+	// sets it, when it also wrote the previous entry.
+	Elapsed float64 `json:"elapsed,omitempty"`
+	// Checksum chains this entry to the previous one for tamper detection.
+	// New fields must be omitempty so older entries keep verifying.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// MountDetail holds per-mount metadata recorded alongside the used bytes
+type MountDetail struct {
+	Device string `json:"device,omitempty"`
+	// Server is the normalized server identity used for grouping
+	Server string `json:"server,omitempty"`
+	// ServerAddr is the server IP as seen by the NFS client, even when mounted by hostname
+	ServerAddr string         `json:"server_addr,omitempty"`
+	Provider   *ProviderInfo  `json:"provider,omitempty"`
+	Transport  *TransportInfo `json:"transport,omitempty"`
+	// Elastic is set for filesystems whose reported capacity is meaningless
+	Elastic bool `json:"elastic,omitempty"`
+	// Available is the free space reported by statfs, nil for elastic filesystems
+	Available *int64 `json:"available,omitempty"`
+	// Size is the total capacity reported by statfs, including blocks reserved
+	// for root. nil for elastic filesystems and entries recorded before it was.
+	Size *int64 `json:"size,omitempty"`
+	// Trash is the size of trash and quarantine directories, relative to the mount
+	Trash map[string]int64 `json:"trash,omitempty"`
+	// ReadOnly is set for mounts with the ro option, they can't grow from this host
+	ReadOnly bool `json:"read_only,omitempty"`
+	// Dirs is the size of each top-level directory, recorded in directory mode
+	Dirs map[string]int64 `json:"dirs,omitempty"`
+	// FSID is the statfs filesystem id, stable across remounts of the same export
+	FSID string `json:"fsid,omitempty"`
+	// Inodes are the inode counts reported by statfs, nil when the server reports none
+	Inodes *InodeUsage `json:"inodes,omitempty"`
+	// NFSStats are the client's operation counters, recorded on request
+	NFSStats *NFSStats `json:"nfs_stats,omitempty"`
+	// Ganesha is what an NFS-Ganesha server reports about the export, on request
+	Ganesha *GaneshaInfo `json:"ganesha,omitempty"`
+}
+
+// GaneshaInfo is the server side of an export served by NFS-Ganesha
+type GaneshaInfo struct {
+	ExportID int `json:"export_id"`
+	// Used and Size are the export's usage measured on the server, nil when
+	// it could not be measured there
+	Used *int64 `json:"used,omitempty"`
+	Size *int64 `json:"size,omitempty"`
+	// ServerClients is the number of clients connected to the server, Ganesha
+	// doesn't report clients per export
+	ServerClients int `json:"server_clients"`
+}
+
+// InodeUsage is the used and free inode count of a filesystem
+type InodeUsage struct {
+	Used int64 `json:"used"`
+	Free int64 `json:"free"`
+}
+
+// ServerProbe records the outcome of a reachability probe against an NFS server
+type ServerProbe struct {
+	Address   string  `json:"address"`
+	Reachable bool    `json:"reachable"`
+	RPC       bool    `json:"rpc"`
+	LatencyMs float64 `json:"latency_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ProviderInfo describes a managed cloud filesystem backing an NFS mount
+type ProviderInfo struct {
+	Name         string `json:"name"`
+	FileSystemID string `json:"filesystem_id"`
+	Region       string `json:"region,omitempty"`
+	MeteredBytes int64  `json:"metered_bytes,omitempty"`
+}
+
+// TransportInfo describes how the client talks to the server for a mount
+type TransportInfo struct {
+	Version    string `json:"version,omitempty"`
+	Proto      string `json:"proto,omitempty"`
+	Nconnect   int    `json:"nconnect,omitempty"`
+	MaxConnect int    `json:"max_connect,omitempty"`
+	// Xprts is the number of live transports from mountstats (nconnect + trunks)
+	Xprts int `json:"xprts,omitempty"`
+}
+
+// IsSnapshotMount returns true if the mount path contains ".snapshot"
+func IsSnapshotMount(mountPoint string) bool {
+	return strings.Contains(mountPoint, ".snapshot")
+}
+
+// WithoutSnapshots returns a copy of the entry with .snapshot mounts removed
+// and the total recalculated
+func WithoutSnapshots(entry UsageEntry) UsageEntry {
+	filtered := UsageEntry{
+		Timestamp: entry.Timestamp,
+		Mounts:    make(map[string]int64),
+		Total:     0,
+	}
+	for mount, bytes := range entry.Mounts {
+		if !IsSnapshotMount(mount) {
+			filtered.Mounts[mount] = bytes
+			filtered.Total += bytes
+			if detail, ok := entry.Details[mount]; ok {
+				if filtered.Details == nil {
+					filtered.Details = make(map[string]MountDetail)
+				}
+				filtered.Details[mount] = detail
+			}
+		}
+	}
+	return filtered
+}
+
+// MountBytes returns a mount's usage in e, false when e is nil or lacks the mount
+func (e *UsageEntry) MountBytes(mount string) (int64, bool) {
+	if e == nil {
+		return 0, false
+	}
+	used, ok := e.Mounts[mount]
+	return used, ok
+}
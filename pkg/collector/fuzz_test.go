@@ -0,0 +1,123 @@
+package collector
+
+import (
+	"bytes"
+	"testing"
+)
+
+// FuzzParseMounts feeds arbitrary mount tables to ParseMounts. Seeds cover
+// the formats seen in practice: nfs and nfs4, long option strings, escaped
+// spaces and snapshot mounts.
+func FuzzParseMounts(f *testing.F) {
+	f.Add([]byte("nas1:/vol/home /mnt/home nfs4 rw,relatime,vers=4.1,rsize=1048576,wsize=1048576,namlen=255,hard,proto=tcp,nconnect=4,timeo=600,retrans=2,sec=sys,clientaddr=10.0.0.5,local_lock=none,addr=10.0.0.10 0 0\n"))
+	f.Add([]byte("nas2:/vol/arch /mnt/arch nfs ro,vers=3,proto=udp,mountaddr=10.0.0.11 0 0\nproc /proc proc rw 0 0\n"))
+	f.Add([]byte("nas1:/vol/home/.snapshot /mnt/home/.snapshot nfs4 ro 0 0\n"))
+	f.Add([]byte("nas3:/my\\040share /mnt/my\\040share nfs4 rw 0 0\n"))
+	f.Add([]byte("[fe80::1%eth0]:/export /mnt/v6 nfs4 rw\n"))
+	f.Add([]byte("a b nfs"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		mounts, err := ParseMounts(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		for _, m := range mounts {
+			if m.FSType != "nfs" && m.FSType != "nfs4" {
+				t.Errorf("non-NFS mount returned: %+v", m)
+			}
+			if m.Device == "" || m.MountPoint == "" {
+				t.Errorf("mount with empty fields: %+v", m)
+			}
+			if IsSnapshotMount(m.MountPoint) {
+				t.Errorf("snapshot mount returned: %+v", m)
+			}
+		}
+	})
+}
+
+func FuzzParseMountStats(f *testing.F) {
+	f.Add([]byte(`device nas1:/vol/home mounted on /mnt/home with fstype nfs4 statvers=1.1
+	opts:	rw,vers=4.1,rsize=1048576,wsize=1048576
+	bytes:	1000 2000 0 0 900 1800 10 20
+	RPC iostats version: 1.1  p/v: 100003/4 (nfs)
+	xprt:	tcp 0 1 0 0 3 100 100 0 100 0 2 0 0
+	per-op statistics
+	        NULL: 1 1 0 44 24 0 0 0 0
+	        READ: 50 52 1 6000 300000 3 120 130 0
+	       WRITE: 20 20 0 200000 2400 1 80 85 0
+device proc mounted on /proc with fstype proc
+`))
+	f.Add([]byte("device a mounted on /m with fstype nfs\n\tper-op statistics\n\tX: 1\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		stats, err := ParseMountStats(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		for _, s := range stats {
+			for _, op := range s.NFS.Ops {
+				if op.Ops <= 0 || op.Retrans < 0 {
+					t.Fatalf("invalid op counters %+v", op)
+				}
+			}
+		}
+	})
+}
+
+// FuzzParseGaneshaExports feeds arbitrary dbus-send replies to the Ganesha
+// parsers. Seeds are ShowExports and ShowClients replies and a dbus error.
+func FuzzParseGaneshaExports(f *testing.F) {
+	f.Add([]byte(`method return time=1700000000.000000 sender=:1.5 -> destination=:1.9 serial=12 reply_serial=2
+   struct {
+      uint64 1700000000
+      uint32 52000000
+   }
+   array [
+      struct {
+         uint16 0
+         string "/"
+         boolean false
+         boolean true
+         struct {
+            uint64 1699990000
+            uint32 0
+         }
+      }
+      struct {
+         uint16 100
+         string "/volumes/data"
+         boolean true
+         boolean true
+         struct {
+            uint64 1699990000
+            uint32 0
+         }
+      }
+   ]
+`))
+	f.Add([]byte(`method return time=1700000000.000000 sender=:1.5 -> destination=:1.9 serial=13 reply_serial=2
+   struct {
+      uint64 1700000000
+      uint32 52000000
+   }
+   array [
+      struct {
+         string "::ffff:10.0.0.5"
+         boolean true
+         boolean false
+      }
+   ]
+`))
+	f.Add([]byte("Error org.freedesktop.DBus.Error.ServiceUnknown: The name org.ganesha.nfsd was not provided\n"))
+	f.Add([]byte("array [\n struct {\n uint16 x\n }\n]\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if exports, err := ParseGaneshaExports(bytes.NewReader(data)); err == nil {
+			for _, e := range exports {
+				if e.ID < 0 || e.Path == "" {
+					t.Errorf("invalid export %+v", e)
+				}
+			}
+		}
+		if clients, err := ParseGaneshaClients(bytes.NewReader(data)); err == nil && clients < 0 {
+			t.Errorf("negative client count %d", clients)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// GaneshaExport is an export listed by NFS-Ganesha's ExportMgr.ShowExports
+type GaneshaExport struct {
+	ID   int
+	Path string
+}
+
+// dbusValue is one value of a dbus-send --print-reply, containers hold their
+// members in order
+type dbusValue struct {
+	Type    string
+	Value   string
+	Members []dbusValue
+}
+
+// parseDBusReply parses the output of dbus-send --print-reply into its
+// top-level values
+func parseDBusReply(r io.Reader) ([]dbusValue, error) {
+	root := &dbusValue{}
+	stack := []*dbusValue{root}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "method return") {
+			continue
+		}
+		if strings.HasPrefix(line, "Error ") {
+			return nil, fmt.Errorf("dbus: %s", line)
+		}
+		top := stack[len(stack)-1]
+		switch line {
+		case "struct {", "array [", "dict entry(":
+			top.Members = append(top.Members, dbusValue{Type: strings.Fields(line)[0]})
+			stack = append(stack, &top.Members[len(top.Members)-1])
+			continue
+		case "}", "]", ")":
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("unbalanced %q", line)
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		line = strings.TrimPrefix(line, "variant")
+		typ, value, _ := strings.Cut(strings.TrimSpace(line), " ")
+		if typ == "" {
+			continue
+		}
+		top.Members = append(top.Members, dbusValue{Type: typ, Value: strings.Trim(value, `"`)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("truncated reply")
+	}
+	return root.Members, nil
+}
+
+// dbusRecords returns the structs of the first top-level array, the records
+// of Ganesha's Show* replies, which start with a timestamp struct
+func dbusRecords(values []dbusValue) ([]dbusValue, error) {
+	for _, v := range values {
+		if v.Type == "array" {
+			return v.Members, nil
+		}
+	}
+	return nil, fmt.Errorf("no array in reply")
+}
+
+// ParseGaneshaExports parses the dbus-send reply of
+// org.ganesha.nfsd.exportmgr.ShowExports: per export its id, a uint16, and
+// its path, the first string
+func ParseGaneshaExports(r io.Reader) ([]GaneshaExport, error) {
+	values, err := parseDBusReply(r)
+	if err != nil {
+		return nil, err
+	}
+	records, err := dbusRecords(values)
+	if err != nil {
+		return nil, err
+	}
+	var exports []GaneshaExport
+	for _, record := range records {
+		if record.Type != "struct" {
+			continue
+		}
+		export := GaneshaExport{ID: -1}
+		for _, m := range record.Members {
+			switch {
+			case m.Type == "uint16" && export.ID < 0:
+				id, err := strconv.Atoi(m.Value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid export id %q", m.Value)
+				}
+				export.ID = id
+			case m.Type == "string" && export.Path == "":
+				export.Path = m.Value
+			}
+		}
+		if export.ID >= 0 && export.Path != "" {
+			exports = append(exports, export)
+		}
+	}
+	return exports, nil
+}
+
+// ParseGaneshaClients parses the dbus-send reply of
+// org.ganesha.nfsd.clientmgr.ShowClients and returns the number of clients
+func ParseGaneshaClients(r io.Reader) (int, error) {
+	values, err := parseDBusReply(r)
+	if err != nil {
+		return 0, err
+	}
+	records, err := dbusRecords(values)
+	if err != nil {
+		return 0, err
+	}
+	clients := 0
+	for _, record := range records {
+		if record.Type == "struct" {
+			clients++
+		}
+	}
+	return clients, nil
+}
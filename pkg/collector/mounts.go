@@ -0,0 +1,159 @@
+package collector
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Mount is a single NFS entry parsed from /proc/mounts
+type Mount struct {
+	Device     string
+	MountPoint string
+	FSType     string
+	Options    string
+	ServerAddr string
+}
+
+// ReadMounts parses /proc/mounts to find NFS mounts (excludes .snapshot mounts)
+func ReadMounts() ([]Mount, error) {
+	file, err := os.Open("/proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseMounts(file)
+}
+
+// ParseMounts parses a mount table in /proc/mounts format, returning the NFS
+// mounts except .snapshot mounts
+func ParseMounts(r io.Reader) ([]Mount, error) {
+	var mounts []Mount
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 {
+			fsType := fields[2]
+			mountPoint := fields[1]
+			if (fsType == "nfs" || fsType == "nfs4") && !IsSnapshotMount(mountPoint) {
+				mount := Mount{Device: fields[0], MountPoint: mountPoint, FSType: fsType}
+				if len(fields) >= 4 {
+					mount.Options = fields[3]
+				}
+				mounts = append(mounts, mount)
+			}
+		}
+	}
+
+	return mounts, scanner.Err()
+}
+
+// ServerHost returns the host part of an NFS device string (host:/export or [v6addr]:/export)
+func ServerHost(device string) string {
+	if strings.HasPrefix(device, "[") {
+		if i := strings.Index(device, "]"); i >= 0 {
+			return device[1:i]
+		}
+	}
+	if i := strings.Index(device, ":"); i >= 0 {
+		return device[:i]
+	}
+	return device
+}
+
+// ParseOptions splits a comma separated option string into key/value pairs
+func ParseOptions(options string) map[string]string {
+	opts := make(map[string]string)
+	for _, opt := range strings.Split(options, ",") {
+		if opt == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(opt, "=")
+		opts[key] = value
+	}
+	return opts
+}
+
+// TransportFromOptions extracts transport settings from mount options
+func TransportFromOptions(options string) *TransportInfo {
+	opts := ParseOptions(options)
+	info := &TransportInfo{
+		Version: opts["vers"],
+		Proto:   opts["proto"],
+	}
+	info.Nconnect, _ = strconv.Atoi(opts["nconnect"])
+	info.MaxConnect, _ = strconv.Atoi(opts["max_connect"])
+	return info
+}
+
+var (
+	// efsHostPattern also matches the zonal names of mount targets,
+	// <az>.fs-<id>.efs.<region>.amazonaws.com
+	efsHostPattern = regexp.MustCompile(`^(?:[a-z0-9-]+\.)?(fs-[0-9a-f]+)\.efs\.([a-z0-9-]+)\.amazonaws\.com$`)
+	fsxHostPattern = regexp.MustCompile(`(?:^|\.)(fs-[0-9a-f]+)\.fsx\.([a-z0-9-]+)\.amazonaws\.com$`)
+)
+
+// DetectProvider inspects the server part of an NFS device string (host:/export)
+// and returns provider metadata for AWS EFS and FSx mount targets, or nil
+func DetectProvider(device string) *ProviderInfo {
+	host := strings.ToLower(ServerHost(device))
+
+	if m := efsHostPattern.FindStringSubmatch(host); m != nil {
+		return &ProviderInfo{Name: "efs", FileSystemID: m[1], Region: m[2]}
+	}
+	if m := fsxHostPattern.FindStringSubmatch(host); m != nil {
+		return &ProviderInfo{Name: "fsx", FileSystemID: m[1], Region: m[2]}
+	}
+	return nil
+}
+
+// efsUtilsStateDir is where amazon-efs-utils keeps the state and stunnel
+// configs of its TLS mounts
+var efsUtilsStateDir = "/var/run/efs"
+
+// MountProvider returns provider metadata for a mount. Besides the server name
+// it recognizes amazon-efs-utils TLS mounts, which reach EFS through a local
+// stunnel: 127.0.0.1:/ with the stunnel's port in the mount options.
+func MountProvider(mount Mount) *ProviderInfo {
+	if p := DetectProvider(mount.Device); p != nil {
+		return p
+	}
+	port := ParseOptions(mount.Options)["port"]
+	if ServerHost(mount.Device) != "127.0.0.1" || port == "" {
+		return nil
+	}
+	return efsUtilsProvider(efsUtilsStateDir, port)
+}
+
+// efsUtilsProvider detects the file system an efs-utils mount on port connects
+// to from its stunnel config, falling back to the state file name, which
+// starts with the file system id but lacks the region
+func efsUtilsProvider(dir, port string) *ProviderInfo {
+	configs, _ := filepath.Glob(filepath.Join(dir, "stunnel-config.fs-*."+port))
+	for _, path := range configs {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			key, value, ok := strings.Cut(line, "=")
+			if key = strings.TrimSpace(key); !ok || (key != "connect" && key != "checkHost") {
+				continue
+			}
+			if p := DetectProvider(strings.TrimSpace(value)); p != nil {
+				return p
+			}
+		}
+	}
+	states, _ := filepath.Glob(filepath.Join(dir, "fs-*."+port))
+	if len(states) == 0 {
+		return nil
+	}
+	id, _, _ := strings.Cut(filepath.Base(states[0]), ".")
+	return &ProviderInfo{Name: "efs", FileSystemID: id}
+}
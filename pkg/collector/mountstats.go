@@ -0,0 +1,155 @@
+package collector
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// mountStatsPath is the kernel's per-mount NFS client statistics
+const mountStatsPath = "/proc/self/mountstats"
+
+// NFSStats are the NFS client counters of a mount from mountstats. They count
+// from when the mount was made, so usage between two entries is their
+// difference, and a remount starts them again from zero.
+type NFSStats struct {
+	// ReadBytes and WriteBytes are the bytes read from and written to the
+	// server, the server fields of the bytes: line
+	ReadBytes  int64 `json:"read_bytes"`
+	WriteBytes int64 `json:"write_bytes"`
+	// Ops are the counters of each operation the mount has issued, by name
+	Ops map[string]NFSOpStats `json:"ops,omitempty"`
+}
+
+// NFSOpStats are the counters of one NFS operation
+type NFSOpStats struct {
+	Ops      int64 `json:"ops"`
+	Retrans  int64 `json:"retrans,omitempty"`
+	Timeouts int64 `json:"timeouts,omitempty"`
+	// RTTMillis and ExecMillis are the summed round trip and total execution
+	// times, divide by Ops for the average
+	RTTMillis  int64 `json:"rtt_ms"`
+	ExecMillis int64 `json:"exec_ms"`
+}
+
+// Totals sums the counters of every operation
+func (s *NFSStats) Totals() NFSOpStats {
+	var t NFSOpStats
+	for _, op := range s.Ops {
+		t.Ops += op.Ops
+		t.Retrans += op.Retrans
+		t.Timeouts += op.Timeouts
+		t.RTTMillis += op.RTTMillis
+		t.ExecMillis += op.ExecMillis
+	}
+	return t
+}
+
+// MountStats is what collection takes from one mount's mountstats block
+type MountStats struct {
+	// Xprts is the number of live transports (nconnect + trunks)
+	Xprts int
+	NFS   *NFSStats
+}
+
+// ReadMountStats parses the kernel's mountstats, keyed by mount point
+func ReadMountStats() (map[string]*MountStats, error) {
+	file, err := os.Open(mountStatsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseMountStats(file)
+}
+
+// ParseMountStats parses mountstats content. Only NFS mounts have statistics,
+// other devices are skipped, as are malformed lines.
+func ParseMountStats(r io.Reader) (map[string]*MountStats, error) {
+	stats := make(map[string]*MountStats)
+	var current *MountStats
+	inOps := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "device ") {
+			// device server:/export mounted on /mnt/data with fstype nfs4 statvers=1.1
+			current, inOps = nil, false
+			fields := strings.Fields(line)
+			if len(fields) >= 8 && fields[2] == "mounted" && fields[3] == "on" && strings.HasPrefix(fields[7], "nfs") {
+				current = &MountStats{NFS: &NFSStats{}}
+				stats[fields[4]] = current
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case fields[0] == "xprt:":
+			current.Xprts++
+		case fields[0] == "bytes:" && len(fields) >= 7:
+			// normalread normalwrite directread directwrite serverread serverwrite ...
+			current.NFS.ReadBytes, _ = strconv.ParseInt(fields[5], 10, 64)
+			current.NFS.WriteBytes, _ = strconv.ParseInt(fields[6], 10, 64)
+		case fields[0] == "per-op":
+			inOps = true
+		case inOps && strings.HasSuffix(fields[0], ":") && len(fields) >= 9:
+			// OP: ops trans timeouts bytes_sent bytes_recv queue_ms rtt_ms exec_ms [errors]
+			var n [8]int64
+			for i := range n {
+				n[i], _ = strconv.ParseInt(fields[i+1], 10, 64)
+			}
+			if n[0] == 0 {
+				continue
+			}
+			if current.NFS.Ops == nil {
+				current.NFS.Ops = make(map[string]NFSOpStats)
+			}
+			current.NFS.Ops[strings.TrimSuffix(fields[0], ":")] = NFSOpStats{
+				Ops:        n[0],
+				Retrans:    max(n[1]-n[0], 0),
+				Timeouts:   n[2],
+				RTTMillis:  n[6],
+				ExecMillis: n[7],
+			}
+		}
+	}
+	return stats, scanner.Err()
+}
+
+// StatsDelta returns the counters accumulated between base and current, or
+// ok=false when base has none or the mount was remounted in between
+func StatsDelta(current, base *NFSStats) (NFSStats, bool) {
+	if base == nil {
+		return NFSStats{}, false
+	}
+	delta := NFSStats{
+		ReadBytes:  current.ReadBytes - base.ReadBytes,
+		WriteBytes: current.WriteBytes - base.WriteBytes,
+		Ops:        make(map[string]NFSOpStats, len(current.Ops)),
+	}
+	if delta.ReadBytes < 0 || delta.WriteBytes < 0 {
+		return NFSStats{}, false
+	}
+	for name, op := range current.Ops {
+		prev := base.Ops[name]
+		d := NFSOpStats{
+			Ops:        op.Ops - prev.Ops,
+			Retrans:    op.Retrans - prev.Retrans,
+			Timeouts:   op.Timeouts - prev.Timeouts,
+			RTTMillis:  op.RTTMillis - prev.RTTMillis,
+			ExecMillis: op.ExecMillis - prev.ExecMillis,
+		}
+		if d.Ops < 0 {
+			return NFSStats{}, false
+		}
+		delta.Ops[name] = d
+	}
+	return delta, true
+}
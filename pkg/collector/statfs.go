@@ -0,0 +1,44 @@
+package collector
+
+import (
+	"fmt"
+	"math"
+
+	"golang.org/x/sys/unix"
+)
+
+// Usage is what statfs reports for one filesystem
+type Usage struct {
+	Used, Available, Size  int64
+	InodesUsed, InodesFree int64
+	// FSID identifies the filesystem, for NFS derived from the server's fsid
+	FSID string
+}
+
+// Statfs measures the filesystem mounted at mountPoint, computed like df:
+// used is total minus free blocks, available is what unprivileged users can
+// still write and size is the total
+func Statfs(mountPoint string) (Usage, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(mountPoint, &st); err != nil {
+		return Usage{}, err
+	}
+	// The field types vary by architecture
+	size := int64(st.Frsize)
+	if size == 0 {
+		size = int64(st.Bsize)
+	}
+	usage := Usage{
+		Used:      int64(st.Blocks-st.Bfree) * size,
+		Available: int64(st.Bavail) * size,
+		Size:      int64(st.Blocks) * size,
+	}
+	// Some servers report no inodes (0) or a meaningless maximum
+	if st.Files > 0 && st.Files <= math.MaxInt64 && st.Ffree <= st.Files {
+		usage.InodesUsed, usage.InodesFree = int64(st.Files-st.Ffree), int64(st.Ffree)
+	}
+	if st.Fsid.Val != [2]int32{} {
+		usage.FSID = fmt.Sprintf("%08x%08x", uint32(st.Fsid.Val[0]), uint32(st.Fsid.Val[1]))
+	}
+	return usage, nil
+}
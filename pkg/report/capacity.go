@@ -0,0 +1,42 @@
+package report
+
+import (
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+// CapacityBytes returns a mount's total capacity, computed as used plus
+// available for entries recorded before the size was
+func CapacityBytes(entry collector.UsageEntry, mount string) (int64, bool) {
+	detail := entry.Details[mount]
+	if detail.Size != nil {
+		return *detail.Size, true
+	}
+	if detail.Available == nil {
+		return 0, false
+	}
+	return entry.Mounts[mount] + *detail.Available, true
+}
+
+// UsedPercent returns a mount's used share of its capacity, false for mounts
+// without a meaningful capacity (elastic, or recorded before free space was).
+// Like df, blocks reserved for root don't count towards the capacity.
+func UsedPercent(entry collector.UsageEntry, mount string) (float64, bool) {
+	avail := entry.Details[mount].Available
+	if avail == nil {
+		return 0, false
+	}
+	capacity := entry.Mounts[mount] + *avail
+	if capacity <= 0 {
+		return 0, false
+	}
+	return float64(entry.Mounts[mount]) / float64(capacity) * 100, true
+}
+
+// TrashBytes sums a mount's measured trash
+func TrashBytes(detail collector.MountDetail) int64 {
+	var sum int64
+	for _, size := range detail.Trash {
+		sum += size
+	}
+	return sum
+}
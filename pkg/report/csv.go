@@ -0,0 +1,70 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+// CSV renders the current entry as one row per mount with raw byte
+// values, with baseline columns when comparing against base
+func CSV(current collector.UsageEntry, base *collector.UsageEntry) ([]byte, error) {
+	header := []string{"timestamp", "time", "mount", "device", "server", "used_bytes", "available_bytes", "size_bytes", "used_percent", "inodes_used", "inodes_free"}
+	if base != nil {
+		header = append(header, "baseline_timestamp", "baseline_bytes", "diff_bytes", "removed")
+	}
+	mounts := make(map[string]bool)
+	for mount := range current.Mounts {
+		mounts[mount] = true
+	}
+	if base != nil {
+		for mount := range base.Mounts {
+			mounts[mount] = true
+		}
+	}
+	names := make([]string, 0, len(mounts))
+	for mount := range mounts {
+		names = append(names, mount)
+	}
+	sort.Strings(names)
+
+	optional := func(n *int64) string {
+		if n == nil {
+			return ""
+		}
+		return strconv.FormatInt(*n, 10)
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(header)
+	ts := strconv.FormatInt(current.Timestamp, 10)
+	when := time.Unix(current.Timestamp, 0).UTC().Format(time.RFC3339)
+	for _, mount := range names {
+		used, ok := current.Mounts[mount]
+		row := []string{ts, when, mount, "", "", "", "", "", "", "", ""}
+		if ok {
+			detail := current.Details[mount]
+			row[3], row[4], row[5], row[6] = detail.Device, detail.Server, strconv.FormatInt(used, 10), optional(detail.Available)
+			if size, ok := CapacityBytes(current, mount); ok {
+				row[7] = strconv.FormatInt(size, 10)
+			}
+			if pct, ok := UsedPercent(current, mount); ok {
+				row[8] = strconv.FormatFloat(pct, 'f', 1, 64)
+			}
+			if detail.Inodes != nil {
+				row[9], row[10] = strconv.FormatInt(detail.Inodes.Used, 10), strconv.FormatInt(detail.Inodes.Free, 10)
+			}
+		}
+		if base != nil {
+			old := base.Mounts[mount]
+			row = append(row, strconv.FormatInt(base.Timestamp, 10), strconv.FormatInt(old, 10), strconv.FormatInt(used-old, 10), strconv.FormatBool(!ok))
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
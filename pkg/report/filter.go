@@ -0,0 +1,479 @@
+package report
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// Filter is a filter expression evaluated against each mount of an entry, such as server=="filer01" && pct_used>80. It supports ==, !=, <, <=,
+// >, >=, =~ (regex), &&, ||, ! and parentheses over the fields in
+// filterFields, with "quoted" strings, numbers, sizes like 1TiB, and true and
+// false. A field a mount doesn't have, such as pct_used of an elastic
+// filesystem, fails every comparison.
+type Filter struct {
+	expr filterNode
+}
+
+// filterFields are the per-mount fields a filter can use
+var filterFields = map[string]func(r mountRecord) interface{}{
+	"host":   func(r mountRecord) interface{} { return r.host },
+	"mount":  func(r mountRecord) interface{} { return r.mount },
+	"device": func(r mountRecord) interface{} { return r.detail.Device },
+	"server": func(r mountRecord) interface{} { return r.detail.Server },
+	"fsid":   func(r mountRecord) interface{} { return r.detail.FSID },
+	"used":   func(r mountRecord) interface{} { return float64(r.used) },
+	"avail": func(r mountRecord) interface{} {
+		if r.detail.Available == nil {
+			return nil
+		}
+		return float64(*r.detail.Available)
+	},
+	"size": func(r mountRecord) interface{} {
+		if r.detail.Size == nil {
+			return nil
+		}
+		return float64(*r.detail.Size)
+	},
+	"pct_used": func(r mountRecord) interface{} {
+		if r.detail.Available == nil || r.used+*r.detail.Available <= 0 {
+			return nil
+		}
+		return float64(r.used) / float64(r.used+*r.detail.Available) * 100
+	},
+	"inodes_used": func(r mountRecord) interface{} {
+		if r.detail.Inodes == nil {
+			return nil
+		}
+		return float64(r.detail.Inodes.Used)
+	},
+	"inodes_free": func(r mountRecord) interface{} {
+		if r.detail.Inodes == nil {
+			return nil
+		}
+		return float64(r.detail.Inodes.Free)
+	},
+	"pct_inodes": func(r mountRecord) interface{} {
+		if r.detail.Inodes == nil || r.detail.Inodes.Used+r.detail.Inodes.Free == 0 {
+			return nil
+		}
+		return float64(r.detail.Inodes.Used) / float64(r.detail.Inodes.Used+r.detail.Inodes.Free) * 100
+	},
+	"read_only": func(r mountRecord) interface{} { return r.detail.ReadOnly },
+	"elastic":   func(r mountRecord) interface{} { return r.detail.Elastic },
+}
+
+// mountRecord is one mount of an entry as a filter sees it
+type mountRecord struct {
+	host, mount string
+	used        int64
+	detail      collector.MountDetail
+}
+
+// filterNode is a parsed filter expression
+type filterNode interface {
+	eval(r mountRecord) interface{}
+}
+
+type (
+	filterField   string
+	filterLiteral struct{ value interface{} }
+	filterNot     struct{ x filterNode }
+	filterLogic   struct {
+		op   string
+		x, y filterNode
+	}
+	filterCompare struct {
+		op   string
+		x, y filterNode
+		re   *regexp.Regexp
+	}
+)
+
+func (f filterField) eval(r mountRecord) interface{}   { return filterFields[string(f)](r) }
+func (l filterLiteral) eval(r mountRecord) interface{} { return l.value }
+
+func (n filterNot) eval(r mountRecord) interface{} {
+	b, _ := n.x.eval(r).(bool)
+	return !b
+}
+
+func (l filterLogic) eval(r mountRecord) interface{} {
+	x, _ := l.x.eval(r).(bool)
+	if l.op == "&&" && !x || l.op == "||" && x {
+		return x
+	}
+	y, _ := l.y.eval(r).(bool)
+	return y
+}
+
+func (c filterCompare) eval(r mountRecord) interface{} {
+	x, y := c.x.eval(r), c.y.eval(r)
+	if x == nil || y == nil {
+		return false
+	}
+	if c.re != nil {
+		s, ok := x.(string)
+		return ok && c.re.MatchString(s)
+	}
+	switch x := x.(type) {
+	case float64:
+		y, ok := y.(float64)
+		if !ok {
+			return false
+		}
+		switch c.op {
+		case "==":
+			return x == y
+		case "!=":
+			return x != y
+		case "<":
+			return x < y
+		case "<=":
+			return x <= y
+		case ">":
+			return x > y
+		case ">=":
+			return x >= y
+		}
+	case string:
+		y, ok := y.(string)
+		if !ok {
+			return false
+		}
+		switch c.op {
+		case "==":
+			return x == y
+		case "!=":
+			return x != y
+		case "<":
+			return x < y
+		case "<=":
+			return x <= y
+		case ">":
+			return x > y
+		case ">=":
+			return x >= y
+		}
+	case bool:
+		y, ok := y.(bool)
+		if !ok {
+			return false
+		}
+		switch c.op {
+		case "==":
+			return x == y
+		case "!=":
+			return x != y
+		}
+	}
+	return false
+}
+
+// filterParser is a recursive descent parser over the tokens of a filter
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+// ParseFilter parses a filter expression, nil for an empty one
+func ParseFilter(src string) (*Filter, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, nil
+	}
+	tokens, err := tokenizeFilter(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if _, ok := expr.(filterLiteral); ok {
+		return nil, fmt.Errorf("filter %q compares no field", src)
+	}
+	return &Filter{expr: expr}, nil
+}
+
+// tokenizeFilter splits a filter into operators, parentheses, quoted strings
+// (kept with their quotes) and words
+func tokenizeFilter(src string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, src[i:j+1])
+			i = j + 1
+		case strings.ContainsRune("()", rune(c)):
+			tokens = append(tokens, string(c))
+			i++
+		case strings.ContainsRune("=!<>&|~", rune(c)):
+			op := string(c)
+			if i+1 < len(src) {
+				if two := src[i : i+2]; two == "==" || two == "!=" || two == "<=" || two == ">=" || two == "&&" || two == "||" || two == "=~" {
+					op = two
+				}
+			}
+			if op == "=" || op == "&" || op == "|" || op == "~" {
+				return nil, fmt.Errorf("unknown operator %q at offset %d", op, i)
+			}
+			tokens = append(tokens, op)
+			i += len(op)
+		default:
+			j := i
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || strings.ContainsRune("_.-/:", rune(src[j]))) {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+			}
+			tokens = append(tokens, src[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *filterParser) or() (filterNode, error) {
+	x, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.pos++
+		var y filterNode
+		if y, err = p.and(); err == nil {
+			x = filterLogic{"||", x, y}
+		}
+	}
+	return x, err
+}
+
+func (p *filterParser) and() (filterNode, error) {
+	x, err := p.unary()
+	for err == nil && p.peek() == "&&" {
+		p.pos++
+		var y filterNode
+		if y, err = p.unary(); err == nil {
+			x = filterLogic{"&&", x, y}
+		}
+	}
+	return x, err
+}
+
+func (p *filterParser) unary() (filterNode, error) {
+	switch p.peek() {
+	case "!":
+		p.pos++
+		x, err := p.unary()
+		return filterNot{x}, err
+	case "(":
+		p.pos++
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return x, nil
+	}
+	x, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek()
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "=~":
+		p.pos++
+	default:
+		// A bare boolean field such as read_only
+		return x, nil
+	}
+	y, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	c := filterCompare{op: op, x: x, y: y}
+	if op == "=~" {
+		lit, ok := y.(filterLiteral)
+		pattern, isString := lit.value.(string)
+		if !ok || !isString {
+			return nil, fmt.Errorf("=~ needs a quoted regular expression")
+		}
+		if c.re, err = regexp.Compile(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// operand parses a field name or a literal
+func (p *filterParser) operand() (filterNode, error) {
+	tok := p.peek()
+	if tok == "" {
+		return nil, fmt.Errorf("unexpected end of filter")
+	}
+	p.pos++
+	switch {
+	case strings.HasPrefix(tok, `"`):
+		s, err := strconv.Unquote(tok)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", tok)
+		}
+		return filterLiteral{s}, nil
+	case tok == "true" || tok == "false":
+		return filterLiteral{tok == "true"}, nil
+	case filterFields[tok] != nil:
+		return filterField(tok), nil
+	case tok[0] >= '0' && tok[0] <= '9':
+		if n, err := strconv.ParseFloat(tok, 64); err == nil {
+			return filterLiteral{n}, nil
+		}
+		n, err := ParseSize(tok)
+		if err != nil {
+			return nil, err
+		}
+		return filterLiteral{float64(n)}, nil
+	}
+	return nil, fmt.Errorf("unknown field %q (known: %s)", tok, filterFieldNames())
+}
+
+// filterFieldNames lists the fields a filter can use
+func filterFieldNames() string {
+	names := make([]string, 0, len(filterFields))
+	for name := range filterFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Match reports whether one mount of entry passes the filter
+func (f *Filter) Match(entry collector.UsageEntry, mount string) bool {
+	r := mountRecord{host: entry.Host, mount: mount, used: entry.Mounts[mount], detail: entry.Details[mount]}
+	b, _ := f.expr.eval(r).(bool)
+	return b
+}
+
+// Entry keeps the mounts of e that pass the filter, with the total
+// recalculated. Snapshot space is kept along with its mount. A nil filter
+// keeps every mount.
+func (f *Filter) Entry(e collector.UsageEntry) collector.UsageEntry {
+	if f == nil {
+		return e
+	}
+	filtered := e
+	filtered.Mounts = make(map[string]int64, len(e.Mounts))
+	filtered.Details = make(map[string]collector.MountDetail, len(e.Details))
+	filtered.Total = 0
+	for mount, used := range e.Mounts {
+		if collector.IsSnapshotMount(mount) || !f.Match(e, mount) {
+			continue
+		}
+		filtered.Mounts[mount] = used
+		if detail, ok := e.Details[mount]; ok {
+			filtered.Details[mount] = detail
+		}
+		filtered.Total += used
+	}
+	for mount, used := range e.Mounts {
+		if _, ok := filtered.Mounts[path.Dir(mount)]; ok && collector.IsSnapshotMount(mount) {
+			filtered.Mounts[mount] = used
+		}
+	}
+	return filtered
+}
+
+// Baseline filters the entry a comparison is made against so it lines up with
+// the filtered current entry: mounts shown now are kept whatever their
+// baseline values, mounts gone since are kept when their last values pass.
+func (f *Filter) Baseline(base, current collector.UsageEntry, shown collector.UsageEntry) collector.UsageEntry {
+	if f == nil {
+		return base
+	}
+	kept := f.Entry(base)
+	for mount, used := range base.Mounts {
+		_, isShown := shown.Mounts[mount]
+		_, isCurrent := current.Mounts[mount]
+		if _, ok := kept.Mounts[mount]; ok && isCurrent && !isShown {
+			delete(kept.Mounts, mount)
+			delete(kept.Details, mount)
+			if !collector.IsSnapshotMount(mount) {
+				kept.Total -= used
+			}
+		} else if !ok && isShown {
+			kept.Mounts[mount] = used
+			if detail, ok := base.Details[mount]; ok {
+				kept.Details[mount] = detail
+			}
+			if !collector.IsSnapshotMount(mount) {
+				kept.Total += used
+			}
+		}
+	}
+	return kept
+}
+
+// filterStore reads a store with a filter applied to every entry
+type filterStore struct {
+	store.Store
+	filter *Filter
+}
+
+// WithFilter wraps st so reads only see mounts passing f, st itself for nil
+func WithFilter(st store.Store, f *Filter) store.Store {
+	if f == nil {
+		return st
+	}
+	return &filterStore{Store: st, filter: f}
+}
+
+func (s *filterStore) Load() ([]collector.UsageEntry, error) {
+	entries, err := s.Store.Load()
+	for i := range entries {
+		entries[i] = s.filter.Entry(entries[i])
+	}
+	return entries, err
+}
+
+func (s *filterStore) Scan(fn func(collector.UsageEntry) error) error {
+	return s.Store.Scan(func(e collector.UsageEntry) error {
+		return fn(s.filter.Entry(e))
+	})
+}
+
+func (s *filterStore) ScanRange(from, to int64, fn func(collector.UsageEntry) error) error {
+	return store.ScanRange(s.Store, from, to, func(e collector.UsageEntry) error {
+		return fn(s.filter.Entry(e))
+	})
+}
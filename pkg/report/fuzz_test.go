@@ -0,0 +1,26 @@
+package report
+
+import (
+	"testing"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+func FuzzParseFilter(f *testing.F) {
+	f.Add(`server=="filer01" && pct_used>80`)
+	f.Add(`!(mount =~ "^/scratch") || used >= 1.5TiB`)
+	f.Add(`read_only && (inodes_free < 1000 || elastic == false)`)
+	f.Add(`"a" == `)
+	avail := int64(100)
+	entry := collector.UsageEntry{
+		Mounts:  map[string]int64{"/mnt/a": 300, "/mnt/a/.snapshot": 5},
+		Details: map[string]collector.MountDetail{"/mnt/a": {Server: "filer01", Available: &avail}},
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		filter, err := ParseFilter(src)
+		if err != nil || filter == nil {
+			return
+		}
+		filter.Entry(entry)
+	})
+}
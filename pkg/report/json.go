@@ -0,0 +1,144 @@
+// Package report renders usage entries for other programs: the JSON
+// document described by the embedded schema, CSV and the Prometheus text
+// exposition format. It also holds what reports compute from a history:
+// mount filters and growth rates.
+package report
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+
+	_ "embed"
+)
+
+// SchemaVersion is the major version of the --output json contract.
+// Fields may be added without bumping it; it only changes when a field is
+// removed, renamed or changes meaning, and nfsusage.schema.json changes with it.
+const SchemaVersion = 1
+
+// Schema is the JSON Schema describing --output json, printed by 'nfsusage schema'
+//
+//go:embed nfsusage.schema.json
+var Schema string
+
+// Document is the document written by --output json
+type Document struct {
+	SchemaVersion int      `json:"schema_version"`
+	Timestamp     int64    `json:"timestamp"`
+	Time          string   `json:"time"`
+	TotalBytes    int64    `json:"total_bytes"`
+	Mounts        []Mount  `json:"mounts"`
+	Partial       bool     `json:"partial"`
+	Missing       []string `json:"missing,omitempty"`
+	Absent        []string `json:"absent,omitempty"`
+	Stale         []string `json:"stale,omitempty"`
+	// Unmeasured and Unavailable give the reason per mount or data source
+	Unmeasured  map[string]string `json:"unmeasured,omitempty"`
+	Unavailable map[string]string `json:"unavailable,omitempty"`
+	Baseline    *Baseline         `json:"baseline,omitempty"`
+}
+
+// Mount is one mount in --output json
+type Mount struct {
+	Mount          string `json:"mount"`
+	Device         string `json:"device,omitempty"`
+	Server         string `json:"server,omitempty"`
+	UsedBytes      int64  `json:"used_bytes"`
+	AvailableBytes *int64 `json:"available_bytes,omitempty"`
+	// SizeBytes and UsedPercent are absent for elastic filesystems
+	SizeBytes   *int64   `json:"size_bytes,omitempty"`
+	UsedPercent *float64 `json:"used_percent,omitempty"`
+	Elastic     bool     `json:"elastic"`
+	ReadOnly    bool     `json:"read_only,omitempty"`
+	// TrashBytes is the measured trash, only with measure_trash
+	TrashBytes *int64 `json:"trash_bytes,omitempty"`
+	// InodesUsed and InodesFree are set when the server reports inodes
+	InodesUsed *int64 `json:"inodes_used,omitempty"`
+	InodesFree *int64 `json:"inodes_free,omitempty"`
+	// Set when comparing: usage at the baseline and the change since
+	BaselineBytes *int64 `json:"baseline_bytes,omitempty"`
+	DiffBytes     *int64 `json:"diff_bytes,omitempty"`
+	Removed       bool   `json:"removed,omitempty"`
+}
+
+// Baseline describes the entry a comparison was made against
+type Baseline struct {
+	Timestamp  int64  `json:"timestamp"`
+	Time       string `json:"time"`
+	TotalBytes int64  `json:"total_bytes"`
+	DiffBytes  int64  `json:"diff_bytes"`
+}
+
+// JSON renders the current entry, compared against base when it is not nil
+func JSON(current collector.UsageEntry, base *collector.UsageEntry) ([]byte, error) {
+	return json.MarshalIndent(NewDocument(current, base), "", "  ")
+}
+
+// NewDocument builds the --output json document
+func NewDocument(current collector.UsageEntry, base *collector.UsageEntry) Document {
+	out := Document{
+		SchemaVersion: SchemaVersion,
+		Timestamp:     current.Timestamp,
+		Time:          time.Unix(current.Timestamp, 0).UTC().Format(time.RFC3339),
+		TotalBytes:    current.Total,
+		Mounts:        []Mount{},
+		Partial:       current.Partial,
+		Missing:       current.Missing,
+		Absent:        current.Absent,
+		Stale:         current.Stale,
+		Unmeasured:    current.Unmeasured,
+		Unavailable:   current.Unavailable,
+	}
+	for mount, used := range current.Mounts {
+		detail := current.Details[mount]
+		m := Mount{
+			Mount:          mount,
+			Device:         detail.Device,
+			Server:         detail.Server,
+			UsedBytes:      used,
+			AvailableBytes: detail.Available,
+			Elastic:        detail.Elastic,
+			ReadOnly:       detail.ReadOnly,
+		}
+		if size, ok := CapacityBytes(current, mount); ok {
+			m.SizeBytes = &size
+		}
+		if pct, ok := UsedPercent(current, mount); ok {
+			pct = math.Round(pct*10) / 10
+			m.UsedPercent = &pct
+		}
+		if detail.Trash != nil {
+			trash := TrashBytes(detail)
+			m.TrashBytes = &trash
+		}
+		if detail.Inodes != nil {
+			m.InodesUsed, m.InodesFree = &detail.Inodes.Used, &detail.Inodes.Free
+		}
+		if base != nil {
+			old := base.Mounts[mount]
+			diff := used - old
+			m.BaselineBytes, m.DiffBytes = &old, &diff
+		}
+		out.Mounts = append(out.Mounts, m)
+	}
+	if base != nil {
+		for mount, old := range base.Mounts {
+			if _, ok := current.Mounts[mount]; !ok {
+				old, diff := old, -old
+				out.Mounts = append(out.Mounts, Mount{Mount: mount, BaselineBytes: &old, DiffBytes: &diff, Removed: true})
+			}
+		}
+		out.Baseline = &Baseline{
+			Timestamp:  base.Timestamp,
+			Time:       time.Unix(base.Timestamp, 0).UTC().Format(time.RFC3339),
+			TotalBytes: base.Total,
+			DiffBytes:  current.Total - base.Total,
+		}
+	}
+	sort.Slice(out.Mounts, func(i, j int) bool { return out.Mounts[i].Mount < out.Mounts[j].Mount })
+	return out
+}
@@ -0,0 +1,74 @@
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+// Metrics renders an entry in the Prometheus text exposition format
+func Metrics(entry collector.UsageEntry) string {
+	mounts := make([]string, 0, len(entry.Mounts))
+	for mount := range entry.Mounts {
+		mounts = append(mounts, mount)
+	}
+	sort.Strings(mounts)
+
+	var b strings.Builder
+	b.WriteString("# HELP nfsusage_used_bytes Used bytes per NFS mount.\n")
+	b.WriteString("# TYPE nfsusage_used_bytes gauge\n")
+	for _, mount := range mounts {
+		fmt.Fprintf(&b, "nfsusage_used_bytes{%s} %d\n", MetricLabels(mount, entry.Details[mount]), entry.Mounts[mount])
+	}
+	b.WriteString("# HELP nfsusage_free_bytes Bytes available to unprivileged users per NFS mount, not for elastic filesystems.\n")
+	b.WriteString("# TYPE nfsusage_free_bytes gauge\n")
+	for _, mount := range mounts {
+		if avail := entry.Details[mount].Available; avail != nil {
+			fmt.Fprintf(&b, "nfsusage_free_bytes{%s} %d\n", MetricLabels(mount, entry.Details[mount]), *avail)
+		}
+	}
+	b.WriteString("# HELP nfsusage_size_bytes Capacity per NFS mount, not for elastic filesystems.\n")
+	b.WriteString("# TYPE nfsusage_size_bytes gauge\n")
+	for _, mount := range mounts {
+		if size, ok := CapacityBytes(entry, mount); ok {
+			fmt.Fprintf(&b, "nfsusage_size_bytes{%s} %d\n", MetricLabels(mount, entry.Details[mount]), size)
+		}
+	}
+	b.WriteString("# HELP nfsusage_inodes_used Used inodes per NFS mount.\n")
+	b.WriteString("# TYPE nfsusage_inodes_used gauge\n")
+	for _, mount := range mounts {
+		if inodes := entry.Details[mount].Inodes; inodes != nil {
+			fmt.Fprintf(&b, "nfsusage_inodes_used{%s} %d\n", MetricLabels(mount, entry.Details[mount]), inodes.Used)
+		}
+	}
+	b.WriteString("# HELP nfsusage_inodes_free Free inodes per NFS mount.\n")
+	b.WriteString("# TYPE nfsusage_inodes_free gauge\n")
+	for _, mount := range mounts {
+		if inodes := entry.Details[mount].Inodes; inodes != nil {
+			fmt.Fprintf(&b, "nfsusage_inodes_free{%s} %d\n", MetricLabels(mount, entry.Details[mount]), inodes.Free)
+		}
+	}
+	b.WriteString("# HELP nfsusage_total_used_bytes Used bytes summed over all NFS mounts.\n")
+	b.WriteString("# TYPE nfsusage_total_used_bytes gauge\n")
+	fmt.Fprintf(&b, "nfsusage_total_used_bytes %d\n", entry.Total)
+	b.WriteString("# HELP nfsusage_last_collection_timestamp_seconds Unix time of the last collection.\n")
+	b.WriteString("# TYPE nfsusage_last_collection_timestamp_seconds gauge\n")
+	fmt.Fprintf(&b, "nfsusage_last_collection_timestamp_seconds %d\n", entry.Timestamp)
+	return b.String()
+}
+
+// MetricLabels renders the label set identifying a mount
+func MetricLabels(mount string, detail collector.MountDetail) string {
+	labels := fmt.Sprintf(`mount="%s"`, EscapeLabel(mount))
+	if detail.Server != "" {
+		labels += fmt.Sprintf(`,server="%s"`, EscapeLabel(detail.Server))
+	}
+	return labels
+}
+
+// EscapeLabel escapes a Prometheus label value
+func EscapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,214 @@
+package report
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// Gap policies for rate calculations
+const (
+	GapsSkip        = "skip"
+	GapsInterpolate = "interpolate"
+	GapsFlag        = "flag"
+)
+
+// ValidGapPolicy reports whether policy is one of the gap policies
+func ValidGapPolicy(policy string) bool {
+	return policy == GapsSkip || policy == GapsInterpolate || policy == GapsFlag
+}
+
+// Smoothing methods for rate calculations
+const (
+	SmoothEMA     = "ema"
+	SmoothRolling = "rolling"
+)
+
+// Smoothing damps short-lived spikes in usage before rates are computed,
+// so a large temporary file doesn't dominate the growth rate and fill date.
+// ema is an exponential moving average with window as its time constant,
+// rolling the mean of the samples within the last window. A zero window uses
+// the raw samples.
+type Smoothing struct {
+	Method string
+	Window int64
+}
+
+// usageSample is one raw sample kept for the rolling mean
+type usageSample struct {
+	timestamp int64
+	bytes     int64
+}
+
+// Rate is a mount's growth rate over a window
+type Rate struct {
+	Mount   string
+	Samples int
+	// PerDay is the growth rate in bytes per day
+	PerDay float64
+	Gaps   int
+	// Flagged is set when the rate spans gaps under the flag policy
+	Flagged bool
+	// Free is the free space at the newest sample, nil when unknown
+	Free *int64
+}
+
+// rateState accumulates one mount's series while streaming the history
+type rateState struct {
+	Rate
+	firstTS, lastTS       int64
+	firstBytes, lastBytes int64
+	// spanSecs is the time from the first to the last sample, coveredDelta
+	// and coveredSecs only include intervals without gaps
+	spanSecs                  int64
+	coveredDelta, coveredSecs int64
+	// ema and recent hold the smoothing state
+	ema    float64
+	recent []usageSample
+	// fitSecs and fitBytes are the series fitted under the interpolate
+	// policy, in seconds since the first sample with gaps filled in
+	fitSecs, fitBytes []int64
+}
+
+// interpolate adds samples every interval across a gap of dt seconds ending
+// at bytes, on the straight line from the previous sample. It must be called
+// before lastBytes and spanSecs are advanced.
+func (s *rateState) interpolate(interval, dt, bytes int64) {
+	for offset := interval; offset < dt; offset += interval {
+		filled := s.lastBytes + int64(math.Round(float64(bytes-s.lastBytes)*float64(offset)/float64(dt)))
+		s.fitSecs = append(s.fitSecs, s.spanSecs+offset)
+		s.fitBytes = append(s.fitBytes, filled)
+	}
+}
+
+// smooth returns the smoothed usage after adding a raw sample. It must be
+// called before lastTS is advanced.
+func (s *rateState) smooth(sm Smoothing, ts, bytes int64) int64 {
+	switch {
+	case sm.Window <= 0:
+		return bytes
+	case sm.Method == SmoothRolling:
+		s.recent = append(s.recent, usageSample{ts, bytes})
+		for len(s.recent) > 1 && s.recent[0].timestamp <= ts-sm.Window {
+			s.recent = s.recent[1:]
+		}
+		var sum float64
+		for _, r := range s.recent {
+			sum += float64(r.bytes)
+		}
+		return int64(math.Round(sum / float64(len(s.recent))))
+	default:
+		if s.Samples == 0 {
+			s.ema = float64(bytes)
+		} else {
+			alpha := 1 - math.Exp(-float64(ts-s.lastTS)/float64(sm.Window))
+			s.ema += alpha * (float64(bytes) - s.ema)
+		}
+		return int64(math.Round(s.ema))
+	}
+}
+
+// Rates computes per-mount growth rates for entries at or after from.
+// Intervals between consecutive daemon samples use their monotonic elapsed
+// time rather than the timestamp difference.
+// Intervals longer than GapFactor collection intervals are gaps: skip leaves
+// them out of the rate entirely, interpolate fills them with samples every
+// interval on the straight line across the gap and fits a line through the
+// whole series, so an outage weighs by its length rather than as one jump,
+// and flag uses the rate from first to last sample but marks the mount so
+// averaged-over outages are visible. Usage is smoothed first when smoothing
+// has a window.
+func Rates(st store.Store, from int64, policy string, smoothing Smoothing) ([]Rate, error) {
+	interval, err := ExpectedInterval(st, from)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]*rateState)
+	var prevTS int64
+	err = store.ScanRange(st, from, 0, func(entry collector.UsageEntry) error {
+		defer func() { prevTS = entry.Timestamp }()
+		for mount, bytes := range entry.Mounts {
+			if collector.IsSnapshotMount(mount) {
+				continue
+			}
+			s := states[mount]
+			if s == nil {
+				s = &rateState{Rate: Rate{Mount: mount}}
+				bytes = s.smooth(smoothing, entry.Timestamp, bytes)
+				s.Samples = 1
+				s.firstTS, s.lastTS, s.firstBytes, s.lastBytes = entry.Timestamp, entry.Timestamp, bytes, bytes
+				s.Free = entry.Details[mount].Available
+				if policy == GapsInterpolate {
+					s.fitSecs, s.fitBytes = []int64{0}, []int64{bytes}
+				}
+				states[mount] = s
+				continue
+			}
+			bytes = s.smooth(smoothing, entry.Timestamp, bytes)
+			s.Free = entry.Details[mount].Available
+			dt := entry.Timestamp - s.lastTS
+			if entry.Elapsed > 0 && s.lastTS == prevTS {
+				// The daemon stores the monotonic time only when the previous
+				// entry is its own sample
+				dt = int64(entry.Elapsed + 0.5)
+			}
+			gap := interval > 0 && dt > GapFactor*interval
+			if policy == GapsInterpolate {
+				if gap {
+					s.interpolate(interval, dt, bytes)
+				}
+				s.fitSecs = append(s.fitSecs, s.spanSecs+dt)
+				s.fitBytes = append(s.fitBytes, bytes)
+			}
+			s.spanSecs += dt
+			if gap {
+				s.Gaps++
+			} else {
+				s.coveredDelta += bytes - s.lastBytes
+				s.coveredSecs += dt
+			}
+			s.Samples++
+			s.lastTS, s.lastBytes = entry.Timestamp, bytes
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Rate, 0, len(states))
+	for _, s := range states {
+		r := s.Rate
+		switch {
+		case policy == GapsSkip && s.coveredSecs > 0:
+			r.PerDay = float64(s.coveredDelta) / float64(s.coveredSecs) * 86400
+		case policy == GapsInterpolate:
+			if slope, _, _, ok := LinearFit(s.fitSecs, s.fitBytes); ok {
+				r.PerDay = slope * 86400
+			}
+		case policy == GapsFlag && s.spanSecs > 0:
+			r.PerDay = float64(s.lastBytes-s.firstBytes) / float64(s.spanSecs) * 86400
+			r.Flagged = policy == GapsFlag && s.Gaps > 0
+		}
+		result = append(result, r)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Mount < result[j].Mount })
+	return result, nil
+}
+
+// FullIn returns how long until the mount fills at its current rate, "-"
+// when it isn't growing or its free space is unknown
+func (r Rate) FullIn() string {
+	if r.Free == nil || r.PerDay <= 0 {
+		return "-"
+	}
+	days := float64(*r.Free) / r.PerDay
+	if days > 3650 {
+		return ">10y"
+	}
+	return fmt.Sprintf("%.0fd", math.Ceil(days))
+}
@@ -0,0 +1,85 @@
+package report
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// hourlyHistory stores hourly samples of /mnt/a from usage, skipping the
+// hours it returns -1 for
+func hourlyHistory(t *testing.T, hours int, usage func(hour int) int64) store.Store {
+	t.Helper()
+	st := store.Open(filepath.Join(t.TempDir(), "history.jsonl"), nil, false)
+	var entries []collector.UsageEntry
+	for hour := 0; hour < hours; hour++ {
+		if bytes := usage(hour); bytes >= 0 {
+			entries = append(entries, collector.UsageEntry{Timestamp: 1_700_000_000 + int64(hour)*3600, Mounts: map[string]int64{"/mnt/a": bytes}, Total: bytes})
+		}
+	}
+	if err := st.Rewrite(entries); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func TestCollectRatesGapPolicies(t *testing.T) {
+	// a day of samples, a day without, and another day of samples
+	outage := func(hour int) bool { return hour >= 24 && hour < 47 }
+	steady := func(hour int) int64 {
+		if outage(hour) {
+			return -1
+		}
+		return int64(hour) * 1000
+	}
+	// usage stays flat but grows by 24000 during the outage
+	step := func(hour int) int64 {
+		switch {
+		case outage(hour):
+			return -1
+		case hour < 24:
+			return 0
+		}
+		return 24000
+	}
+
+	tests := []struct {
+		name        string
+		usage       func(int) int64
+		policy      string
+		wantPerDay  float64
+		wantFlagged bool
+	}{
+		{"steady skip", steady, GapsSkip, 24000, false},
+		{"steady interpolate", steady, GapsInterpolate, 24000, false},
+		{"steady flag", steady, GapsFlag, 24000, true},
+		{"step skip", step, GapsSkip, 0, false},
+		// the fit through the filled-in outage, not the endpoint rate
+		{"step interpolate", step, GapsInterpolate, 11708.65, false},
+		{"step flag", step, GapsFlag, 24000.0 / 70 * 24, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rates, err := Rates(hourlyHistory(t, 71, tt.usage), 0, tt.policy, Smoothing{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rates) != 1 {
+				t.Fatalf("got %d rates, want 1", len(rates))
+			}
+			r := rates[0]
+			if math.Abs(r.PerDay-tt.wantPerDay) > 0.01 {
+				t.Errorf("perDay %.2f, want %.2f", r.PerDay, tt.wantPerDay)
+			}
+			if r.Flagged != tt.wantFlagged {
+				t.Errorf("flagged %v, want %v", r.Flagged, tt.wantFlagged)
+			}
+			if r.Gaps != 1 || r.Samples != 48 {
+				t.Errorf("%d gaps and %d samples, want 1 and 48", r.Gaps, r.Samples)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package report
+
+import (
+	"sort"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	"github.com/jessegalley/nfsusage/pkg/store"
+)
+
+// GapFactor is how many expected collection intervals may pass between two
+// samples of a mount before the time between them counts as a gap
+const GapFactor = 3
+
+// ExpectedInterval returns the median time between consecutive entries at or
+// after from, which is the collection interval in practice
+func ExpectedInterval(st store.Store, from int64) (int64, error) {
+	var deltas []int64
+	var prev int64
+	err := store.ScanRange(st, from, 0, func(entry collector.UsageEntry) error {
+		if prev != 0 && entry.Timestamp > prev {
+			deltas = append(deltas, entry.Timestamp-prev)
+		}
+		prev = entry.Timestamp
+		return nil
+	})
+	if err != nil || len(deltas) == 0 {
+		return 0, err
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i] < deltas[j] })
+	return deltas[len(deltas)/2], nil
+}
+
+// LinearFit returns the least squares line through the samples and its R²,
+// false when there are fewer than two distinct timestamps. Timestamps are
+// centred before fitting to keep the sums precise.
+func LinearFit(timestamps, bytes []int64) (slope, intercept, r2 float64, ok bool) {
+	n := float64(len(timestamps))
+	if len(timestamps) < 2 {
+		return 0, 0, 0, false
+	}
+	var meanT, meanY float64
+	for i := range timestamps {
+		meanT += float64(timestamps[i])
+		meanY += float64(bytes[i])
+	}
+	meanT /= n
+	meanY /= n
+	var sxy, sxx, syy float64
+	for i := range timestamps {
+		dt, dy := float64(timestamps[i])-meanT, float64(bytes[i])-meanY
+		sxy += dt * dy
+		sxx += dt * dt
+		syy += dy * dy
+	}
+	if sxx == 0 {
+		return 0, 0, 0, false
+	}
+	slope = sxy / sxx
+	// A flat series is fit perfectly by a flat line
+	r2 = 1
+	if syy > 0 {
+		r2 = sxy * sxy / (sxx * syy)
+	}
+	return slope, meanY - slope*meanT, r2, true
+}
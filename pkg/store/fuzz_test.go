@@ -0,0 +1,25 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+// FuzzLoadHistory feeds arbitrary bytes to both history formats. Decoding
+// must fail cleanly rather than panic on truncated or hand-edited files.
+func FuzzLoadHistory(f *testing.F) {
+	f.Add([]byte(`[{"timestamp":1717236000,"mounts":{"/mnt/a":10},"total":10}]`))
+	f.Add([]byte(`{"timestamp":1717236000,"mounts":{"/mnt/a":10,"/mnt/b":5},"total":15}
+{"timestamp":1717239600,"mounts":{"#0":2},"total":17,"delta":true}
+{"timestamp":1717243200,"mounts":{"/mnt/c":1},"total":18,"delta":true,"removed":["#1"]}
+`))
+	f.Add([]byte(`{"timestamp":1,"mounts":{"#5":1},"total":1,"delta":true}`))
+	f.Add([]byte("bm90IGpzb24=\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ParseEntries(data)
+		st := &jsonlStore{path: "fuzz.jsonl"}
+		st.decodeFrom(bytes.NewReader(data), 0, func(collector.UsageEntry) error { return nil })
+	})
+}
@@ -0,0 +1,187 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+// indexRecordSize is the size of one sidecar index record:
+// timestamp (int64), byte offset (int64) and a keyframe flag
+const indexRecordSize = 17
+
+// indexRecord locates one JSONL line
+type indexRecord struct {
+	timestamp int64
+	offset    int64
+	keyframe  bool
+}
+
+// RangeScanner is implemented by stores that can seek to a time range
+type RangeScanner interface {
+	ScanRange(from, to int64, fn func(collector.UsageEntry) error) error
+}
+
+// Tailer is implemented by stores that can return the newest entry and the
+// entry count without reading the whole history
+type Tailer interface {
+	Tail() (*collector.UsageEntry, int, error)
+}
+
+// ScanRange calls fn for entries with from <= timestamp <= to, seeking directly
+// to the range when the store supports it. Zero bounds are open.
+func ScanRange(st Store, from, to int64, fn func(collector.UsageEntry) error) error {
+	if rs, ok := st.(RangeScanner); ok {
+		return rs.ScanRange(from, to, fn)
+	}
+	return st.Scan(func(entry collector.UsageEntry) error {
+		if from != 0 && entry.Timestamp < from {
+			return nil
+		}
+		if to != 0 && entry.Timestamp > to {
+			return ErrStopScan
+		}
+		return fn(entry)
+	})
+}
+
+// IndexPath returns the sidecar index path for a JSONL store
+func IndexPath(filePath string) string {
+	return filePath + ".idx"
+}
+
+func encodeIndexRecord(buf *bytes.Buffer, rec indexRecord) {
+	var b [indexRecordSize]byte
+	binary.BigEndian.PutUint64(b[0:], uint64(rec.timestamp))
+	binary.BigEndian.PutUint64(b[8:], uint64(rec.offset))
+	if rec.keyframe {
+		b[16] = 1
+	}
+	buf.Write(b[:])
+}
+
+// readIndex loads the sidecar index. It is small (17 bytes per entry) even for
+// multi-GB stores, so it is read whole.
+func readIndex(filePath string) ([]indexRecord, error) {
+	data, err := os.ReadFile(IndexPath(filePath))
+	if err != nil {
+		return nil, err
+	}
+	records := make([]indexRecord, 0, len(data)/indexRecordSize)
+	for i := 0; i+indexRecordSize <= len(data); i += indexRecordSize {
+		records = append(records, indexRecord{
+			timestamp: int64(binary.BigEndian.Uint64(data[i:])),
+			offset:    int64(binary.BigEndian.Uint64(data[i+8:])),
+			keyframe:  data[i+16] == 1,
+		})
+	}
+	return records, nil
+}
+
+// appendIndex adds records to the sidecar index
+func appendIndex(filePath string, records ...indexRecord) error {
+	var buf bytes.Buffer
+	for _, rec := range records {
+		encodeIndexRecord(&buf, rec)
+	}
+	file, err := os.OpenFile(IndexPath(filePath), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeIndex replaces the sidecar index
+func writeIndex(filePath string, records []indexRecord) error {
+	var buf bytes.Buffer
+	for _, rec := range records {
+		encodeIndexRecord(&buf, rec)
+	}
+	tmp := IndexPath(filePath) + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, IndexPath(filePath))
+}
+
+// validIndex reports whether the index describes the store: it must be a
+// whole number of records and its last record must be the last line, ending
+// exactly at the end of the file. Anything else (a crash between writing a
+// line and indexing it, manual edits) forces a rebuild.
+func validIndex(filePath string, records []indexRecord) bool {
+	idxInfo, err := os.Stat(IndexPath(filePath))
+	if err != nil || idxInfo.Size()%indexRecordSize != 0 {
+		return false
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	if len(records) == 0 {
+		return info.Size() == 0
+	}
+	last := records[len(records)-1].offset
+	if last < 0 || last >= info.Size() {
+		return false
+	}
+	line := make([]byte, info.Size()-last)
+	if _, err := file.ReadAt(line, last); err != nil {
+		return false
+	}
+	return bytes.IndexByte(line, '\n') == len(line)-1
+}
+
+// ordered reports whether the indexed timestamps never decrease. Entries
+// recorded with --allow-time-regression break the order, and with it binary
+// searching the index and stopping at the first entry past a range.
+func ordered(records []indexRecord) bool {
+	for i := 1; i < len(records); i++ {
+		if records[i].timestamp < records[i-1].timestamp {
+			return false
+		}
+	}
+	return true
+}
+
+// seekRecord returns the position in records to start decoding from so that the
+// first entry with timestamp >= from is reached: the closest keyframe at or
+// before it, since delta lines can't be decoded on their own. Unordered
+// records are searched linearly for the first entry at or after from.
+func seekRecord(records []indexRecord, from int64, ordered bool) int {
+	var i int
+	if ordered {
+		i = sort.Search(len(records), func(i int) bool { return records[i].timestamp >= from })
+	} else {
+		for i < len(records) && records[i].timestamp < from {
+			i++
+		}
+	}
+	if i >= len(records) {
+		i = len(records) - 1
+	}
+	for i > 0 && !records[i].keyframe {
+		i--
+	}
+	return i
+}
+
+// readAt returns a reader positioned at offset
+func readAt(file *os.File, offset int64) (io.Reader, error) {
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"os"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+// Manifest is written next to the store and records how many entries it should
+// hold and the checksum of the newest one, so truncation can be detected
+type Manifest struct {
+	Entries int    `json:"entries"`
+	Head    string `json:"head"`
+	Updated int64  `json:"updated"`
+}
+
+// ManifestPath returns the sidecar manifest path for a store file
+func ManifestPath(filePath string) string {
+	return filePath + ".manifest"
+}
+
+// EntryChecksum chains an entry to its predecessor. With a key it is an HMAC,
+// which also detects deliberate edits, otherwise a plain SHA-256.
+func EntryChecksum(prev string, entry collector.UsageEntry, key []byte) (string, error) {
+	entry.Checksum = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+
+	var h hash.Hash
+	if key != nil {
+		h = hmac.New(sha256.New, macKey(key))
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(prev))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// macKey derives the HMAC key of the checksum chain from the store key, so
+// the encryption key is never used for anything but AES
+func macKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("nfsusage entry checksum"))
+	return mac.Sum(nil)
+}
+
+// Seal adds checksums to every entry after the last sealed one. Legacy
+// files without checksums are sealed in full on their first save.
+func Seal(entries []collector.UsageEntry, key []byte) error {
+	start := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Checksum != "" {
+			start = i + 1
+			break
+		}
+	}
+
+	prev := ""
+	if start > 0 {
+		prev = entries[start-1].Checksum
+	}
+	for i := start; i < len(entries); i++ {
+		sum, err := EntryChecksum(prev, entries[i], key)
+		if err != nil {
+			return err
+		}
+		entries[i].Checksum = sum
+		prev = sum
+	}
+	return nil
+}
+
+// SealLegacy appends entry to a history stored without checksums and seals
+// it in full, calling backup before the rewrite. It returns the number of
+// entries now stored.
+func SealLegacy(st Store, entry collector.UsageEntry, key []byte, backup func() error) (int, error) {
+	entries, err := st.Load()
+	if err != nil {
+		return 0, fmt.Errorf("loading existing data: %v", err)
+	}
+	entries = append(entries, entry)
+	if err := Seal(entries, key); err != nil {
+		return 0, fmt.Errorf("sealing entries: %v", err)
+	}
+	if err := backup(); err != nil {
+		return 0, fmt.Errorf("backing up data: %v", err)
+	}
+	if err := st.Rewrite(entries); err != nil {
+		return 0, fmt.Errorf("saving data: %v", err)
+	}
+	// A stale cache only costs speed, it no longer matches the manifest
+	WriteLatest(st.File(), entries[len(entries)-1], st.EncryptionKey())
+	return len(entries), nil
+}
+
+// WriteManifest records the entry count and head checksum for the store
+func WriteManifest(filePath string, count int, head string) error {
+	m := Manifest{Entries: count, Head: head, Updated: time.Now().Unix()}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ManifestPath(filePath), data, 0644)
+}
+
+// ReadManifest reads the manifest of a store
+func ReadManifest(filePath string) (*Manifest, error) {
+	data, err := os.ReadFile(ManifestPath(filePath))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Verify returns an error when the checksum chain or the manifest shows that
+// entries were modified, reordered or removed. Unlike Validate it accepts
+// entries without checksums and a missing manifest, as in legacy stores, so
+// it can guard rewrites that reseal the history.
+func Verify(filePath string, entries []collector.UsageEntry, key []byte) error {
+	prev := ""
+	for i, entry := range entries {
+		if entry.Checksum != "" {
+			sum, err := EntryChecksum(prev, entry, key)
+			if err != nil {
+				return fmt.Errorf("entry %d: %v", i, err)
+			}
+			if sum != entry.Checksum {
+				return fmt.Errorf("entry %d (timestamp %d) checksum mismatch", i, entry.Timestamp)
+			}
+		}
+		prev = entry.Checksum
+	}
+
+	m, err := ReadManifest(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading manifest: %v", err)
+	}
+	if m.Entries != len(entries) {
+		return fmt.Errorf("manifest expects %d entries, store has %d", m.Entries, len(entries))
+	}
+	if len(entries) > 0 && m.Head != entries[len(entries)-1].Checksum {
+		return fmt.Errorf("newest entry does not match manifest head checksum")
+	}
+	return nil
+}
+
+// Validate verifies the checksum chain and compares it with the manifest,
+// returning one message per problem found
+func Validate(filePath string, entries []collector.UsageEntry, key []byte) []string {
+	var problems []string
+
+	prev := ""
+	for i, entry := range entries {
+		if entry.Checksum == "" {
+			problems = append(problems, fmt.Sprintf("entry %d (timestamp %d) has no checksum", i, entry.Timestamp))
+			prev = ""
+			continue
+		}
+		sum, err := EntryChecksum(prev, entry, key)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("entry %d: %v", i, err))
+		} else if sum != entry.Checksum {
+			problems = append(problems, fmt.Sprintf("entry %d (timestamp %d) checksum mismatch: modified, reordered or removed entries", i, entry.Timestamp))
+		}
+		prev = entry.Checksum
+	}
+
+	data, err := os.ReadFile(ManifestPath(filePath))
+	if os.IsNotExist(err) {
+		return append(problems, "no manifest found, truncation cannot be detected")
+	} else if err != nil {
+		return append(problems, fmt.Sprintf("error reading manifest: %v", err))
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return append(problems, fmt.Sprintf("error parsing manifest: %v", err))
+	}
+	if m.Entries != len(entries) {
+		problems = append(problems, fmt.Sprintf("manifest expects %d entries, store has %d", m.Entries, len(entries)))
+	}
+	if len(entries) > 0 && m.Head != entries[len(entries)-1].Checksum {
+		problems = append(problems, "newest entry does not match manifest head checksum")
+	}
+	return problems
+}
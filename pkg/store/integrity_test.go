@@ -0,0 +1,158 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+func TestSealValidateAfterPruneAndRewrite(t *testing.T) {
+	key := make([]byte, 32)
+	tests := []struct {
+		name    string
+		key     []byte
+		compact bool
+		// prune returns the entries kept from history
+		prune func(history []collector.UsageEntry) []collector.UsageEntry
+	}{
+		{"drop oldest", nil, false, func(h []collector.UsageEntry) []collector.UsageEntry { return h[3:] }},
+		{"drop oldest keyed", key, false, func(h []collector.UsageEntry) []collector.UsageEntry { return h[3:] }},
+		{"drop oldest compact", nil, true, func(h []collector.UsageEntry) []collector.UsageEntry { return h[3:] }},
+		{"downsample keyed compact", key, true, func(h []collector.UsageEntry) []collector.UsageEntry {
+			var kept []collector.UsageEntry
+			for i := 0; i < len(h); i += 2 {
+				kept = append(kept, h[i])
+			}
+			return kept
+		}},
+		{"keep newest only", key, false, func(h []collector.UsageEntry) []collector.UsageEntry { return h[len(h)-1:] }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "history.jsonl")
+			st := Open(path, tt.key, tt.compact)
+			appendAll(t, st, testHistory(t, 8, tt.key))
+
+			history, err := st.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Reseal the kept entries the way retention does
+			kept := append([]collector.UsageEntry(nil), tt.prune(history)...)
+			for i := range kept {
+				kept[i].Checksum = ""
+			}
+			if err := Seal(kept, tt.key); err != nil {
+				t.Fatal(err)
+			}
+			if err := st.Rewrite(kept); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, err := st.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if problems := Validate(path, loaded, tt.key); len(problems) > 0 {
+				t.Errorf("Validate() after rewrite = %v", problems)
+			}
+			if len(loaded) > 1 {
+				loaded[0], loaded[1] = loaded[1], loaded[0]
+				if problems := Validate(path, loaded, tt.key); len(problems) == 0 {
+					t.Error("Validate() accepted reordered entries")
+				}
+			}
+			if problems := Validate(path, loaded[:len(loaded)-1], tt.key); len(problems) == 0 {
+				t.Error("Validate() accepted a truncated store")
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	key := make([]byte, 32)
+	tests := []struct {
+		name    string
+		key     []byte
+		damage  func(path string, entries []collector.UsageEntry) []collector.UsageEntry
+		wantErr bool
+	}{
+		{"intact", key, func(string, []collector.UsageEntry) []collector.UsageEntry { return nil }, false},
+		{"modified entry", key, func(_ string, h []collector.UsageEntry) []collector.UsageEntry {
+			h[2].Total++
+			return h
+		}, true},
+		{"reordered entries", nil, func(_ string, h []collector.UsageEntry) []collector.UsageEntry {
+			h[1], h[2] = h[2], h[1]
+			return h
+		}, true},
+		{"dropped newest", nil, func(_ string, h []collector.UsageEntry) []collector.UsageEntry { return h[:len(h)-1] }, true},
+		{"resealed without the key", key, func(_ string, h []collector.UsageEntry) []collector.UsageEntry {
+			h[2].Total++
+			for i := range h {
+				h[i].Checksum = ""
+			}
+			Seal(h, nil)
+			return h
+		}, true},
+		{"legacy unsealed entries", nil, func(path string, h []collector.UsageEntry) []collector.UsageEntry {
+			for i := range h {
+				h[i].Checksum = ""
+			}
+			os.Remove(ManifestPath(path))
+			return h
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "history.jsonl")
+			st := Open(path, tt.key, false)
+			appendAll(t, st, testHistory(t, 5, tt.key))
+			history, err := st.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if damaged := tt.damage(path, history); damaged != nil {
+				history = damaged
+			}
+			if err := Verify(path, history, tt.key); (err != nil) != tt.wantErr {
+				t.Errorf("Verify() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSealLegacy(t *testing.T) {
+	key := make([]byte, 32)
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	st := Open(path, key, false)
+	history := testHistory(t, 4, key)
+	for i := range history {
+		history[i].Checksum = ""
+	}
+	legacy, entry := history[:3], history[3]
+	if err := st.Rewrite(legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	backedUp := false
+	count, err := SealLegacy(st, entry, key, func() error { backedUp = true; return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 || !backedUp {
+		t.Errorf("SealLegacy() = %d entries, backed up %v, want 4 and true", count, backedUp)
+	}
+	entries, err := st.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := Validate(path, entries, key); len(problems) > 0 {
+		t.Errorf("sealed history fails validation: %v", problems)
+	}
+	if latest, n, ok := ReadLatest(path, key); !ok || n != 4 || latest.Checksum != entries[3].Checksum {
+		t.Errorf("latest cache = %+v, %d, %v, want the sealed newest entry", latest, n, ok)
+	}
+}
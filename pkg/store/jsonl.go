@@ -0,0 +1,581 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+// isKeyframe reports whether entry must be stored in full: the first entry and
+// the first entry of each UTC day are, so a damaged line only affects the
+// entries up to the next day and no entry count is needed to decide
+func isKeyframe(prev *collector.UsageEntry, entry collector.UsageEntry) bool {
+	const day = 24 * 60 * 60
+	return prev == nil || prev.Timestamp/day != entry.Timestamp/day
+}
+
+// maxLineSize is the longest line a JSONL store decodes
+const maxLineSize = 64 * 1024 * 1024
+
+// jsonlStore keeps one entry per line so new entries are appended instead of
+// rewriting the file. With compact set, lines between keyframes only hold the
+// per-mount byte deltas and changed details relative to the previous entry,
+// and refer to mount paths already known from the previous entry by their
+// index ("#3") instead of repeating them, so each keyframe acts as the path
+// table for its segment. With a key, every line is encrypted on its own.
+// Any damaged line fails the load; only a torn final line, the trace of a
+// crash mid-append, can be dropped with RepairTail.
+type jsonlStore struct {
+	path    string
+	key     []byte
+	compact bool
+}
+
+// jsonlRecord is one line of a JSONL store. Plain lines are ordinary entries;
+// in delta lines Mounts holds byte differences for changed mounts only,
+// Details holds only changed details, Removed lists vanished mounts and
+// RemovedDetails the details dropped for mounts that are still there, all
+// keyed by path references (see pathRefs) where the previous entry had the path.
+type jsonlRecord struct {
+	collector.UsageEntry
+	Delta          bool     `json:"delta,omitempty"`
+	Removed        []string `json:"removed,omitempty"`
+	RemovedDetails []string `json:"removed_details,omitempty"`
+}
+
+func (s *jsonlStore) File() string { return s.path }
+
+func (s *jsonlStore) EncryptionKey() []byte { return s.key }
+
+func (s *jsonlStore) Load() ([]collector.UsageEntry, error) {
+	var entries []collector.UsageEntry
+	err := s.Scan(func(entry collector.UsageEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+func (s *jsonlStore) Scan(fn func(collector.UsageEntry) error) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return s.decodeFrom(file, 0, fn)
+}
+
+// decodeFrom decodes lines from r, which must start at a keyframe line
+func (s *jsonlStore) decodeFrom(r io.Reader, firstLine int, fn func(collector.UsageEntry) error) error {
+	var prev *collector.UsageEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	lineNo := firstLine
+	for scanner.Scan() {
+		lineNo++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		record, err := s.decodeLine(line)
+		var corrupt *CorruptStoreError
+		if errors.As(err, &corrupt) {
+			corrupt.Line = lineNo
+			return corrupt
+		} else if err != nil {
+			return fmt.Errorf("%s line %d: %v", s.path, lineNo, err)
+		}
+		entry, err := expandRecord(prev, record)
+		if err != nil {
+			return &CorruptStoreError{Path: s.path, Line: lineNo, Err: err}
+		}
+		if err := fn(entry); err != nil {
+			return IgnoreStop(err)
+		}
+		prev = &entry
+	}
+	return scanner.Err()
+}
+
+// index returns the sidecar index, rebuilding it when missing or stale
+func (s *jsonlStore) index() ([]indexRecord, error) {
+	records, err := readIndex(s.path)
+	if err == nil && validIndex(s.path, records) {
+		return records, nil
+	}
+	if err := s.rebuildIndex(); err != nil {
+		return nil, err
+	}
+	return readIndex(s.path)
+}
+
+// rebuildIndex scans the whole store once to recreate the sidecar index
+func (s *jsonlStore) rebuildIndex() error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var records []indexRecord
+	reader := bufio.NewReader(file)
+	var offset int64
+	lineNo := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		lineNo++
+		if len(line) > 0 && len(bytes.TrimSpace(line)) > 0 {
+			record, decodeErr := s.decodeLine(bytes.TrimSpace(line))
+			var corrupt *CorruptStoreError
+			if errors.As(decodeErr, &corrupt) {
+				corrupt.Line = lineNo
+				return corrupt
+			} else if decodeErr != nil {
+				return fmt.Errorf("%s: %v", s.path, decodeErr)
+			}
+			records = append(records, indexRecord{timestamp: record.Timestamp, offset: offset, keyframe: !record.Delta})
+		}
+		offset += int64(len(line))
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	return writeIndex(s.path, records)
+}
+
+// ScanRange seeks via the index to the keyframe preceding from and decodes
+// only until entries pass to
+func (s *jsonlStore) ScanRange(from, to int64, fn func(collector.UsageEntry) error) error {
+	records, err := s.index()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	inOrder := ordered(records)
+	start := seekRecord(records, from, inOrder)
+	r, err := readAt(file, records[start].offset)
+	if err != nil {
+		return err
+	}
+	return s.decodeFrom(r, start, func(entry collector.UsageEntry) error {
+		if from != 0 && entry.Timestamp < from {
+			return nil
+		}
+		if to != 0 && entry.Timestamp > to {
+			if inOrder {
+				return ErrStopScan
+			}
+			// An older entry may still follow
+			return nil
+		}
+		return fn(entry)
+	})
+}
+
+// Tail decodes from the last keyframe to find the newest entry
+func (s *jsonlStore) Tail() (*collector.UsageEntry, int, error) {
+	records, err := s.index()
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(records) == 0 {
+		return nil, 0, nil
+	}
+
+	var last *collector.UsageEntry
+	err = s.ScanRange(records[len(records)-1].timestamp, 0, func(entry collector.UsageEntry) error {
+		last = &entry
+		return nil
+	})
+	return last, len(records), err
+}
+
+func (s *jsonlStore) Append(prev *collector.UsageEntry, count int, entry collector.UsageEntry) error {
+	var buf bytes.Buffer
+	if err := s.encodeLine(&buf, prev, entry); err != nil {
+		return err
+	}
+
+	if torn, err := endsTorn(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	} else if torn {
+		return &CorruptStoreError{Path: s.path, Err: fmt.Errorf("unterminated final line")}
+	}
+	// Make sure the index covers the existing lines before extending it
+	if _, err := s.index(); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, s.perm())
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err == nil && s.key != nil {
+		// Tighten a store created before a key was set
+		err = file.Chmod(s.perm())
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	rec := indexRecord{timestamp: entry.Timestamp, offset: info.Size(), keyframe: !s.compact || isKeyframe(prev, entry)}
+	if info.Size() == 0 {
+		// New store, don't extend an index left over from a deleted file
+		err = writeIndex(s.path, []indexRecord{rec})
+	} else {
+		err = appendIndex(s.path, rec)
+	}
+	if err != nil {
+		return err
+	}
+	return WriteManifest(s.path, count+1, entry.Checksum)
+}
+
+func (s *jsonlStore) Rewrite(history []collector.UsageEntry) error {
+	var buf bytes.Buffer
+	for i := range history {
+		var prev *collector.UsageEntry
+		if i > 0 {
+			prev = &history[i-1]
+		}
+		if err := s.encodeLine(&buf, prev, history[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := WriteFileAtomic(s.path, buf.Bytes(), s.perm()); err != nil {
+		return err
+	}
+	if err := s.rebuildIndex(); err != nil {
+		return err
+	}
+	head := ""
+	if len(history) > 0 {
+		head = history[len(history)-1].Checksum
+	}
+	return WriteManifest(s.path, len(history), head)
+}
+
+// endsTorn reports whether a non-empty file lacks its final newline, which
+// appending to would merge the next line into the torn one
+func endsTorn(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return false, err
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return false, err
+	}
+	return last[0] != '\n', nil
+}
+
+// RepairTail truncates an unterminated or undecodable final line back to the
+// end of the previous one, when every line before it decodes, and rebuilds
+// the index and manifest for the remaining entries
+func (s *jsonlStore) RepairTail() (bool, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	size := info.Size()
+	base := size - min(size, maxLineSize+1)
+	tail := make([]byte, size-base)
+	if _, err := file.ReadAt(tail, base); err != nil && err != io.EOF {
+		return false, err
+	}
+
+	var cut int64
+	if end := bytes.LastIndexByte(tail, '\n'); end+1 < len(tail) {
+		// The last append never wrote its newline
+		if end < 0 && base > 0 {
+			return false, nil
+		}
+		cut = base + int64(end+1)
+	} else {
+		line := bytes.TrimRight(tail, " \t\r\n")
+		start := bytes.LastIndexByte(line, '\n') + 1
+		if len(line) == 0 || (start == 0 && base > 0) {
+			return false, nil
+		}
+		var corrupt *CorruptStoreError
+		if _, err := s.decodeLine(line[start:]); !errors.As(err, &corrupt) {
+			return false, nil
+		}
+		cut = base + int64(start)
+	}
+
+	count, head := 0, ""
+	err = s.decodeFrom(io.NewSectionReader(file, 0, cut), 0, func(entry collector.UsageEntry) error {
+		count, head = count+1, entry.Checksum
+		return nil
+	})
+	if err != nil {
+		// Damage before the last line is not a torn append
+		return false, nil
+	}
+	if err := os.Truncate(s.path, cut); err != nil {
+		return false, err
+	}
+	if err := s.rebuildIndex(); err != nil {
+		return false, err
+	}
+	if err := os.Remove(LatestPath(s.path)); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, WriteManifest(s.path, count, head)
+}
+
+func (s *jsonlStore) perm() os.FileMode {
+	if s.key != nil {
+		return 0600
+	}
+	return 0644
+}
+
+// encodeLine writes entry as one line, delta encoded against prev when
+// compaction is on and entry is not a keyframe
+func (s *jsonlStore) encodeLine(buf *bytes.Buffer, prev *collector.UsageEntry, entry collector.UsageEntry) error {
+	record := jsonlRecord{UsageEntry: entry}
+	if s.compact && !isKeyframe(prev, entry) {
+		record = deltaRecord(*prev, entry)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if s.key != nil {
+		sealed, err := Encrypt(s.key, data)
+		if err != nil {
+			return err
+		}
+		data = []byte(base64.StdEncoding.EncodeToString(sealed))
+	}
+	buf.Write(data)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// decodeLine parses one line, decrypting it when it is not plain JSON.
+// Lines that can't be parsed yield a CorruptStoreError, decryption failures
+// (e.g. a wrong key) don't.
+func (s *jsonlStore) decodeLine(line []byte) (jsonlRecord, error) {
+	var record jsonlRecord
+	if line[0] != '{' {
+		sealed, err := base64.StdEncoding.DecodeString(string(line))
+		if err != nil || !IsEncrypted(sealed) {
+			return record, &CorruptStoreError{Path: s.path, Err: fmt.Errorf("line is neither JSON nor an encrypted record")}
+		}
+		if line, err = Decrypt(s.key, sealed); err != nil {
+			return record, err
+		}
+	}
+	if err := json.Unmarshal(line, &record); err != nil {
+		return record, &CorruptStoreError{Path: s.path, Err: err}
+	}
+	return record, nil
+}
+
+// deltaRecord encodes cur relative to prev
+func deltaRecord(prev, cur collector.UsageEntry) jsonlRecord {
+	record := jsonlRecord{UsageEntry: cur, Delta: true}
+	record.Mounts = make(map[string]int64)
+	record.Details = nil
+
+	for mount, bytes := range cur.Mounts {
+		if old, ok := prev.Mounts[mount]; !ok || old != bytes {
+			record.Mounts[mount] = bytes - old
+		}
+	}
+	removed := make(map[string]bool)
+	for mount := range prev.Mounts {
+		if _, ok := cur.Mounts[mount]; !ok {
+			record.Removed = append(record.Removed, mount)
+			removed[mount] = true
+		}
+	}
+	sort.Strings(record.Removed)
+
+	// Details are compared with what expandRecord carries over from prev
+	for mount, detail := range cur.Details {
+		if old, ok := prev.Details[mount]; !ok || removed[mount] || !reflect.DeepEqual(detail, old) {
+			if record.Details == nil {
+				record.Details = make(map[string]collector.MountDetail)
+			}
+			record.Details[mount] = detail
+		}
+	}
+	for mount := range prev.Details {
+		if _, ok := cur.Details[mount]; !ok && !removed[mount] {
+			record.RemovedDetails = append(record.RemovedDetails, mount)
+		}
+	}
+	sort.Strings(record.RemovedDetails)
+
+	refs := pathRefs(prev)
+	record.Mounts = internKeys(record.Mounts, refs)
+	record.Details = internKeys(record.Details, refs)
+	internPaths(record.Removed, refs)
+	internPaths(record.RemovedDetails, refs)
+	return record
+}
+
+// internPaths replaces the paths that have a reference in place
+func internPaths(paths []string, refs map[string]string) {
+	for i, path := range paths {
+		if ref, ok := refs[path]; ok {
+			paths[i] = ref
+		}
+	}
+}
+
+// pathRefs maps each mount path of entry to its reference "#i", i being the
+// path's position in sorted order, which the decoder can rebuild from the
+// same entry without any stored table
+func pathRefs(entry collector.UsageEntry) map[string]string {
+	paths := sortedPaths(entry)
+	refs := make(map[string]string, len(paths))
+	for i, path := range paths {
+		refs[path] = "#" + strconv.Itoa(i)
+	}
+	return refs
+}
+
+func sortedPaths(entry collector.UsageEntry) []string {
+	paths := make([]string, 0, len(entry.Mounts))
+	for mount := range entry.Mounts {
+		paths = append(paths, mount)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// internKeys replaces keys that have a reference, leaving new paths literal
+func internKeys[V any](m map[string]V, refs map[string]string) map[string]V {
+	if m == nil {
+		return nil
+	}
+	interned := make(map[string]V, len(m))
+	for key, value := range m {
+		if ref, ok := refs[key]; ok {
+			key = ref
+		}
+		interned[key] = value
+	}
+	return interned
+}
+
+// resolvePath turns a path reference back into the path, mount points never
+// start with '#' so anything else is a literal path
+func resolvePath(key string, paths []string) (string, error) {
+	if !strings.HasPrefix(key, "#") {
+		return key, nil
+	}
+	i, err := strconv.Atoi(key[1:])
+	if err != nil || i < 0 || i >= len(paths) {
+		return "", fmt.Errorf("invalid path reference %q", key)
+	}
+	return paths[i], nil
+}
+
+// expandRecord reverses deltaRecord
+func expandRecord(prev *collector.UsageEntry, record jsonlRecord) (collector.UsageEntry, error) {
+	entry := record.UsageEntry
+	if !record.Delta {
+		return entry, nil
+	}
+	if prev == nil {
+		return entry, fmt.Errorf("delta record without a preceding entry")
+	}
+
+	paths := sortedPaths(*prev)
+	removed := make(map[string]bool, len(record.Removed))
+	for _, ref := range record.Removed {
+		mount, err := resolvePath(ref, paths)
+		if err != nil {
+			return entry, err
+		}
+		removed[mount] = true
+	}
+
+	entry.Mounts = make(map[string]int64, len(prev.Mounts))
+	for mount, bytes := range prev.Mounts {
+		if !removed[mount] {
+			entry.Mounts[mount] = bytes
+		}
+	}
+	for ref, delta := range record.Mounts {
+		mount, err := resolvePath(ref, paths)
+		if err != nil {
+			return entry, err
+		}
+		entry.Mounts[mount] += delta
+	}
+
+	for _, ref := range record.RemovedDetails {
+		mount, err := resolvePath(ref, paths)
+		if err != nil {
+			return entry, err
+		}
+		removed[mount] = true
+	}
+	entry.Details = make(map[string]collector.MountDetail, len(prev.Details))
+	for mount, detail := range prev.Details {
+		if !removed[mount] {
+			entry.Details[mount] = detail
+		}
+	}
+	for ref, detail := range record.Details {
+		mount, err := resolvePath(ref, paths)
+		if err != nil {
+			return entry, err
+		}
+		entry.Details[mount] = detail
+	}
+	if len(entry.Details) == 0 {
+		entry.Details = nil
+	}
+	return entry, nil
+}
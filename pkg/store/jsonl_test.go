@@ -0,0 +1,209 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+// testHistory returns n sealed entries an hour apart measuring /mnt/a
+func testHistory(t *testing.T, n int, key []byte) []collector.UsageEntry {
+	t.Helper()
+	entries := make([]collector.UsageEntry, n)
+	for i := range entries {
+		used := int64(100 + i)
+		entries[i] = collector.UsageEntry{
+			Timestamp: 1717236000 + int64(i)*3600,
+			Mounts:    map[string]int64{"/mnt/a": used},
+			Total:     used,
+		}
+	}
+	if err := Seal(entries, key); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+// appendAll appends entries one by one, as collections do
+func appendAll(t *testing.T, st Store, entries []collector.UsageEntry) {
+	t.Helper()
+	for i, entry := range entries {
+		var prev *collector.UsageEntry
+		if i > 0 {
+			prev = &entries[i-1]
+		}
+		if err := st.Append(prev, i, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRepairTailAfterTornAppend(t *testing.T) {
+	tests := []struct {
+		name    string
+		compact bool
+		tail    string
+	}{
+		{"unterminated line", false, `{"timestamp":1717250400,"mou`},
+		{"unterminated compact line", true, `{"timestamp":1717250400,"mounts":{"#0":`},
+		{"undecodable final line", false, "{bad\n"},
+		{"partial line and newline", true, "{\"timestamp\":17\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "history.jsonl")
+			st := Open(path, nil, tt.compact)
+			entries := testHistory(t, 4, nil)
+			appendAll(t, st, entries[:3])
+
+			file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.WriteString(tt.tail)
+			file.Close()
+
+			if _, err := st.Load(); err == nil {
+				t.Fatal("Load accepted the torn line")
+			}
+			repaired, err := st.(TailRepairer).RepairTail()
+			if err != nil || !repaired {
+				t.Fatalf("RepairTail() = %v, %v, want true, nil", repaired, err)
+			}
+			records, err := readIndex(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 3 || !validIndex(path, records) {
+				t.Fatalf("index has %d records (valid %v), want 3 valid", len(records), validIndex(path, records))
+			}
+
+			if err := st.Append(&entries[2], 3, entries[3]); err != nil {
+				t.Fatalf("Append after repair: %v", err)
+			}
+			loaded, err := st.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(loaded, entries) {
+				t.Errorf("Load() = %+v, want %+v", loaded, entries)
+			}
+			if problems := Validate(path, loaded, nil); len(problems) > 0 {
+				t.Errorf("Validate() = %v", problems)
+			}
+			var scanned []int64
+			err = ScanRange(st, entries[3].Timestamp, entries[3].Timestamp, func(e collector.UsageEntry) error {
+				scanned = append(scanned, e.Timestamp)
+				return nil
+			})
+			if err != nil || len(scanned) != 1 {
+				t.Errorf("ScanRange found %v, %v, want the appended entry", scanned, err)
+			}
+		})
+	}
+}
+
+func TestRepairTailKeepsMidFileDamage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	st := Open(path, nil, false)
+	appendAll(t, st, testHistory(t, 2, nil))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append([]byte("{bad\n"), data...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if repaired, err := st.(TailRepairer).RepairTail(); repaired || err != nil {
+		t.Errorf("RepairTail() = %v, %v, want false, nil", repaired, err)
+	}
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	const hour = 3600
+	base := int64(1717236000)
+	detail := func(server string) collector.MountDetail {
+		return collector.MountDetail{Server: server}
+	}
+	tests := []struct {
+		name    string
+		entries []collector.UsageEntry
+	}{
+		{"removed mount", []collector.UsageEntry{
+			{Timestamp: base, Mounts: map[string]int64{"/mnt/a": 10, "/mnt/b": 20}, Total: 30},
+			{Timestamp: base + hour, Mounts: map[string]int64{"/mnt/a": 12}, Total: 12},
+			{Timestamp: base + 2*hour, Mounts: map[string]int64{"/mnt/a": 12}, Total: 12},
+		}},
+		{"renamed mount", []collector.UsageEntry{
+			{Timestamp: base, Mounts: map[string]int64{"/mnt/a": 10, "/mnt/b": 20}, Total: 30,
+				Details: map[string]collector.MountDetail{"/mnt/a": detail("filer01"), "/mnt/b": detail("filer02")}},
+			{Timestamp: base + hour, Mounts: map[string]int64{"/mnt/a": 10, "/mnt/c": 20}, Total: 30,
+				Details: map[string]collector.MountDetail{"/mnt/a": detail("filer01"), "/mnt/c": detail("filer02")}},
+			{Timestamp: base + 2*hour, Mounts: map[string]int64{"/mnt/a": 11, "/mnt/c": 25}, Total: 36,
+				Details: map[string]collector.MountDetail{"/mnt/a": detail("filer01"), "/mnt/c": detail("filer03")}},
+		}},
+		{"removed and remounted", []collector.UsageEntry{
+			{Timestamp: base, Mounts: map[string]int64{"/mnt/a": 10, "/mnt/b": 20}, Total: 30},
+			{Timestamp: base + hour, Mounts: map[string]int64{"/mnt/b": 20}, Total: 20},
+			{Timestamp: base + 2*hour, Mounts: map[string]int64{"/mnt/a": 5, "/mnt/b": 21}, Total: 26},
+		}},
+		{"all mounts gone", []collector.UsageEntry{
+			{Timestamp: base, Mounts: map[string]int64{"/mnt/a": 10}, Total: 10},
+			{Timestamp: base + hour, Mounts: map[string]int64{}, Total: 0},
+			{Timestamp: base + 2*hour, Mounts: map[string]int64{"/mnt/z": 1}, Total: 1},
+		}},
+		{"dropped detail", []collector.UsageEntry{
+			{Timestamp: base, Mounts: map[string]int64{"/mnt/a": 10, "/mnt/b": 20}, Total: 30,
+				Details: map[string]collector.MountDetail{"/mnt/a": detail("filer01"), "/mnt/b": detail("filer02")}},
+			{Timestamp: base + hour, Mounts: map[string]int64{"/mnt/a": 10, "/mnt/b": 20}, Total: 30,
+				Details: map[string]collector.MountDetail{"/mnt/a": detail("filer01")}},
+			{Timestamp: base + 2*hour, Mounts: map[string]int64{"/mnt/a": 11, "/mnt/b": 20}, Total: 31,
+				Details: map[string]collector.MountDetail{"/mnt/a": detail("filer01"), "/mnt/b": detail("filer02")}},
+		}},
+		{"all details dropped", []collector.UsageEntry{
+			{Timestamp: base, Mounts: map[string]int64{"/mnt/a": 10}, Total: 10,
+				Details: map[string]collector.MountDetail{"/mnt/a": detail("filer01")}},
+			{Timestamp: base + hour, Mounts: map[string]int64{"/mnt/a": 10}, Total: 10},
+		}},
+		{"detail of removed mount kept", []collector.UsageEntry{
+			{Timestamp: base, Mounts: map[string]int64{"/mnt/a": 10, "/mnt/b": 20}, Total: 30,
+				Details: map[string]collector.MountDetail{"/mnt/b": detail("filer02")}},
+			{Timestamp: base + hour, Mounts: map[string]int64{"/mnt/a": 10}, Total: 10,
+				Details: map[string]collector.MountDetail{"/mnt/b": detail("filer02")}},
+		}},
+		{"rename across keyframe", []collector.UsageEntry{
+			{Timestamp: base + 23*hour, Mounts: map[string]int64{"/mnt/a": 10, "/mnt/b": 20}, Total: 30},
+			{Timestamp: base + 25*hour, Mounts: map[string]int64{"/mnt/b": 20, "/mnt/c": 10}, Total: 30},
+			{Timestamp: base + 26*hour, Mounts: map[string]int64{"/mnt/c": 11}, Total: 11},
+		}},
+	}
+	for _, tt := range tests {
+		for _, write := range []string{"append", "rewrite"} {
+			t.Run(tt.name+"/"+write, func(t *testing.T) {
+				path := filepath.Join(t.TempDir(), "history.jsonl")
+				st := Open(path, nil, true)
+				if err := Seal(tt.entries, nil); err != nil {
+					t.Fatal(err)
+				}
+				if write == "append" {
+					appendAll(t, st, tt.entries)
+				} else if err := st.Rewrite(tt.entries); err != nil {
+					t.Fatal(err)
+				}
+				loaded, err := st.Load()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(loaded, tt.entries) {
+					t.Errorf("Load() = %+v, want %+v", loaded, tt.entries)
+				}
+				if problems := Validate(path, loaded, nil); len(problems) > 0 {
+					t.Errorf("Validate() = %v", problems)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// KeyEnvVar holds the store key directly, as hex or base64
+const KeyEnvVar = "NFSUSAGE_KEY"
+
+// LoadKey returns the store key from, in order: keyFile, the NFSUSAGE_KEY
+// environment variable, or the output of keyCommand run through sh. A nil key
+// means encryption is disabled.
+func LoadKey(keyFile, keyCommand string) ([]byte, error) {
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return ParseKey(data)
+	}
+	if env := os.Getenv(KeyEnvVar); env != "" {
+		return ParseKey([]byte(env))
+	}
+	if keyCommand != "" {
+		output, err := exec.Command("sh", "-c", keyCommand).Output()
+		if err != nil {
+			return nil, fmt.Errorf("key command failed: %v", err)
+		}
+		return ParseKey(output)
+	}
+	return nil, nil
+}
+
+// ParseKey accepts a 32 byte key as hex, base64 or raw bytes
+func ParseKey(data []byte) ([]byte, error) {
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	return nil, fmt.Errorf("key must be 32 bytes (AES-256) as hex, base64 or raw")
+}
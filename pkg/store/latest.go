@@ -0,0 +1,54 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+// LatestPath returns the sidecar caching the newest entry of a store, so
+// status checks, --compare=previous and exporters never read the history
+func LatestPath(filePath string) string {
+	return filePath + ".latest"
+}
+
+// WriteLatest caches entry as the newest one, encrypted like the store
+func WriteLatest(filePath string, entry collector.UsageEntry, key []byte) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	perm := os.FileMode(0644)
+	if key != nil {
+		if data, err = Encrypt(key, data); err != nil {
+			return err
+		}
+		perm = 0600
+	}
+	return WriteFileAtomic(LatestPath(filePath), data, perm)
+}
+
+// ReadLatest returns the cached newest entry and the entry count. The cache
+// is only trusted when it matches the manifest head, so a cache left behind
+// by a restored, quarantined or hand-edited store falls back to the history.
+func ReadLatest(filePath string, key []byte) (*collector.UsageEntry, int, bool) {
+	m, err := ReadManifest(filePath)
+	if err != nil || m.Head == "" {
+		return nil, 0, false
+	}
+	data, err := os.ReadFile(LatestPath(filePath))
+	if err != nil {
+		return nil, 0, false
+	}
+	if IsEncrypted(data) {
+		if data, err = Decrypt(key, data); err != nil {
+			return nil, 0, false
+		}
+	}
+	var entry collector.UsageEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Checksum != m.Head {
+		return nil, 0, false
+	}
+	return &entry, m.Entries, true
+}
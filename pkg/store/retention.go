@@ -0,0 +1,121 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+// downsampleAge is how long pruning goes between downsampling passes, which
+// need to read the whole history to find days with several entries
+const downsampleAge = 24 * time.Hour
+
+// RetentionPolicy bounds the history kept in a store. Entries are pruned
+// after a write when the oldest is past MaxAge or there are more than
+// MaxEntries, and daily when downsampling. The newest entry is always kept.
+type RetentionPolicy struct {
+	MaxAge     time.Duration
+	MaxEntries int
+	// DownsampleAfter keeps only the last entry of each day for entries
+	// older than this, 0 keeps every entry
+	DownsampleAfter time.Duration
+}
+
+// Enabled reports whether the policy prunes anything
+func (p RetentionPolicy) Enabled() bool {
+	return p.MaxAge > 0 || p.MaxEntries > 0 || p.DownsampleAfter > 0
+}
+
+// PruneStampPath records when the history was last pruned
+func PruneStampPath(filePath string) string {
+	return filePath + ".lastprune"
+}
+
+// Due reports whether a store holding count entries, the oldest first, needs
+// pruning at now, without reading more than the oldest entry
+func (p RetentionPolicy) Due(filePath string, first *collector.UsageEntry, count int, now time.Time) bool {
+	if p.MaxEntries > 0 && count > p.MaxEntries {
+		return true
+	}
+	if p.MaxAge > 0 && first != nil && first.Timestamp < now.Add(-p.MaxAge).Unix() {
+		return true
+	}
+	if p.DownsampleAfter > 0 {
+		info, err := os.Stat(PruneStampPath(filePath))
+		return err != nil || now.Sub(info.ModTime()) >= downsampleAge
+	}
+	return false
+}
+
+// Prune returns the entries the policy keeps, oldest first. Downsampled days
+// keep their last entry, their closing usage as in the monthly report.
+func (p RetentionPolicy) Prune(entries []collector.UsageEntry, now time.Time) []collector.UsageEntry {
+	if len(entries) == 0 {
+		return entries
+	}
+	newest := len(entries) - 1
+	var kept []collector.UsageEntry
+	for i, entry := range entries {
+		if i != newest {
+			if p.MaxAge > 0 && entry.Timestamp < now.Add(-p.MaxAge).Unix() {
+				continue
+			}
+			if p.DownsampleAfter > 0 && entry.Timestamp < now.Add(-p.DownsampleAfter).Unix() &&
+				sameDay(entry.Timestamp, entries[i+1].Timestamp) {
+				continue
+			}
+		}
+		kept = append(kept, entry)
+	}
+	if p.MaxEntries > 0 && len(kept) > p.MaxEntries {
+		kept = kept[len(kept)-p.MaxEntries:]
+	}
+	return kept
+}
+
+// sameDay reports whether two timestamps fall on the same UTC day, the days
+// keyframes start
+func sameDay(a, b int64) bool {
+	ta, tb := time.Unix(a, 0).UTC(), time.Unix(b, 0).UTC()
+	return ta.Year() == tb.Year() && ta.YearDay() == tb.YearDay()
+}
+
+// ApplyRetention prunes the history now, whether or not it is due, and
+// returns how many entries were dropped out of how many. Dropping entries
+// breaks the checksum chain, so the kept entries are resealed after backup
+// is called. A history that fails verification is not pruned, resealing it
+// would sign the tampered entries.
+func ApplyRetention(st Store, p RetentionPolicy, key []byte, now time.Time, backup func() error) (int, int, error) {
+	entries, err := st.Load()
+	if err != nil {
+		return 0, 0, err
+	}
+	kept := p.Prune(entries, now)
+	if len(kept) < len(entries) {
+		if err := Verify(st.File(), entries, key); err != nil {
+			return 0, 0, fmt.Errorf("refusing to reseal a history that fails validation: %v", err)
+		}
+		for i := range kept {
+			kept[i].Checksum = ""
+		}
+		if err := Seal(kept, key); err != nil {
+			return 0, 0, err
+		}
+		if err := backup(); err != nil {
+			return 0, 0, fmt.Errorf("backing up data: %v", err)
+		}
+		if err := st.Rewrite(kept); err != nil {
+			return 0, 0, err
+		}
+		// A stale cache only costs speed, it no longer matches the manifest
+		WriteLatest(st.File(), kept[len(kept)-1], st.EncryptionKey())
+	}
+	if p.DownsampleAfter > 0 {
+		if err := os.WriteFile(PruneStampPath(st.File()), nil, 0644); err != nil {
+			return 0, 0, err
+		}
+	}
+	return len(entries) - len(kept), len(entries), nil
+}
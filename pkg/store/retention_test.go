@@ -0,0 +1,97 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+func TestSameDay(t *testing.T) {
+	// 2024-06-01 23:30 and 2024-06-02 00:30 UTC
+	late, early := time.Date(2024, 6, 1, 23, 30, 0, 0, time.UTC).Unix(), time.Date(2024, 6, 2, 0, 30, 0, 0, time.UTC).Unix()
+	tests := []struct {
+		zone string
+		a, b int64
+		want bool
+	}{
+		{"UTC", late, early, false},
+		{"UTC", late - 3600, late, true},
+		// Same local day east of UTC, still different UTC days
+		{"Asia/Tokyo", late, early, false},
+		// Different local days west of UTC, the same UTC day
+		{"America/New_York", late - 20*3600, late, true},
+	}
+	defer func(loc *time.Location) { time.Local = loc }(time.Local)
+	for _, tt := range tests {
+		loc, err := time.LoadLocation(tt.zone)
+		if err != nil {
+			t.Skipf("no zone data: %v", err)
+		}
+		time.Local = loc
+		if got := sameDay(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameDay(%d, %d) in %s = %v, want %v", tt.a, tt.b, tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestApplyRetentionRefusesTamperedHistory(t *testing.T) {
+	policy := RetentionPolicy{MaxEntries: 2}
+	key := make([]byte, 32)
+
+	tests := []struct {
+		name    string
+		tamper  bool
+		wantErr bool
+	}{
+		{"intact", false, false},
+		{"tampered", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "history.jsonl")
+			st := Open(path, key, false)
+			now := time.Now().Unix()
+			var prev *collector.UsageEntry
+			for i := 0; i < 4; i++ {
+				entry := collector.UsageEntry{Timestamp: now - int64(4-i)*3600, Mounts: map[string]int64{"/mnt/a": int64(i)}, Total: int64(i)}
+				prevSum := ""
+				if prev != nil {
+					prevSum = prev.Checksum
+				}
+				entry.Checksum, _ = EntryChecksum(prevSum, entry, key)
+				if err := st.Append(prev, i, entry); err != nil {
+					t.Fatal(err)
+				}
+				prev = &entry
+			}
+			if tt.tamper {
+				entries, _ := st.Load()
+				entries[1].Mounts["/mnt/a"] = 99
+				// Rewrite keeps the stale checksums, like a hand edit would
+				if err := st.Rewrite(entries); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			backup := func() error { return nil }
+			if _, _, err := ApplyRetention(st, policy, key, time.Now(), backup); (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyRetention() = %v, wantErr %v", err, tt.wantErr)
+			}
+			entries, err := st.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr {
+				if len(entries) != 4 {
+					t.Errorf("tampered history was pruned to %d entries", len(entries))
+				}
+				return
+			}
+			if len(entries) != 2 || len(Validate(path, entries, key)) > 0 {
+				t.Errorf("pruned history has %d entries, problems %v", len(entries), Validate(path, entries, key))
+			}
+		})
+	}
+}
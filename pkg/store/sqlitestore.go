@@ -0,0 +1,258 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteScheme prefixes --store URLs naming an SQLite database
+const sqliteScheme = "sqlite://"
+
+// sqliteSchema creates the entries table. Rows keep insertion order by id,
+// the timestamp index serves range queries.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS entries (
+	id        INTEGER PRIMARY KEY,
+	timestamp INTEGER NOT NULL,
+	data      BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS entries_timestamp ON entries (timestamp);`
+
+// IsSQLitePath reports whether a data file is an SQLite database
+func IsSQLitePath(filePath string) bool {
+	switch filepath.Ext(filePath) {
+	case ".db", ".sqlite", ".sqlite3":
+		return true
+	}
+	return false
+}
+
+// ParseURL returns the data file named by a --store URL: sqlite://PATH
+// for an SQLite database, or a plain path
+func ParseURL(url string) (string, error) {
+	path, ok := strings.CutPrefix(url, sqliteScheme)
+	if !ok {
+		if strings.Contains(url, "://") {
+			return "", fmt.Errorf("unsupported store %q (want sqlite://PATH or a file path)", url)
+		}
+		return url, nil
+	}
+	if path == "" {
+		return "", fmt.Errorf("store %q has no path", url)
+	}
+	if !IsSQLitePath(path) {
+		return "", fmt.Errorf("sqlite store path %q must end in .db, .sqlite or .sqlite3", path)
+	}
+	return path, nil
+}
+
+// sqliteStore keeps one row per entry in an SQLite database in WAL mode, so
+// readers such as report or the exporter don't block the collector. With a
+// key, each row's JSON is encrypted on its own. Every operation opens and
+// closes the database, as stores aren't closed by their callers.
+type sqliteStore struct {
+	path string
+	key  []byte
+}
+
+func (s *sqliteStore) File() string { return s.path }
+
+func (s *sqliteStore) EncryptionKey() []byte { return s.key }
+
+// open opens the database, creating it and the schema when create is set.
+// Without it a missing database is reported like a missing data file.
+func (s *sqliteStore) open(create bool) (*sql.DB, error) {
+	if !create {
+		if _, err := os.Stat(s.path); err != nil {
+			return nil, err
+		}
+	}
+	db, err := sql.Open("sqlite", "file:"+s.path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	if create {
+		if _, err := db.Exec(sqliteSchema); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("%s: %v", s.path, err)
+		}
+		if s.key != nil {
+			os.Chmod(s.path, 0600)
+		}
+	}
+	return db, nil
+}
+
+func (s *sqliteStore) Load() ([]collector.UsageEntry, error) {
+	var entries []collector.UsageEntry
+	err := s.Scan(func(entry collector.UsageEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+func (s *sqliteStore) Scan(fn func(collector.UsageEntry) error) error {
+	return s.ScanRange(0, 0, fn)
+}
+
+// ScanRange queries the timestamp index for from <= timestamp <= to, zero
+// bounds are open
+func (s *sqliteStore) ScanRange(from, to int64, fn func(collector.UsageEntry) error) error {
+	db, err := s.open(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	query, args := "SELECT id, data FROM entries", []any{}
+	var where []string
+	if from != 0 {
+		where = append(where, "timestamp >= ?")
+		args = append(args, from)
+	}
+	if to != 0 {
+		where = append(where, "timestamp <= ?")
+		args = append(args, to)
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	rows, err := db.Query(query+" ORDER BY id", args...)
+	if err != nil {
+		return fmt.Errorf("%s: %v", s.path, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return fmt.Errorf("%s: %v", s.path, err)
+		}
+		entry, err := s.decodeRow(id, data)
+		if err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return IgnoreStop(err)
+		}
+	}
+	return rows.Err()
+}
+
+// Tail reads the newest row and the row count
+func (s *sqliteStore) Tail() (*collector.UsageEntry, int, error) {
+	db, err := s.open(false)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer db.Close()
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM entries").Scan(&count); err != nil {
+		return nil, 0, fmt.Errorf("%s: %v", s.path, err)
+	}
+	if count == 0 {
+		return nil, 0, nil
+	}
+	var id int
+	var data []byte
+	if err := db.QueryRow("SELECT id, data FROM entries ORDER BY id DESC LIMIT 1").Scan(&id, &data); err != nil {
+		return nil, 0, fmt.Errorf("%s: %v", s.path, err)
+	}
+	entry, err := s.decodeRow(id, data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &entry, count, nil
+}
+
+func (s *sqliteStore) Append(prev *collector.UsageEntry, count int, entry collector.UsageEntry) error {
+	data, err := s.encodeRow(entry)
+	if err != nil {
+		return err
+	}
+	db, err := s.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec("INSERT INTO entries (timestamp, data) VALUES (?, ?)", entry.Timestamp, data); err != nil {
+		return fmt.Errorf("%s: %v", s.path, err)
+	}
+	return WriteManifest(s.path, count+1, entry.Checksum)
+}
+
+// rewrite replaces all rows in one transaction, so readers see either the
+// old or the new history
+func (s *sqliteStore) Rewrite(history []collector.UsageEntry) error {
+	db, err := s.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("%s: %v", s.path, err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM entries"); err != nil {
+		return fmt.Errorf("%s: %v", s.path, err)
+	}
+	insert, err := tx.Prepare("INSERT INTO entries (timestamp, data) VALUES (?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %v", s.path, err)
+	}
+	defer insert.Close()
+	for _, entry := range history {
+		data, err := s.encodeRow(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := insert.Exec(entry.Timestamp, data); err != nil {
+			return fmt.Errorf("%s: %v", s.path, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %v", s.path, err)
+	}
+
+	head := ""
+	if len(history) > 0 {
+		head = history[len(history)-1].Checksum
+	}
+	return WriteManifest(s.path, len(history), head)
+}
+
+// encodeRow marshals an entry, sealing it when the store is encrypted
+func (s *sqliteStore) encodeRow(entry collector.UsageEntry) ([]byte, error) {
+	data, err := json.Marshal(entry)
+	if err != nil || s.key == nil {
+		return data, err
+	}
+	return Encrypt(s.key, data)
+}
+
+// decodeRow parses one row. Rows that can't be parsed yield a
+// CorruptStoreError with the row id as the line, decryption failures don't.
+func (s *sqliteStore) decodeRow(id int, data []byte) (collector.UsageEntry, error) {
+	var entry collector.UsageEntry
+	if IsEncrypted(data) {
+		var err error
+		if data, err = Decrypt(s.key, data); err != nil {
+			return entry, err
+		}
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, &CorruptStoreError{Path: s.path, Line: id, Err: err}
+	}
+	return entry, nil
+}
@@ -0,0 +1,267 @@
+// Package store persists the usage history collected by the collector
+// package. The backend is picked from the data file name: a JSON array, one
+// entry per line (.jsonl) or an SQLite database. Every backend can encrypt
+// its entries and keeps a manifest so truncation and edits are detected.
+// A RetentionPolicy prunes the history and reseals what it keeps.
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/jessegalley/nfsusage/pkg/collector"
+)
+
+// Store persists the usage history
+type Store interface {
+	// Load returns the full history, oldest first
+	Load() ([]collector.UsageEntry, error)
+	// Scan calls fn for each entry oldest first without holding the whole
+	// history in memory. Returning ErrStopScan from fn ends the scan early.
+	Scan(fn func(collector.UsageEntry) error) error
+	// Append persists entry after prev, the current newest of count entries
+	// (nil when empty). Stores that cannot append in place rewrite the file.
+	Append(prev *collector.UsageEntry, count int, entry collector.UsageEntry) error
+	// Rewrite replaces the stored history, e.g. after pruning
+	Rewrite(history []collector.UsageEntry) error
+	// File is the data file path, sidecars (index, manifest, events) live next to it
+	File() string
+	// EncryptionKey is the key the store is encrypted with, nil when it isn't
+	EncryptionKey() []byte
+}
+
+// TailRepairer is implemented by stores whose last record can be torn by a
+// crash in the middle of an append
+type TailRepairer interface {
+	// RepairTail drops a torn final record when everything before it is
+	// intact, reporting whether it did
+	RepairTail() (bool, error)
+}
+
+// ErrStopScan ends a scan early without reporting an error
+var ErrStopScan = errors.New("stop scan")
+
+// Open picks the backend from the file extension: .jsonl files are
+// appended line by line, .db, .sqlite and .sqlite3 are SQLite databases,
+// anything else is a single JSON array
+func Open(filePath string, key []byte, compact bool) Store {
+	if IsSQLitePath(filePath) {
+		return &sqliteStore{path: filePath, key: key}
+	}
+	if filepath.Ext(filePath) == ".jsonl" {
+		return &jsonlStore{path: filePath, key: key, compact: compact}
+	}
+	return &jsonStore{path: filePath, key: key}
+}
+
+// First returns the oldest entry, reading only as far as needed
+func First(st Store) (*collector.UsageEntry, error) {
+	var first *collector.UsageEntry
+	err := st.Scan(func(entry collector.UsageEntry) error {
+		first = &entry
+		return ErrStopScan
+	})
+	return first, err
+}
+
+// Last returns the newest entry and the number of entries while keeping
+// only one entry in memory, from the latest entry cache when it is current
+func Last(st Store) (*collector.UsageEntry, int, error) {
+	if entry, count, ok := ReadLatest(st.File(), st.EncryptionKey()); ok {
+		return entry, count, nil
+	}
+	if t, ok := st.(Tailer); ok {
+		return t.Tail()
+	}
+	var last *collector.UsageEntry
+	count := 0
+	err := st.Scan(func(entry collector.UsageEntry) error {
+		last = &entry
+		count++
+		return nil
+	})
+	return last, count, err
+}
+
+// jsonStore keeps the history as a pretty-printed JSON array
+type jsonStore struct {
+	path string
+	key  []byte
+}
+
+func (s *jsonStore) File() string { return s.path }
+
+func (s *jsonStore) EncryptionKey() []byte { return s.key }
+
+func (s *jsonStore) Load() ([]collector.UsageEntry, error) {
+	return LoadEntries(s.path, s.key)
+}
+
+// Scan decodes the array one element at a time. Encrypted files have to be
+// decrypted as a whole first.
+func (s *jsonStore) Scan(fn func(collector.UsageEntry) error) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	if magic, _ := reader.Peek(len(encryptedMagic)); IsEncrypted(magic) {
+		entries, err := s.Load()
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := fn(entry); err != nil {
+				return IgnoreStop(err)
+			}
+		}
+		return nil
+	}
+
+	dec := json.NewDecoder(reader)
+	if tok, err := dec.Token(); err == io.EOF {
+		return nil
+	} else if err != nil {
+		return &CorruptStoreError{Path: s.path, Err: err}
+	} else if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return &CorruptStoreError{Path: s.path, Err: fmt.Errorf("expected a JSON array")}
+	}
+	for dec.More() {
+		var entry collector.UsageEntry
+		if err := dec.Decode(&entry); err != nil {
+			return &CorruptStoreError{Path: s.path, Err: err}
+		}
+		if err := fn(entry); err != nil {
+			return IgnoreStop(err)
+		}
+	}
+	return nil
+}
+
+func (s *jsonStore) Append(prev *collector.UsageEntry, count int, entry collector.UsageEntry) error {
+	entries, err := s.Load()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return SaveEntries(s.path, append(entries, entry), s.key)
+}
+
+func (s *jsonStore) Rewrite(history []collector.UsageEntry) error {
+	return SaveEntries(s.path, history, s.key)
+}
+
+// IgnoreStop turns ErrStopScan into a successful scan
+func IgnoreStop(err error) error {
+	if err == ErrStopScan {
+		return nil
+	}
+	return err
+}
+
+// LoadEntries loads existing entries from the JSON file, decrypting it if needed
+func LoadEntries(filePath string, key []byte) ([]collector.UsageEntry, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if IsEncrypted(data) {
+		if data, err = Decrypt(key, data); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := ParseEntries(data)
+	if err != nil {
+		return nil, &CorruptStoreError{Path: filePath, Err: err}
+	}
+	return entries, nil
+}
+
+// ParseEntries decodes the JSON array format of the history file
+func ParseEntries(data []byte) ([]collector.UsageEntry, error) {
+	var entries []collector.UsageEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// SaveEntries saves entries to the JSON file, encrypting it when a key is set,
+// and updates the manifest
+func SaveEntries(filePath string, entries []collector.UsageEntry, key []byte) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	perm := os.FileMode(0644)
+	if key != nil {
+		if data, err = Encrypt(key, data); err != nil {
+			return err
+		}
+		perm = 0600
+	}
+
+	if err := WriteFileAtomic(filePath, data, perm); err != nil {
+		return err
+	}
+	head := ""
+	if len(entries) > 0 {
+		head = entries[len(entries)-1].Checksum
+	}
+	return WriteManifest(filePath, len(entries), head)
+}
+
+// CorruptStoreError means the data file exists but can't be parsed, as
+// opposed to failing to read or decrypt it
+type CorruptStoreError struct {
+	Path string
+	// Line is the line of a .jsonl file or the row of an SQLite store, 0 when unknown
+	Line int
+	Err  error
+}
+
+func (e *CorruptStoreError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s line %d: %v", e.Path, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *CorruptStoreError) Unwrap() error { return e.Err }
+
+// WriteFileAtomic writes data in two phases: the new contents go to a
+// temporary file that is synced to disk, then renamed over path. A crash at
+// any point leaves either the old or the new file, never a torn one. A
+// private perm is applied even to a temporary file left behind by an earlier
+// write, so the result is never more readable than asked for.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if perm&0077 == 0 {
+		err = file.Chmod(perm)
+	}
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}